@@ -0,0 +1,98 @@
+package dfs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// blockCacheKey includes the checksum so a block rewritten under the same ID
+// is never served stale, and the offset and size to tell apart the ranges of
+// a block and the extents of a container block.
+type blockCacheKey struct {
+	blockID      string
+	offset, size int64
+	checksum     int64
+}
+
+type blockCacheEntry struct {
+	key  blockCacheKey
+	data []byte
+}
+
+// blockCache is an LRU of block ranges bounded by their total size. It is
+// shared by all readers of a client. A nil *blockCache is a disabled cache.
+type blockCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	entries  map[blockCacheKey]*list.Element
+}
+
+func newBlockCache(maxBytes int64) *blockCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &blockCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[blockCacheKey]*list.Element),
+	}
+}
+
+func cacheKey(rg blockRange) blockCacheKey {
+	return blockCacheKey{blockID: rg.info.BlockId, offset: rg.info.Offset + rg.start, size: rg.size, checksum: rg.info.Checksum}
+}
+
+func (c *blockCache) get(rg blockRange) []byte {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[cacheKey(rg)]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*blockCacheEntry).data
+}
+
+func (c *blockCache) contains(rg blockRange) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.entries[cacheKey(rg)]
+	return ok
+}
+
+// add stores a range, evicting the least recently used ones to stay within
+// maxBytes. Ranges larger than the whole cache are not stored.
+func (c *blockCache) add(rg blockRange, data []byte) {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(rg)
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&blockCacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.maxBytes {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*blockCacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+}
@@ -0,0 +1,80 @@
+package dfs
+
+import (
+	"bytes"
+	"testing"
+
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+)
+
+func TestBlockCache(t *testing.T) {
+	block := func(id string) blockRange {
+		return blockRange{info: &commonv1.BlockInfo{BlockId: id, Checksum: 1, Size: 10}, size: 10}
+	}
+	data := func(n int) []byte { return bytes.Repeat([]byte{'x'}, n) }
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		// ops are run in order: "+id" adds a 10 byte block, "?id" gets one.
+		ops    []string
+		cached []string
+		gone   []string
+	}{
+		{name: "within capacity", maxBytes: 30, ops: []string{"+a", "+b", "+c"}, cached: []string{"a", "b", "c"}},
+		{name: "evicts the oldest", maxBytes: 30, ops: []string{"+a", "+b", "+c", "+d"}, cached: []string{"b", "c", "d"}, gone: []string{"a"}},
+		{name: "get refreshes", maxBytes: 30, ops: []string{"+a", "+b", "+c", "?a", "+d"}, cached: []string{"a", "c", "d"}, gone: []string{"b"}},
+		{name: "re-add refreshes", maxBytes: 20, ops: []string{"+a", "+b", "+a", "+c"}, cached: []string{"a", "c"}, gone: []string{"b"}},
+		{name: "block larger than the cache", maxBytes: 5, ops: []string{"+a"}, gone: []string{"a"}},
+		{name: "disabled", maxBytes: 0, ops: []string{"+a"}, gone: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBlockCache(tt.maxBytes)
+			for _, op := range tt.ops {
+				switch op[0] {
+				case '+':
+					c.add(block(op[1:]), data(10))
+				case '?':
+					c.get(block(op[1:]))
+				}
+			}
+			for _, id := range tt.cached {
+				if got := c.get(block(id)); len(got) != 10 {
+					t.Errorf("block %s not cached", id)
+				}
+			}
+			for _, id := range tt.gone {
+				if c.contains(block(id)) {
+					t.Errorf("block %s still cached", id)
+				}
+			}
+			if c != nil && c.size > c.maxBytes {
+				t.Errorf("cache holds %d bytes, over its %d", c.size, c.maxBytes)
+			}
+		})
+	}
+}
+
+func TestBlockCacheKey(t *testing.T) {
+	block := &commonv1.BlockInfo{BlockId: "a", Checksum: 1, Size: 10}
+	c := newBlockCache(100)
+	c.add(blockRange{info: block, size: 5}, []byte("first"))
+
+	tests := []struct {
+		name string
+		rg   blockRange
+		want string
+	}{
+		{name: "same range", rg: blockRange{info: block, size: 5}, want: "first"},
+		{name: "rewritten block", rg: blockRange{info: &commonv1.BlockInfo{BlockId: "a", Checksum: 2, Size: 10}, size: 5}},
+		{name: "other extent", rg: blockRange{info: &commonv1.BlockInfo{BlockId: "a", Checksum: 1, Size: 5, Offset: 10, Packed: true}, size: 5}},
+		{name: "next range", rg: blockRange{info: block, start: 5, size: 5}},
+		{name: "whole block", rg: blockRange{info: block, size: 10}},
+	}
+	for _, tt := range tests {
+		if got := string(c.get(tt.rg)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package dfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeWorker is a datanode serving blocks from memory. Its knobs are set
+// before the worker is used.
+type fakeWorker struct {
+	datanodev1.UnimplementedDataNodeServiceServer
+	id   string
+	addr string
+
+	// delay holds every fetch back that long, or until it is cancelled;
+	// delays overrides it for some blocks.
+	delay  time.Duration
+	delays map[string]time.Duration
+	// corrupt serves blocks with their first byte flipped.
+	corrupt bool
+	// token, when set, is the only block token fetches are served with.
+	token string
+	// pushErr fails pushes once their data was received.
+	pushErr error
+
+	mu     sync.Mutex
+	blocks map[string][]byte
+
+	fetches, cancelledFetches, pushes atomic.Int32
+}
+
+// startWorker serves a new fake worker until the test ends.
+func startWorker(t *testing.T, id string, configure ...func(*fakeWorker)) *fakeWorker {
+	t.Helper()
+	w := &fakeWorker{id: id, blocks: make(map[string][]byte)}
+	for _, c := range configure {
+		c(w)
+	}
+	w.addr = serve(t, func(s *grpc.Server) { datanodev1.RegisterDataNodeServiceServer(s, w) })
+	return w
+}
+
+func (w *fakeWorker) location(blockID string) *commonv1.BlockLocation {
+	return &commonv1.BlockLocation{BlockId: blockID, WorkerId: w.id, Address: w.addr}
+}
+
+func (w *fakeWorker) block(blockID string) ([]byte, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, ok := w.blocks[blockID]
+	return data, ok
+}
+
+func (w *fakeWorker) FetchBlock(req *datanodev1.FetchBlockRequest, stream datanodev1.DataNodeService_FetchBlockServer) error {
+	w.fetches.Add(1)
+	if w.token != "" && req.BlockToken != w.token {
+		return status.Errorf(codes.Unauthenticated, "block token %q expired", req.BlockToken)
+	}
+	delay, ok := w.delays[req.BlockId]
+	if !ok {
+		delay = w.delay
+	}
+	if delay > 0 {
+		select {
+		case <-stream.Context().Done():
+			w.cancelledFetches.Add(1)
+			return stream.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+
+	data, ok := w.block(req.BlockId)
+	if !ok {
+		return status.Errorf(codes.NotFound, "block %s not found", req.BlockId)
+	}
+	if req.Length > 0 {
+		data = data[req.Offset : req.Offset+req.Length]
+	}
+	if w.corrupt {
+		data = slices.Clone(data)
+		data[0] ^= 0xff
+	}
+	for len(data) > 0 {
+		n := min(len(data), 1024)
+		if err := stream.Send(&datanodev1.FetchBlockResponse{Chunk: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (w *fakeWorker) PushBlock(stream datanodev1.DataNodeService_PushBlockServer) error {
+	w.pushes.Add(1)
+	var blockID string
+	var data []byte
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if meta := req.GetMetadata(); meta != nil {
+			blockID = meta.BlockId
+		}
+		data = append(data, req.GetChunk()...)
+	}
+	if w.pushErr != nil {
+		return w.pushErr
+	}
+
+	w.mu.Lock()
+	w.blocks[blockID] = data
+	w.mu.Unlock()
+	sum := sha256.Sum256(data)
+	return stream.SendAndClose(&datanodev1.PushBlockResponse{
+		Success:     true,
+		Checksum:    crc32.ChecksumIEEE(data),
+		ContentHash: hex.EncodeToString(sum[:]),
+	})
+}
+
+// fakeCoordinator stands in for the masters. It places every block on the
+// first of its workers that is not excluded.
+type fakeCoordinator struct {
+	coordinatorv1.CoordinatorServiceClient
+	workers []*fakeWorker
+
+	mu sync.Mutex
+	// files are returned by GetFileMetadata in turn, the last one repeatedly.
+	files    []*coordinatorv1.GetFileMetadataResponse
+	metadata int
+	// stored maps the content hashes the project already has to their
+	// blocks.
+	stored map[string]string
+	// allocErrs fail the first allocations, one each.
+	allocErrs []error
+	commitErr error
+	nextBlock int
+
+	allocs      []*coordinatorv1.AllocateBlockRequest
+	commits     []*coordinatorv1.CommitFileRequest
+	abandoned   []string
+	badReplicas []*coordinatorv1.ReportBadReplicaRequest
+}
+
+func (f *fakeCoordinator) GetFileMetadata(ctx context.Context, req *coordinatorv1.GetFileMetadataRequest, opts ...grpc.CallOption) (*coordinatorv1.GetFileMetadataResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.files) == 0 {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FilePath)
+	}
+	resp := f.files[min(f.metadata, len(f.files)-1)]
+	f.metadata++
+	return resp, nil
+}
+
+func (f *fakeCoordinator) AllocateBlock(ctx context.Context, req *coordinatorv1.AllocateBlockRequest, opts ...grpc.CallOption) (*coordinatorv1.AllocateBlockResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allocs = append(f.allocs, req)
+	if len(f.allocErrs) > 0 {
+		err := f.allocErrs[0]
+		f.allocErrs = f.allocErrs[1:]
+		return nil, err
+	}
+
+	if blockID, ok := f.stored[req.ContentHash]; ok && req.ContentHash != "" {
+		return &coordinatorv1.AllocateBlockResponse{BlockId: blockID, Deduplicated: true,
+			TargetDatanodes: []*commonv1.BlockLocation{f.workers[0].location(blockID)}}, nil
+	}
+	for _, w := range f.workers {
+		if slices.Contains(req.ExcludedWorkerIds, w.id) {
+			continue
+		}
+		f.nextBlock++
+		blockID := fmt.Sprintf("block-%d", f.nextBlock)
+		return &coordinatorv1.AllocateBlockResponse{BlockId: blockID,
+			TargetDatanodes: []*commonv1.BlockLocation{w.location(blockID)}}, nil
+	}
+	return nil, status.Errorf(codes.ResourceExhausted, "no workers outside %v", req.ExcludedWorkerIds)
+}
+
+func (f *fakeCoordinator) CommitFile(ctx context.Context, req *coordinatorv1.CommitFileRequest, opts ...grpc.CallOption) (*coordinatorv1.CommitFileResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commits = append(f.commits, req)
+	if f.commitErr != nil {
+		return nil, f.commitErr
+	}
+	return &coordinatorv1.CommitFileResponse{Generation: int64(len(f.commits))}, nil
+}
+
+func (f *fakeCoordinator) AbandonBlock(ctx context.Context, req *coordinatorv1.AbandonBlockRequest, opts ...grpc.CallOption) (*coordinatorv1.AbandonBlockResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.abandoned = append(f.abandoned, req.BlockId)
+	return &coordinatorv1.AbandonBlockResponse{}, nil
+}
+
+func (f *fakeCoordinator) ReportBadReplica(ctx context.Context, req *coordinatorv1.ReportBadReplicaRequest, opts ...grpc.CallOption) (*coordinatorv1.ReportBadReplicaResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.badReplicas = append(f.badReplicas, req)
+	return &coordinatorv1.ReportBadReplicaResponse{}, nil
+}
+
+// newTestClient returns a client of master that dials workers directly.
+func newTestClient(t *testing.T, master coordinatorv1.CoordinatorServiceClient, opts ...ClientOption) *dfsClient {
+	t.Helper()
+	c := &dfsClient{
+		masterClient: master,
+		readAhead:    DefaultReadAheadRanges,
+		writeRetries: DefaultWriteRetries,
+
+		readRangeSize:   DefaultReadRangeSize,
+		readAheadBudget: newReadAheadBudget(DefaultMaxReadAheadBytes),
+
+		uploadConcurrency: DefaultUploadConcurrency,
+		inFlight:          make(chan struct{}, DefaultMaxInFlightBytes/streamChunkSize),
+	}
+	c.chunkPool.New = newChunk
+	for _, opt := range opts {
+		opt(c)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// storeFile puts blocks on every one of replicas and returns the file's
+// metadata, with the replicas in the order given. A block's ID is its index.
+func storeFile(replicas []*fakeWorker, blocks ...[]byte) *coordinatorv1.GetFileMetadataResponse {
+	resp := &coordinatorv1.GetFileMetadataResponse{
+		Locations: make(map[string]*commonv1.BlockLocation),
+		Replicas:  make(map[string]*coordinatorv1.BlockReplicas),
+	}
+	for i, data := range blocks {
+		blockID := fmt.Sprintf("block-%d", i)
+		resp.Blocks = append(resp.Blocks, &commonv1.BlockInfo{
+			BlockId:  blockID,
+			Size:     int64(len(data)),
+			Checksum: int64(crc32.ChecksumIEEE(data)),
+		})
+		set := &coordinatorv1.BlockReplicas{}
+		for _, w := range replicas {
+			w.mu.Lock()
+			w.blocks[blockID] = data
+			w.mu.Unlock()
+			set.Locations = append(set.Locations, w.location(blockID))
+		}
+		resp.Replicas[blockID] = set
+		resp.Locations[blockID] = set.Locations[0]
+	}
+	return resp
+}
+
+// eventually fails the test unless cond holds within a few seconds.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal(msg)
+}
@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
 	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -16,9 +21,23 @@ type Client interface {
 	Create(ctx context.Context, path string, opts ...CreateOption) (File, error)
 	Open(ctx context.Context, path string) (File, error)
 	Delete(ctx context.Context, path string) error
+	RemoveAll(ctx context.Context, path string) error
 	Rename(ctx context.Context, oldPath, newPath string) error
 	MkdirAll(ctx context.Context, path string) error
 	List(ctx context.Context, path string) ([]string, error)
+	Stat(ctx context.Context, path string) (FileInfo, error)
+	ReadDir(ctx context.Context, path string) ([]FileInfo, error)
+	ListFiles(ctx context.Context, projectID, prefix string) ([]FileInfo, error)
+	ListFilesAfter(ctx context.Context, projectID, prefix, startAfter string, limit int) ([]FileInfo, bool, error)
+	Concat(ctx context.Context, target string, sources []string, opts ...CreateOption) error
+	Checksum(ctx context.Context, path string) (FileChecksum, error)
+	SetStoragePolicy(ctx context.Context, path, policy string) error
+	Chmod(ctx context.Context, path string, mode fs.FileMode) error
+	Chown(ctx context.Context, path, owner, group string) error
+	Chtimes(ctx context.Context, path string, atime, mtime time.Time) error
+	SetXAttr(ctx context.Context, path, name, value string) error
+	RemoveXAttr(ctx context.Context, path, name string) error
+	Watch(ctx context.Context, prefix string, fromTxID int64) (Watcher, error)
 	Close() error
 }
 
@@ -29,14 +48,36 @@ type File interface {
 	io.Closer
 	Sync() error
 	Stat() (FileInfo, error)
+	// Abort discards a file being written: uploads stop, the blocks stored
+	// so far are abandoned and nothing is committed. On a reader it is Close.
+	Abort() error
 }
 
 type FileInfo struct {
-	Name    string
-	Size    int64
-	IsDir   bool
+	Name  string
+	Size  int64
+	IsDir bool
+	// ModTime is the last modification time in Unix nanoseconds, 0 if unknown.
 	ModTime int64
-	Blocks  []BlockMetadata
+	// Generation identifies the version of a file, for IfGenerationMatch.
+	// It is 0 for directories.
+	Generation int64
+	// BlockSize and Replication are the file's settings, 0 if unknown.
+	BlockSize   int64
+	Replication int
+	// StoragePolicy is the policy in effect for the path, where known.
+	StoragePolicy string
+	Blocks        []BlockMetadata
+
+	// AccessTime and ChangeTime are the last read and the last attribute
+	// change in Unix nanoseconds, 0 if unknown.
+	AccessTime int64
+	ChangeTime int64
+	// Mode holds the permission bits, Owner and Group who they apply to.
+	Mode   fs.FileMode
+	Owner  string
+	Group  string
+	XAttrs map[string]string
 }
 
 type BlockMetadata struct {
@@ -44,26 +85,238 @@ type BlockMetadata struct {
 	Size     int64
 	WorkerId string
 	Address  string
+	// Checksum is the CRC-32 (IEEE) of the block, 0 if unknown.
+	Checksum uint32
+	// Packed is set when the file is packed with other small files into a
+	// container block. Its data is then Size bytes of the block from Offset
+	// on, and Checksum covers those only.
+	Packed bool
+	Offset int64
+	// ContentHash is the hex SHA-256 of blocks written with WithDedup.
+	ContentHash string
+}
+
+// FileChecksum is a whole-file checksum. It does not depend on the file's
+// block layout, so it matches a CRC-32 (IEEE) computed over a local copy.
+type FileChecksum struct {
+	Algorithm string
+	Checksum  uint32
+	Length    int64
 }
 
 type dfsClient struct {
 	masterURL    string
-	masterConn   *grpc.ClientConn
+	masterConn   *MountConn
 	masterClient coordinatorv1.CoordinatorServiceClient
 	workerConns  sync.Map
+
+	// clientHost is sent to the master so it can order replicas by proximity.
+	clientHost string
+	// hedgeThreshold enables hedged reads when > 0.
+	hedgeThreshold time.Duration
+	// readRangeSize is how much of a block a reader fetches at once.
+	readRangeSize int64
+	// readAhead is the number of ranges a reader prefetches past the current
+	// one, within readAheadBudget.
+	readAhead       int
+	readAheadBudget *readAheadBudget
+	blockCache      *blockCache
+	// writeRetries bounds how often a writer re-allocates a block after a
+	// failed upload.
+	writeRetries int
+	// uploadConcurrency is the number of blocks a writer uploads at once.
+	uploadConcurrency int
+	// inFlight holds one token per chunk buffered by any writer of this client.
+	inFlight chan struct{}
+	// chunkPool holds *[]byte chunk buffers.
+	chunkPool sync.Pool
+	// openUploads are the blocks of all writers that still take chunks. A
+	// writer waiting for the in-flight budget seals them, so idle writers
+	// cannot hold it forever.
+	openMu      sync.Mutex
+	openUploads map[*blockUpload]struct{}
+	// creds secure the master and worker connections; plaintext when nil.
+	creds credentials.TransportCredentials
+	// staleReads lets standby masters serve namespace reads.
+	staleReads bool
+	// mounts routes paths to master groups other than masterAddr's.
+	mounts *MountTable
+}
+
+// ClientOption configures optional client behaviour in NewClient.
+type ClientOption func(*dfsClient)
+
+// WithHedgedReads makes readers request a block from a second replica when the
+// first has not answered within threshold, and use whichever answers first.
+func WithHedgedReads(threshold time.Duration) ClientOption {
+	return func(c *dfsClient) { c.hedgeThreshold = threshold }
+}
+
+// WithReadRangeSize sets how many bytes of a block a reader fetches, caches
+// and reads ahead at once.
+func WithReadRangeSize(n int64) ClientOption {
+	return func(c *dfsClient) { c.readRangeSize = max(n, 1) }
+}
+
+// WithReadAhead sets how many ranges past the current one a reader fetches in
+// the background. Zero disables read-ahead.
+func WithReadAhead(ranges int) ClientOption {
+	return func(c *dfsClient) { c.readAhead = max(ranges, 0) }
+}
+
+// WithMaxReadAheadBytes caps the memory all readers of the client may hold in
+// read-aheads. Readers over the cap fetch ranges when they reach them.
+func WithMaxReadAheadBytes(n int64) ClientOption {
+	return func(c *dfsClient) { c.readAheadBudget = newReadAheadBudget(n) }
+}
+
+// WithBlockCache enables an LRU cache of the ranges readers fetch, shared by
+// all readers of the client and bounded by maxBytes.
+func WithBlockCache(maxBytes int64) ClientOption {
+	return func(c *dfsClient) { c.blockCache = newBlockCache(maxBytes) }
+}
+
+// WithWriteRetries sets how many times a writer retries a block on a fresh
+// worker after an upload fails. Zero disables retries.
+func WithWriteRetries(n int) ClientOption {
+	return func(c *dfsClient) { c.writeRetries = n }
+}
+
+// WithUploadConcurrency sets how many blocks each writer uploads in parallel.
+func WithUploadConcurrency(n int) ClientOption {
+	return func(c *dfsClient) { c.uploadConcurrency = max(n, 1) }
+}
+
+// WithMaxInFlightBytes caps the memory all writers of the client may hold in
+// unacknowledged chunks. It is rounded down to whole 2MB chunks, minimum one.
+// Each open writer also holds the chunk it is filling.
+func WithMaxInFlightBytes(n int64) ClientOption {
+	return func(c *dfsClient) { c.inFlight = make(chan struct{}, max(n/streamChunkSize, 1)) }
+}
+
+// WithClientHost overrides the host or node name reported to the master for
+// replica ordering. It defaults to $NODE_NAME, then the hostname.
+func WithClientHost(host string) ClientOption {
+	return func(c *dfsClient) { c.clientHost = host }
+}
+
+// WithTransportCredentials secures the connections to the master and the
+// workers, e.g. with the mutual TLS credentials of pkg/tlsconfig.
+func WithTransportCredentials(creds credentials.TransportCredentials) ClientOption {
+	return func(c *dfsClient) { c.creds = creds }
+}
+
+// WithStaleReads sends namespace reads to standby masters that allow them,
+// taking load off the leader. Reads still observe the client's own writes.
+func WithStaleReads() ClientOption {
+	return func(c *dfsClient) { c.staleReads = true }
+}
+
+// WithMountTable routes the paths of table's mounts to their master groups.
+// Without it the mount table is read from $DFS_MOUNT_TABLE, in the format of
+// ParseMountTable.
+func WithMountTable(table *MountTable) ClientOption {
+	return func(c *dfsClient) { c.mounts = table }
 }
 
-func NewClient(masterAddr string) (Client, error) {
-	conn, err := grpc.NewClient(masterAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewClient returns a client of the DFS whose masters are at masterAddr, a
+// comma separated list. Calls follow the leader across failovers.
+func NewClient(masterAddr string, opts ...ClientOption) (Client, error) {
+	c := &dfsClient{
+		masterURL:    masterAddr,
+		clientHost:   os.Getenv("NODE_NAME"),
+		readAhead:    DefaultReadAheadRanges,
+		writeRetries: DefaultWriteRetries,
+
+		readRangeSize:   DefaultReadRangeSize,
+		readAheadBudget: newReadAheadBudget(DefaultMaxReadAheadBytes),
+
+		uploadConcurrency: DefaultUploadConcurrency,
+		inFlight:          make(chan struct{}, DefaultMaxInFlightBytes/streamChunkSize),
+	}
+	c.chunkPool.New = newChunk
+	if c.clientHost == "" {
+		c.clientHost, _ = os.Hostname()
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.mounts == nil {
+		mounts, err := ParseMountTable(os.Getenv("DFS_MOUNT_TABLE"))
+		if err != nil {
+			return nil, fmt.Errorf("DFS_MOUNT_TABLE: %w", err)
+		}
+		c.mounts = mounts
+	}
+	conn, err := DialMounts(ParseMasterAddrs(masterAddr), c.mounts, c.dialOptions()...)
 	if err != nil {
 		return nil, err
 	}
+	conn.SetStaleReads(c.staleReads)
+	c.masterConn = conn
+	c.masterClient = coordinatorv1.NewCoordinatorServiceClient(conn)
 
-	return &dfsClient{
-		masterURL:    masterAddr,
-		masterConn:   conn,
-		masterClient: coordinatorv1.NewCoordinatorServiceClient(conn),
-	}, nil
+	return c, nil
+}
+
+func (c *dfsClient) dialOptions() []grpc.DialOption {
+	creds := c.creds
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+}
+
+func newChunk() any {
+	chunk := make([]byte, 0, streamChunkSize)
+	return &chunk
+}
+
+func (c *dfsClient) getChunk() []byte {
+	return (*c.chunkPool.Get().(*[]byte))[:0]
+}
+
+func (c *dfsClient) putChunk(chunk []byte) {
+	chunk = chunk[:0]
+	c.chunkPool.Put(&chunk)
+}
+
+// releaseChunk returns a chunk buffer to the pool and frees its in-flight slot.
+func (c *dfsClient) releaseChunk(chunk []byte) {
+	c.putChunk(chunk)
+	<-c.inFlight
+}
+
+func (c *dfsClient) openUpload(b *blockUpload) {
+	c.openMu.Lock()
+	defer c.openMu.Unlock()
+	if c.openUploads == nil {
+		c.openUploads = make(map[*blockUpload]struct{})
+	}
+	c.openUploads[b] = struct{}{}
+}
+
+func (c *dfsClient) closeUpload(b *blockUpload) {
+	c.openMu.Lock()
+	defer c.openMu.Unlock()
+	delete(c.openUploads, b)
+}
+
+// sealOpenUploads seals the open blocks of every writer, so their chunks are
+// stored and their in-flight slots freed even if their writers are idle.
+func (c *dfsClient) sealOpenUploads() {
+	c.openMu.Lock()
+	open := make([]*blockUpload, 0, len(c.openUploads))
+	for b := range c.openUploads {
+		open = append(open, b)
+	}
+	c.openMu.Unlock()
+
+	for _, b := range open {
+		b.seal()
+	}
 }
 
 func (c *dfsClient) getWorkerClient(addr string) (datanodev1.DataNodeServiceClient, error) {
@@ -71,7 +324,7 @@ func (c *dfsClient) getWorkerClient(addr string) (datanodev1.DataNodeServiceClie
 		return datanodev1.NewDataNodeServiceClient(conn.(*grpc.ClientConn)), nil
 	}
 
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(addr, c.dialOptions()...)
 	if err != nil {
 		return nil, err
 	}
@@ -95,16 +348,188 @@ func (c *dfsClient) List(ctx context.Context, path string) ([]string, error) {
 	return resp.FilePaths, nil
 }
 
+// Stat describes the file or directory at path, a full namespace path.
+func (c *dfsClient) Stat(ctx context.Context, path string) (FileInfo, error) {
+	resp, err := c.masterClient.GetFileInfo(ctx, &coordinatorv1.GetFileInfoRequest{Path: path})
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return fileInfoFromStatus(resp.Status), nil
+}
+
+// ReadDir lists the direct children of the directory at path, sorted by name.
+func (c *dfsClient) ReadDir(ctx context.Context, path string) ([]FileInfo, error) {
+	resp, err := c.masterClient.ListDirectory(ctx, &coordinatorv1.ListDirectoryRequest{Path: path})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]FileInfo, 0, len(resp.Entries))
+	for _, st := range resp.Entries {
+		infos = append(infos, fileInfoFromStatus(st))
+	}
+	return infos, nil
+}
+
+func fileInfoFromStatus(st *coordinatorv1.FileStatus) FileInfo {
+	return FileInfo{
+		Name:          st.Path,
+		Size:          st.Size,
+		IsDir:         st.IsDir,
+		ModTime:       st.ModTime,
+		Generation:    st.Generation,
+		BlockSize:     st.BlockSize,
+		Replication:   int(st.Replication),
+		StoragePolicy: st.StoragePolicy,
+
+		AccessTime: st.AccessTime,
+		ChangeTime: st.ChangeTime,
+		Mode:       fs.FileMode(st.Mode),
+		Owner:      st.Owner,
+		Group:      st.Group,
+		XAttrs:     st.Xattrs,
+	}
+}
+
+// ListFiles returns every file of a project whose path relative to the project
+// starts with prefix, sorted by path.
+func (c *dfsClient) ListFiles(ctx context.Context, projectID, prefix string) ([]FileInfo, error) {
+	infos, _, err := c.ListFilesAfter(ctx, projectID, prefix, "", 0)
+	return infos, err
+}
+
+// ListFilesAfter is ListFiles for one page: at most limit files whose path
+// relative to the project sorts after startAfter. It reports whether more
+// files follow. A limit of 0 lists them all.
+func (c *dfsClient) ListFilesAfter(ctx context.Context, projectID, prefix, startAfter string, limit int) ([]FileInfo, bool, error) {
+	resp, err := c.masterClient.ListFiles(ctx, &coordinatorv1.ListFilesRequest{
+		ProjectId:       projectID,
+		DirectoryPrefix: prefix,
+		StartAfter:      startAfter,
+		Limit:           int32(limit),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	infos := make([]FileInfo, 0, len(resp.Files))
+	for _, st := range resp.Files {
+		infos = append(infos, fileInfoFromStatus(st))
+	}
+	return infos, resp.Truncated, nil
+}
+
+// Concat creates target from the blocks of sources, in order, and removes the
+// sources. No data is copied.
+func (c *dfsClient) Concat(ctx context.Context, target string, sources []string, opts ...CreateOption) error {
+	w := &writer{projectID: "default", format: "bin"}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	_, err := c.masterClient.ConcatFiles(ctx, &coordinatorv1.ConcatFilesRequest{
+		ProjectId:         w.projectID,
+		OwnerId:           w.ownerID,
+		TargetPath:        target,
+		SourcePaths:       sources,
+		FileFormat:        w.format,
+		Mode:              w.mode,
+		IfGenerationMatch: w.ifGenerationMatch,
+	})
+	return err
+}
+
 func (c *dfsClient) Delete(ctx context.Context, path string) error {
-	return fmt.Errorf("not implemented")
+	_, err := c.masterClient.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: path})
+	return err
+}
+
+// RemoveAll deletes a file, or a directory and everything below it.
+func (c *dfsClient) RemoveAll(ctx context.Context, path string) error {
+	_, err := c.masterClient.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: path, Recursive: true})
+	return err
 }
 
+// Rename moves a file or directory tree. newPath must not exist.
 func (c *dfsClient) Rename(ctx context.Context, oldPath, newPath string) error {
-	return fmt.Errorf("not implemented")
+	_, err := c.masterClient.Rename(ctx, &coordinatorv1.RenameRequest{SrcPath: oldPath, DstPath: newPath})
+	return err
 }
 
 func (c *dfsClient) MkdirAll(ctx context.Context, path string) error {
-	return nil
+	_, err := c.masterClient.Mkdirs(ctx, &coordinatorv1.MkdirsRequest{Path: path})
+	return err
+}
+
+// Checksum returns the file's checksum as combined by the master from its
+// block checksums, without reading any data.
+func (c *dfsClient) Checksum(ctx context.Context, path string) (FileChecksum, error) {
+	resp, err := c.masterClient.GetFileChecksum(ctx, &coordinatorv1.GetFileChecksumRequest{FilePath: path})
+	if err != nil {
+		return FileChecksum{}, err
+	}
+	return FileChecksum{Algorithm: resp.Algorithm, Checksum: resp.Checksum, Length: resp.Length}, nil
+}
+
+// SetStoragePolicy sets the storage policy of a file or directory, a full
+// namespace path; an empty policy makes it inherit its parent's again. The
+// master moves existing blocks to matching workers in the background.
+func (c *dfsClient) SetStoragePolicy(ctx context.Context, path, policy string) error {
+	_, err := c.masterClient.SetStoragePolicy(ctx, &coordinatorv1.SetStoragePolicyRequest{
+		Path:          path,
+		StoragePolicy: strings.ToUpper(policy),
+	})
+	return err
+}
+
+// Chmod sets the permission bits of a file or directory. Only its owner may.
+func (c *dfsClient) Chmod(ctx context.Context, path string, mode fs.FileMode) error {
+	perm := uint32(mode.Perm())
+	_, err := c.masterClient.SetAttr(ctx, &coordinatorv1.SetAttrRequest{Path: path, Mode: &perm})
+	return err
+}
+
+// Chown sets the owner and group of a file or directory; an empty owner or
+// group is left as it is. Only superusers may change the owner, and owners
+// may only hand a path to a group they are in.
+func (c *dfsClient) Chown(ctx context.Context, path, owner, group string) error {
+	req := &coordinatorv1.SetAttrRequest{Path: path}
+	if owner != "" {
+		req.Owner = &owner
+	}
+	if group != "" {
+		req.Group = &group
+	}
+	_, err := c.masterClient.SetAttr(ctx, req)
+	return err
+}
+
+// Chtimes sets the access and modification times of a file or directory, like
+// os.Chtimes; a zero time is left as it is.
+func (c *dfsClient) Chtimes(ctx context.Context, path string, atime, mtime time.Time) error {
+	req := &coordinatorv1.SetAttrRequest{Path: path}
+	if !atime.IsZero() {
+		ns := atime.UnixNano()
+		req.AccessTime = &ns
+	}
+	if !mtime.IsZero() {
+		ns := mtime.UnixNano()
+		req.ModTime = &ns
+	}
+	_, err := c.masterClient.SetAttr(ctx, req)
+	return err
+}
+
+// SetXAttr sets an extended attribute of a file or directory.
+func (c *dfsClient) SetXAttr(ctx context.Context, path, name, value string) error {
+	_, err := c.masterClient.SetXAttr(ctx, &coordinatorv1.SetXAttrRequest{Path: path, Name: name, Value: value})
+	return err
+}
+
+// RemoveXAttr removes an extended attribute of a file or directory.
+func (c *dfsClient) RemoveXAttr(ctx context.Context, path, name string) error {
+	_, err := c.masterClient.SetXAttr(ctx, &coordinatorv1.SetXAttrRequest{Path: path, Name: name, Remove: true})
+	return err
 }
 
 func (c *dfsClient) Close() error {
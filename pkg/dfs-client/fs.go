@@ -0,0 +1,217 @@
+package dfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FS exposes a subtree of the DFS namespace as a read-only fs.FS, so standard
+// library tooling such as fs.WalkDir and http.FS can work against the lake.
+type FS struct {
+	ctx    context.Context
+	client Client
+	root   string
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// NewFS returns an FS rooted at root, e.g. a project ID. Every call made
+// through it uses ctx.
+func NewFS(ctx context.Context, client Client, root string) *FS {
+	return &FS{ctx: ctx, client: client, root: path.Clean(root)}
+}
+
+func (f *FS) fullPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.root, name), nil
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return nil, withOp(err, "open")
+	}
+
+	if info.IsDir() {
+		return &dirFile{fsys: f, name: name, info: info.(fileInfo)}, nil
+	}
+
+	full, _ := f.fullPath("open", name)
+	file, err := f.client.Open(f.ctx, full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &fsFile{File: file, info: info.(fileInfo)}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	full, err := f.fullPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.client.Stat(f.ctx, full)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fileInfo{info}, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := f.fullPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := f.client.ReadDir(f.ctx, full)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fileInfo{info})
+	}
+	return entries, nil
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, withOp(err, "readfile")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDir}
+	}
+
+	data := make([]byte, info.Size())
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+var errIsDir = errors.New("is a directory")
+
+// pathError maps coordinator errors onto the io/fs sentinel errors.
+func pathError(op, name string, err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		err = fs.ErrNotExist
+	case codes.PermissionDenied:
+		err = fs.ErrPermission
+	case codes.InvalidArgument:
+		err = fs.ErrInvalid
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func withOp(err error, op string) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: op, Path: pe.Path, Err: pe.Err}
+	}
+	return err
+}
+
+// fileInfo adapts FileInfo to fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	info FileInfo
+}
+
+func (fi fileInfo) Name() string { return path.Base(fi.info.Name) }
+func (fi fileInfo) Size() int64  { return fi.info.Size }
+func (fi fileInfo) IsDir() bool  { return fi.info.IsDir }
+func (fi fileInfo) Sys() any     { return fi.info }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	perm := fi.info.Mode.Perm()
+	if perm == 0 {
+		perm = 0o444
+		if fi.info.IsDir {
+			perm = 0o555
+		}
+	}
+	if fi.info.IsDir {
+		return fs.ModeDir | perm
+	}
+	return perm
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	if fi.info.ModTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, fi.info.ModTime)
+}
+
+func (fi fileInfo) Type() fs.FileMode          { return fi.Mode().Type() }
+func (fi fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// fsFile is an open DFS file. It keeps Seek from the underlying reader, which
+// http.FS needs to serve ranges.
+type fsFile struct {
+	File
+	info fileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// dirFile is an open directory; its entries are fetched on the first ReadDir.
+type dirFile struct {
+	fsys    *FS
+	name    string
+	info    fileInfo
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
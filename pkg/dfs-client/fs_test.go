@@ -0,0 +1,109 @@
+package dfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memClient is a Client over files held in memory, for the read-only calls
+// FS makes. Directories are implied by the file paths.
+type memClient struct {
+	Client
+	files map[string][]byte
+	mtime int64
+}
+
+func (c *memClient) Stat(ctx context.Context, p string) (FileInfo, error) {
+	if data, ok := c.files[p]; ok {
+		return FileInfo{Name: p, Size: int64(len(data)), ModTime: c.mtime}, nil
+	}
+	for name := range c.files {
+		if strings.HasPrefix(name, p+"/") {
+			return FileInfo{Name: p, IsDir: true, ModTime: c.mtime}, nil
+		}
+	}
+	return FileInfo{}, status.Errorf(codes.NotFound, "%s not found", p)
+}
+
+func (c *memClient) ReadDir(ctx context.Context, dir string) ([]FileInfo, error) {
+	if info, err := c.Stat(ctx, dir); err != nil {
+		return nil, err
+	} else if !info.IsDir {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a directory", dir)
+	}
+	seen := make(map[string]bool)
+	var infos []FileInfo
+	for name := range c.files {
+		rest, ok := strings.CutPrefix(name, dir+"/")
+		if !ok {
+			continue
+		}
+		child, _, _ := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		info, err := c.Stat(ctx, path.Join(dir, child))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (c *memClient) Open(ctx context.Context, p string) (File, error) {
+	data, ok := c.files[p]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", p)
+	}
+	return &memFile{Reader: bytes.NewReader(data)}, nil
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (f *memFile) Write([]byte) (int, error) { return 0, errors.New("read-only") }
+func (f *memFile) Sync() error               { return nil }
+func (f *memFile) Close() error              { return nil }
+func (f *memFile) Abort() error              { return nil }
+func (f *memFile) Stat() (FileInfo, error)   { return FileInfo{Size: f.Size()}, nil }
+
+func TestFS(t *testing.T) {
+	client := &memClient{
+		files: map[string][]byte{
+			"proj/readme.txt":           []byte("hello"),
+			"proj/raw/2024/a.avro":      []byte("aaaa"),
+			"proj/raw/2024/b.avro":      []byte("bbbbbbbb"),
+			"proj/raw/empty.parquet":    nil,
+			"proj/clean/part-0.parquet": bytes.Repeat([]byte("x"), 1<<10),
+			"other/secret.txt":          []byte("not below the root"),
+		},
+		mtime: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano(),
+	}
+	fsys := NewFS(context.Background(), client, "proj")
+
+	if err := fstest.TestFS(fsys, "readme.txt", "raw/2024/a.avro", "raw/2024/b.avro", "raw/empty.parquet", "clean/part-0.parquet"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Stat(fsys, "../other/secret.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("stat outside the root: %v, want %v", err, fs.ErrInvalid)
+	}
+	if _, err := fs.ReadFile(fsys, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read of a missing file: %v, want %v", err, fs.ErrNotExist)
+	}
+}
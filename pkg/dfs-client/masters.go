@@ -0,0 +1,356 @@
+package dfs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/razvanmarinn/datalake/pkg/dfsmeta"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// FailoverTimeout bounds how long a call keeps looking for the leader
+	// while masters are unreachable or electing one.
+	FailoverTimeout = 30 * time.Second
+
+	failoverBackoff    = 100 * time.Millisecond
+	maxFailoverBackoff = 2 * time.Second
+)
+
+// staleReadMethods are the coordinator RPCs standbys may serve.
+var staleReadMethods = map[string]bool{
+	coordinatorv1.CoordinatorService_ListFiles_FullMethodName:       true,
+	coordinatorv1.CoordinatorService_GetFileInfo_FullMethodName:     true,
+	coordinatorv1.CoordinatorService_ListDirectory_FullMethodName:   true,
+	coordinatorv1.CoordinatorService_GetFileMetadata_FullMethodName: true,
+}
+
+// readMethods are the coordinator RPCs that change nothing. Only they are
+// sent again after failing in transit, when the master may have applied them.
+var readMethods = map[string]bool{
+	coordinatorv1.CoordinatorService_ListFiles_FullMethodName:       true,
+	coordinatorv1.CoordinatorService_GetFileInfo_FullMethodName:     true,
+	coordinatorv1.CoordinatorService_ListDirectory_FullMethodName:   true,
+	coordinatorv1.CoordinatorService_GetFileMetadata_FullMethodName: true,
+	coordinatorv1.CoordinatorService_GetFileChecksum_FullMethodName: true,
+}
+
+// MasterConn is a connection to a group of masters that sends every call to
+// the leader. Standbys answer with FailedPrecondition and a NotLeader detail
+// naming the leader, which the call is then retried against. When there are
+// several masters, unreachable ones are skipped as well. Mutations are only
+// sent once a connection is up, and are not retried after a failure in
+// transit, which may have reached the master.
+//
+// With stale reads enabled, namespace reads go to the standbys first. The
+// highest TxID seen in any response is sent along as the minimum, so reads
+// observe this connection's own writes; a standby that is behind or
+// unreachable is skipped in favour of the leader.
+//
+// It implements grpc.ClientConnInterface, so any coordinator client can be
+// built on it.
+type MasterConn struct {
+	dialOpts []grpc.DialOption
+
+	mu     sync.Mutex
+	addrs  []string
+	conns  map[string]*grpc.ClientConn
+	leader string
+	// nextStandby is where the round-robin over standbys continues.
+	nextStandby int
+
+	staleReads atomic.Bool
+	txID       atomic.Int64
+}
+
+// ParseMasterAddrs splits a comma separated list of master addresses.
+func ParseMasterAddrs(list string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" && !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// DialMasters returns a MasterConn over addrs. Like grpc.NewClient it does
+// not connect until the first call.
+func DialMasters(addrs []string, dialOpts ...grpc.DialOption) (*MasterConn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no master addresses")
+	}
+	m := &MasterConn{
+		dialOpts: dialOpts,
+		addrs:    slices.Clone(addrs),
+		conns:    make(map[string]*grpc.ClientConn),
+		leader:   addrs[0],
+	}
+	if _, err := m.conn(m.leader); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetStaleReads enables or disables serving namespace reads from standbys.
+func (m *MasterConn) SetStaleReads(enabled bool) {
+	m.staleReads.Store(enabled)
+}
+
+// TxID returns the highest TxID seen in a response, the minimum that stale
+// reads require.
+func (m *MasterConn) TxID() int64 {
+	return m.txID.Load()
+}
+
+// Leader returns the address calls are currently sent to.
+func (m *MasterConn) Leader() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+func (m *MasterConn) conn(addr string) (*grpc.ClientConn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cc, ok := m.conns[addr]; ok {
+		return cc, nil
+	}
+	cc, err := grpc.NewClient(addr, m.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	m.conns[addr] = cc
+	return cc, nil
+}
+
+// Invoke implements grpc.ClientConnInterface.
+func (m *MasterConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	if m.staleReads.Load() && staleReadMethods[method] {
+		if addr := m.standby(); addr != "" {
+			err := m.invokeStale(ctx, addr, method, args, reply, opts...)
+			if !standbyDeclined(err) {
+				return err
+			}
+		}
+	}
+
+	deadline := time.Now().Add(FailoverTimeout)
+	backoff := failoverBackoff
+	read := readMethods[method]
+	addr := m.Leader()
+	for {
+		var err error
+		sent := true
+		if read {
+			err = m.invoke(ctx, addr, method, args, reply, opts...)
+		} else {
+			sent, err = m.invokeMutation(ctx, addr, method, args, reply, opts...)
+		}
+
+		next, redirected, retry := m.failover(addr, err, read || !sent)
+		if !retry || ctx.Err() != nil || time.Now().After(deadline) {
+			return err
+		}
+		if !redirected {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxFailoverBackoff)
+		}
+		addr = next
+	}
+}
+
+// invoke calls method on addr and records the TxID of the response.
+func (m *MasterConn) invoke(ctx context.Context, addr, method string, args, reply any, opts ...grpc.CallOption) error {
+	cc, err := m.conn(addr)
+	if err != nil {
+		return err
+	}
+	var header metadata.MD
+	err = cc.Invoke(ctx, method, args, reply, append(opts, grpc.Header(&header))...)
+	if values := header.Get(dfsmeta.TxIDHeader); len(values) > 0 {
+		if txID, parseErr := strconv.ParseInt(values[0], 10, 64); parseErr == nil {
+			m.observeTxID(txID)
+		}
+	}
+	return err
+}
+
+// invokeMutation is invoke for calls that change the namespace. It waits for
+// the connection to addr before sending, so sent is false when the call is
+// known not to have reached the master.
+func (m *MasterConn) invokeMutation(ctx context.Context, addr, method string, args, reply any, opts ...grpc.CallOption) (sent bool, err error) {
+	cc, err := m.conn(addr)
+	if err != nil {
+		return false, err
+	}
+	if err := connect(ctx, cc); err != nil {
+		return false, err
+	}
+	return true, m.invoke(ctx, addr, method, args, reply, opts...)
+}
+
+// connect waits until cc is ready, failing with Unavailable once it has
+// failed to connect.
+func connect(ctx context.Context, cc *grpc.ClientConn) error {
+	cc.Connect()
+	for {
+		state := cc.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return status.Errorf(codes.Unavailable, "master %s: connection %s", cc.Target(), state)
+		}
+		if !cc.WaitForStateChange(ctx, state) {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// invokeStale asks the standby at addr to serve a read at least as recent as
+// the latest TxID seen.
+func (m *MasterConn) invokeStale(ctx context.Context, addr, method string, args, reply any, opts ...grpc.CallOption) error {
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		dfsmeta.AllowStaleHeader, "true",
+		dfsmeta.MinTxIDHeader, strconv.FormatInt(m.txID.Load(), 10))
+	return m.invoke(ctx, addr, method, args, reply, opts...)
+}
+
+func (m *MasterConn) observeTxID(txID int64) {
+	for {
+		seen := m.txID.Load()
+		if txID <= seen || m.txID.CompareAndSwap(seen, txID) {
+			return
+		}
+	}
+}
+
+// standby returns the next master other than the leader, or "" if there is
+// none.
+func (m *MasterConn) standby() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for range m.addrs {
+		m.nextStandby = (m.nextStandby + 1) % len(m.addrs)
+		if addr := m.addrs[m.nextStandby]; addr != m.leader {
+			return addr
+		}
+	}
+	return ""
+}
+
+// NewStream implements grpc.ClientConnInterface. Streams are opened on the
+// current leader and are not retried here.
+func (m *MasterConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cc, err := m.conn(m.Leader())
+	if err != nil {
+		return nil, err
+	}
+	return cc.NewStream(ctx, desc, method, opts...)
+}
+
+// streamFailover is failover for a stream that failed with err on the
+// leader. Streams are reopened by their owner, on the new leader guess.
+func (m *MasterConn) streamFailover(err error) (redirected, retry bool) {
+	_, redirected, retry = m.failover(m.Leader(), err, true)
+	return redirected, retry
+}
+
+// failover decides where a call that failed with err on addr goes next.
+// redirected is set when a standby named a leader other than addr, which is
+// tried right away. Standbys reject calls without applying them, so those
+// are always retried; unreachable masters only when resend allows it.
+func (m *MasterConn) failover(addr string, err error, resend bool) (next string, redirected, retry bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return "", false, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch st.Code() {
+	case codes.FailedPrecondition:
+		hint, isStandby := notLeaderHint(st)
+		if !isStandby {
+			return "", false, false
+		}
+		if hint != "" && hint != addr {
+			if !slices.Contains(m.addrs, hint) {
+				m.addrs = append(m.addrs, hint)
+			}
+			m.leader = hint
+			return hint, true, true
+		}
+		// The standby does not know the leader yet: an election is under way.
+		return m.nextAddr(addr), false, true
+	case codes.Unavailable:
+		if !resend || len(m.addrs) < 2 {
+			return "", false, false
+		}
+		return m.nextAddr(addr), false, true
+	}
+	return "", false, false
+}
+
+// nextAddr returns the master after addr, making it the leader guess. Callers
+// hold m.mu.
+func (m *MasterConn) nextAddr(addr string) string {
+	i := slices.Index(m.addrs, addr)
+	m.leader = m.addrs[(i+1)%len(m.addrs)]
+	return m.leader
+}
+
+// standbyDeclined reports whether a stale read failed because the standby
+// would not or could not serve it, rather than on its merits.
+func standbyDeclined(err error) bool {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unavailable:
+		return true
+	case codes.FailedPrecondition:
+		_, isStandby := notLeaderHint(st)
+		return isStandby
+	}
+	return false
+}
+
+func notLeaderHint(st *status.Status) (string, bool) {
+	for _, detail := range st.Details() {
+		if nl, ok := detail.(*coordinatorv1.NotLeader); ok {
+			return nl.LeaderAddress, true
+		}
+	}
+	return "", false
+}
+
+// Close closes the connections to all masters.
+func (m *MasterConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for addr, cc := range m.conns {
+		errs = append(errs, cc.Close())
+		delete(m.conns, addr)
+	}
+	return errors.Join(errs...)
+}
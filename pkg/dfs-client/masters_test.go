@@ -0,0 +1,179 @@
+package dfs
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeMaster answers DeleteFile, GetFileInfo, Rename and ConcatFiles with
+// err, or successfully when err is nil, counting the calls it receives.
+type fakeMaster struct {
+	coordinatorv1.UnimplementedCoordinatorServiceServer
+	err   error
+	calls atomic.Int32
+	// files are the sorted paths ListFiles lists.
+	files []string
+}
+
+func (f *fakeMaster) ListFiles(ctx context.Context, req *coordinatorv1.ListFilesRequest) (*coordinatorv1.ListFilesResponse, error) {
+	f.calls.Add(1)
+	resp := &coordinatorv1.ListFilesResponse{}
+	for _, p := range f.files {
+		if p <= req.StartAfter {
+			continue
+		}
+		if req.Limit > 0 && len(resp.FilePaths) == int(req.Limit) {
+			resp.Truncated = true
+			break
+		}
+		resp.FilePaths = append(resp.FilePaths, p)
+		resp.Files = append(resp.Files, &coordinatorv1.FileStatus{Path: p})
+	}
+	return resp, nil
+}
+
+func (f *fakeMaster) DeleteFile(context.Context, *coordinatorv1.DeleteFileRequest) (*coordinatorv1.DeleteFileResponse, error) {
+	f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &coordinatorv1.DeleteFileResponse{}, nil
+}
+
+func (f *fakeMaster) GetFileInfo(context.Context, *coordinatorv1.GetFileInfoRequest) (*coordinatorv1.GetFileInfoResponse, error) {
+	f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &coordinatorv1.GetFileInfoResponse{Status: &coordinatorv1.FileStatus{}}, nil
+}
+
+func (f *fakeMaster) Rename(context.Context, *coordinatorv1.RenameRequest) (*coordinatorv1.RenameResponse, error) {
+	f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &coordinatorv1.RenameResponse{}, nil
+}
+
+func (f *fakeMaster) ConcatFiles(context.Context, *coordinatorv1.ConcatFilesRequest) (*coordinatorv1.ConcatFilesResponse, error) {
+	f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &coordinatorv1.ConcatFilesResponse{}, nil
+}
+
+// serve runs a server with the services register adds on a loopback port
+// until the test ends and returns its address.
+func serve(t *testing.T, register func(*grpc.Server)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func serveMaster(t *testing.T, f *fakeMaster) string {
+	return serve(t, func(s *grpc.Server) { coordinatorv1.RegisterCoordinatorServiceServer(s, f) })
+}
+
+// unreachableAddr returns a loopback address nothing listens on.
+func unreachableAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func notLeaderErr(t *testing.T, leader string) error {
+	t.Helper()
+	st, err := status.New(codes.FailedPrecondition, "not the leader").
+		WithDetails(&coordinatorv1.NotLeader{LeaderAddress: leader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func TestMasterConnFailover(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "shutting down")
+
+	tests := []struct {
+		name string
+		// The first master tried is down, fails with firstErr, or with
+		// redirect names the second as the leader.
+		firstDown bool
+		firstErr  error
+		redirect  bool
+		read      bool
+
+		wantCode   codes.Code
+		wantSecond bool
+	}{
+		{name: "mutation redirected by standby", redirect: true, wantCode: codes.OK, wantSecond: true},
+		{name: "mutation to unreachable master", firstDown: true, wantCode: codes.OK, wantSecond: true},
+		{name: "mutation failed in transit", firstErr: unavailable, wantCode: codes.Unavailable},
+		{name: "read failed in transit", firstErr: unavailable, read: true, wantCode: codes.OK, wantSecond: true},
+		{name: "read to unreachable master", firstDown: true, read: true, wantCode: codes.OK, wantSecond: true},
+		{name: "mutation rejected", firstErr: status.Error(codes.NotFound, "no such file"), wantCode: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := &fakeMaster{}
+			secondAddr := serveMaster(t, second)
+
+			first := &fakeMaster{err: tt.firstErr}
+			if tt.redirect {
+				first.err = notLeaderErr(t, secondAddr)
+			}
+			firstAddr := unreachableAddr(t)
+			if !tt.firstDown {
+				firstAddr = serveMaster(t, first)
+			}
+
+			conn, err := DialMasters([]string{firstAddr, secondAddr}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			client := coordinatorv1.NewCoordinatorServiceClient(conn)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if tt.read {
+				_, err = client.GetFileInfo(ctx, &coordinatorv1.GetFileInfoRequest{Path: "/a"})
+			} else {
+				_, err = client.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: "/a"})
+			}
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %v, want %v (err %v)", got, tt.wantCode, err)
+			}
+			if got := second.calls.Load() > 0; got != tt.wantSecond {
+				t.Errorf("second master called = %v, want %v", got, tt.wantSecond)
+			}
+			if !tt.firstDown && first.calls.Load() != 1 {
+				t.Errorf("first master called %d times, want 1", first.calls.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package dfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Mount serves the namespace below Prefix from the master group at Addrs, a
+// separate set of masters with a namespace and op log of its own. Groups
+// share the workers.
+type Mount struct {
+	Prefix string
+	Addrs  []string
+}
+
+// MountTable maps namespace paths to master groups. A path belongs to the
+// mount with the longest prefix containing it, and to the root group, the
+// masters a client is created with, when there is none.
+type MountTable struct {
+	// mounts is sorted by prefix, longest first.
+	mounts []Mount
+}
+
+// ParseMountTable parses mounts written as "prefix=addr,addr;prefix=addr",
+// where a prefix is a project ID or a longer namespace path.
+func ParseMountTable(spec string) (*MountTable, error) {
+	t := &MountTable{}
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		prefix, addrs, ok := strings.Cut(entry, "=")
+		prefix = cleanPath(prefix)
+		m := Mount{Prefix: prefix, Addrs: ParseMasterAddrs(addrs)}
+		if !ok || prefix == "." || len(m.Addrs) == 0 {
+			return nil, fmt.Errorf("invalid mount %q, want prefix=addr,...", entry)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("duplicate mount %q", prefix)
+		}
+		seen[prefix] = true
+		t.mounts = append(t.mounts, m)
+	}
+	sort.Slice(t.mounts, func(i, j int) bool { return len(t.mounts[i].Prefix) > len(t.mounts[j].Prefix) })
+	return t, nil
+}
+
+// Mounts returns the mounts of the table.
+func (t *MountTable) Mounts() []Mount {
+	if t == nil {
+		return nil
+	}
+	return append([]Mount(nil), t.mounts...)
+}
+
+// Lookup returns the mount p belongs to, and false for paths of the root
+// group.
+func (t *MountTable) Lookup(p string) (Mount, bool) {
+	if t == nil {
+		return Mount{}, false
+	}
+	p = cleanPath(p)
+	for _, m := range t.mounts {
+		if isUnder(p, m.Prefix) {
+			return m, true
+		}
+	}
+	return Mount{}, false
+}
+
+// below returns the mounts strictly below dir.
+func (t *MountTable) below(dir string) []Mount {
+	if t == nil {
+		return nil
+	}
+	var mounts []Mount
+	for _, m := range t.mounts {
+		if m.Prefix != dir && isUnder(m.Prefix, dir) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+func cleanPath(p string) string {
+	return path.Clean(strings.TrimPrefix(strings.TrimSpace(p), "/"))
+}
+
+func isUnder(p, dir string) bool {
+	return dir == "." || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// MountConn routes coordinator calls to the master group of the paths in
+// their request, so the clients built on it see one namespace. Listings
+// below a mount point include what the mounted groups hold; renames,
+// concats and compactions across groups fail with InvalidArgument, as do
+// renames and recursive deletes of directories with mount points below them.
+//
+// It implements grpc.ClientConnInterface. Streams carry their request only
+// after they are opened and so go to the root group; open them on Group
+// instead.
+type MountConn struct {
+	table *MountTable
+	root  *MasterConn
+	// groups holds a connection per master group, by address list.
+	groups map[string]*MasterConn
+}
+
+// DialMounts returns a MountConn with the root group at rootAddrs and the
+// groups of table.
+func DialMounts(rootAddrs []string, table *MountTable, dialOpts ...grpc.DialOption) (*MountConn, error) {
+	root, err := DialMasters(rootAddrs, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	m := &MountConn{table: table, root: root, groups: make(map[string]*MasterConn)}
+	for _, mount := range table.Mounts() {
+		key := strings.Join(mount.Addrs, ",")
+		if _, ok := m.groups[key]; ok {
+			continue
+		}
+		conn, err := DialMasters(mount.Addrs, dialOpts...)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("mount %s: %w", mount.Prefix, err)
+		}
+		m.groups[key] = conn
+	}
+	return m, nil
+}
+
+// Group returns the connection to the master group serving p.
+func (m *MountConn) Group(p string) *MasterConn {
+	mount, ok := m.table.Lookup(p)
+	if !ok {
+		return m.root
+	}
+	return m.groups[strings.Join(mount.Addrs, ",")]
+}
+
+// SetStaleReads enables or disables stale reads on every group.
+func (m *MountConn) SetStaleReads(enabled bool) {
+	m.root.SetStaleReads(enabled)
+	for _, conn := range m.groups {
+		conn.SetStaleReads(enabled)
+	}
+}
+
+// Invoke implements grpc.ClientConnInterface.
+func (m *MountConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	switch req := args.(type) {
+	case *coordinatorv1.ListFilesRequest:
+		return m.listFiles(ctx, method, req, reply.(*coordinatorv1.ListFilesResponse), opts...)
+	case *coordinatorv1.ListDirectoryRequest:
+		return m.listDirectory(ctx, method, req, reply.(*coordinatorv1.ListDirectoryResponse), opts...)
+	case *coordinatorv1.GetFileInfoRequest:
+		return m.getFileInfo(ctx, method, req, reply.(*coordinatorv1.GetFileInfoResponse), opts...)
+	case *coordinatorv1.RenameRequest:
+		if err := m.checkNoMountsBelow(req.SrcPath, req.DstPath); err != nil {
+			return err
+		}
+	case *coordinatorv1.DeleteFileRequest:
+		if req.Recursive {
+			if err := m.checkNoMountsBelow(req.FilePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	conn, err := m.route(requestPaths(args))
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implements grpc.ClientConnInterface on the root group.
+func (m *MountConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return m.root.NewStream(ctx, desc, method, opts...)
+}
+
+// route returns the group of paths, which must all be on the same one.
+func (m *MountConn) route(paths []string) (*MasterConn, error) {
+	if len(paths) == 0 {
+		return m.root, nil
+	}
+	conn := m.Group(paths[0])
+	for _, p := range paths[1:] {
+		if m.Group(p) != conn {
+			return nil, status.Errorf(codes.InvalidArgument, "%s and %s are on different mounts", paths[0], p)
+		}
+	}
+	return conn, nil
+}
+
+// checkNoMountsBelow fails for paths with mount points below them. Their
+// group only holds the part of the subtree outside the mounts, so moving or
+// deleting it there would leave the mounted parts behind.
+func (m *MountConn) checkNoMountsBelow(paths ...string) error {
+	for _, p := range nonEmpty(paths...) {
+		if mounts := m.table.below(cleanPath(p)); len(mounts) > 0 {
+			return status.Errorf(codes.InvalidArgument, "%s has mount point %s below it", p, mounts[0].Prefix)
+		}
+	}
+	return nil
+}
+
+// requestPaths returns the namespace paths a coordinator request touches.
+func requestPaths(req any) []string {
+	switch r := req.(type) {
+	case *coordinatorv1.AllocateBlockRequest:
+		return nonEmpty(firstNonEmpty(r.FilePath, r.ProjectId))
+	case *coordinatorv1.CommitFileRequest:
+		return nonEmpty(r.FilePath)
+	case *coordinatorv1.CommitCompactionRequest:
+		paths := nonEmpty(r.OldFilePaths...)
+		if r.NewFile != nil {
+			paths = append(nonEmpty(r.NewFile.FilePath), paths...)
+		}
+		return paths
+	case *coordinatorv1.GetFileMetadataRequest:
+		return nonEmpty(r.FilePath)
+	case *coordinatorv1.ReportBadReplicaRequest:
+		return nonEmpty(r.FilePath)
+	case *coordinatorv1.AbandonBlockRequest:
+		return nonEmpty(r.FilePath)
+	case *coordinatorv1.GetFileInfoRequest:
+		return nonEmpty(r.Path)
+	case *coordinatorv1.DeleteFileRequest:
+		return nonEmpty(r.FilePath)
+	case *coordinatorv1.ConcatFilesRequest:
+		return nonEmpty(append([]string{r.TargetPath}, r.SourcePaths...)...)
+	case *coordinatorv1.RenameRequest:
+		return nonEmpty(r.SrcPath, r.DstPath)
+	case *coordinatorv1.MkdirsRequest:
+		return nonEmpty(r.Path)
+	case *coordinatorv1.GetFileChecksumRequest:
+		return nonEmpty(r.FilePath)
+	case *coordinatorv1.SetStoragePolicyRequest:
+		return nonEmpty(r.Path)
+	case *coordinatorv1.SetAttrRequest:
+		return nonEmpty(r.Path)
+	case *coordinatorv1.SetXAttrRequest:
+		return nonEmpty(r.Path)
+	}
+	return nil
+}
+
+func nonEmpty(paths ...string) []string {
+	var out []string
+	for _, p := range paths {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// listFiles lists the files of the project on its group and on the groups
+// mounted below the listed directory.
+func (m *MountConn) listFiles(ctx context.Context, method string, req *coordinatorv1.ListFilesRequest, reply *coordinatorv1.ListFilesResponse, opts ...grpc.CallOption) error {
+	// A prefix not ending in / is a name prefix within its directory.
+	dir := path.Join(req.ProjectId, req.DirectoryPrefix)
+	if req.DirectoryPrefix != "" && !strings.HasSuffix(req.DirectoryPrefix, "/") {
+		dir = path.Join(req.ProjectId, path.Dir(req.DirectoryPrefix))
+	}
+	dir = cleanPath(dir)
+
+	owner := m.Group(dir)
+	if err := owner.Invoke(ctx, method, req, reply, opts...); err != nil {
+		return err
+	}
+	others := m.groupsBelow(dir, owner)
+	if len(others) == 0 {
+		return nil
+	}
+
+	for _, conn := range others {
+		var part coordinatorv1.ListFilesResponse
+		if err := conn.Invoke(ctx, method, req, &part, opts...); err != nil {
+			return err
+		}
+		reply.FilePaths = append(reply.FilePaths, part.FilePaths...)
+		reply.Files = append(reply.Files, part.Files...)
+		reply.Truncated = reply.Truncated || part.Truncated
+	}
+	if len(reply.Files) == len(reply.FilePaths) {
+		sort.Sort(listFilesByPath{reply})
+	} else {
+		sort.Strings(reply.FilePaths)
+	}
+	// Every group returned its first limit files, so the first limit of them
+	// all are the page.
+	if limit := int(req.Limit); limit > 0 && len(reply.FilePaths) > limit {
+		reply.FilePaths = reply.FilePaths[:limit]
+		if len(reply.Files) > limit {
+			reply.Files = reply.Files[:limit]
+		}
+		reply.Truncated = true
+	}
+	return nil
+}
+
+type listFilesByPath struct {
+	*coordinatorv1.ListFilesResponse
+}
+
+func (l listFilesByPath) Len() int           { return len(l.FilePaths) }
+func (l listFilesByPath) Less(i, j int) bool { return l.FilePaths[i] < l.FilePaths[j] }
+func (l listFilesByPath) Swap(i, j int) {
+	l.FilePaths[i], l.FilePaths[j] = l.FilePaths[j], l.FilePaths[i]
+	l.Files[i], l.Files[j] = l.Files[j], l.Files[i]
+}
+
+// listDirectory lists dir on its group, adding the mount points below it as
+// directories.
+func (m *MountConn) listDirectory(ctx context.Context, method string, req *coordinatorv1.ListDirectoryRequest, reply *coordinatorv1.ListDirectoryResponse, opts ...grpc.CallOption) error {
+	dir := cleanPath(req.Path)
+	mounts := m.table.below(dir)
+
+	err := m.Group(dir).Invoke(ctx, method, req, reply, opts...)
+	if err != nil && (len(mounts) == 0 || status.Code(err) != codes.NotFound) {
+		return err
+	}
+
+	for _, mount := range mounts {
+		rest := mount.Prefix
+		if dir != "." {
+			rest = strings.TrimPrefix(mount.Prefix, dir+"/")
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		if hasEntry(reply.Entries, name) {
+			continue
+		}
+		reply.Entries = append(reply.Entries, &coordinatorv1.FileStatus{
+			Path:  path.Join(dir, name),
+			Name:  name,
+			IsDir: true,
+		})
+	}
+	sort.Slice(reply.Entries, func(i, j int) bool { return reply.Entries[i].Name < reply.Entries[j].Name })
+	return nil
+}
+
+// getFileInfo stats p on its group. Directories that only exist as the parent
+// of a mount point are reported too.
+func (m *MountConn) getFileInfo(ctx context.Context, method string, req *coordinatorv1.GetFileInfoRequest, reply *coordinatorv1.GetFileInfoResponse, opts ...grpc.CallOption) error {
+	p := cleanPath(req.Path)
+	err := m.Group(p).Invoke(ctx, method, req, reply, opts...)
+	if status.Code(err) != codes.NotFound || len(m.table.below(p)) == 0 {
+		return err
+	}
+	reply.Status = &coordinatorv1.FileStatus{Path: p, Name: path.Base(p), IsDir: true}
+	return nil
+}
+
+func hasEntry(entries []*coordinatorv1.FileStatus, name string) bool {
+	for _, e := range entries {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsBelow returns the groups of the mounts below dir, other than except.
+func (m *MountConn) groupsBelow(dir string, except *MasterConn) []*MasterConn {
+	seen := map[*MasterConn]bool{except: true}
+	var conns []*MasterConn
+	for _, mount := range m.table.below(dir) {
+		conn := m.groups[strings.Join(mount.Addrs, ",")]
+		if !seen[conn] {
+			seen[conn] = true
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// Close closes the connections to all groups.
+func (m *MountConn) Close() error {
+	errs := []error{m.root.Close()}
+	for _, conn := range m.groups {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,292 @@
+package dfs
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseMasterAddrs(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{list: "", want: nil},
+		{list: "a:1", want: []string{"a:1"}},
+		{list: " a:1 , b:2,", want: []string{"a:1", "b:2"}},
+		{list: "a:1,b:2,a:1", want: []string{"a:1", "b:2"}},
+		{list: " , ", want: nil},
+	}
+	for _, tt := range tests {
+		if got := ParseMasterAddrs(tt.list); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseMasterAddrs(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestParseMountTable(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		// want lists the mounts as prefix=addrs, longest prefix first.
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", spec: ""},
+		{name: "single", spec: "p1=a:1,b:2", want: []string{"p1=a:1,b:2"}},
+		{name: "cleaned", spec: " /p1/hot/ = a:1 ; ;", want: []string{"p1/hot=a:1"}},
+		{name: "overlapping prefixes", spec: "p1=a:1;p1/hot/2024=c:3;p1/hot=b:2", want: []string{"p1/hot/2024=c:3", "p1/hot=b:2", "p1=a:1"}},
+		{name: "root mount", spec: "/=a:1", wantErr: true},
+		{name: "missing addresses", spec: "p1=", wantErr: true},
+		{name: "missing separator", spec: "p1", wantErr: true},
+		{name: "duplicate prefix", spec: "p1=a:1;/p1/=b:2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := ParseMountTable(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMountTable(%q) error %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, m := range table.Mounts() {
+				got = append(got, m.Prefix+"="+strings.Join(m.Addrs, ","))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("mounts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountTableLookup(t *testing.T) {
+	table, err := ParseMountTable("p1=a:1;p1/hot=b:2;p2/x=c:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		// want is the prefix of the mount, empty for the root group.
+		want string
+	}{
+		{path: "p1/f", want: "p1"},
+		{path: "/p1", want: "p1"},
+		{path: "p1/hot/f", want: "p1/hot"},
+		{path: "p1/hot", want: "p1/hot"},
+		{path: "p1/hotter/f", want: "p1"},
+		{path: "p1/./hot/../hot/f", want: "p1/hot"},
+		{path: "p10/f"},
+		{path: "p2/f"},
+		{path: "p2/x/y", want: "p2/x"},
+		{path: "/"},
+	}
+	for _, tt := range tests {
+		m, ok := table.Lookup(tt.path)
+		if ok != (tt.want != "") || m.Prefix != tt.want {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", tt.path, m.Prefix, ok, tt.want)
+		}
+	}
+
+	var none *MountTable
+	if _, ok := none.Lookup("p1/f"); ok {
+		t.Error("a nil table has mounts")
+	}
+}
+
+func TestMountConnRouting(t *testing.T) {
+	masters := map[string]*fakeMaster{"root": {}, "p1": {}, "hot": {}}
+	addrs := make(map[string]string)
+	for name, f := range masters {
+		addrs[name] = serveMaster(t, f)
+	}
+	// p3 shares the group of p1.
+	table, err := ParseMountTable("p1=" + addrs["p1"] + ";p1/hot=" + addrs["hot"] + ";p3=" + addrs["p1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := DialMounts([]string{addrs["root"]}, table, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if len(conn.groups) != 2 {
+		t.Errorf("%d mounted groups dialed, want 2", len(conn.groups))
+	}
+	client := coordinatorv1.NewCoordinatorServiceClient(conn)
+
+	tests := []struct {
+		name string
+		call func(context.Context) error
+		// wantGroup is the master called, empty when none is.
+		wantGroup string
+		wantCode  codes.Code
+	}{
+		{
+			name: "root group",
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: "p2/f"})
+				return err
+			},
+			wantGroup: "root",
+		},
+		{
+			name: "mount",
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: "/p1/f"})
+				return err
+			},
+			wantGroup: "p1",
+		},
+		{
+			name: "longest prefix",
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: "p1/hot/f"})
+				return err
+			},
+			wantGroup: "hot",
+		},
+		{
+			name: "rename within a mount",
+			call: func(ctx context.Context) error {
+				_, err := client.Rename(ctx, &coordinatorv1.RenameRequest{SrcPath: "p1/hot/a", DstPath: "p1/hot/b"})
+				return err
+			},
+			wantGroup: "hot",
+		},
+		{
+			name: "rename between mounts of a group",
+			call: func(ctx context.Context) error {
+				_, err := client.Rename(ctx, &coordinatorv1.RenameRequest{SrcPath: "p1/a", DstPath: "p3/a"})
+				return err
+			},
+			wantGroup: "p1",
+		},
+		{
+			name: "rename across mounts",
+			call: func(ctx context.Context) error {
+				_, err := client.Rename(ctx, &coordinatorv1.RenameRequest{SrcPath: "p1/a", DstPath: "p1/hot/a"})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "rename out of a mount",
+			call: func(ctx context.Context) error {
+				_, err := client.Rename(ctx, &coordinatorv1.RenameRequest{SrcPath: "p1/a", DstPath: "p2/a"})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "rename of a mount's parent",
+			call: func(ctx context.Context) error {
+				_, err := client.Rename(ctx, &coordinatorv1.RenameRequest{SrcPath: "p1", DstPath: "p3/old"})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "recursive delete of a mount's parent",
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: "/p1", Recursive: true})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "recursive delete beside a mount",
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteFile(ctx, &coordinatorv1.DeleteFileRequest{FilePath: "p1/cold", Recursive: true})
+				return err
+			},
+			wantGroup: "p1",
+		},
+		{
+			name: "concat across mounts",
+			call: func(ctx context.Context) error {
+				_, err := client.ConcatFiles(ctx, &coordinatorv1.ConcatFilesRequest{TargetPath: "p1/t", SourcePaths: []string{"p1/a", "p1/hot/b"}})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "concat within a mount",
+			call: func(ctx context.Context) error {
+				_, err := client.ConcatFiles(ctx, &coordinatorv1.ConcatFilesRequest{TargetPath: "p1/t", SourcePaths: []string{"p1/a", "p3/b"}})
+				return err
+			},
+			wantGroup: "p1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make(map[string]int32)
+			for name, f := range masters {
+				before[name] = f.calls.Load()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if got := status.Code(tt.call(ctx)); got != tt.wantCode {
+				t.Fatalf("code %v, want %v", got, tt.wantCode)
+			}
+			for name, f := range masters {
+				want := before[name]
+				if name == tt.wantGroup {
+					want++
+				}
+				if got := f.calls.Load(); got != want {
+					t.Errorf("master %s called %d times, want %d", name, got-before[name], want-before[name])
+				}
+			}
+		})
+	}
+}
+
+func TestMountConnListFilesPages(t *testing.T) {
+	root := &fakeMaster{files: []string{"a", "c", "e", "g"}}
+	mounted := &fakeMaster{files: []string{"hot/b", "hot/d"}}
+	table, err := ParseMountTable("p/hot=" + serveMaster(t, mounted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := DialMounts([]string{serveMaster(t, root)}, table, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := coordinatorv1.NewCoordinatorServiceClient(conn)
+
+	var pages [][]string
+	after := ""
+	for {
+		resp, err := client.ListFiles(context.Background(), &coordinatorv1.ListFilesRequest{ProjectId: "p", StartAfter: after, Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, resp.FilePaths)
+		if len(resp.Files) != len(resp.FilePaths) {
+			t.Fatalf("%d statuses for %d paths", len(resp.Files), len(resp.FilePaths))
+		}
+		if !resp.Truncated {
+			break
+		}
+		after = resp.FilePaths[len(resp.FilePaths)-1]
+	}
+	want := [][]string{{"a", "c", "e"}, {"g", "hot/b", "hot/d"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %v, want %v", pages, want)
+	}
+}
@@ -3,12 +3,93 @@ package dfs
 import (
 	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"sort"
+	"sync"
+	"time"
 
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
 	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+const (
+	// DefaultReadRangeSize is how much of a block a reader fetches at once
+	// unless WithReadRangeSize says otherwise.
+	DefaultReadRangeSize = 8 * 1024 * 1024
+	// DefaultReadAheadRanges is the number of ranges a reader prefetches
+	// unless WithReadAhead says otherwise.
+	DefaultReadAheadRanges = 4
+	// DefaultMaxReadAheadBytes bounds the read-aheads of all readers of a
+	// client unless WithMaxReadAheadBytes says otherwise.
+	DefaultMaxReadAheadBytes = 128 * 1024 * 1024
+)
+
+// blockRange is the part of a block, or of a packed file's extent of one,
+// that a reader fetches and caches as a unit.
+type blockRange struct {
+	info *commonv1.BlockInfo
+	// pos is the range's offset in the file, start its offset in the block
+	// or extent.
+	pos, start, size int64
+}
+
+// whole reports whether the range is the entire block or extent, which is
+// what the committed checksum covers.
+func (rg blockRange) whole() bool {
+	return rg.start == 0 && rg.size == rg.info.Size
+}
+
+// splitRanges cuts blocks into ranges of at most size bytes.
+func splitRanges(blocks []*commonv1.BlockInfo, size int64) []blockRange {
+	var ranges []blockRange
+	var pos int64
+	for _, b := range blocks {
+		for start := int64(0); start < b.Size; start += size {
+			ranges = append(ranges, blockRange{info: b, pos: pos + start, start: start, size: min(size, b.Size-start)})
+		}
+		pos += b.Size
+	}
+	return ranges
+}
+
+// readAheadBudget bounds the memory held by the read-aheads of all readers
+// of a client. A nil *readAheadBudget is unbounded.
+type readAheadBudget struct {
+	mu        sync.Mutex
+	used, max int64
+}
+
+func newReadAheadBudget(maxBytes int64) *readAheadBudget {
+	return &readAheadBudget{max: max(maxBytes, 0)}
+}
+
+// tryAcquire takes n bytes of the budget if they are free.
+func (b *readAheadBudget) tryAcquire(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *readAheadBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+}
+
 type reader struct {
 	client   *dfsClient
 	ctx      context.Context
@@ -16,12 +97,37 @@ type reader struct {
 	fileSize int64
 	offset   int64
 	metadata *coordinatorv1.GetFileMetadataResponse
+	ranges   []blockRange
+
+	// cur holds the range at index curIdx, which Read serves from.
+	cur    []byte
+	curIdx int
+	// prefetches holds background fetches of the ranges after curIdx.
+	prefetches map[int]*prefetch
+
+	mu sync.Mutex
+	// badReplicas holds "blockID/workerID" keys of replicas that failed and
+	// are skipped for the rest of this reader's life.
+	badReplicas map[string]struct{}
+	// tokens holds the read token of each block, renewed when they expire.
+	tokens map[string]string
+}
+
+// prefetch is a read-ahead fetch of one range; data and err are valid once
+// done is closed. It holds size bytes of the client's read-ahead budget.
+type prefetch struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	size   int64
+	data   []byte
+	err    error
 }
 
 func (c *dfsClient) Open(ctx context.Context, path string) (File, error) {
 	resp, err := c.masterClient.GetFileMetadata(ctx, &coordinatorv1.GetFileMetadataRequest{
-		ProjectId: "default",
-		FilePath:  path,
+		ProjectId:  "default",
+		FilePath:   path,
+		ClientHost: c.clientHost,
 	})
 	if err != nil {
 		return nil, err
@@ -33,12 +139,17 @@ func (c *dfsClient) Open(ctx context.Context, path string) (File, error) {
 	}
 
 	return &reader{
-		client:   c,
-		ctx:      ctx,
-		path:     path,
-		metadata: resp,
-		fileSize: totalSize,
-		offset:   0,
+		client:      c,
+		ctx:         ctx,
+		path:        path,
+		metadata:    resp,
+		ranges:      splitRanges(resp.Blocks, c.readRangeSize),
+		fileSize:    totalSize,
+		offset:      0,
+		curIdx:      -1,
+		prefetches:  make(map[int]*prefetch),
+		badReplicas: make(map[string]struct{}),
+		tokens:      resp.BlockTokens,
 	}, nil
 }
 
@@ -47,82 +158,325 @@ func (r *reader) Read(p []byte) (n int, err error) {
 		return 0, io.EOF
 	}
 
-	blockIdx, blockOffset, err := r.locateBlock(r.offset)
+	idx := r.locateRange(r.offset)
+	data, err := r.rangeData(idx)
 	if err != nil {
 		return 0, err
 	}
 
-	blockInfo := r.metadata.Blocks[blockIdx]
-	bytesLeftInBlock := blockInfo.Size - blockOffset
-	toRead := int64(len(p))
-	if toRead > bytesLeftInBlock {
-		toRead = bytesLeftInBlock
+	bytesRead := copy(p, data[r.offset-r.ranges[idx].pos:])
+	r.offset += int64(bytesRead)
+	return bytesRead, nil
+}
+
+// rangeData returns the contents of range idx, taking it from a finished
+// read-ahead, the client's block cache or the workers, in that order, and
+// schedules read-ahead of the ranges after it.
+func (r *reader) rangeData(idx int) ([]byte, error) {
+	if idx == r.curIdx {
+		return r.cur, nil
 	}
 
-	loc, ok := r.metadata.Locations[blockInfo.BlockId]
-	if !ok {
-		return 0, fmt.Errorf("no location for block %s", blockInfo.BlockId)
+	rg := r.ranges[idx]
+	var data []byte
+
+	if p, ok := r.prefetches[idx]; ok {
+		select {
+		case <-p.done:
+		case <-r.ctx.Done():
+			r.dropPrefetch(idx, p)
+			return nil, r.ctx.Err()
+		}
+		r.dropPrefetch(idx, p)
+		// A failed read-ahead is retried in the foreground below.
+		if p.err == nil {
+			data = p.data
+		}
+	}
+
+	if data == nil {
+		data = r.client.blockCache.get(rg)
+	}
+
+	if data == nil {
+		var err error
+		data, err = r.fetchRange(r.ctx, rg)
+		if err != nil {
+			return nil, err
+		}
+		r.client.blockCache.add(rg, data)
+	}
+
+	r.cur, r.curIdx = data, idx
+	r.schedulePrefetch(idx)
+	return data, nil
+}
+
+// schedulePrefetch starts fetching the readAhead ranges after idx and cancels
+// read-aheads that fell out of that window, e.g. after a Seek. Once the
+// client's read-ahead budget is spent, the remaining ranges are fetched when
+// they are read.
+func (r *reader) schedulePrefetch(idx int) {
+	last := min(idx+r.client.readAhead, len(r.ranges)-1)
+
+	for i, p := range r.prefetches {
+		if i <= idx || i > last {
+			r.dropPrefetch(i, p)
+		}
+	}
+
+	for i := idx + 1; i <= last; i++ {
+		rg := r.ranges[i]
+		if _, ok := r.prefetches[i]; ok || r.client.blockCache.contains(rg) {
+			continue
+		}
+		if !r.client.readAheadBudget.tryAcquire(rg.size) {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.ctx)
+		p := &prefetch{done: make(chan struct{}), cancel: cancel, size: rg.size}
+		r.prefetches[i] = p
+
+		go func() {
+			defer close(p.done)
+			p.data, p.err = r.fetchRange(ctx, rg)
+			if p.err == nil {
+				r.client.blockCache.add(rg, p.data)
+			}
+		}()
+	}
+}
+
+// dropPrefetch forgets read-ahead i, cancelling it if it still runs, and
+// returns its memory to the client's budget.
+func (r *reader) dropPrefetch(i int, p *prefetch) {
+	p.cancel()
+	delete(r.prefetches, i)
+	r.client.readAheadBudget.release(p.size)
+}
+
+// fetchRange reads a range of a block, or of a packed file's extent of it,
+// from its replicas.
+func (r *reader) fetchRange(ctx context.Context, rg blockRange) ([]byte, error) {
+	replicas := r.replicas(rg.info.BlockId)
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no location for block %s", rg.info.BlockId)
+	}
+
+	if r.client.hedgeThreshold > 0 && len(replicas) > 1 {
+		return r.hedgedFetch(ctx, replicas, rg)
+	}
+	return r.failoverFetch(ctx, replicas, rg)
+}
+
+// replicas returns the block's locations in the master's preferred order,
+// skipping replicas this reader has already seen fail.
+func (r *reader) replicas(blockID string) []*commonv1.BlockLocation {
+	var all []*commonv1.BlockLocation
+	if set, ok := r.metadata.Replicas[blockID]; ok {
+		all = set.Locations
+	} else if loc, ok := r.metadata.Locations[blockID]; ok {
+		all = []*commonv1.BlockLocation{loc}
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usable := make([]*commonv1.BlockLocation, 0, len(all))
+	for _, loc := range all {
+		if _, bad := r.badReplicas[blockID+"/"+loc.WorkerId]; !bad {
+			usable = append(usable, loc)
+		}
+	}
+	return usable
+}
+
+// failoverFetch tries each replica in turn until one returns the range.
+func (r *reader) failoverFetch(ctx context.Context, replicas []*commonv1.BlockLocation, rg blockRange) ([]byte, error) {
+	buf := make([]byte, rg.size)
+	var lastErr error
+	for _, loc := range replicas {
+		n, err := r.fetchFrom(ctx, loc, rg, buf)
+		if err == nil {
+			return buf[:n], nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		r.replicaFailed(loc, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("all %d replicas of block %s failed: %w", len(replicas), replicas[0].BlockId, lastErr)
+}
+
+type fetchResult struct {
+	loc *commonv1.BlockLocation
+	buf []byte
+	err error
+}
+
+// hedgedFetch reads from the first replica and, if it has not answered within
+// the hedge threshold, also from the next one. The first successful response
+// wins and the others are cancelled. Failed replicas are replaced by the next
+// unused one.
+func (r *reader) hedgedFetch(ctx context.Context, replicas []*commonv1.BlockLocation, rg blockRange) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan fetchResult, len(replicas))
+	next, pending := 0, 0
+	launch := func() {
+		loc := replicas[next]
+		next++
+		pending++
+		go func() {
+			buf := make([]byte, rg.size)
+			n, err := r.fetchFrom(ctx, loc, rg, buf)
+			results <- fetchResult{loc: loc, buf: buf[:n], err: err}
+		}()
+	}
+
+	launch()
+	hedge := time.NewTimer(r.client.hedgeThreshold)
+	defer hedge.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-hedge.C:
+			if next < len(replicas) {
+				launch()
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.buf, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			r.replicaFailed(res.loc, res.err)
+			lastErr = res.err
+			if next < len(replicas) {
+				launch()
+			}
+		}
+	}
+	return nil, fmt.Errorf("all %d replicas of block %s failed: %w", len(replicas), replicas[0].BlockId, lastErr)
+}
+
+// fetchFrom reads a range of a block into dst from a single replica. Once
+// the block tokens have expired, which workers report as Unauthenticated, it
+// gets new ones from the master and tries again.
+func (r *reader) fetchFrom(ctx context.Context, loc *commonv1.BlockLocation, rg blockRange, dst []byte) (int, error) {
+	n, err := r.fetchReplica(ctx, loc, rg, dst)
+	if status.Code(err) != codes.Unauthenticated {
+		return n, err
+	}
+	if err := r.refreshTokens(ctx); err != nil {
+		return 0, err
+	}
+	return r.fetchReplica(ctx, loc, rg, dst)
+}
+
+// refreshTokens replaces the reader's block tokens with fresh ones.
+func (r *reader) refreshTokens(ctx context.Context) error {
+	resp, err := r.client.masterClient.GetFileMetadata(ctx, &coordinatorv1.GetFileMetadataRequest{
+		ProjectId:  "default",
+		FilePath:   r.path,
+		ClientHost: r.client.clientHost,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = resp.BlockTokens
+	return nil
+}
+
+func (r *reader) blockToken(blockID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens[blockID]
+}
+
+// fetchReplica reads a range of a block from a single replica. A range that
+// covers the whole block or extent is checked against its committed checksum,
+// if it has one, and a mismatch is reported as DataLoss so the replica is
+// flagged to the master. Smaller ranges rely on the worker verifying the block
+// it reads them from.
+func (r *reader) fetchReplica(ctx context.Context, loc *commonv1.BlockLocation, rg blockRange, dst []byte) (int, error) {
 	workerClient, err := r.client.getWorkerClient(loc.Address)
 	if err != nil {
 		return 0, err
 	}
 
-	stream, err := workerClient.FetchBlock(r.ctx, &datanodev1.FetchBlockRequest{
-		BlockId: blockInfo.BlockId,
-	})
+	req := &datanodev1.FetchBlockRequest{
+		BlockId:    loc.BlockId,
+		BlockToken: r.blockToken(loc.BlockId),
+	}
+	// Packed files read only their extent of the container block.
+	if info := rg.info; info.Packed || !rg.whole() {
+		req.Offset, req.Length = info.Offset+rg.start, rg.size
+	}
+	stream, err := workerClient.FetchBlock(ctx, req)
 	if err != nil {
 		return 0, err
 	}
 
-	var discarded int64
 	bytesRead := 0
-	for {
+	for bytesRead < len(dst) {
 		resp, err := stream.Recv()
 		if err == io.EOF {
-			break
+			return bytesRead, io.ErrUnexpectedEOF
 		}
 		if err != nil {
 			return bytesRead, err
 		}
+		bytesRead += copy(dst[bytesRead:], resp.Chunk)
+	}
 
-		chunk := resp.Chunk
-		if discarded < blockOffset {
-			if discarded+int64(len(chunk)) <= blockOffset {
-				discarded += int64(len(chunk))
-				continue
-			}
-			chunk = chunk[blockOffset-discarded:]
-			discarded = blockOffset
-		}
-
-		take := int64(len(chunk))
-		if int64(bytesRead)+take > toRead {
-			take = toRead - int64(bytesRead)
+	if want := uint32(rg.info.Checksum); want != 0 && rg.whole() {
+		if sum := crc32.ChecksumIEEE(dst[:bytesRead]); sum != want {
+			return 0, status.Errorf(codes.DataLoss, "block %s from worker %s: checksum %08x, want %08x",
+				loc.BlockId, loc.WorkerId, sum, want)
 		}
+	}
+	return bytesRead, nil
+}
 
-		copy(p[bytesRead:], chunk[:take])
-		bytesRead += int(take)
+// replicaFailed stops using a replica for this reader and, when the worker
+// says the block is corrupt or gone, reports it to the master.
+func (r *reader) replicaFailed(loc *commonv1.BlockLocation, err error) {
+	r.mu.Lock()
+	r.badReplicas[loc.BlockId+"/"+loc.WorkerId] = struct{}{}
+	r.mu.Unlock()
 
-		if int64(bytesRead) >= toRead {
-			break
-		}
+	switch status.Code(err) {
+	case codes.DataLoss, codes.NotFound:
+	default:
+		return
 	}
 
-	r.offset += int64(bytesRead)
-	return bytesRead, nil
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = r.client.masterClient.ReportBadReplica(ctx, &coordinatorv1.ReportBadReplicaRequest{
+		BlockId:  loc.BlockId,
+		WorkerId: loc.WorkerId,
+		Reason:   err.Error(),
+		FilePath: r.path,
+	})
 }
 
-func (r *reader) locateBlock(globalOffset int64) (index int, offsetInBlock int64, err error) {
-	var currentPos int64 = 0
-	for i, block := range r.metadata.Blocks {
-		if globalOffset >= currentPos && globalOffset < currentPos+block.Size {
-			return i, globalOffset - currentPos, nil
-		}
-		currentPos += block.Size
-	}
-	return -1, 0, io.EOF
+// locateRange returns the index of the range holding the file offset, which
+// must be below the file size.
+func (r *reader) locateRange(offset int64) int {
+	return sort.Search(len(r.ranges), func(i int) bool {
+		return r.ranges[i].pos+r.ranges[i].size > offset
+	})
 }
 
 func (r *reader) Write(p []byte) (n int, err error) {
@@ -130,26 +484,35 @@ func (r *reader) Write(p []byte) (n int, err error) {
 }
 
 func (r *reader) Close() error {
+	for i, p := range r.prefetches {
+		r.dropPrefetch(i, p)
+	}
+	r.cur = nil
 	return nil
 }
 
+func (r *reader) Abort() error {
+	return r.Close()
+}
+
 func (r *reader) Sync() error {
 	return nil
 }
 
 func (r *reader) Stat() (FileInfo, error) {
 	info := FileInfo{
-		Name: r.path,
-		Size: r.fileSize,
+		Name:       r.path,
+		Size:       r.fileSize,
+		ModTime:    r.metadata.ModTime,
+		Generation: r.metadata.Generation,
 	}
 	for _, b := range r.metadata.Blocks {
-		loc := r.metadata.Locations[b.BlockId]
-		info.Blocks = append(info.Blocks, BlockMetadata{
-			BlockId:  b.BlockId,
-			Size:     b.Size,
-			WorkerId: loc.WorkerId,
-			Address:  loc.Address,
-		})
+		meta := BlockMetadata{BlockId: b.BlockId, Size: b.Size, Checksum: uint32(b.Checksum), Packed: b.Packed, Offset: b.Offset, ContentHash: b.ContentHash}
+		if loc, ok := r.metadata.Locations[b.BlockId]; ok {
+			meta.WorkerId = loc.WorkerId
+			meta.Address = loc.Address
+		}
+		info.Blocks = append(info.Blocks, meta)
 	}
 	return info, nil
 }
@@ -0,0 +1,314 @@
+package dfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+)
+
+func readFile(t *testing.T, c *dfsClient, path string) []byte {
+	t.Helper()
+	f, err := c.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return data
+}
+
+func TestHedgedRead(t *testing.T) {
+	data := bytes.Repeat([]byte("hedged "), 1000)
+
+	tests := []struct {
+		name      string
+		firstSlow time.Duration
+		threshold time.Duration
+		// wantHedge says whether the second replica is asked as well.
+		wantHedge bool
+	}{
+		{name: "first replica answers in time", threshold: time.Second},
+		{name: "slow first replica", firstSlow: 10 * time.Second, threshold: 20 * time.Millisecond, wantHedge: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := startWorker(t, "first", func(w *fakeWorker) { w.delay = tt.firstSlow })
+			second := startWorker(t, "second")
+			master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{storeFile([]*fakeWorker{first, second}, data)}}
+			c := newTestClient(t, master, WithHedgedReads(tt.threshold), WithReadAhead(0))
+
+			start := time.Now()
+			if got := readFile(t, c, "f"); !bytes.Equal(got, data) {
+				t.Fatalf("read %d bytes, want %d", len(got), len(data))
+			}
+
+			if tt.wantHedge {
+				if elapsed := time.Since(start); elapsed >= tt.firstSlow {
+					t.Errorf("read took %v, waited for the slow replica", elapsed)
+				}
+				if second.fetches.Load() != 1 {
+					t.Errorf("second replica fetched %d times, want 1", second.fetches.Load())
+				}
+				eventually(t, func() bool { return first.cancelledFetches.Load() == 1 }, "the slow replica's fetch was not cancelled")
+			} else if second.fetches.Load() != 0 {
+				t.Errorf("second replica fetched %d times, want 0", second.fetches.Load())
+			}
+		})
+	}
+}
+
+func TestReadCorruptReplica(t *testing.T) {
+	data := bytes.Repeat([]byte("checked "), 1000)
+
+	for _, threshold := range []time.Duration{0, 20 * time.Millisecond} {
+		name := "failover"
+		if threshold > 0 {
+			name = "hedged"
+		}
+		t.Run(name, func(t *testing.T) {
+			corrupt := startWorker(t, "corrupt", func(w *fakeWorker) { w.corrupt = true })
+			good := startWorker(t, "good", func(w *fakeWorker) { w.delay = 50 * time.Millisecond })
+			master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{storeFile([]*fakeWorker{corrupt, good}, data, data)}}
+			c := newTestClient(t, master, WithHedgedReads(threshold), WithReadAhead(0))
+
+			if got := readFile(t, c, "f"); !bytes.Equal(got, append(bytes.Clone(data), data...)) {
+				t.Fatal("read returned corrupt data")
+			}
+
+			master.mu.Lock()
+			defer master.mu.Unlock()
+			if len(master.badReplicas) == 0 {
+				t.Fatal("the corrupt replica was not reported")
+			}
+			bad := master.badReplicas[0]
+			if bad.WorkerId != "corrupt" || bad.BlockId != "block-0" || bad.FilePath != "f" {
+				t.Errorf("reported %s/%s of %s, want corrupt/block-0 of f", bad.WorkerId, bad.BlockId, bad.FilePath)
+			}
+			if !strings.Contains(bad.Reason, "checksum") {
+				t.Errorf("reason %q does not mention the checksum", bad.Reason)
+			}
+			// Each block's corrupt replica is tried once and then skipped.
+			if corrupt.fetches.Load() != 2 {
+				t.Errorf("corrupt replica fetched %d times, want 2", corrupt.fetches.Load())
+			}
+		})
+	}
+}
+
+func TestReadRefreshesExpiredTokens(t *testing.T) {
+	data := []byte("tokened data")
+
+	for _, threshold := range []time.Duration{0, 20 * time.Millisecond} {
+		replicas := []*fakeWorker{startWorker(t, "a", requireToken("fresh"))}
+		if threshold > 0 {
+			// Hedged reads need a second replica.
+			replicas = append(replicas, startWorker(t, "b", requireToken("fresh")))
+		}
+		stale := storeFile(replicas, data)
+		stale.BlockTokens = map[string]string{"block-0": "expired"}
+		fresh := storeFile(replicas, data)
+		fresh.BlockTokens = map[string]string{"block-0": "fresh"}
+		master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{stale, fresh}}
+
+		c := newTestClient(t, master, WithHedgedReads(threshold), WithReadAhead(0))
+		if got := readFile(t, c, "f"); !bytes.Equal(got, data) {
+			t.Fatalf("hedge %v: read %q, want %q", threshold, got, data)
+		}
+		if master.metadata < 2 {
+			t.Errorf("hedge %v: tokens were not refreshed", threshold)
+		}
+		if len(master.badReplicas) != 0 {
+			t.Errorf("hedge %v: expired tokens reported %d bad replicas", threshold, len(master.badReplicas))
+		}
+	}
+}
+
+func requireToken(token string) func(*fakeWorker) {
+	return func(w *fakeWorker) { w.token = token }
+}
+
+// fileBlocks returns n distinct blocks of size bytes.
+func fileBlocks(n, size int) [][]byte {
+	blocks := make([][]byte, n)
+	for i := range blocks {
+		blocks[i] = bytes.Repeat([]byte{byte('a' + i)}, size)
+	}
+	return blocks
+}
+
+func TestReadAhead(t *testing.T) {
+	blocks := fileBlocks(4, 1000)
+
+	tests := []struct {
+		name string
+		// seek is the block Read continues at after the first one was read,
+		// while the read-ahead of blocks 1 and 2 is in flight.
+		seek int
+		// wantFetches counts every fetch, read-aheads included;
+		// wantCancelled the read-aheads that were dropped.
+		wantFetches   int32
+		wantCancelled int32
+		wantPrefetch  []int
+	}{
+		{name: "into the read-ahead", seek: 1, wantFetches: 4, wantPrefetch: []int{2, 3}},
+		{name: "past the read-ahead", seek: 3, wantFetches: 4, wantCancelled: 2},
+		{name: "back to the start", seek: 0, wantFetches: 3, wantPrefetch: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The read-aheads are slow enough to still run after a seek.
+			w := startWorker(t, "w", func(w *fakeWorker) {
+				w.delays = map[string]time.Duration{"block-1": 300 * time.Millisecond, "block-2": 300 * time.Millisecond}
+			})
+			master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{storeFile([]*fakeWorker{w}, blocks...)}}
+			c := newTestClient(t, master, WithReadAhead(2))
+
+			f, err := c.Open(context.Background(), "f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := f.(*reader)
+			buf := make([]byte, 1000)
+			if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, blocks[0]) {
+				t.Fatalf("first block: %v", err)
+			}
+			if len(r.prefetches) != 2 {
+				t.Fatalf("%d read-aheads after the first block, want 2", len(r.prefetches))
+			}
+
+			if _, err := r.Seek(int64(tt.seek)*1000, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, blocks[tt.seek]) {
+				t.Fatalf("block %d after seek: %v", tt.seek, err)
+			}
+
+			var pending []int
+			for i := range r.prefetches {
+				pending = append(pending, i)
+			}
+			slices.Sort(pending)
+			if !slices.Equal(pending, tt.wantPrefetch) {
+				t.Errorf("read-aheads %v, want %v", pending, tt.wantPrefetch)
+			}
+			eventually(t, func() bool { return w.fetches.Load() == tt.wantFetches }, "blocks were not fetched exactly once")
+			eventually(t, func() bool { return w.cancelledFetches.Load() == tt.wantCancelled }, "dropped read-aheads were not cancelled")
+
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.prefetches) != 0 {
+				t.Errorf("%d read-aheads left after Close", len(r.prefetches))
+			}
+		})
+	}
+}
+
+func TestReadFromBlockCache(t *testing.T) {
+	blocks := fileBlocks(3, 1000)
+	w := startWorker(t, "w")
+	master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{storeFile([]*fakeWorker{w}, blocks...)}}
+	c := newTestClient(t, master, WithBlockCache(3000), WithReadAhead(0))
+
+	want := bytes.Join(blocks, nil)
+	for i := range 2 {
+		if got := readFile(t, c, "f"); !bytes.Equal(got, want) {
+			t.Fatalf("read %d returned wrong data", i)
+		}
+		if got := w.fetches.Load(); got != 3 {
+			t.Fatalf("read %d: %d fetches, want 3", i, got)
+		}
+	}
+}
+
+func TestReadRanges(t *testing.T) {
+	blocks := make([][]byte, 2)
+	for i := range blocks {
+		blocks[i] = make([]byte, 1000)
+		for j := range blocks[i] {
+			blocks[i][j] = byte((i*1000 + j) % 251)
+		}
+	}
+	w := startWorker(t, "w")
+	master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{storeFile([]*fakeWorker{w}, blocks...)}}
+	c := newTestClient(t, master, WithReadRangeSize(300), WithReadAhead(0))
+
+	want := bytes.Join(blocks, nil)
+	if got := readFile(t, c, "f"); !bytes.Equal(got, want) {
+		t.Fatal("read returned wrong data")
+	}
+	if got := w.fetches.Load(); got != 8 {
+		t.Errorf("%d fetches, want 4 ranges of each block", got)
+	}
+
+	f, err := c.Open(context.Background(), "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(1950, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 50)
+	if _, err := io.ReadFull(f, buf); err != nil || !bytes.Equal(buf, want[1950:]) {
+		t.Fatalf("tail of the file: %v", err)
+	}
+	if got := w.fetches.Load(); got != 9 {
+		t.Errorf("%d fetches after reading the tail, want only its range fetched", got)
+	}
+}
+
+func TestReadAheadBudget(t *testing.T) {
+	blocks := fileBlocks(4, 1000)
+	w := startWorker(t, "w")
+	master := &fakeCoordinator{files: []*coordinatorv1.GetFileMetadataResponse{storeFile([]*fakeWorker{w}, blocks...)}}
+	c := newTestClient(t, master, WithReadAhead(3), WithMaxReadAheadBytes(2000))
+
+	open := func() *reader {
+		f, err := c.Open(context.Background(), "f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := f.(*reader)
+		if _, err := io.ReadFull(r, make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	first := open()
+	if len(first.prefetches) != 2 {
+		t.Fatalf("%d read-aheads, want the 2 the budget allows", len(first.prefetches))
+	}
+	second := open()
+	if len(second.prefetches) != 0 {
+		t.Fatalf("%d read-aheads of a second reader, want none while the first holds the budget", len(second.prefetches))
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	if _, err := io.ReadFull(second, buf); err != nil || !bytes.Equal(buf, blocks[1]) {
+		t.Fatalf("second block: %v", err)
+	}
+	if len(second.prefetches) != 2 {
+		t.Errorf("%d read-aheads once the first reader closed, want 2", len(second.prefetches))
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if used := c.readAheadBudget.used; used != 0 {
+		t.Errorf("%d bytes of the budget still held after Close", used)
+	}
+}
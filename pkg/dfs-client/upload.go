@@ -0,0 +1,352 @@
+package dfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sync"
+	"time"
+
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pushError is an upload failure on a specific worker. The block it was
+// writing is abandoned and the worker excluded from the next allocation.
+type pushError struct {
+	blockID  string
+	workerID string
+	err      error
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("push block %s to worker %s: %v", e.blockID, e.workerID, e.err)
+}
+
+func (e *pushError) Unwrap() error { return e.err }
+
+// blockUpload streams one block to its workers as its chunks arrive. The
+// chunks are kept until every worker acknowledges the block so that a failed
+// upload can be replayed on a freshly allocated block. In dedup mode the
+// whole block is collected and hashed first, and only pushed if the master
+// does not already have its content.
+type blockUpload struct {
+	w      *writer
+	chunks chan []byte
+	// mu guards sealed; chunks is closed once sealed. The block is sealed by
+	// its writer, by another writer waiting for the in-flight budget, or by
+	// release.
+	mu     sync.Mutex
+	sealed bool
+
+	// sent holds every chunk received so far, owned by run.
+	sent [][]byte
+	size int64
+	// crc is the CRC-32 (IEEE) of the chunks in sent.
+	crc uint32
+	// sha is the SHA-256 of the chunks in sent, nil unless deduplicating.
+	sha hash.Hash
+
+	// meta and err are set when run returns.
+	meta BlockMetadata
+	err  error
+}
+
+func newBlockUpload(w *writer) *blockUpload {
+	b := &blockUpload{
+		w:      w,
+		chunks: make(chan []byte, (w.blockSize+streamChunkSize-1)/streamChunkSize),
+	}
+	if w.dedup {
+		b.sha = sha256.New()
+	}
+	return b
+}
+
+// deliver queues a chunk unless the block is sealed. The queue holds a whole
+// block, so it never blocks.
+func (b *blockUpload) deliver(chunk []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sealed {
+		return false
+	}
+	b.chunks <- chunk
+	return true
+}
+
+func (b *blockUpload) seal() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.sealed {
+		b.sealed = true
+		close(b.chunks)
+	}
+}
+
+// run uploads the block. When an attempt fails the block is abandoned and the
+// retained chunks are retried on a new allocation that excludes the failed
+// workers, with exponential backoff between attempts.
+func (b *blockUpload) run() {
+	defer b.release()
+
+	var excluded []string
+	backoff := initialWriteBackoff
+
+	// The content hash is only known once the block is complete.
+	live := b.sha == nil
+	if !live {
+		b.drain()
+	}
+	err := b.attempt(excluded, live)
+	for attempt := 0; err != nil; attempt++ {
+		var pe *pushError
+		isPush := errors.As(err, &pe)
+		if isPush {
+			b.w.abandonBlock(pe.blockID)
+		}
+
+		b.drain()
+		if b.w.ctx.Err() != nil {
+			b.fail(b.w.ctx.Err())
+			return
+		}
+
+		if isPush {
+			excluded = append(excluded, pe.workerID)
+		} else if status.Code(err) != codes.Unavailable {
+			b.fail(err)
+			return
+		}
+
+		if attempt >= b.w.client.writeRetries {
+			b.fail(fmt.Errorf("block write failed after %d attempts: %w", attempt+1, err))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-b.w.ctx.Done():
+			b.fail(b.w.ctx.Err())
+			return
+		}
+		backoff = min(backoff*2, maxWriteBackoff)
+
+		err = b.attempt(excluded, false)
+	}
+}
+
+// attempt allocates a block outside excluded and streams it to the targets.
+// With live set it sends chunks as the writer produces them; otherwise it
+// replays the retained chunks. Failures after allocation are *pushError.
+func (b *blockUpload) attempt(excluded []string, live bool) error {
+	sizeHint := b.w.blockSize
+	if !live {
+		sizeHint = b.size
+	}
+
+	allocResp, err := b.w.client.masterClient.AllocateBlock(b.w.ctx, &coordinatorv1.AllocateBlockRequest{
+		ProjectId:         b.w.projectID,
+		SizeBytes:         sizeHint,
+		ExcludedWorkerIds: excluded,
+		FilePath:          b.w.path,
+		Replication:       b.w.replication,
+		StoragePolicy:     b.w.storagePolicy,
+		ContentHash:       b.contentHash(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(allocResp.TargetDatanodes) == 0 {
+		return fmt.Errorf("no targets")
+	}
+
+	if !allocResp.Deduplicated {
+		if failed, err := b.stream(allocResp.TargetDatanodes, allocResp.BlockId, allocResp.BlockToken, live); err != nil {
+			return &pushError{blockID: allocResp.BlockId, workerID: failed.WorkerId, err: err}
+		}
+	}
+
+	// A live block's size and checksum are only known once it is streamed.
+	primary := allocResp.TargetDatanodes[0]
+	b.meta = BlockMetadata{
+		BlockId:     allocResp.BlockId,
+		Size:        b.size,
+		WorkerId:    primary.WorkerId,
+		Address:     primary.Address,
+		Checksum:    b.crc,
+		ContentHash: b.contentHash(),
+	}
+	return nil
+}
+
+// stream pushes the block to all targets at once, each chunk going to every
+// target before the next is taken. token is the block token from the
+// allocation. On failure it returns the target at fault.
+func (b *blockUpload) stream(targets []*commonv1.BlockLocation, blockID, token string, live bool) (*commonv1.BlockLocation, error) {
+	ctx, cancel := context.WithCancel(b.w.ctx)
+	defer cancel()
+
+	// The final size is unknown while streaming; workers size blocks by what
+	// they receive.
+	totalSize := int64(0)
+	if !live {
+		totalSize = b.size
+	}
+
+	streams := make([]datanodev1.DataNodeService_PushBlockClient, len(targets))
+	for i, target := range targets {
+		workerClient, err := b.w.client.getWorkerClient(target.Address)
+		if err != nil {
+			return target, err
+		}
+
+		stream, err := workerClient.PushBlock(ctx)
+		if err != nil {
+			return target, err
+		}
+
+		err = stream.Send(&datanodev1.PushBlockRequest{
+			Data: &datanodev1.PushBlockRequest_Metadata{
+				Metadata: &datanodev1.BlockMetadata{
+					BlockId:    blockID,
+					TotalSize:  totalSize,
+					BlockToken: token,
+				},
+			},
+		})
+		if err != nil {
+			return target, err
+		}
+		streams[i] = stream
+	}
+
+	send := func(chunk []byte) (*commonv1.BlockLocation, error) {
+		for i, stream := range streams {
+			err := stream.Send(&datanodev1.PushBlockRequest{
+				Data: &datanodev1.PushBlockRequest_Chunk{Chunk: chunk},
+			})
+			if err != nil {
+				return targets[i], err
+			}
+		}
+		return nil, nil
+	}
+
+	if live {
+		for {
+			var chunk []byte
+			var ok bool
+			select {
+			case chunk, ok = <-b.chunks:
+			case <-ctx.Done():
+				return targets[0], ctx.Err()
+			}
+			if !ok {
+				break
+			}
+			b.retain(chunk)
+			if failed, err := send(chunk); err != nil {
+				return failed, err
+			}
+		}
+	} else {
+		for _, chunk := range b.sent {
+			if failed, err := send(chunk); err != nil {
+				return failed, err
+			}
+		}
+	}
+
+	for i, stream := range streams {
+		resp, err := stream.CloseAndRecv()
+		if err != nil {
+			return targets[i], err
+		}
+		if !resp.Success {
+			return targets[i], fmt.Errorf("%s", resp.Message)
+		}
+		// The worker checksums what it wrote to disk; a mismatch means the
+		// data was damaged on the way and the block is retried elsewhere.
+		// Workers that predate stored checksums report none.
+		if resp.Checksum != 0 && resp.Checksum != b.crc {
+			return targets[i], fmt.Errorf("checksum mismatch: worker stored %08x, sent %08x", resp.Checksum, b.crc)
+		}
+		if hash := b.contentHash(); hash != "" && resp.ContentHash != "" && resp.ContentHash != hash {
+			return targets[i], fmt.Errorf("content hash mismatch: worker stored %s, sent %s", resp.ContentHash, hash)
+		}
+	}
+	return nil, nil
+}
+
+func (b *blockUpload) retain(chunk []byte) {
+	b.sent = append(b.sent, chunk)
+	b.size += int64(len(chunk))
+	b.crc = crc32.Update(b.crc, crc32.IEEETable, chunk)
+	if b.sha != nil {
+		b.sha.Write(chunk)
+	}
+}
+
+// contentHash returns the hex SHA-256 of the retained chunks in dedup mode,
+// "" otherwise.
+func (b *blockUpload) contentHash() string {
+	if b.sha == nil {
+		return ""
+	}
+	return hex.EncodeToString(b.sha.Sum(nil))
+}
+
+// drain collects the chunks the writer queues until the block is sealed, or
+// the writer is aborted.
+func (b *blockUpload) drain() {
+	for {
+		select {
+		case chunk, ok := <-b.chunks:
+			if !ok {
+				return
+			}
+			b.retain(chunk)
+		case <-b.w.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *blockUpload) fail(err error) {
+	b.err = err
+	b.w.setUploadErr(err)
+}
+
+// release returns the block's chunks to the client once it is stored or has
+// failed for good.
+func (b *blockUpload) release() {
+	// Sealing stops further chunks, so the queue can be emptied without
+	// waiting for the writer.
+	b.seal()
+	b.w.client.closeUpload(b)
+	for chunk := range b.chunks {
+		b.sent = append(b.sent, chunk)
+	}
+	for _, chunk := range b.sent {
+		b.w.client.releaseChunk(chunk)
+	}
+	b.sent = nil
+}
+
+// abandonBlock tells the master to forget a block whose upload failed. It is
+// best effort: an abandoned block the master never hears about is only an
+// unreferenced entry in its block map.
+func (w *writer) abandonBlock(blockID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = w.client.masterClient.AbandonBlock(ctx, &coordinatorv1.AbandonBlockRequest{BlockId: blockID, FilePath: w.path})
+}
@@ -0,0 +1,170 @@
+package dfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrWatchExpired is returned by Watcher.Next when the masters no longer keep
+// the events after the TxID a watch resumes from. Callers re-list what they
+// track and watch again from 0.
+var ErrWatchExpired = errors.New("dfs: watch history expired")
+
+// EventType says what an Event did to its path.
+type EventType int
+
+const (
+	EventCreate EventType = iota + 1
+	EventDelete
+	EventRename
+	EventMetadata
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreate:
+		return "create"
+	case EventDelete:
+		return "delete"
+	case EventRename:
+		return "rename"
+	case EventMetadata:
+		return "metadata"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a change to the namespace.
+type Event struct {
+	// TxID numbers the operation that made the change. Watches resume after
+	// it.
+	TxID int64
+	Type EventType
+	Path string
+	// DstPath is where a renamed path moved to. Directory renames come as an
+	// event for every path in the tree, parents first.
+	DstPath string
+	// IsDir and Generation are set for creates and deletes.
+	IsDir      bool
+	Generation int64
+	// Time is when the change was made, in Unix nanoseconds.
+	Time int64
+}
+
+// Watcher streams namespace events in TxID order.
+type Watcher interface {
+	// Next blocks until the next event. Across a master failover it resumes
+	// on the new leader without losing events.
+	Next() (Event, error)
+	// TxID returns the TxID of the last event returned, for a later Watch to
+	// resume from.
+	TxID() int64
+	Close() error
+}
+
+// Watch streams the events below prefix, a full namespace path where empty
+// means everything, made after fromTxID; 0 starts at the current state. With
+// a mount table, the watch covers the master group of prefix only.
+func (c *dfsClient) Watch(ctx context.Context, prefix string, fromTxID int64) (Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// TxIDs are per master group, so a watch stays on the group of prefix.
+	conn := c.masterConn.Group(prefix)
+	w := &watcher{
+		conn:   conn,
+		client: coordinatorv1.NewCoordinatorServiceClient(conn),
+		ctx:    ctx,
+		cancel: cancel,
+		prefix: prefix,
+		txID:   fromTxID,
+	}
+	if err := w.open(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return w, nil
+}
+
+type watcher struct {
+	conn   *MasterConn
+	client coordinatorv1.CoordinatorServiceClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	prefix string
+	txID   int64
+	stream grpc.ServerStreamingClient[coordinatorv1.NamespaceEvent]
+}
+
+func (w *watcher) open() error {
+	stream, err := w.client.WatchNamespace(w.ctx, &coordinatorv1.WatchNamespaceRequest{
+		Prefix:   w.prefix,
+		FromTxId: w.txID,
+	})
+	if err != nil {
+		return err
+	}
+	w.stream = stream
+	return nil
+}
+
+func (w *watcher) Next() (Event, error) {
+	deadline := time.Now().Add(FailoverTimeout)
+	backoff := failoverBackoff
+	for {
+		if w.stream == nil {
+			if err := w.open(); err != nil {
+				return Event{}, err
+			}
+		}
+		ev, err := w.stream.Recv()
+		if err == nil {
+			deadline = time.Now().Add(FailoverTimeout)
+			w.txID = ev.TxId
+			return eventFromProto(ev), nil
+		}
+		w.stream = nil
+
+		if status.Code(err) == codes.OutOfRange {
+			return Event{}, fmt.Errorf("%w: %v", ErrWatchExpired, err)
+		}
+		redirected, retry := w.conn.streamFailover(err)
+		if !retry || w.ctx.Err() != nil || time.Now().After(deadline) {
+			return Event{}, err
+		}
+		if !redirected {
+			select {
+			case <-w.ctx.Done():
+				return Event{}, err
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxFailoverBackoff)
+		}
+	}
+}
+
+func (w *watcher) TxID() int64 {
+	return w.txID
+}
+
+func (w *watcher) Close() error {
+	w.cancel()
+	return nil
+}
+
+func eventFromProto(ev *coordinatorv1.NamespaceEvent) Event {
+	return Event{
+		TxID:       ev.TxId,
+		Type:       EventType(ev.Type),
+		Path:       ev.Path,
+		DstPath:    ev.DstPath,
+		IsDir:      ev.IsDir,
+		Generation: ev.Generation,
+		Time:       ev.Timestamp,
+	}
+}
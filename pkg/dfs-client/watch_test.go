@@ -0,0 +1,117 @@
+package dfs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// watchMaster streams the events after the TxID a watch asks for, then fails
+// the stream with err, or holds it open when err is nil.
+type watchMaster struct {
+	coordinatorv1.UnimplementedCoordinatorServiceServer
+	events []*coordinatorv1.NamespaceEvent
+	err    error
+
+	mu   sync.Mutex
+	from []int64
+}
+
+func (f *watchMaster) WatchNamespace(req *coordinatorv1.WatchNamespaceRequest, stream grpc.ServerStreamingServer[coordinatorv1.NamespaceEvent]) error {
+	f.mu.Lock()
+	f.from = append(f.from, req.FromTxId)
+	f.mu.Unlock()
+
+	for _, ev := range f.events {
+		if ev.TxId <= req.FromTxId {
+			continue
+		}
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+	if f.err != nil {
+		return f.err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (f *watchMaster) requests() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.from...)
+}
+
+func watchClient(t *testing.T, masters ...*watchMaster) *dfsClient {
+	t.Helper()
+	addrs := make([]string, len(masters))
+	for i, f := range masters {
+		addrs[i] = serve(t, func(s *grpc.Server) { coordinatorv1.RegisterCoordinatorServiceServer(s, f) })
+	}
+	conn, err := DialMounts(addrs, nil, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &dfsClient{masterConn: conn, masterClient: coordinatorv1.NewCoordinatorServiceClient(conn)}
+}
+
+func TestWatchResumesAfterFailover(t *testing.T) {
+	events := []*coordinatorv1.NamespaceEvent{
+		{TxId: 3, Type: coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_CREATE, Path: "p/a"},
+		{TxId: 5, Type: coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_RENAME, Path: "p/a", DstPath: "p/b"},
+		{TxId: 8, Type: coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_DELETE, Path: "p/b"},
+	}
+	// The old leader goes away after two events; the new one has them all.
+	old := &watchMaster{events: events[:2], err: status.Error(codes.Unavailable, "shutting down")}
+	next := &watchMaster{events: events}
+	c := watchClient(t, old, next)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := c.Watch(ctx, "p", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, want := range events {
+		ev, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.TxID != want.TxId || ev.Path != want.Path || ev.DstPath != want.DstPath || ev.Type != EventType(want.Type) {
+			t.Errorf("event %+v, want %v", ev, want)
+		}
+	}
+	if got := w.TxID(); got != 8 {
+		t.Errorf("TxID() = %d, want 8", got)
+	}
+	if got := next.requests(); len(got) != 1 || got[0] != 5 {
+		t.Errorf("new leader watched from %v, want [5]", got)
+	}
+}
+
+func TestWatchExpired(t *testing.T) {
+	c := watchClient(t, &watchMaster{err: status.Error(codes.OutOfRange, "watch history expired")})
+
+	w, err := c.Watch(context.Background(), "", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Next(); !errors.Is(err, ErrWatchExpired) {
+		t.Errorf("Next error %v, want %v", err, ErrWatchExpired)
+	}
+	if got := w.TxID(); got != 42 {
+		t.Errorf("TxID() = %d after expiry, want 42", got)
+	}
+}
@@ -1,29 +1,88 @@
 package dfs
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"time"
 
 	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
-	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
-	DefaultBlockSize = 64 * 1024 * 1024
-	streamChunkSize  = 2 * 1024 * 1024
+	DefaultBlockSize         = 64 * 1024 * 1024
+	MinBlockSize             = 64 * 1024
+	DefaultWriteRetries      = 3
+	DefaultUploadConcurrency = 2
+	DefaultMaxInFlightBytes  = 256 * 1024 * 1024
+	streamChunkSize          = 2 * 1024 * 1024
+
+	initialWriteBackoff = 200 * time.Millisecond
+	maxWriteBackoff     = 5 * time.Second
+	// budgetResealInterval is how often a writer waiting for the in-flight
+	// budget seals the blocks other writers opened since.
+	budgetResealInterval = 100 * time.Millisecond
+)
+
+var errWriteFinished = errors.New("file already closed or aborted")
+
+// Storage policies for WithStoragePolicy. A file without one inherits the
+// policy of its directory, WARM by default.
+const (
+	// StoragePolicyHot keeps blocks on SSD workers.
+	StoragePolicyHot = "HOT"
+	// StoragePolicyWarm keeps blocks on DISK workers.
+	StoragePolicyWarm = "WARM"
+	// StoragePolicyCold keeps blocks on ARCHIVE workers.
+	StoragePolicyCold = "COLD"
 )
 
+// writer streams data to the DFS in chunks of up to streamChunkSize. Each
+// block is uploaded by its own goroutine while the next one fills; up to
+// uploadConcurrency blocks are in flight per writer. Every chunk handed to a
+// block, queued or kept for a retry, takes one slot of the client-wide
+// in-flight budget.
 type writer struct {
-	client        *dfsClient
-	ctx           context.Context
-	path          string
-	projectID     string
-	ownerID       string
-	format        string
-	currentBuffer *bytes.Buffer
+	client *dfsClient
+	// ctx is cancelled by Abort and once Close returns.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	finished  bool
+	path      string
+	projectID string
+	ownerID   string
+	format    string
+
+	blockSize     int64
+	replication   int32
+	storagePolicy string
+
+	mode              coordinatorv1.WriteMode
+	ifGenerationMatch int64
+	dedup             bool
+	// generation is the committed file's generation, set by Close.
+	generation int64
+
+	// chunk is the partially filled chunk. It takes a budget slot once full.
+	chunk []byte
+	// current is the block receiving chunks, nil between blocks.
+	current     *blockUpload
+	currentSize int64
+	written     int64
+
+	uploads []*blockUpload
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+
 	writtenBlocks []BlockMetadata
 }
 
@@ -41,143 +100,331 @@ func WithFormat(fmt string) CreateOption {
 	return func(w *writer) { w.format = fmt }
 }
 
+// WithBlockSize sets the size of the file's blocks, at least MinBlockSize. It
+// defaults to DefaultBlockSize; small blocks suit small files, large ones cut
+// the metadata of big files.
+func WithBlockSize(n int64) CreateOption {
+	return func(w *writer) { w.blockSize = n }
+}
+
+// WithReplication sets how many workers store each block of the file. It
+// defaults to the cluster's replication factor.
+func WithReplication(n int) CreateOption {
+	return func(w *writer) { w.replication = int32(n) }
+}
+
+// WithStoragePolicy pins the file to a storage policy instead of inheriting
+// its directory's: StoragePolicyHot, StoragePolicyWarm or StoragePolicyCold.
+func WithStoragePolicy(policy string) CreateOption {
+	return func(w *writer) { w.storagePolicy = strings.ToUpper(policy) }
+}
+
+// WithDedup writes the file in dedup mode: each block is keyed by the
+// SHA-256 of its content and, when the project already stores a block with
+// the same content, the file references it instead of storing a copy. Blocks
+// are only uploaded once complete, so writes hold a whole block in memory.
+func WithDedup() CreateOption {
+	return func(w *writer) { w.dedup = true }
+}
+
+// IfNotExists makes the commit fail with AlreadyExists if the path is taken
+// by then. Without a write option the commit replaces any existing file.
+func IfNotExists() CreateOption {
+	return func(w *writer) { w.mode = coordinatorv1.WriteMode_WRITE_MODE_CREATE }
+}
+
+// Overwrite replaces any file at the path; its old blocks are deleted.
+func Overwrite() CreateOption {
+	return func(w *writer) { w.mode = coordinatorv1.WriteMode_WRITE_MODE_OVERWRITE }
+}
+
+// IfGenerationMatch replaces the file only if it is still at generation n,
+// failing with FailedPrecondition otherwise. A generation of 0 requires that
+// the path does not exist.
+func IfGenerationMatch(n int64) CreateOption {
+	return func(w *writer) {
+		w.mode = coordinatorv1.WriteMode_WRITE_MODE_IF_GENERATION_MATCH
+		w.ifGenerationMatch = n
+	}
+}
+
 func (c *dfsClient) Create(ctx context.Context, path string, opts ...CreateOption) (File, error) {
 	w := &writer{
-		client:        c,
-		ctx:           ctx,
-		path:          path,
-		currentBuffer: bytes.NewBuffer(make([]byte, 0, DefaultBlockSize)),
-		format:        "bin",
-		projectID:     "default",
+		client:    c,
+		ctx:       ctx,
+		path:      path,
+		format:    "bin",
+		projectID: "default",
+		blockSize: DefaultBlockSize,
+		slots:     make(chan struct{}, c.uploadConcurrency),
 	}
 
 	for _, opt := range opts {
 		opt(w)
 	}
 
+	if w.blockSize < MinBlockSize {
+		return nil, fmt.Errorf("block size %d is below the minimum of %d", w.blockSize, MinBlockSize)
+	}
+	if w.replication < 0 {
+		return nil, fmt.Errorf("invalid replication %d", w.replication)
+	}
+	w.ctx, w.cancel = context.WithCancel(ctx)
 	return w, nil
 }
 
 func (w *writer) Write(p []byte) (n int, err error) {
+	if w.finished {
+		return 0, errWriteFinished
+	}
+
 	totalWritten := 0
 	for len(p) > 0 {
-		available := DefaultBlockSize - w.currentBuffer.Len()
-		if available <= 0 {
-			if err := w.flushBlock(); err != nil {
-				return totalWritten, err
-			}
-			available = DefaultBlockSize
+		if err := w.uploadErr(); err != nil {
+			return totalWritten, err
 		}
 
-		toWrite := len(p)
-		if toWrite > available {
-			toWrite = available
+		if w.chunk == nil {
+			w.chunk = w.client.getChunk()
 		}
 
-		n, _ := w.currentBuffer.Write(p[:toWrite])
-		totalWritten += n
+		limit := w.chunkLimit()
+		toWrite := min(len(p), limit-len(w.chunk))
+		w.chunk = append(w.chunk, p[:toWrite]...)
+		totalWritten += toWrite
+		w.written += int64(toWrite)
 		p = p[toWrite:]
+
+		if len(w.chunk) == limit {
+			if err := w.emitChunk(); err != nil {
+				return totalWritten, err
+			}
+		}
 	}
 	return totalWritten, nil
 }
 
-func (w *writer) flushBlock() error {
-	if w.currentBuffer.Len() == 0 {
+// chunkLimit is the size at which the chunk being filled is emitted: a full
+// streamChunkSize, or less where that would run past the end of the block.
+func (w *writer) chunkLimit() int {
+	room := w.blockSize
+	if w.current != nil {
+		room -= w.currentSize
+	}
+	return int(min(streamChunkSize, room))
+}
+
+// reserveChunk takes a slot of the client's in-flight budget for the chunk
+// about to be emitted. When the budget is exhausted the open blocks of all the
+// client's writers are sealed, this one's included, so their chunks are freed
+// once stored even if their writers are idle. Blocks opened while waiting are
+// sealed every budgetResealInterval.
+func (w *writer) reserveChunk() error {
+	select {
+	case w.client.inFlight <- struct{}{}:
 		return nil
+	default:
 	}
 
-	dataSize := int64(w.currentBuffer.Len())
-	allocResp, err := w.client.masterClient.AllocateBlock(w.ctx, &coordinatorv1.AllocateBlockRequest{
-		ProjectId: w.projectID,
-		SizeBytes: dataSize,
-	})
-	if err != nil {
+	w.sealBlock()
+	ticker := time.NewTicker(budgetResealInterval)
+	defer ticker.Stop()
+	for {
+		w.client.sealOpenUploads()
+		select {
+		case w.client.inFlight <- struct{}{}:
+			return nil
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+}
+
+// emitChunk hands the filled chunk to the current block, starting a new block
+// if there is none or another writer sealed it, and seals the block once it
+// reaches the file's block size. On failure the chunk is dropped.
+func (w *writer) emitChunk() error {
+	if err := w.reserveChunk(); err != nil {
+		w.client.putChunk(w.chunk)
+		w.chunk = nil
 		return err
 	}
 
-	if len(allocResp.TargetDatanodes) == 0 {
-		return fmt.Errorf("no targets")
+	for w.current == nil || !w.current.deliver(w.chunk) {
+		if err := w.startBlock(); err != nil {
+			w.client.releaseChunk(w.chunk)
+			w.chunk = nil
+			return err
+		}
 	}
-	target := allocResp.TargetDatanodes[0]
+	w.client.openUpload(w.current)
+	w.currentSize += int64(len(w.chunk))
+	w.chunk = nil
 
-	workerClient, err := w.client.getWorkerClient(target.Address)
-	if err != nil {
-		return err
+	if w.currentSize >= w.blockSize {
+		w.sealBlock()
 	}
+	return nil
+}
 
-	stream, err := workerClient.PushBlock(w.ctx)
-	if err != nil {
+// startBlock makes a new block current once one of the writer's upload slots
+// is free and starts its upload.
+func (w *writer) startBlock() error {
+	if err := w.uploadErr(); err != nil {
 		return err
 	}
+	select {
+	case w.slots <- struct{}{}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
 
-	err = stream.Send(&datanodev1.PushBlockRequest{
-		Data: &datanodev1.PushBlockRequest_Metadata{
-			Metadata: &datanodev1.BlockMetadata{
-				BlockId:   allocResp.BlockId,
-				TotalSize: dataSize,
-			},
-		},
-	})
-	if err != nil {
-		return err
+	w.current = newBlockUpload(w)
+	w.currentSize = 0
+	w.uploads = append(w.uploads, w.current)
+	w.wg.Add(1)
+	go func(b *blockUpload) {
+		defer w.wg.Done()
+		defer func() { <-w.slots }()
+		b.run()
+	}(w.current)
+	return nil
+}
+
+func (w *writer) sealBlock() {
+	if w.current == nil {
+		return
 	}
+	w.current.seal()
+	w.client.closeUpload(w.current)
+	w.current = nil
+}
 
-	rawBytes := w.currentBuffer.Bytes()
-	for i := 0; i < len(rawBytes); i += streamChunkSize {
-		end := i + streamChunkSize
-		if end > len(rawBytes) {
-			end = len(rawBytes)
-		}
-		err = stream.Send(&datanodev1.PushBlockRequest{
-			Data: &datanodev1.PushBlockRequest_Chunk{
-				Chunk: rawBytes[i:end],
-			},
-		})
-		if err != nil {
-			return err
+// flush uploads everything written so far and waits for all blocks to be
+// stored.
+func (w *writer) flush() error {
+	if w.chunk != nil {
+		if len(w.chunk) > 0 {
+			if err := w.emitChunk(); err != nil {
+				return err
+			}
+		} else {
+			w.client.putChunk(w.chunk)
+			w.chunk = nil
 		}
 	}
+	w.sealBlock()
+	w.wg.Wait()
 
-	resp, err := stream.CloseAndRecv()
-	if err != nil {
-		return err
-	}
-	if !resp.Success {
-		return fmt.Errorf("%s", resp.Message)
+	w.writtenBlocks = w.writtenBlocks[:0]
+	for _, b := range w.uploads {
+		if b.err != nil {
+			return b.err
+		}
+		w.writtenBlocks = append(w.writtenBlocks, b.meta)
 	}
+	return nil
+}
 
-	w.writtenBlocks = append(w.writtenBlocks, BlockMetadata{
-		BlockId:  allocResp.BlockId,
-		Size:     dataSize,
-		WorkerId: target.WorkerId,
-		Address:  target.Address,
-	})
+func (w *writer) uploadErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
 
-	w.currentBuffer.Reset()
-	return nil
+func (w *writer) setUploadErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
 }
 
 func (w *writer) Close() error {
-	if err := w.flushBlock(); err != nil {
+	if w.finished {
+		return errWriteFinished
+	}
+	w.finished = true
+	defer w.cancel()
+
+	if err := w.flush(); err != nil {
 		return err
 	}
 
 	protoBlocks := make([]*commonv1.BlockInfo, len(w.writtenBlocks))
 	for i, b := range w.writtenBlocks {
 		protoBlocks[i] = &commonv1.BlockInfo{
-			BlockId: b.BlockId,
-			Size:    b.Size,
+			BlockId:     b.BlockId,
+			Size:        b.Size,
+			Checksum:    int64(b.Checksum),
+			ContentHash: b.ContentHash,
 		}
 	}
 
-	_, err := w.client.masterClient.CommitFile(w.ctx, &coordinatorv1.CommitFileRequest{
-		ProjectId:  w.projectID,
-		OwnerId:    w.ownerID,
-		FilePath:   w.path,
-		FileFormat: w.format,
-		Blocks:     protoBlocks,
+	resp, err := w.client.masterClient.CommitFile(w.ctx, &coordinatorv1.CommitFileRequest{
+		ProjectId:         w.projectID,
+		OwnerId:           w.ownerID,
+		FilePath:          w.path,
+		FileFormat:        w.format,
+		Blocks:            protoBlocks,
+		Mode:              w.mode,
+		IfGenerationMatch: w.ifGenerationMatch,
+		BlockSize:         w.blockSize,
+		Replication:       w.replication,
+		StoragePolicy:     w.storagePolicy,
 	})
-	return err
+	if err != nil {
+		// A lost race for the path leaves the uploaded blocks unreferenced.
+		if lostWriteRace(err) {
+			for _, b := range w.writtenBlocks {
+				w.abandonBlock(b.BlockId)
+			}
+		}
+		return err
+	}
+	w.generation = resp.Generation
+	return nil
+}
+
+// Abort stops the uploads, frees the writer's chunks and abandons the blocks
+// stored so far. Nothing is committed; Abort after Close does nothing.
+func (w *writer) Abort() error {
+	if w.finished {
+		return nil
+	}
+	w.finished = true
+	w.cancel()
+
+	if w.chunk != nil {
+		w.client.putChunk(w.chunk)
+		w.chunk = nil
+	}
+	w.sealBlock()
+	w.wg.Wait()
+	for _, b := range w.uploads {
+		if b.meta.BlockId != "" {
+			w.abandonBlock(b.meta.BlockId)
+		}
+	}
+	w.uploads = nil
+	return nil
+}
+
+// lostWriteRace reports whether a commit failed because the path was taken
+// or changed under the writer's write mode. A standby's NotLeader is also a
+// FailedPrecondition, but there the commit was never decided and the blocks
+// are still needed for a retry.
+func lostWriteRace(err error) bool {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.AlreadyExists:
+		return true
+	case codes.FailedPrecondition:
+		_, isStandby := notLeaderHint(st)
+		return !isStandby
+	}
+	return false
 }
 
 func (w *writer) Read(p []byte) (n int, err error) {
@@ -185,18 +432,21 @@ func (w *writer) Read(p []byte) (n int, err error) {
 }
 
 func (w *writer) Sync() error {
-	return w.flushBlock()
+	if w.finished {
+		return errWriteFinished
+	}
+	return w.flush()
 }
 
 func (w *writer) Stat() (FileInfo, error) {
-	var total int64
-	for _, b := range w.writtenBlocks {
-		total += b.Size
-	}
 	return FileInfo{
-		Name:   w.path,
-		Size:   total + int64(w.currentBuffer.Len()),
-		Blocks: w.writtenBlocks,
+		Name:          w.path,
+		Size:          w.written,
+		Generation:    w.generation,
+		BlockSize:     w.blockSize,
+		Replication:   int(w.replication),
+		StoragePolicy: w.storagePolicy,
+		Blocks:        w.writtenBlocks,
 	}, nil
 }
 
@@ -0,0 +1,365 @@
+package dfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"testing"
+	"time"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func writeFile(c *dfsClient, path string, data []byte, opts ...CreateOption) error {
+	f, err := c.Create(context.Background(), path, opts...)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func TestWriteWithinChunkBudget(t *testing.T) {
+	w := startWorker(t, "w")
+	master := &fakeCoordinator{workers: []*fakeWorker{w}}
+	// A single chunk may be in flight, so each block has to be stored before
+	// the next one is filled.
+	c := newTestClient(t, master, WithMaxInFlightBytes(1), WithUploadConcurrency(4))
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*MinBlockSize/16+100)
+	if err := writeFile(c, "f", data, WithBlockSize(MinBlockSize)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.inFlight); n != 0 {
+		t.Errorf("%d chunks still held after Close", n)
+	}
+
+	commit := master.commits[0]
+	if len(commit.Blocks) != 4 {
+		t.Fatalf("committed %d blocks, want 4", len(commit.Blocks))
+	}
+	var stored []byte
+	for _, b := range commit.Blocks {
+		block, ok := w.block(b.BlockId)
+		if !ok {
+			t.Fatalf("block %s was not pushed", b.BlockId)
+		}
+		if b.Size != int64(len(block)) || uint32(b.Checksum) != crc32.ChecksumIEEE(block) {
+			t.Errorf("block %s committed with size %d checksum %08x, stored %d bytes", b.BlockId, b.Size, b.Checksum, len(block))
+		}
+		stored = append(stored, block...)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Error("stored blocks do not add up to the data written")
+	}
+}
+
+func TestWriteRetries(t *testing.T) {
+	data := bytes.Repeat([]byte("retry "), 1000)
+
+	tests := []struct {
+		name string
+		// badPush fails the pushes to the first worker.
+		badPush   bool
+		allocErrs []error
+		retries   int
+
+		wantErr      codes.Code
+		wantAllocs   int
+		wantExcluded []string
+		wantAbandon  []string
+	}{
+		{
+			name:    "push failure excludes the worker",
+			badPush: true, retries: 1,
+			wantAllocs: 2, wantExcluded: []string{"first"}, wantAbandon: []string{"block-1"},
+		},
+		{
+			name:    "push failure without retries",
+			badPush: true, retries: 0,
+			wantErr: codes.DataLoss, wantAllocs: 1, wantAbandon: []string{"block-1"},
+		},
+		{
+			name:      "unavailable master is retried",
+			allocErrs: []error{status.Error(codes.Unavailable, "electing")}, retries: 1,
+			wantAllocs: 2,
+		},
+		{
+			name:      "rejected allocation is not retried",
+			allocErrs: []error{status.Error(codes.InvalidArgument, "bad storage policy")}, retries: 3,
+			wantErr: codes.InvalidArgument, wantAllocs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := startWorker(t, "first", func(w *fakeWorker) {
+				if tt.badPush {
+					w.pushErr = status.Error(codes.DataLoss, "disk failed")
+				}
+			})
+			second := startWorker(t, "second")
+			master := &fakeCoordinator{workers: []*fakeWorker{first, second}, allocErrs: tt.allocErrs}
+			c := newTestClient(t, master, WithWriteRetries(tt.retries))
+
+			err := writeFile(c, "f", data)
+			if got := status.Code(err); got != tt.wantErr {
+				t.Fatalf("write error %v, want code %v", err, tt.wantErr)
+			}
+			var pe *pushError
+			if err != nil && errors.As(err, &pe) != tt.badPush {
+				t.Errorf("error %v: push error %v, want %v", err, !tt.badPush, tt.badPush)
+			}
+
+			if len(master.allocs) != tt.wantAllocs {
+				t.Fatalf("%d allocations, want %d", len(master.allocs), tt.wantAllocs)
+			}
+			last := master.allocs[len(master.allocs)-1]
+			if !slices.Equal(last.ExcludedWorkerIds, tt.wantExcluded) {
+				t.Errorf("last allocation excluded %v, want %v", last.ExcludedWorkerIds, tt.wantExcluded)
+			}
+			if !slices.Equal(master.abandoned, tt.wantAbandon) {
+				t.Errorf("abandoned %v, want %v", master.abandoned, tt.wantAbandon)
+			}
+			if tt.wantErr == codes.OK {
+				stored := master.commits[0].Blocks[0]
+				// Failed pushes move the block to the second worker.
+				block, _ := first.block(stored.BlockId)
+				if tt.badPush {
+					block, _ = second.block(stored.BlockId)
+				}
+				if !bytes.Equal(block, data) || stored.Size != int64(len(data)) {
+					t.Error("the retried block was not stored as committed")
+				}
+			}
+		})
+	}
+}
+
+func TestWriteDedup(t *testing.T) {
+	data := bytes.Repeat([]byte("dedup "), 2000)
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	for _, known := range []bool{false, true} {
+		w := startWorker(t, "w")
+		master := &fakeCoordinator{workers: []*fakeWorker{w}}
+		if known {
+			master.stored = map[string]string{hash: "existing"}
+		}
+		c := newTestClient(t, master)
+
+		if err := writeFile(c, "f", data, WithDedup()); err != nil {
+			t.Fatal(err)
+		}
+		// The block is hashed before it is allocated.
+		alloc := master.allocs[0]
+		if alloc.ContentHash != hash || alloc.SizeBytes != int64(len(data)) {
+			t.Errorf("known %v: allocated %d bytes with hash %q, want %d with %q", known, alloc.SizeBytes, alloc.ContentHash, len(data), hash)
+		}
+		commit := master.commits[0].Blocks[0]
+		if commit.ContentHash != hash {
+			t.Errorf("known %v: committed hash %q, want %q", known, commit.ContentHash, hash)
+		}
+
+		wantPushes, wantBlock := int32(1), "block-1"
+		if known {
+			wantPushes, wantBlock = 0, "existing"
+		}
+		if w.pushes.Load() != wantPushes {
+			t.Errorf("known %v: %d pushes, want %d", known, w.pushes.Load(), wantPushes)
+		}
+		if commit.BlockId != wantBlock {
+			t.Errorf("known %v: committed block %s, want %s", known, commit.BlockId, wantBlock)
+		}
+	}
+}
+
+func TestCloseAbandonsBlocks(t *testing.T) {
+	notLeader, err := status.New(codes.FailedPrecondition, "not the leader").
+		WithDetails(&coordinatorv1.NotLeader{LeaderAddress: "master-1:50055"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		commitErr   error
+		wantAbandon bool
+	}{
+		{name: "path taken", commitErr: status.Error(codes.AlreadyExists, "file exists"), wantAbandon: true},
+		{name: "generation changed", commitErr: status.Error(codes.FailedPrecondition, "generation mismatch"), wantAbandon: true},
+		{name: "no leader", commitErr: notLeader.Err()},
+		{name: "master unreachable", commitErr: status.Error(codes.Unavailable, "connection refused")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := startWorker(t, "w")
+			master := &fakeCoordinator{workers: []*fakeWorker{w}, commitErr: tt.commitErr}
+			c := newTestClient(t, master)
+
+			data := bytes.Repeat([]byte("x"), 2*MinBlockSize)
+			if err := writeFile(c, "f", data, WithBlockSize(MinBlockSize), IfNotExists()); status.Code(err) != status.Code(tt.commitErr) {
+				t.Fatalf("write error %v, want %v", err, tt.commitErr)
+			}
+			if mode := master.commits[0].Mode; mode != coordinatorv1.WriteMode_WRITE_MODE_CREATE {
+				t.Errorf("committed with mode %v", mode)
+			}
+
+			var want []string
+			if tt.wantAbandon {
+				want = []string{"block-1", "block-2"}
+			}
+			slices.Sort(master.abandoned)
+			if !slices.Equal(master.abandoned, want) {
+				t.Errorf("abandoned %v, want %v", master.abandoned, want)
+			}
+		})
+	}
+}
+
+func TestCreateDefaultsToOverwrite(t *testing.T) {
+	master := &fakeCoordinator{workers: []*fakeWorker{startWorker(t, "w")}}
+	c := newTestClient(t, master)
+
+	if err := writeFile(c, "f", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if mode := master.commits[0].Mode; mode != coordinatorv1.WriteMode_WRITE_MODE_UNSPECIFIED {
+		t.Errorf("committed with mode %v, want the default", mode)
+	}
+}
+
+func TestInterleavedWritersShareBudget(t *testing.T) {
+	w := startWorker(t, "w")
+	master := &fakeCoordinator{workers: []*fakeWorker{w}}
+	// Two chunks for three writers: a writer that runs out seals the blocks
+	// the others left open.
+	c := newTestClient(t, master, WithMaxInFlightBytes(4<<20))
+
+	files := make([]File, 3)
+	for i := range files {
+		f, err := c.Create(context.Background(), fmt.Sprintf("f%d", i), WithBlockSize(16<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = f
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		for round := 0; round < 4; round++ {
+			for i, f := range files {
+				if _, err := f.Write(bytes.Repeat([]byte{byte('a' + i)}, streamChunkSize)); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		for _, f := range files {
+			if err := f.Close(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("writers deadlocked on the in-flight budget")
+	}
+
+	if n := len(c.inFlight); n != 0 {
+		t.Errorf("%d chunks still held after Close", n)
+	}
+	for _, commit := range master.commits {
+		// File fi is filled with the i-th letter.
+		fill := []byte{'a' + commit.FilePath[1] - '0'}
+		var size int64
+		for _, b := range commit.Blocks {
+			block, _ := w.block(b.BlockId)
+			if len(bytes.Trim(block, string(fill))) != 0 {
+				t.Errorf("%s: block %s holds another file's data", commit.FilePath, b.BlockId)
+			}
+			size += b.Size
+		}
+		if size != 4*streamChunkSize {
+			t.Errorf("%s committed with %d bytes, want %d", commit.FilePath, size, 4*streamChunkSize)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	w := startWorker(t, "w")
+	master := &fakeCoordinator{workers: []*fakeWorker{w}}
+	c := newTestClient(t, master)
+
+	f, err := c.Create(context.Background(), "f", WithBlockSize(MinBlockSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(bytes.Repeat([]byte("x"), 2*MinBlockSize+10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(master.commits) != 0 {
+		t.Error("an aborted file was committed")
+	}
+	if n := len(c.inFlight); n != 0 {
+		t.Errorf("%d chunks still held after Abort", n)
+	}
+	slices.Sort(master.abandoned)
+	want := make([]string, master.nextBlock)
+	for i := range want {
+		want[i] = fmt.Sprintf("block-%d", i+1)
+	}
+	if !slices.Equal(master.abandoned, want) {
+		t.Errorf("abandoned %v, want %v", master.abandoned, want)
+	}
+	if _, err := f.Write([]byte("more")); err == nil {
+		t.Error("write after Abort succeeded")
+	}
+	if err := f.Close(); err == nil {
+		t.Error("Close after Abort succeeded")
+	}
+}
+
+func TestCancelReleasesOpenBlock(t *testing.T) {
+	master := &fakeCoordinator{workers: []*fakeWorker{startWorker(t, "w")}}
+	c := newTestClient(t, master)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := c.Create(ctx, "f", WithBlockSize(2*streamChunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A full chunk goes to a block that stays open for the next one.
+	if _, err := f.Write(bytes.Repeat([]byte("x"), streamChunkSize)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.inFlight); n != 1 {
+		t.Fatalf("%d chunks in flight, want 1", n)
+	}
+
+	cancel()
+	eventually(t, func() bool { return len(c.inFlight) == 0 }, "the open block kept its chunk after the context was cancelled")
+	if err := f.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,13 @@
+// Package dfsmeta holds the gRPC metadata keys that DFS clients and masters
+// exchange, so neither side has to import the other.
+package dfsmeta
+
+// Metadata keys of stale reads. Standbys started with stale reads enabled
+// serve the namespace reads of callers that send AllowStaleHeader, once they
+// have applied the TxID in MinTxIDHeader. Every coordinator response carries
+// the TxID of the namespace state it reflects in TxIDHeader.
+const (
+	AllowStaleHeader = "x-dfs-allow-stale"
+	MinTxIDHeader    = "x-dfs-min-txid"
+	TxIDHeader       = "x-dfs-txid"
+)
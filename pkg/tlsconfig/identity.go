@@ -0,0 +1,119 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/x509"
+	"net/url"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// trustDomain is the host part of the identity URIs.
+const trustDomain = "datalake"
+
+// IdentityURI returns the URI SAN that names service, e.g.
+// spiffe://datalake/master.
+func IdentityURI(service string) *url.URL {
+	return &url.URL{Scheme: "spiffe", Host: trustDomain, Path: "/" + service}
+}
+
+// Identity returns the service named by the certificate's URI SAN, or "" if
+// it has none.
+func Identity(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" && u.Host == trustDomain {
+			return strings.TrimPrefix(u.Path, "/")
+		}
+	}
+	return ""
+}
+
+// PeerIdentity returns the identity of the caller of a gRPC handler. It is
+// false when the connection does not use TLS.
+func PeerIdentity(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return "", false
+	}
+	return Identity(info.State.PeerCertificates[0]), true
+}
+
+// Policy maps gRPC methods to the identities allowed to call them. Keys are
+// full method names, "/datanode.v1.DataNodeService/DeleteBlock", or service
+// names ending in a slash, "/admin.v1.AdminService/", covering all of the
+// service's methods. Methods not listed are open to every caller.
+//
+// Calls to listed methods without a verified client certificate are refused.
+// ServerOptions leaves policies out of plaintext servers, which have no caller
+// identity to check.
+type Policy map[string][]string
+
+// allowed returns the identities that may call method, or nil if any may.
+func (p Policy) allowed(method string) []string {
+	if ids, ok := p[method]; ok {
+		return ids
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		return p[method[:i+1]]
+	}
+	return nil
+}
+
+func (p Policy) authorize(ctx context.Context, method string) error {
+	allowed := p.allowed(method)
+	if allowed == nil {
+		return nil
+	}
+	id, ok := PeerIdentity(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "%s requires a verified client certificate", method)
+	}
+	if !slices.Contains(allowed, id) {
+		return status.Errorf(codes.PermissionDenied, "%q may not call %s", id, method)
+	}
+	return nil
+}
+
+// UnaryInterceptor rejects unary calls the policy does not allow.
+func (p Policy) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := p.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor rejects streaming calls the policy does not allow.
+func (p Policy) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := p.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// ServerOptions returns the credentials and policy interceptors for a gRPC
+// server, without the interceptors when TLS is disabled. Servers with more
+// interceptors chain them after these.
+func (s *Source) ServerOptions(p Policy) []grpc.ServerOption {
+	if !s.Enabled() {
+		return []grpc.ServerOption{s.ServerOption()}
+	}
+	return []grpc.ServerOption{
+		s.ServerOption(),
+		grpc.ChainUnaryInterceptor(p.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(p.StreamInterceptor()),
+	}
+}
@@ -0,0 +1,267 @@
+// Package tlsconfig sets up mutual TLS between the datalake services.
+//
+// Every service presents a certificate whose URI SAN names it, as in
+// spiffe://datalake/master, and trusts certificates signed by a shared CA.
+// Certificates are read from files and reloaded when the files change, so
+// rotated certificates are picked up without a restart. Servers authorize
+// callers by the identity in their certificate, see Policy.
+//
+// Services read their settings from the environment:
+//
+//	TLS_CERT_FILE, TLS_KEY_FILE  the service's certificate and key
+//	TLS_CA_FILE                  the CA bundle peers are checked against
+//	TLS_DEV_MODE=true            issue certificates from a local CA instead
+//	TLS_DEV_DIR                  where dev mode keeps its CA
+//
+// With none of these set, connections stay plaintext as before.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// reloadInterval is how often the certificate files are checked for changes.
+const reloadInterval = 30 * time.Second
+
+// Config says where a service finds its certificates.
+type Config struct {
+	// Service is the identity the service runs as, e.g. "master". Dev mode
+	// issues the certificate for it.
+	Service string
+
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	// DevMode issues a certificate for Service from a CA kept in DevDir,
+	// created on first use. It is meant for local runs only.
+	DevMode bool
+	DevDir  string
+}
+
+// FromEnv reads the TLS settings of service from the environment.
+func FromEnv(service string) Config {
+	cfg := Config{
+		Service:  service,
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+		CAFile:   os.Getenv("TLS_CA_FILE"),
+		DevDir:   os.Getenv("TLS_DEV_DIR"),
+	}
+	cfg.DevMode, _ = strconv.ParseBool(os.Getenv("TLS_DEV_MODE"))
+	if cfg.DevDir == "" {
+		cfg.DevDir = filepath.Join(os.TempDir(), "datalake-dev-ca")
+	}
+	return cfg
+}
+
+// Source hands out TLS configurations built from the current certificates.
+// A nil or disabled Source yields plaintext credentials.
+type Source struct {
+	cfg Config
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+	modTime time.Time
+	checked time.Time
+}
+
+// Load reads the certificates named by cfg. It returns a disabled Source when
+// cfg names none and dev mode is off.
+func Load(cfg Config) (*Source, error) {
+	s := &Source{cfg: cfg}
+
+	switch {
+	case cfg.DevMode:
+		cert, roots, err := devCertificate(cfg.DevDir, cfg.Service)
+		if err != nil {
+			return nil, fmt.Errorf("dev certificates: %w", err)
+		}
+		s.cert, s.roots = cert, roots
+		log.Printf("TLS: dev mode, %s certificate issued by the CA in %s", cfg.Service, cfg.DevDir)
+	case cfg.CertFile != "" || cfg.KeyFile != "" || cfg.CAFile != "":
+		if cfg.CertFile == "" || cfg.KeyFile == "" || cfg.CAFile == "" {
+			return nil, errors.New("TLS needs a certificate, a key and a CA file")
+		}
+		if err := s.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// MustLoad is like Load but exits the process on error.
+func MustLoad(cfg Config) *Source {
+	s, err := Load(cfg)
+	if err != nil {
+		log.Fatalf("TLS: %v", err)
+	}
+	return s
+}
+
+// Enabled reports whether connections use TLS.
+func (s *Source) Enabled() bool {
+	return s != nil && (s.cfg.DevMode || s.cfg.CertFile != "")
+}
+
+// reload reads the certificate files again if any of them changed since the
+// last read.
+func (s *Source) reload() error {
+	files := []string{s.cfg.CertFile, s.cfg.KeyFile, s.cfg.CAFile}
+	var latest time.Time
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	if s.cert != nil && !latest.After(s.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	pem, err := os.ReadFile(s.cfg.CAFile)
+	if err != nil {
+		return fmt.Errorf("read CA file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates in CA file %s", s.cfg.CAFile)
+	}
+
+	if s.cert != nil {
+		log.Printf("TLS: reloaded certificates from %s", s.cfg.CertFile)
+	}
+	s.cert, s.roots, s.modTime = &cert, roots, latest
+	return nil
+}
+
+// current returns the certificate and CA pool to use for a new connection,
+// reloading them from disk at most every reloadInterval. A failed reload
+// keeps the previous certificates.
+func (s *Source) current() (*tls.Certificate, *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg.CertFile != "" && !s.cfg.DevMode && time.Since(s.checked) >= reloadInterval {
+		s.checked = time.Now()
+		if err := s.reload(); err != nil {
+			log.Printf("TLS: keeping the current certificates, reload failed: %v", err)
+		}
+	}
+	return s.cert, s.roots
+}
+
+// ServerConfig returns a TLS configuration for servers that requires client
+// certificates signed by the CA.
+func (s *Source) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, roots := s.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    roots,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				NextProtos:   []string{"h2"},
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns a TLS configuration for clients that presents the
+// service's certificate and accepts servers whose certificate is signed by the
+// CA and names one of peers. With no peers any service is accepted.
+//
+// Services are addressed by Kubernetes DNS names that the certificates need
+// not list, so the server is checked by identity rather than host name.
+func (s *Source) ClientConfig(peers ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The chain and identity are checked by VerifyConnection below.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			return cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, roots := s.current()
+			leaf, err := verifyChain(cs.PeerCertificates, roots, x509.ExtKeyUsageServerAuth)
+			if err != nil {
+				return err
+			}
+			if id := Identity(leaf); len(peers) > 0 && !slices.Contains(peers, id) {
+				return fmt.Errorf("server identity %q is not one of %v", id, peers)
+			}
+			return nil
+		},
+	}
+}
+
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, usage x509.ExtKeyUsage) (*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("peer sent no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// ServerCredentials returns the transport credentials for gRPC servers.
+func (s *Source) ServerCredentials() credentials.TransportCredentials {
+	if !s.Enabled() {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(s.ServerConfig())
+}
+
+// ClientCredentials returns the transport credentials for dialing services
+// with one of the identities in peers.
+func (s *Source) ClientCredentials(peers ...string) credentials.TransportCredentials {
+	if !s.Enabled() {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(s.ClientConfig(peers...))
+}
+
+// ServerOption is grpc.Creds with the server credentials.
+func (s *Source) ServerOption() grpc.ServerOption {
+	return grpc.Creds(s.ServerCredentials())
+}
+
+// DialOption is grpc.WithTransportCredentials with the client credentials.
+func (s *Source) DialOption(peers ...string) grpc.DialOption {
+	return grpc.WithTransportCredentials(s.ClientCredentials(peers...))
+}
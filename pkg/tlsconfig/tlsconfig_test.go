@@ -0,0 +1,313 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// writeCertFiles issues a certificate for service from the dev CA in caDir
+// and writes it, its key and the CA to dir, as a deployment would mount them.
+func writeCertFiles(t *testing.T, caDir, dir, service string) Config {
+	t.Helper()
+	cert, _, err := devCertificate(caDir, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _, err := readDevCA(filepath.Join(caDir, devCAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		Service:  service,
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	var chain []byte
+	for _, der := range cert.Certificate {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	files := map[string][]byte{
+		cfg.CertFile: chain,
+		cfg.KeyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		cfg.CAFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg
+}
+
+// touch moves the modification time of the files of cfg forward, as a
+// rotation by a secret mount does.
+func touch(t *testing.T, cfg Config, at time.Time) {
+	t.Helper()
+	for _, name := range []string{cfg.CertFile, cfg.KeyFile, cfg.CAFile} {
+		if err := os.Chtimes(name, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedLeaf starts a TLS handshake with a server of src and returns the
+// certificate it presents.
+func servedLeaf(t *testing.T, src *Source, client *Source) *x509.Certificate {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", src.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client.ClientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestReloadRotatedCertificates(t *testing.T) {
+	caDir, dir := t.TempDir(), t.TempDir()
+	cfg := writeCertFiles(t, caDir, dir, "master")
+	src, err := Load(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Load(Config{Service: "worker", DevMode: true, DevDir: caDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := servedLeaf(t, src, client)
+	if got := Identity(first); got != "master" {
+		t.Fatalf("served identity %q, want master", got)
+	}
+
+	// The rotated files are picked up once the reload interval has passed.
+	writeCertFiles(t, caDir, dir, "master")
+	touch(t, cfg, time.Now().Add(time.Minute))
+	if leaf := servedLeaf(t, src, client); !leaf.Equal(first) {
+		t.Fatal("certificate reloaded before the reload interval passed")
+	}
+	src.mu.Lock()
+	src.checked = time.Time{}
+	src.mu.Unlock()
+	second := servedLeaf(t, src, client)
+	if second.Equal(first) {
+		t.Fatal("rotated certificate was not served")
+	}
+
+	// A broken rotation keeps the last good certificate.
+	if err := os.WriteFile(cfg.KeyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, cfg, time.Now().Add(2*time.Minute))
+	src.mu.Lock()
+	src.checked = time.Time{}
+	src.mu.Unlock()
+	if leaf := servedLeaf(t, src, client); !leaf.Equal(second) {
+		t.Fatal("a failed reload replaced the certificate")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	caDir := t.TempDir()
+	files := writeCertFiles(t, caDir, t.TempDir(), "master")
+
+	tests := []struct {
+		name        string
+		cfg         Config
+		wantErr     bool
+		wantEnabled bool
+	}{
+		{name: "plaintext", cfg: Config{Service: "master"}},
+		{name: "files", cfg: files, wantEnabled: true},
+		{name: "missing CA file", cfg: Config{Service: "master", CertFile: files.CertFile, KeyFile: files.KeyFile}, wantErr: true},
+		{name: "unreadable files", cfg: Config{Service: "master", CertFile: "/nonexistent", KeyFile: "/nonexistent", CAFile: "/nonexistent"}, wantErr: true},
+		{name: "dev mode", cfg: Config{Service: "master", DevMode: true, DevDir: caDir}, wantEnabled: true},
+		{name: "dev mode without identity", cfg: Config{DevMode: true, DevDir: caDir}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := Load(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && src.Enabled() != tt.wantEnabled {
+				t.Errorf("Enabled() = %v, want %v", src.Enabled(), tt.wantEnabled)
+			}
+		})
+	}
+}
+
+func TestDevCertificates(t *testing.T) {
+	caDir := t.TempDir()
+	master, err := Load(Config{Service: "master", DevMode: true, DevDir: caDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, err := Load(Config{Service: "worker", DevMode: true, DevDir: caDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := servedLeaf(t, master, worker)
+	if got := Identity(leaf); got != "master" {
+		t.Errorf("served identity %q, want master", got)
+	}
+	if got := leaf.URIs[0].String(); got != "spiffe://datalake/master" {
+		t.Errorf("URI SAN %s", got)
+	}
+
+	// Services started with another dev directory have another CA.
+	stranger, err := Load(Config{Service: "worker", DevMode: true, DevDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", master.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		if conn, err := lis.Accept(); err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	if conn, err := tls.Dial("tcp", lis.Addr().String(), stranger.ClientConfig()); err == nil {
+		conn.Close()
+		t.Error("a certificate from another CA was accepted")
+	}
+}
+
+func TestPeerIdentity(t *testing.T) {
+	caDir := t.TempDir()
+	master, err := Load(Config{Service: "master", DevMode: true, DevDir: caDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(chan string, 1)
+	record := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id, ok := PeerIdentity(ctx)
+		if !ok {
+			id = "<none>"
+		}
+		seen <- id
+		return handler(ctx, req)
+	}
+	policy := Policy{"/grpc.health.v1.Health/": {"master", "worker"}}
+	s := grpc.NewServer(append(master.ServerOptions(policy), grpc.ChainUnaryInterceptor(record))...)
+	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	tests := []struct {
+		name     string
+		service  string
+		peers    []string
+		wantCode codes.Code
+	}{
+		{name: "allowed identity", service: "worker", wantCode: codes.OK},
+		{name: "identity outside the policy", service: "gateway", wantCode: codes.PermissionDenied},
+		{name: "server identity checked", service: "worker", peers: []string{"catalog"}, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := Load(Config{Service: tt.service, DevMode: true, DevDir: caDir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc, err := grpc.NewClient(lis.Addr().String(), client.DialOption(tt.peers...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = grpc_health_v1.NewHealthClient(cc).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code %v, want %v (%v)", got, tt.wantCode, err)
+			}
+			if tt.wantCode == codes.OK {
+				if id := <-seen; id != tt.service {
+					t.Errorf("PeerIdentity = %q, want %q", id, tt.service)
+				}
+			}
+		})
+	}
+
+	if _, ok := PeerIdentity(context.Background()); ok {
+		t.Error("PeerIdentity found an identity without a peer")
+	}
+}
+
+func TestPolicyWithoutPeerIdentity(t *testing.T) {
+	policy := Policy{"/grpc.health.v1.Health/": {"master"}}
+	handler := func(ctx context.Context, req any) (any, error) { return "served", nil }
+
+	_, err := policy.UnaryInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	if got := status.Code(err); got != codes.Unauthenticated {
+		t.Errorf("call without a peer certificate: code %v, want %v", got, codes.Unauthenticated)
+	}
+	if _, err := policy.UnaryInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/other.v1.Service/Call"}, handler); err != nil {
+		t.Errorf("method outside the policy: %v", err)
+	}
+
+	// Plaintext servers have no identities to check, so the policy is left out.
+	var plaintext *Source
+	s := grpc.NewServer(plaintext.ServerOptions(policy)...)
+	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	cc, err := grpc.NewClient(lis.Addr().String(), plaintext.DialOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := grpc_health_v1.NewHealthClient(cc).Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Errorf("plaintext call: %v", err)
+	}
+}
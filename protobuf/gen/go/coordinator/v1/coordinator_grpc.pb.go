@@ -24,6 +24,19 @@ const (
 	CoordinatorService_CommitCompaction_FullMethodName = "/coordinator.v1.CoordinatorService/CommitCompaction"
 	CoordinatorService_GetFileMetadata_FullMethodName  = "/coordinator.v1.CoordinatorService/GetFileMetadata"
 	CoordinatorService_ListFiles_FullMethodName        = "/coordinator.v1.CoordinatorService/ListFiles"
+	CoordinatorService_ReportBadReplica_FullMethodName = "/coordinator.v1.CoordinatorService/ReportBadReplica"
+	CoordinatorService_AbandonBlock_FullMethodName     = "/coordinator.v1.CoordinatorService/AbandonBlock"
+	CoordinatorService_GetFileInfo_FullMethodName      = "/coordinator.v1.CoordinatorService/GetFileInfo"
+	CoordinatorService_ListDirectory_FullMethodName    = "/coordinator.v1.CoordinatorService/ListDirectory"
+	CoordinatorService_DeleteFile_FullMethodName       = "/coordinator.v1.CoordinatorService/DeleteFile"
+	CoordinatorService_ConcatFiles_FullMethodName      = "/coordinator.v1.CoordinatorService/ConcatFiles"
+	CoordinatorService_Rename_FullMethodName           = "/coordinator.v1.CoordinatorService/Rename"
+	CoordinatorService_Mkdirs_FullMethodName           = "/coordinator.v1.CoordinatorService/Mkdirs"
+	CoordinatorService_GetFileChecksum_FullMethodName  = "/coordinator.v1.CoordinatorService/GetFileChecksum"
+	CoordinatorService_SetStoragePolicy_FullMethodName = "/coordinator.v1.CoordinatorService/SetStoragePolicy"
+	CoordinatorService_SetAttr_FullMethodName          = "/coordinator.v1.CoordinatorService/SetAttr"
+	CoordinatorService_SetXAttr_FullMethodName         = "/coordinator.v1.CoordinatorService/SetXAttr"
+	CoordinatorService_WatchNamespace_FullMethodName   = "/coordinator.v1.CoordinatorService/WatchNamespace"
 )
 
 // CoordinatorServiceClient is the client API for CoordinatorService service.
@@ -35,6 +48,19 @@ type CoordinatorServiceClient interface {
 	CommitCompaction(ctx context.Context, in *CommitCompactionRequest, opts ...grpc.CallOption) (*CommitCompactionResponse, error)
 	GetFileMetadata(ctx context.Context, in *GetFileMetadataRequest, opts ...grpc.CallOption) (*GetFileMetadataResponse, error)
 	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	ReportBadReplica(ctx context.Context, in *ReportBadReplicaRequest, opts ...grpc.CallOption) (*ReportBadReplicaResponse, error)
+	AbandonBlock(ctx context.Context, in *AbandonBlockRequest, opts ...grpc.CallOption) (*AbandonBlockResponse, error)
+	GetFileInfo(ctx context.Context, in *GetFileInfoRequest, opts ...grpc.CallOption) (*GetFileInfoResponse, error)
+	ListDirectory(ctx context.Context, in *ListDirectoryRequest, opts ...grpc.CallOption) (*ListDirectoryResponse, error)
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
+	ConcatFiles(ctx context.Context, in *ConcatFilesRequest, opts ...grpc.CallOption) (*ConcatFilesResponse, error)
+	Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error)
+	Mkdirs(ctx context.Context, in *MkdirsRequest, opts ...grpc.CallOption) (*MkdirsResponse, error)
+	GetFileChecksum(ctx context.Context, in *GetFileChecksumRequest, opts ...grpc.CallOption) (*GetFileChecksumResponse, error)
+	SetStoragePolicy(ctx context.Context, in *SetStoragePolicyRequest, opts ...grpc.CallOption) (*SetStoragePolicyResponse, error)
+	SetAttr(ctx context.Context, in *SetAttrRequest, opts ...grpc.CallOption) (*SetAttrResponse, error)
+	SetXAttr(ctx context.Context, in *SetXAttrRequest, opts ...grpc.CallOption) (*SetXAttrResponse, error)
+	WatchNamespace(ctx context.Context, in *WatchNamespaceRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[NamespaceEvent], error)
 }
 
 type coordinatorServiceClient struct {
@@ -95,6 +121,145 @@ func (c *coordinatorServiceClient) ListFiles(ctx context.Context, in *ListFilesR
 	return out, nil
 }
 
+func (c *coordinatorServiceClient) ReportBadReplica(ctx context.Context, in *ReportBadReplicaRequest, opts ...grpc.CallOption) (*ReportBadReplicaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReportBadReplicaResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_ReportBadReplica_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) AbandonBlock(ctx context.Context, in *AbandonBlockRequest, opts ...grpc.CallOption) (*AbandonBlockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AbandonBlockResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_AbandonBlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) GetFileInfo(ctx context.Context, in *GetFileInfoRequest, opts ...grpc.CallOption) (*GetFileInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetFileInfoResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_GetFileInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) ListDirectory(ctx context.Context, in *ListDirectoryRequest, opts ...grpc.CallOption) (*ListDirectoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDirectoryResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_ListDirectory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteFileResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_DeleteFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) ConcatFiles(ctx context.Context, in *ConcatFilesRequest, opts ...grpc.CallOption) (*ConcatFilesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConcatFilesResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_ConcatFiles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RenameResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_Rename_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) Mkdirs(ctx context.Context, in *MkdirsRequest, opts ...grpc.CallOption) (*MkdirsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MkdirsResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_Mkdirs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) GetFileChecksum(ctx context.Context, in *GetFileChecksumRequest, opts ...grpc.CallOption) (*GetFileChecksumResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetFileChecksumResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_GetFileChecksum_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) SetStoragePolicy(ctx context.Context, in *SetStoragePolicyRequest, opts ...grpc.CallOption) (*SetStoragePolicyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetStoragePolicyResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_SetStoragePolicy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) SetAttr(ctx context.Context, in *SetAttrRequest, opts ...grpc.CallOption) (*SetAttrResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetAttrResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_SetAttr_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) SetXAttr(ctx context.Context, in *SetXAttrRequest, opts ...grpc.CallOption) (*SetXAttrResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetXAttrResponse)
+	err := c.cc.Invoke(ctx, CoordinatorService_SetXAttr_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorServiceClient) WatchNamespace(ctx context.Context, in *WatchNamespaceRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[NamespaceEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CoordinatorService_ServiceDesc.Streams[0], CoordinatorService_WatchNamespace_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchNamespaceRequest, NamespaceEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CoordinatorService_WatchNamespaceClient = grpc.ServerStreamingClient[NamespaceEvent]
+
 // CoordinatorServiceServer is the server API for CoordinatorService service.
 // All implementations must embed UnimplementedCoordinatorServiceServer
 // for forward compatibility.
@@ -104,6 +269,19 @@ type CoordinatorServiceServer interface {
 	CommitCompaction(context.Context, *CommitCompactionRequest) (*CommitCompactionResponse, error)
 	GetFileMetadata(context.Context, *GetFileMetadataRequest) (*GetFileMetadataResponse, error)
 	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
+	ReportBadReplica(context.Context, *ReportBadReplicaRequest) (*ReportBadReplicaResponse, error)
+	AbandonBlock(context.Context, *AbandonBlockRequest) (*AbandonBlockResponse, error)
+	GetFileInfo(context.Context, *GetFileInfoRequest) (*GetFileInfoResponse, error)
+	ListDirectory(context.Context, *ListDirectoryRequest) (*ListDirectoryResponse, error)
+	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error)
+	ConcatFiles(context.Context, *ConcatFilesRequest) (*ConcatFilesResponse, error)
+	Rename(context.Context, *RenameRequest) (*RenameResponse, error)
+	Mkdirs(context.Context, *MkdirsRequest) (*MkdirsResponse, error)
+	GetFileChecksum(context.Context, *GetFileChecksumRequest) (*GetFileChecksumResponse, error)
+	SetStoragePolicy(context.Context, *SetStoragePolicyRequest) (*SetStoragePolicyResponse, error)
+	SetAttr(context.Context, *SetAttrRequest) (*SetAttrResponse, error)
+	SetXAttr(context.Context, *SetXAttrRequest) (*SetXAttrResponse, error)
+	WatchNamespace(*WatchNamespaceRequest, grpc.ServerStreamingServer[NamespaceEvent]) error
 	mustEmbedUnimplementedCoordinatorServiceServer()
 }
 
@@ -129,6 +307,45 @@ func (UnimplementedCoordinatorServiceServer) GetFileMetadata(context.Context, *G
 func (UnimplementedCoordinatorServiceServer) ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
 }
+func (UnimplementedCoordinatorServiceServer) ReportBadReplica(context.Context, *ReportBadReplicaRequest) (*ReportBadReplicaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportBadReplica not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) AbandonBlock(context.Context, *AbandonBlockRequest) (*AbandonBlockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbandonBlock not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) GetFileInfo(context.Context, *GetFileInfoRequest) (*GetFileInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileInfo not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) ListDirectory(context.Context, *ListDirectoryRequest) (*ListDirectoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDirectory not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) ConcatFiles(context.Context, *ConcatFilesRequest) (*ConcatFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConcatFiles not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Rename(context.Context, *RenameRequest) (*RenameResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) Mkdirs(context.Context, *MkdirsRequest) (*MkdirsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mkdirs not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) GetFileChecksum(context.Context, *GetFileChecksumRequest) (*GetFileChecksumResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileChecksum not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) SetStoragePolicy(context.Context, *SetStoragePolicyRequest) (*SetStoragePolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetStoragePolicy not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) SetAttr(context.Context, *SetAttrRequest) (*SetAttrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetAttr not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) SetXAttr(context.Context, *SetXAttrRequest) (*SetXAttrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetXAttr not implemented")
+}
+func (UnimplementedCoordinatorServiceServer) WatchNamespace(*WatchNamespaceRequest, grpc.ServerStreamingServer[NamespaceEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNamespace not implemented")
+}
 func (UnimplementedCoordinatorServiceServer) mustEmbedUnimplementedCoordinatorServiceServer() {}
 func (UnimplementedCoordinatorServiceServer) testEmbeddedByValue()                            {}
 
@@ -240,6 +457,233 @@ func _CoordinatorService_ListFiles_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CoordinatorService_ReportBadReplica_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportBadReplicaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).ReportBadReplica(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_ReportBadReplica_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).ReportBadReplica(ctx, req.(*ReportBadReplicaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_AbandonBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbandonBlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).AbandonBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_AbandonBlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).AbandonBlock(ctx, req.(*AbandonBlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_GetFileInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).GetFileInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_GetFileInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).GetFileInfo(ctx, req.(*GetFileInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_ListDirectory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDirectoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).ListDirectory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_ListDirectory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).ListDirectory(ctx, req.(*ListDirectoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_ConcatFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConcatFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).ConcatFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_ConcatFiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).ConcatFiles(ctx, req.(*ConcatFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Rename(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Rename_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Rename(ctx, req.(*RenameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_Mkdirs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkdirsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).Mkdirs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_Mkdirs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).Mkdirs(ctx, req.(*MkdirsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_GetFileChecksum_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileChecksumRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).GetFileChecksum(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_GetFileChecksum_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).GetFileChecksum(ctx, req.(*GetFileChecksumRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_SetStoragePolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetStoragePolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).SetStoragePolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_SetStoragePolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).SetStoragePolicy(ctx, req.(*SetStoragePolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_SetAttr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetAttrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).SetAttr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_SetAttr_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).SetAttr(ctx, req.(*SetAttrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_SetXAttr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetXAttrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServiceServer).SetXAttr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoordinatorService_SetXAttr_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServiceServer).SetXAttr(ctx, req.(*SetXAttrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoordinatorService_WatchNamespace_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchNamespaceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CoordinatorServiceServer).WatchNamespace(m, &grpc.GenericServerStream[WatchNamespaceRequest, NamespaceEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CoordinatorService_WatchNamespaceServer = grpc.ServerStreamingServer[NamespaceEvent]
+
 // CoordinatorService_ServiceDesc is the grpc.ServiceDesc for CoordinatorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -267,7 +711,61 @@ var CoordinatorService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListFiles",
 			Handler:    _CoordinatorService_ListFiles_Handler,
 		},
+		{
+			MethodName: "ReportBadReplica",
+			Handler:    _CoordinatorService_ReportBadReplica_Handler,
+		},
+		{
+			MethodName: "AbandonBlock",
+			Handler:    _CoordinatorService_AbandonBlock_Handler,
+		},
+		{
+			MethodName: "GetFileInfo",
+			Handler:    _CoordinatorService_GetFileInfo_Handler,
+		},
+		{
+			MethodName: "ListDirectory",
+			Handler:    _CoordinatorService_ListDirectory_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _CoordinatorService_DeleteFile_Handler,
+		},
+		{
+			MethodName: "ConcatFiles",
+			Handler:    _CoordinatorService_ConcatFiles_Handler,
+		},
+		{
+			MethodName: "Rename",
+			Handler:    _CoordinatorService_Rename_Handler,
+		},
+		{
+			MethodName: "Mkdirs",
+			Handler:    _CoordinatorService_Mkdirs_Handler,
+		},
+		{
+			MethodName: "GetFileChecksum",
+			Handler:    _CoordinatorService_GetFileChecksum_Handler,
+		},
+		{
+			MethodName: "SetStoragePolicy",
+			Handler:    _CoordinatorService_SetStoragePolicy_Handler,
+		},
+		{
+			MethodName: "SetAttr",
+			Handler:    _CoordinatorService_SetAttr_Handler,
+		},
+		{
+			MethodName: "SetXAttr",
+			Handler:    _CoordinatorService_SetXAttr_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNamespace",
+			Handler:       _CoordinatorService_WatchNamespace_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "coordinator/v1/coordinator.proto",
 }
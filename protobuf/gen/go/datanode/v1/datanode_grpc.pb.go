@@ -24,6 +24,8 @@ const (
 	DataNodeService_GetWorkerInfo_FullMethodName    = "/datanode.v1.DataNodeService/GetWorkerInfo"
 	DataNodeService_DeleteBlock_FullMethodName      = "/datanode.v1.DataNodeService/DeleteBlock"
 	DataNodeService_GetBlockChecksum_FullMethodName = "/datanode.v1.DataNodeService/GetBlockChecksum"
+	DataNodeService_TransferBlock_FullMethodName    = "/datanode.v1.DataNodeService/TransferBlock"
+	DataNodeService_GetBlockReport_FullMethodName   = "/datanode.v1.DataNodeService/GetBlockReport"
 )
 
 // DataNodeServiceClient is the client API for DataNodeService service.
@@ -35,6 +37,8 @@ type DataNodeServiceClient interface {
 	GetWorkerInfo(ctx context.Context, in *GetWorkerInfoRequest, opts ...grpc.CallOption) (*GetWorkerInfoResponse, error)
 	DeleteBlock(ctx context.Context, in *DeleteBlockRequest, opts ...grpc.CallOption) (*DeleteBlockResponse, error)
 	GetBlockChecksum(ctx context.Context, in *GetBlockChecksumRequest, opts ...grpc.CallOption) (*GetBlockChecksumResponse, error)
+	TransferBlock(ctx context.Context, in *TransferBlockRequest, opts ...grpc.CallOption) (*TransferBlockResponse, error)
+	GetBlockReport(ctx context.Context, in *GetBlockReportRequest, opts ...grpc.CallOption) (*GetBlockReportResponse, error)
 }
 
 type dataNodeServiceClient struct {
@@ -107,6 +111,26 @@ func (c *dataNodeServiceClient) GetBlockChecksum(ctx context.Context, in *GetBlo
 	return out, nil
 }
 
+func (c *dataNodeServiceClient) TransferBlock(ctx context.Context, in *TransferBlockRequest, opts ...grpc.CallOption) (*TransferBlockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransferBlockResponse)
+	err := c.cc.Invoke(ctx, DataNodeService_TransferBlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dataNodeServiceClient) GetBlockReport(ctx context.Context, in *GetBlockReportRequest, opts ...grpc.CallOption) (*GetBlockReportResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBlockReportResponse)
+	err := c.cc.Invoke(ctx, DataNodeService_GetBlockReport_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DataNodeServiceServer is the server API for DataNodeService service.
 // All implementations must embed UnimplementedDataNodeServiceServer
 // for forward compatibility.
@@ -116,6 +140,8 @@ type DataNodeServiceServer interface {
 	GetWorkerInfo(context.Context, *GetWorkerInfoRequest) (*GetWorkerInfoResponse, error)
 	DeleteBlock(context.Context, *DeleteBlockRequest) (*DeleteBlockResponse, error)
 	GetBlockChecksum(context.Context, *GetBlockChecksumRequest) (*GetBlockChecksumResponse, error)
+	TransferBlock(context.Context, *TransferBlockRequest) (*TransferBlockResponse, error)
+	GetBlockReport(context.Context, *GetBlockReportRequest) (*GetBlockReportResponse, error)
 	mustEmbedUnimplementedDataNodeServiceServer()
 }
 
@@ -141,6 +167,12 @@ func (UnimplementedDataNodeServiceServer) DeleteBlock(context.Context, *DeleteBl
 func (UnimplementedDataNodeServiceServer) GetBlockChecksum(context.Context, *GetBlockChecksumRequest) (*GetBlockChecksumResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlockChecksum not implemented")
 }
+func (UnimplementedDataNodeServiceServer) TransferBlock(context.Context, *TransferBlockRequest) (*TransferBlockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferBlock not implemented")
+}
+func (UnimplementedDataNodeServiceServer) GetBlockReport(context.Context, *GetBlockReportRequest) (*GetBlockReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockReport not implemented")
+}
 func (UnimplementedDataNodeServiceServer) mustEmbedUnimplementedDataNodeServiceServer() {}
 func (UnimplementedDataNodeServiceServer) testEmbeddedByValue()                         {}
 
@@ -234,6 +266,42 @@ func _DataNodeService_GetBlockChecksum_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DataNodeService_TransferBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferBlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataNodeServiceServer).TransferBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataNodeService_TransferBlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataNodeServiceServer).TransferBlock(ctx, req.(*TransferBlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DataNodeService_GetBlockReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBlockReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataNodeServiceServer).GetBlockReport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DataNodeService_GetBlockReport_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataNodeServiceServer).GetBlockReport(ctx, req.(*GetBlockReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DataNodeService_ServiceDesc is the grpc.ServiceDesc for DataNodeService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -253,6 +321,14 @@ var DataNodeService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlockChecksum",
 			Handler:    _DataNodeService_GetBlockChecksum_Handler,
 		},
+		{
+			MethodName: "TransferBlock",
+			Handler:    _DataNodeService_TransferBlock_Handler,
+		},
+		{
+			MethodName: "GetBlockReport",
+			Handler:    _DataNodeService_GetBlockReport_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -8,10 +8,10 @@ import (
 
 	compactormanager "github.com/razvanmarinn/datalake/compactor/internal/compactor-manager"
 	"github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	catalogv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/catalog/v1"
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
@@ -25,7 +25,9 @@ func main() {
 		metadataServiceAddr = "metadata-service:50056"
 	}
 
-	metaConn, err := grpc.NewClient(metadataServiceAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	tlsSource := tlsconfig.MustLoad(tlsconfig.FromEnv("compactor"))
+
+	metaConn, err := grpc.NewClient(metadataServiceAddr, tlsSource.DialOption("metadata-service"))
 	if err != nil {
 		log.Fatalf("Failed to connect to metadata service: %v", err)
 	}
@@ -38,13 +40,13 @@ func main() {
 		masterServiceAddr = "master.datalake.svc.cluster.local:50055"
 	}
 
-	dfsClient, err := dfs.NewClient(masterServiceAddr)
+	dfsClient, err := dfs.NewClient(masterServiceAddr, dfs.WithTransportCredentials(tlsSource.ClientCredentials("master", "worker")))
 	if err != nil {
 		log.Fatalf("Failed to create DFS client: %v", err)
 	}
 	defer dfsClient.Close()
 
-	masterConn, err := grpc.NewClient(masterServiceAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	masterConn, err := grpc.NewClient(masterServiceAddr, tlsSource.DialOption("master"))
 	if err != nil {
 		log.Fatalf("Failed to connect to master service: %v", err)
 	}
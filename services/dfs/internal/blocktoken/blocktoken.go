@@ -0,0 +1,263 @@
+// Package blocktoken issues and checks the block access tokens that let
+// clients read and write blocks on workers.
+//
+// The master hands out a token with every block it allocates or describes.
+// A token names one block, the operations it allows and the project the
+// block belongs to, and expires after a short lifetime. It is signed with
+// HMAC-SHA256 under a key shared by the masters and workers.
+//
+// Keys are read from a file with one "<id> <base64 secret>" pair per line.
+// The last key signs new tokens and all of them are accepted, so keys are
+// rotated by appending a new one and dropping the oldest once the tokens it
+// signed have expired. The file is re-read when it changes.
+package blocktoken
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is an operation a token allows.
+type Op string
+
+const (
+	OpRead   Op = "read"
+	OpWrite  Op = "write"
+	OpDelete Op = "delete"
+)
+
+const (
+	// DefaultLifetime is how long tokens are valid unless
+	// $DFS_BLOCK_TOKEN_LIFETIME says otherwise.
+	DefaultLifetime = time.Hour
+
+	reloadInterval = 30 * time.Second
+)
+
+var (
+	// ErrInvalid is returned for tokens that are malformed, signed with an
+	// unknown key or issued for another block or operation.
+	ErrInvalid = errors.New("invalid block token")
+	// ErrExpired is returned for tokens past their expiry.
+	ErrExpired = errors.New("block token expired")
+)
+
+// Claims is the signed content of a token.
+type Claims struct {
+	KeyID   string `json:"kid"`
+	BlockID string `json:"blk"`
+	Ops     []Op   `json:"ops"`
+	// ProjectID is empty in the tokens the master issues for its own work on
+	// workers, which may touch blocks of any project.
+	ProjectID string `json:"prj,omitempty"`
+	// Expiry is in Unix seconds.
+	Expiry int64 `json:"exp"`
+}
+
+// AllowsProject reports whether the token may access a block of project. It
+// does unless both name a project and they differ; nil claims, from a nil
+// KeyRing, allow every project.
+func (c *Claims) AllowsProject(project string) bool {
+	return c == nil || c.ProjectID == "" || project == "" || c.ProjectID == project
+}
+
+// KeyRing signs and verifies tokens. A nil KeyRing means block tokens are
+// off: it issues empty tokens and accepts any.
+type KeyRing struct {
+	path     string
+	lifetime time.Duration
+
+	mu      sync.Mutex
+	keys    map[string][]byte
+	current string
+	modTime time.Time
+	checked time.Time
+}
+
+// FromEnv loads the key file named by $DFS_BLOCK_TOKEN_KEYS_FILE, with the
+// token lifetime from $DFS_BLOCK_TOKEN_LIFETIME. It returns nil when no key
+// file is set.
+func FromEnv() (*KeyRing, error) {
+	path := os.Getenv("DFS_BLOCK_TOKEN_KEYS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	lifetime := DefaultLifetime
+	if v := os.Getenv("DFS_BLOCK_TOKEN_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid DFS_BLOCK_TOKEN_LIFETIME %q", v)
+		}
+		lifetime = d
+	}
+	return Load(path, lifetime)
+}
+
+// Load reads the keys in path. Tokens it issues are valid for lifetime.
+func Load(path string, lifetime time.Duration) (*KeyRing, error) {
+	k := &KeyRing{path: path, lifetime: lifetime}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyRing) reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	if k.keys != nil && !info.ModTime().After(k.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	keys, current, err := parseKeys(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", k.path, err)
+	}
+
+	if k.keys != nil {
+		log.Printf("Block tokens: reloaded %d keys, signing with %q", len(keys), current)
+	}
+	k.keys, k.current, k.modTime = keys, current, info.ModTime()
+	return nil
+}
+
+func parseKeys(data []byte) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte)
+	current := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, "", fmt.Errorf("line %d: want \"<id> <base64 secret>\"", n)
+		}
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: %w", n, err)
+		}
+		if len(secret) < 16 {
+			return nil, "", fmt.Errorf("line %d: secret shorter than 16 bytes", n)
+		}
+		keys[id] = secret
+		current = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	if current == "" {
+		return nil, "", errors.New("no keys")
+	}
+	return keys, current, nil
+}
+
+// snapshot returns the keys and signing key ID, re-reading the key file at
+// most every reloadInterval. A failed reload keeps the previous keys.
+func (k *KeyRing) snapshot() (map[string][]byte, string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.checked) >= reloadInterval {
+		k.checked = time.Now()
+		if err := k.reload(); err != nil {
+			log.Printf("Block tokens: keeping the current keys, reload failed: %v", err)
+		}
+	}
+	return k.keys, k.current
+}
+
+// Issue returns a token for ops on blockID, owned by projectID.
+func (k *KeyRing) Issue(blockID, projectID string, ops ...Op) (string, error) {
+	if k == nil {
+		return "", nil
+	}
+	keys, current := k.snapshot()
+
+	payload, err := json.Marshal(Claims{
+		KeyID:     current,
+		BlockID:   blockID,
+		Ops:       ops,
+		ProjectID: projectID,
+		Expiry:    time.Now().Add(k.lifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(keys[current], encoded)), nil
+}
+
+// Verify checks that token allows op on blockID and returns its claims.
+func (k *KeyRing) Verify(token, blockID string, op Op) (*Claims, error) {
+	if k == nil {
+		return nil, nil
+	}
+	if token == "" {
+		return nil, fmt.Errorf("%w: no token for block %s", ErrInvalid, blockID)
+	}
+
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalid)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalid)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalid)
+	}
+	keys, _ := k.snapshot()
+	key, ok := keys[claims.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalid, claims.KeyID)
+	}
+	if !hmac.Equal(signature, sign(key, encoded)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalid)
+	}
+
+	if claims.BlockID != blockID {
+		return nil, fmt.Errorf("%w: issued for block %s, not %s", ErrInvalid, claims.BlockID, blockID)
+	}
+	if !slices.Contains(claims.Ops, op) {
+		return nil, fmt.Errorf("%w: %s not allowed on block %s", ErrInvalid, op, blockID)
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, fmt.Errorf("%w: block %s", ErrExpired, blockID)
+	}
+	return &claims, nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,102 @@
+package blocktoken
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeys(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+}
+
+func TestKeyRing_IssueAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeys(t, path, "# block token keys", "k1 MDEyMzQ1Njc4OWFiY2RlZg==")
+	keys, err := Load(path, time.Hour)
+	require.NoError(t, err)
+
+	token, err := keys.Issue("blk-1", "proj", OpRead)
+	require.NoError(t, err)
+
+	claims, err := keys.Verify(token, "blk-1", OpRead)
+	require.NoError(t, err)
+	assert.Equal(t, "proj", claims.ProjectID)
+	assert.Equal(t, "k1", claims.KeyID)
+
+	_, err = keys.Verify(token, "blk-2", OpRead)
+	assert.ErrorIs(t, err, ErrInvalid, "other block")
+	_, err = keys.Verify(token, "blk-1", OpWrite)
+	assert.ErrorIs(t, err, ErrInvalid, "other operation")
+	_, err = keys.Verify("", "blk-1", OpRead)
+	assert.ErrorIs(t, err, ErrInvalid, "missing token")
+
+	payload, sig, _ := strings.Cut(token, ".")
+	_, err = keys.Verify(payload+"x."+sig, "blk-1", OpRead)
+	assert.ErrorIs(t, err, ErrInvalid, "tampered payload")
+}
+
+func TestClaims_AllowsProject(t *testing.T) {
+	scoped := &Claims{ProjectID: "proj"}
+	assert.True(t, scoped.AllowsProject("proj"))
+	assert.False(t, scoped.AllowsProject("other"))
+	assert.True(t, scoped.AllowsProject(""), "blocks without a recorded project")
+
+	assert.True(t, (&Claims{}).AllowsProject("other"), "the master's own tokens")
+	var off *Claims
+	assert.True(t, off.AllowsProject("other"), "tokens turned off")
+}
+
+func TestKeyRing_Expiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeys(t, path, "k1 MDEyMzQ1Njc4OWFiY2RlZg==")
+	keys, err := Load(path, -time.Second)
+	require.NoError(t, err)
+
+	token, err := keys.Issue("blk-1", "", OpRead)
+	require.NoError(t, err)
+	_, err = keys.Verify(token, "blk-1", OpRead)
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestKeyRing_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeys(t, path, "k1 MDEyMzQ1Njc4OWFiY2RlZg==")
+	keys, err := Load(path, time.Hour)
+	require.NoError(t, err)
+	old, err := keys.Issue("blk-1", "", OpRead)
+	require.NoError(t, err)
+
+	writeKeys(t, path, "k1 MDEyMzQ1Njc4OWFiY2RlZg==", "k2 ZmVkY2JhOTg3NjU0MzIxMA==")
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	keys.checked = time.Time{}
+
+	fresh, err := keys.Issue("blk-1", "", OpRead)
+	require.NoError(t, err)
+	claims, err := keys.Verify(fresh, "blk-1", OpRead)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", claims.KeyID, "the last key signs")
+	_, err = keys.Verify(old, "blk-1", OpRead)
+	assert.NoError(t, err, "older keys still verify")
+
+	writeKeys(t, path, "k2 ZmVkY2JhOTg3NjU0MzIxMA==")
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	keys.checked = time.Time{}
+	_, err = keys.Verify(old, "blk-1", OpRead)
+	assert.ErrorIs(t, err, ErrInvalid, "dropped keys no longer verify")
+}
+
+func TestKeyRing_Disabled(t *testing.T) {
+	var keys *KeyRing
+	token, err := keys.Issue("blk-1", "", OpRead)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	_, err = keys.Verify("", "blk-1", OpDelete)
+	assert.NoError(t, err)
+}
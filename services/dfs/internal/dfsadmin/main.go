@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
+	adminv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/admin/v1"
+	"google.golang.org/grpc"
+)
+
+const defaultMasterAddr = "localhost:50055"
+
+const usage = `Usage: dfsadmin [-master host:port] [-timeout d] <command> [args]
+
+Commands:
+  report                      cluster capacity and per-worker usage
+  safemode get|enter|leave    query or change safe mode
+  decommission <worker>       drain a worker (ID or address) and retire it
+  refreshNodes                reconnect to unreachable and newly listed workers
+  saveNamespace               force a namespace checkpoint
+  metasave [file]             dump the block map to stdout or a file
+  fsck [path] [-move|-delete] [-depth n]
+                              check block health of files under path
+`
+
+func main() {
+	masterAddr := os.Getenv("DFS_MASTER_ADDR")
+	if masterAddr == "" {
+		masterAddr = defaultMasterAddr
+	}
+
+	flag.StringVar(&masterAddr, "master", masterAddr, "master gRPC address")
+	timeout := flag.Duration("timeout", 30*time.Second, "RPC timeout")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv("admin"))
+	if err != nil {
+		fatalf("TLS: %v", err)
+	}
+
+	conn, err := grpc.NewClient(masterAddr, tlsSource.DialOption("master"))
+	if err != nil {
+		fatalf("failed to connect to master %s: %v", masterAddr, err)
+	}
+	defer conn.Close()
+
+	client := adminv1.NewAdminServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "report":
+		err = report(ctx, client)
+	case "safemode":
+		err = safeMode(ctx, client, args)
+	case "decommission":
+		err = decommission(ctx, client, args)
+	case "refreshNodes":
+		err = refreshNodes(ctx, client)
+	case "saveNamespace":
+		err = saveNamespace(ctx, client)
+	case "metasave":
+		err = metaSave(ctx, client, args)
+	case "fsck":
+		err = fsck(ctx, client, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		cancel()
+		fatalf("%s: %v", cmd, err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "dfsadmin: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func report(ctx context.Context, client adminv1.AdminServiceClient) error {
+	resp, err := client.GetReport(ctx, &adminv1.GetReportRequest{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Configured Capacity: %s\n", formatBytes(resp.CapacityBytes))
+	fmt.Printf("DFS Used:            %s (%s)\n", formatBytes(resp.UsedBytes), percent(resp.UsedBytes, resp.CapacityBytes))
+	fmt.Printf("DFS Remaining:       %s (%s)\n", formatBytes(resp.RemainingBytes), percent(resp.RemainingBytes, resp.CapacityBytes))
+	fmt.Printf("Safe mode:           %s\n", onOff(resp.SafeMode))
+	fmt.Printf("Files:               %d\n", resp.Files)
+	fmt.Printf("Directories:         %d\n", resp.Directories)
+	fmt.Printf("Blocks:              %d\n", resp.Blocks)
+	fmt.Printf("Under-replicated:    %d\n", resp.UnderReplicatedBlocks)
+	fmt.Printf("Missing blocks:      %d\n", resp.MissingBlocks)
+	fmt.Printf("Corrupt blocks:      %d\n", resp.CorruptBlocks)
+	fmt.Printf("Workers:             %d live, %d dead\n\n", resp.LiveWorkers, resp.DeadWorkers)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKER\tADDRESS\tSTATE\tALIVE\tLAST HEARTBEAT\tCAPACITY\tUSED\tREMAINING\tUSED%\tBLOCKS")
+	for _, w := range resp.Workers {
+		lastHeartbeat := "never"
+		if w.LastHeartbeatUnix > 0 {
+			lastHeartbeat = time.Since(time.Unix(w.LastHeartbeatUnix, 0)).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			w.WorkerId, w.Address, w.AdminState, w.Alive, lastHeartbeat,
+			formatBytes(w.CapacityBytes), formatBytes(w.UsedBytes), formatBytes(w.RemainingBytes),
+			percent(w.UsedBytes, w.CapacityBytes), w.BlockCount)
+	}
+	return tw.Flush()
+}
+
+func safeMode(ctx context.Context, client adminv1.AdminServiceClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: safemode get|enter|leave")
+	}
+
+	var action adminv1.SafeModeAction
+	switch args[0] {
+	case "get":
+		action = adminv1.SafeModeAction_SAFE_MODE_ACTION_GET
+	case "enter":
+		action = adminv1.SafeModeAction_SAFE_MODE_ACTION_ENTER
+	case "leave":
+		action = adminv1.SafeModeAction_SAFE_MODE_ACTION_LEAVE
+	default:
+		return fmt.Errorf("unknown safemode action %q (want get, enter or leave)", args[0])
+	}
+
+	resp, err := client.SetSafeMode(ctx, &adminv1.SetSafeModeRequest{Action: action})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Safe mode is %s\n", onOff(resp.Enabled))
+	if resp.Reason != "" {
+		fmt.Printf("Reason: %s\n", resp.Reason)
+	}
+	return nil
+}
+
+func decommission(ctx context.Context, client adminv1.AdminServiceClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: decommission <worker>")
+	}
+
+	resp, err := client.Decommission(ctx, &adminv1.DecommissionRequest{Worker: args[0]})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Worker %s is %s, %d blocks to re-replicate\n", resp.WorkerId, resp.AdminState, resp.BlocksToReplicate)
+	return nil
+}
+
+func refreshNodes(ctx context.Context, client adminv1.AdminServiceClient) error {
+	resp, err := client.RefreshNodes(ctx, &adminv1.RefreshNodesRequest{})
+	if err != nil {
+		return err
+	}
+
+	if len(resp.AddedWorkers) > 0 {
+		fmt.Printf("Added workers: %s\n", strings.Join(resp.AddedWorkers, ", "))
+	} else {
+		fmt.Println("No new workers")
+	}
+	fmt.Printf("Workers: %d live, %d dead\n", resp.LiveWorkers, resp.DeadWorkers)
+	return nil
+}
+
+func saveNamespace(ctx context.Context, client adminv1.AdminServiceClient) error {
+	resp, err := client.SaveNamespace(ctx, &adminv1.SaveNamespaceRequest{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Checkpoint written to %s (%d inodes, %d blocks)\n", resp.CheckpointPath, resp.Inodes, resp.Blocks)
+	return nil
+}
+
+func metaSave(ctx context.Context, client adminv1.AdminServiceClient, args []string) error {
+	resp, err := client.MetaSave(ctx, &adminv1.MetaSaveRequest{})
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		fmt.Print(resp.Dump)
+		return nil
+	}
+
+	if err := os.WriteFile(args[0], []byte(resp.Dump), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Metasave written to %s\n", args[0])
+	return nil
+}
+
+func fsck(ctx context.Context, client adminv1.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	move := fs.Bool("move", false, "move corrupt and missing files to /lost+found")
+	del := fs.Bool("delete", false, "delete corrupt and missing files")
+	depth := fs.Int("depth", 0, "path components used to group the summary (default 2)")
+
+	// Allow the path before or after the flags.
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	req := &adminv1.FsckRequest{Path: path, PrefixDepth: int32(*depth)}
+	switch {
+	case *move && *del:
+		return fmt.Errorf("-move and -delete are mutually exclusive")
+	case *move:
+		req.Action = adminv1.FsckAction_FSCK_ACTION_MOVE
+	case *del:
+		req.Action = adminv1.FsckAction_FSCK_ACTION_DELETE
+	}
+
+	resp, err := client.Fsck(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tFILES\tHEALTHY\tUNDER-REPLICATED\tUNVERIFIED\tCORRUPT\tMISSING")
+	for _, p := range resp.Prefixes {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", p.Prefix, p.Files, p.HealthyFiles, p.UnderReplicatedFiles, p.UnverifiedFiles, p.CorruptFiles, p.MissingFiles)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, f := range resp.Files {
+		fmt.Printf("\n%s: %s\n", f.Path, healthName(f.Status))
+		for _, b := range f.Blocks {
+			fmt.Printf("  block %s: %s (live=%d healthy=%d)", b.BlockId, healthName(b.Status), b.LiveReplicas, b.HealthyReplicas)
+			if b.Message != "" {
+				fmt.Printf(" %s", b.Message)
+			}
+			fmt.Println()
+		}
+	}
+
+	for _, p := range resp.MovedFiles {
+		fmt.Printf("Moved to %s\n", p)
+	}
+	for _, p := range resp.DeletedFiles {
+		fmt.Printf("Deleted %s\n", p)
+	}
+
+	fmt.Printf("\nTotal files:  %d\n", resp.FilesChecked)
+	fmt.Printf("Total blocks: %d\n", resp.BlocksChecked)
+	if !resp.Healthy {
+		fmt.Printf("The filesystem under path '%s' is CORRUPT\n", path)
+		os.Exit(1)
+	}
+	fmt.Printf("The filesystem under path '%s' is HEALTHY\n", path)
+	return nil
+}
+
+func healthName(s adminv1.HealthStatus) string {
+	return strings.TrimPrefix(s.String(), "HEALTH_STATUS_")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func percent(part, total int64) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(part)*100/float64(total))
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// config holds the connection settings of the CLI. It is read from -config,
+// $DFS_CONFIG or <user config dir>/dfs/config.json, e.g.
+//
+//	{"master": "master:50055", "project": "sales", "owner_id": "…"}
+//
+// Masters identify the CLI by the client certificate it is configured with,
+// see tlsconfig.FromEnv.
+type config struct {
+	Master  string `json:"master"`
+	Project string `json:"project"`
+	OwnerID string `json:"owner_id"`
+}
+
+func defaultConfigPath() string {
+	if p := os.Getenv("DFS_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dfs", "config.json")
+}
+
+// loadConfig reads the config file at path. A missing file is only an error
+// when the path was given explicitly.
+func loadConfig(path string, explicit bool) (config, error) {
+	var cfg config
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
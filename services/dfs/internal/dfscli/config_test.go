@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"master": "master:50055", "project": "sales", "owner_id": "u1"}`), 0o600))
+
+	cfg, err := loadConfig(path, true)
+	require.NoError(t, err)
+	assert.Equal(t, config{Master: "master:50055", Project: "sales", OwnerID: "u1"}, cfg)
+
+	t.Run("missing", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing.json")
+		cfg, err := loadConfig(missing, false)
+		require.NoError(t, err, "the default path need not exist")
+		assert.Equal(t, config{}, cfg)
+
+		_, err = loadConfig(missing, true)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.json")
+		require.NoError(t, os.WriteFile(bad, []byte(`{"master":`), 0o600))
+		_, err := loadConfig(bad, false)
+		assert.ErrorContains(t, err, "parse "+bad)
+	})
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	t.Setenv("DFS_CONFIG", "/etc/dfs.json")
+	assert.Equal(t, "/etc/dfs.json", defaultConfigPath())
+}
+
+func TestFirstNonEmpty(t *testing.T) {
+	assert.Equal(t, "b", firstNonEmpty("", "b", "c"))
+	assert.Equal(t, "", firstNonEmpty("", ""))
+}
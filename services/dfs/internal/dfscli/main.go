@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
+)
+
+const defaultMasterAddr = "localhost:50055"
+
+const usage = `Usage: dfs [-master host:port] [-project name] [-config file] <command> [args]
+
+Commands:
+  ls [-R] [-h] [path]                 list a directory, recursively with -R
+  stat path...                        show file and directory details
+  du [-s] [-h] [path]                 space used by each entry of a directory, or its total with -s
+  put [-r] [-q] [-f] [-d] [-b size] [-n replicas] [-p policy] local... remote
+                                      upload files, and directories with -r; -f overwrites;
+                                      -d deduplicates blocks by content;
+                                      -b, -n and -p set the block size, replication and storage policy
+  get [-r] [-q] remote... local       download files, and directories with -r
+  cat path...                         print files
+  tail [-c bytes] path                print the end of a file (default 1024 bytes)
+  rm [-r] path...                     delete files, and directories with -r
+  mv src dst                          move or rename a file or directory
+  mkdir path...                       create directories and their parents
+  setpolicy policy path...            set the storage policy (HOT, WARM, COLD or none to inherit)
+  checksum path...                    print the CRC-32 of whole files, comparable with local copies
+  chmod mode path...                  set the octal permission bits
+  chown owner[:group] path...         set the owner, or with :group only the group
+  setxattr name value path...         set an extended attribute
+  rmxattr name path...                remove an extended attribute
+  watch [-t txid] [path]              print changes below a path as they happen, after
+                                      transaction txid with -t
+
+With a project set, paths are relative to the project's root; a leading /
+makes a path absolute in the namespace.
+`
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath(), "config file")
+	masterFlag := flag.String("master", "", "master gRPC addresses, comma separated")
+	projectFlag := flag.String("project", "", "project whose root relative paths resolve against")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	explicit := false
+	flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	cfg, err := loadConfig(*configPath, explicit)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	masterAddr := firstNonEmpty(*masterFlag, os.Getenv("DFS_MASTER_ADDR"), cfg.Master, defaultMasterAddr)
+	project := firstNonEmpty(*projectFlag, os.Getenv("DFS_PROJECT"), cfg.Project)
+
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv("dfscli"))
+	if err != nil {
+		fatalf("TLS: %v", err)
+	}
+
+	client, err := dfs.NewClient(masterAddr, dfs.WithTransportCredentials(tlsSource.ClientCredentials("master", "worker")))
+	if err != nil {
+		fatalf("failed to connect to master %s: %v", masterAddr, err)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sh := &shell{
+		client:  client,
+		project: project,
+		ownerID: cfg.OwnerID,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "ls":
+		err = sh.ls(ctx, args)
+	case "stat":
+		err = sh.stat(ctx, args)
+	case "du":
+		err = sh.du(ctx, args)
+	case "put":
+		err = sh.put(ctx, args)
+	case "get":
+		err = sh.get(ctx, args)
+	case "cat":
+		err = sh.cat(ctx, args)
+	case "tail":
+		err = sh.tail(ctx, args)
+	case "rm":
+		err = sh.rm(ctx, args)
+	case "mv":
+		err = sh.mv(ctx, args)
+	case "mkdir":
+		err = sh.mkdir(ctx, args)
+	case "setpolicy":
+		err = sh.setPolicy(ctx, args)
+	case "checksum":
+		err = sh.checksum(ctx, args)
+	case "chmod":
+		err = sh.chmod(ctx, args)
+	case "chown":
+		err = sh.chown(ctx, args)
+	case "setxattr":
+		err = sh.setXAttr(ctx, args)
+	case "rmxattr":
+		err = sh.rmXAttr(ctx, args)
+	case "watch":
+		err = sh.watch(ctx, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		stop()
+		client.Close()
+		fatalf("%s: %v", cmd, err)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "dfs: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultTailBytes = 1024
+
+// shell runs the CLI commands against a DFS client.
+type shell struct {
+	client  dfs.Client
+	project string
+	ownerID string
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// resolve turns a command-line path into a namespace path. Relative paths are
+// resolved against the project's root when a project is set.
+func (sh *shell) resolve(p string) (string, error) {
+	switch {
+	case strings.HasPrefix(p, "/"):
+		p = strings.TrimPrefix(path.Clean(p), "/")
+	case sh.project != "":
+		p = path.Join(sh.project, p)
+	default:
+		p = path.Clean(p)
+	}
+	if p == "" || p == "." || strings.HasPrefix(p, "../") || p == ".." {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return p, nil
+}
+
+// display is the inverse of resolve, for output.
+func (sh *shell) display(p string) string {
+	if sh.project == "" {
+		return p
+	}
+	if p == sh.project {
+		return "."
+	}
+	if rel, ok := strings.CutPrefix(p, sh.project+"/"); ok {
+		return rel
+	}
+	return "/" + p
+}
+
+// pathArgs resolves the positional arguments, defaulting to the project root.
+func (sh *shell) pathArgs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		if sh.project == "" {
+			return nil, fmt.Errorf("path required when no project is set")
+		}
+		args = []string{""}
+	}
+
+	paths := make([]string, 0, len(args))
+	for _, a := range args {
+		p, err := sh.resolve(a)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+// splitRoot splits a namespace path into its project root and the path below
+// it, as ListFiles expects.
+func splitRoot(p string) (root, rel string) {
+	root, rel, _ = strings.Cut(p, "/")
+	return root, rel
+}
+
+// listTree returns every file at or below p.
+func (sh *shell) listTree(ctx context.Context, p string) ([]dfs.FileInfo, error) {
+	root, rel := splitRoot(p)
+	if rel != "" {
+		rel += "/"
+	}
+	return sh.client.ListFiles(ctx, root, rel)
+}
+
+func (sh *shell) ls(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	recursive := flags.Bool("R", false, "list subdirectories recursively")
+	human := flags.Bool("h", false, "print sizes in human-readable units")
+	flags.Parse(args)
+
+	paths, err := sh.pathArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(sh.stdout, 0, 4, 2, ' ', 0)
+	for _, p := range paths {
+		info, err := sh.client.Stat(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+		if !info.IsDir {
+			sh.printEntry(tw, info, *human)
+			continue
+		}
+		if err := sh.listDir(ctx, tw, p, *recursive, *human); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func (sh *shell) listDir(ctx context.Context, tw *tabwriter.Writer, dir string, recursive, human bool) error {
+	entries, err := sh.client.ReadDir(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sh.display(dir), err)
+	}
+	for _, e := range entries {
+		sh.printEntry(tw, e, human)
+		if recursive && e.IsDir {
+			if err := sh.listDir(ctx, tw, e.Name, recursive, human); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sh *shell) printEntry(w io.Writer, info dfs.FileInfo, human bool) {
+	mode, size := info.Mode.Perm(), formatSize(info.Size, human)
+	if info.IsDir {
+		mode, size = mode|fs.ModeDir, "-"
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", mode, orDash(info.Owner), orDash(info.Group),
+		size, formatTime(info.ModTime), sh.display(info.Name))
+}
+
+func (sh *shell) stat(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: stat path...")
+	}
+	paths, err := sh.pathArgs(args)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range paths {
+		info, err := sh.client.Stat(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+
+		if i > 0 {
+			fmt.Fprintln(sh.stdout)
+		}
+		fmt.Fprintf(sh.stdout, "Path:     %s\n", info.Name)
+		if info.IsDir {
+			fmt.Fprintf(sh.stdout, "Type:     directory\n")
+		} else {
+			fmt.Fprintf(sh.stdout, "Type:     file\n")
+			fmt.Fprintf(sh.stdout, "Size:     %d (%s)\n", info.Size, formatBytes(info.Size))
+			fmt.Fprintf(sh.stdout, "Gen:      %d\n", info.Generation)
+			if info.BlockSize > 0 {
+				fmt.Fprintf(sh.stdout, "Blksize:  %s\n", formatBytes(info.BlockSize))
+			}
+			fmt.Fprintf(sh.stdout, "Replicas: %d\n", info.Replication)
+		}
+		if info.StoragePolicy != "" {
+			fmt.Fprintf(sh.stdout, "Policy:   %s\n", info.StoragePolicy)
+		}
+		fmt.Fprintf(sh.stdout, "Mode:     %#o\n", info.Mode.Perm())
+		fmt.Fprintf(sh.stdout, "Owner:    %s\n", orDash(info.Owner))
+		fmt.Fprintf(sh.stdout, "Group:    %s\n", orDash(info.Group))
+		fmt.Fprintf(sh.stdout, "Modified: %s\n", formatTime(info.ModTime))
+		fmt.Fprintf(sh.stdout, "Accessed: %s\n", formatTime(info.AccessTime))
+		fmt.Fprintf(sh.stdout, "Changed:  %s\n", formatTime(info.ChangeTime))
+		for _, name := range slices.Sorted(maps.Keys(info.XAttrs)) {
+			fmt.Fprintf(sh.stdout, "XAttr:    %s=%q\n", name, info.XAttrs[name])
+		}
+
+		if !info.IsDir {
+			blocks, packed, err := sh.blockCount(ctx, p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", sh.display(p), err)
+			}
+			if packed {
+				fmt.Fprintf(sh.stdout, "Blocks:   %d (packed in a container)\n", blocks)
+			} else {
+				fmt.Fprintf(sh.stdout, "Blocks:   %d\n", blocks)
+			}
+		}
+	}
+	return nil
+}
+
+// blockCount returns the number of blocks of the file at p and whether any of
+// them is a container block shared with other small files.
+func (sh *shell) blockCount(ctx context.Context, p string) (int, bool, error) {
+	f, err := sh.client.Open(ctx, p)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	packed := slices.ContainsFunc(info.Blocks, func(b dfs.BlockMetadata) bool { return b.Packed })
+	return len(info.Blocks), packed, nil
+}
+
+func (sh *shell) du(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("du", flag.ExitOnError)
+	summary := flags.Bool("s", false, "print only the total of each path")
+	human := flags.Bool("h", false, "print sizes in human-readable units")
+	flags.Parse(args)
+
+	paths, err := sh.pathArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(sh.stdout, 0, 4, 2, ' ', 0)
+	for _, p := range paths {
+		info, err := sh.client.Stat(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+		if !info.IsDir {
+			fmt.Fprintf(tw, "%s\t%s\n", formatSize(info.Size, *human), sh.display(p))
+			continue
+		}
+
+		files, err := sh.listTree(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+		totals, total := usageByChild(p, files)
+
+		if *summary {
+			fmt.Fprintf(tw, "%s\t%s\n", formatSize(total, *human), sh.display(p))
+			continue
+		}
+		children := make([]string, 0, len(totals))
+		for child := range totals {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			fmt.Fprintf(tw, "%s\t%s\n", formatSize(totals[child], *human), sh.display(path.Join(p, child)))
+		}
+	}
+	return tw.Flush()
+}
+
+// usageByChild sums the sizes of files below dir by the direct child of dir
+// they live under.
+func usageByChild(dir string, files []dfs.FileInfo) (map[string]int64, int64) {
+	totals := make(map[string]int64)
+	var total int64
+	for _, f := range files {
+		rel, ok := strings.CutPrefix(f.Name, dir+"/")
+		if !ok {
+			continue
+		}
+		child, _, _ := strings.Cut(rel, "/")
+		totals[child] += f.Size
+		total += f.Size
+	}
+	return totals, total
+}
+
+func (sh *shell) cat(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: cat path...")
+	}
+	paths, err := sh.pathArgs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.copyOut(ctx, p, 0); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) tail(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	n := flags.Int64("c", defaultTailBytes, "number of bytes to print")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tail [-c bytes] path")
+	}
+
+	p, err := sh.resolve(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	info, err := sh.client.Stat(ctx, p)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sh.display(p), err)
+	}
+	if err := sh.copyOut(ctx, p, max(info.Size-*n, 0)); err != nil {
+		return fmt.Errorf("%s: %w", sh.display(p), err)
+	}
+	return nil
+}
+
+// copyOut writes the file at p from offset to stdout.
+func (sh *shell) copyOut(ctx context.Context, p string, offset int64) error {
+	f, err := sh.client.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(sh.stdout, f)
+	return err
+}
+
+func (sh *shell) rm(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ExitOnError)
+	recursive := flags.Bool("r", false, "delete directories and their contents")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: rm [-r] path...")
+	}
+	paths, err := sh.pathArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if *recursive {
+			err = sh.client.RemoveAll(ctx, p)
+		} else {
+			err = sh.client.Delete(ctx, p)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+		fmt.Fprintf(sh.stdout, "Deleted %s\n", sh.display(p))
+	}
+	return nil
+}
+
+func (sh *shell) mv(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: mv src dst")
+	}
+	paths, err := sh.pathArgs(args)
+	if err != nil {
+		return err
+	}
+	src, dst := paths[0], paths[1]
+
+	// Like mv(1), moving onto an existing directory moves into it.
+	if info, err := sh.client.Stat(ctx, dst); err == nil && info.IsDir {
+		dst = path.Join(dst, path.Base(src))
+	} else if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	return sh.client.Rename(ctx, src, dst)
+}
+
+func (sh *shell) mkdir(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: mkdir path...")
+	}
+	paths, err := sh.pathArgs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.client.MkdirAll(ctx, p); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+// setPolicy sets the storage policy of files and directories; "none" clears
+// it so they inherit their parent's again.
+func (sh *shell) setPolicy(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: setpolicy HOT|WARM|COLD|none path...")
+	}
+	policy := strings.ToUpper(args[0])
+	if policy == "NONE" {
+		policy = ""
+	}
+	paths, err := sh.pathArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.client.SetStoragePolicy(ctx, p, policy); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) chmod(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: chmod mode path...")
+	}
+	mode, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil || mode > 0o777 {
+		return fmt.Errorf("invalid mode %q, want octal permission bits such as 750", args[0])
+	}
+	paths, err := sh.pathArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.client.Chmod(ctx, p, fs.FileMode(mode)); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+// chown sets the owner and group of paths from an "owner[:group]" or
+// ":group" argument.
+func (sh *shell) chown(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: chown owner[:group] path...")
+	}
+	owner, group, _ := strings.Cut(args[0], ":")
+	if owner == "" && group == "" {
+		return fmt.Errorf("invalid owner %q", args[0])
+	}
+	paths, err := sh.pathArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.client.Chown(ctx, p, owner, group); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) setXAttr(ctx context.Context, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: setxattr name value path...")
+	}
+	paths, err := sh.pathArgs(args[2:])
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.client.SetXAttr(ctx, p, args[0], args[1]); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) rmXAttr(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: rmxattr name path...")
+	}
+	paths, err := sh.pathArgs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if err := sh.client.RemoveXAttr(ctx, p, args[0]); err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+	}
+	return nil
+}
+
+// watch prints namespace events below a path, or anywhere without a path
+// or project, until interrupted.
+func (sh *shell) watch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	from := flags.Int64("t", 0, "resume after this transaction ID")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: watch [-t txid] [path]")
+	}
+
+	prefix := ""
+	if flags.NArg() == 1 || sh.project != "" {
+		paths, err := sh.pathArgs(flags.Args())
+		if err != nil {
+			return err
+		}
+		prefix = paths[0]
+	}
+
+	w, err := sh.client.Watch(ctx, prefix, *from)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for {
+		ev, err := w.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("after transaction %d: %w", w.TxID(), err)
+		}
+		target := sh.display(ev.Path)
+		if ev.DstPath != "" {
+			target += " -> " + sh.display(ev.DstPath)
+		}
+		fmt.Fprintf(sh.stdout, "%d\t%s\t%s\t%s\n", ev.TxID, formatTime(ev.Time), ev.Type, target)
+	}
+}
+
+func (sh *shell) checksum(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: checksum path...")
+	}
+	paths, err := sh.pathArgs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		sum, err := sh.client.Checksum(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(p), err)
+		}
+		fmt.Fprintf(sh.stdout, "%s\t%s\t%08x\t%d\n", sh.display(p), sum.Algorithm, sum.Checksum, sum.Length)
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatTime(unixNano int64) string {
+	if unixNano == 0 {
+		return "-"
+	}
+	return time.Unix(0, unixNano).Format("2006-01-02 15:04")
+}
+
+func formatSize(b int64, human bool) string {
+	if human {
+		return formatBytes(b)
+	}
+	return fmt.Sprint(b)
+}
+
+// parseBytes parses a byte count with an optional K, M or G (binary) suffix.
+func parseBytes(s string) (int64, error) {
+	shift := 0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClient serves Stat and ListFiles from a fixed set of files and records
+// renames. The other methods are left to the embedded nil interface.
+type fakeClient struct {
+	dfs.Client
+	files   map[string]dfs.FileInfo
+	renamed [][2]string
+}
+
+func (c *fakeClient) Stat(ctx context.Context, p string) (dfs.FileInfo, error) {
+	if info, ok := c.files[p]; ok {
+		return info, nil
+	}
+	return dfs.FileInfo{}, status.Error(codes.NotFound, p)
+}
+
+func (c *fakeClient) ListFiles(ctx context.Context, projectID, prefix string) ([]dfs.FileInfo, error) {
+	var files []dfs.FileInfo
+	for p, info := range c.files {
+		if !info.IsDir && strings.HasPrefix(p, projectID+"/"+prefix) {
+			files = append(files, info)
+		}
+	}
+	return files, nil
+}
+
+func (c *fakeClient) Rename(ctx context.Context, oldPath, newPath string) error {
+	c.renamed = append(c.renamed, [2]string{oldPath, newPath})
+	return nil
+}
+
+func newTestShell(files ...dfs.FileInfo) (*shell, *fakeClient, *bytes.Buffer) {
+	client := &fakeClient{files: make(map[string]dfs.FileInfo)}
+	for _, f := range files {
+		client.files[f.Name] = f
+	}
+	var out bytes.Buffer
+	return &shell{client: client, project: "sales", stdout: &out, stderr: &out}, client, &out
+}
+
+func TestShell_Resolve(t *testing.T) {
+	sh := &shell{project: "sales"}
+	for in, want := range map[string]string{
+		"raw/a.avro":   "sales/raw/a.avro",
+		"/ops/x":       "ops/x",
+		"raw/../b":     "sales/b",
+		"/sales/raw/":  "sales/raw",
+		"./raw//a.bin": "sales/raw/a.bin",
+	} {
+		got, err := sh.resolve(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+		assert.Equal(t, got, mustResolve(t, sh, "/"+got), "absolute paths resolve to themselves")
+	}
+	for _, in := range []string{"/", "/.."} {
+		_, err := sh.resolve(in)
+		assert.Error(t, err, in)
+	}
+	_, err := (&shell{}).resolve("../x")
+	assert.Error(t, err, "relative paths cannot leave the namespace")
+
+	assert.Equal(t, "raw/a.avro", sh.display("sales/raw/a.avro"))
+	assert.Equal(t, ".", sh.display("sales"))
+	assert.Equal(t, "/salesforce/a", sh.display("salesforce/a"))
+
+	_, err = (&shell{}).pathArgs(nil)
+	assert.Error(t, err, "no default path without a project")
+}
+
+func mustResolve(t *testing.T, sh *shell, p string) string {
+	t.Helper()
+	got, err := sh.resolve(p)
+	require.NoError(t, err)
+	return got
+}
+
+func TestShell_Du(t *testing.T) {
+	sh, _, out := newTestShell(
+		dfs.FileInfo{Name: "sales", IsDir: true},
+		dfs.FileInfo{Name: "sales/raw", IsDir: true},
+		dfs.FileInfo{Name: "sales/raw/a.avro", Size: 100},
+		dfs.FileInfo{Name: "sales/raw/2024/b.avro", Size: 50},
+		dfs.FileInfo{Name: "sales/top.csv", Size: 7},
+	)
+	ctx := context.Background()
+
+	require.NoError(t, sh.du(ctx, []string{"-s"}))
+	assert.Equal(t, "157  .\n", out.String())
+
+	out.Reset()
+	require.NoError(t, sh.du(ctx, []string{"raw"}))
+	assert.Equal(t, "50   raw/2024\n100  raw/a.avro\n", out.String())
+
+	out.Reset()
+	require.NoError(t, sh.du(ctx, []string{"top.csv"}))
+	assert.Equal(t, "7  top.csv\n", out.String())
+}
+
+func TestShell_Mv(t *testing.T) {
+	sh, client, _ := newTestShell(
+		dfs.FileInfo{Name: "sales/raw", IsDir: true},
+		dfs.FileInfo{Name: "sales/a.avro", Size: 1},
+	)
+	ctx := context.Background()
+
+	require.NoError(t, sh.mv(ctx, []string{"a.avro", "raw"}))
+	require.NoError(t, sh.mv(ctx, []string{"a.avro", "b.avro"}))
+	assert.Equal(t, [][2]string{
+		{"sales/a.avro", "sales/raw/a.avro"},
+		{"sales/a.avro", "sales/b.avro"},
+	}, client.renamed, "moving onto a directory moves into it")
+
+	assert.Error(t, sh.mv(ctx, []string{"a.avro"}))
+}
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{"512": 512, "4K": 4 << 10, "64m": 64 << 20, "1G": 1 << 30} {
+		got, err := parseBytes(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"0", "-1K", "1.5M", "K"} {
+		_, err := parseBytes(in)
+		assert.Error(t, err, in)
+	}
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.50 KiB", formatBytes(1536))
+	assert.Equal(t, "2.00 GiB", formatBytes(2<<30))
+}
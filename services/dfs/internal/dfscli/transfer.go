@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+func (sh *shell) put(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("put", flag.ExitOnError)
+	recursive := flags.Bool("r", false, "upload directories recursively")
+	quiet := flags.Bool("q", false, "do not show progress")
+	force := flags.Bool("f", false, "overwrite existing files")
+	blockSize := flags.String("b", "", "block size, e.g. 1M or 256M")
+	replication := flags.Int("n", 0, "replicas per block")
+	policy := flags.String("p", "", "storage policy: HOT, WARM or COLD")
+	dedup := flags.Bool("d", false, "deduplicate blocks by content")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: put [-r] [-q] [-f] [-d] [-b size] [-n replicas] [-p policy] local... remote")
+	}
+
+	var opts []dfs.CreateOption
+	if *force {
+		opts = append(opts, dfs.Overwrite())
+	}
+	if *blockSize != "" {
+		n, err := parseBytes(*blockSize)
+		if err != nil {
+			return fmt.Errorf("-b: %w", err)
+		}
+		opts = append(opts, dfs.WithBlockSize(n))
+	}
+	if *replication != 0 {
+		opts = append(opts, dfs.WithReplication(*replication))
+	}
+	if *policy != "" {
+		opts = append(opts, dfs.WithStoragePolicy(*policy))
+	}
+	if *dedup {
+		opts = append(opts, dfs.WithDedup())
+	}
+
+	srcs, dstArg := flags.Args()[:flags.NArg()-1], flags.Arg(flags.NArg()-1)
+	dst, err := sh.resolve(dstArg)
+	if err != nil {
+		return err
+	}
+
+	dstIsDir := strings.HasSuffix(dstArg, "/")
+	if info, err := sh.client.Stat(ctx, dst); err == nil {
+		dstIsDir = info.IsDir
+	} else if !isNotFound(err) {
+		return err
+	}
+	if len(srcs) > 1 && !dstIsDir {
+		return fmt.Errorf("%s: destination must be a directory for multiple sources", dstArg)
+	}
+
+	for _, src := range srcs {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		target := dst
+		if dstIsDir {
+			target = path.Join(dst, filepath.Base(src))
+		}
+
+		if !info.IsDir() {
+			if err := sh.putFile(ctx, src, target, info.Size(), *quiet, opts); err != nil {
+				return err
+			}
+			continue
+		}
+		if !*recursive {
+			return fmt.Errorf("%s: is a directory (use -r)", src)
+		}
+		err = filepath.WalkDir(src, func(local string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(src, local)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return sh.putFile(ctx, local, path.Join(target, filepath.ToSlash(rel)), info.Size(), *quiet, opts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sh *shell) putFile(ctx context.Context, local, remote string, size int64, quiet bool, extra []dfs.CreateOption) error {
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	root, _ := splitRoot(remote)
+	opts := []dfs.CreateOption{dfs.WithProjectID(root)}
+	if sh.ownerID != "" {
+		opts = append(opts, dfs.WithOwnerID(sh.ownerID))
+	}
+	if ext := strings.TrimPrefix(path.Ext(remote), "."); ext != "" {
+		opts = append(opts, dfs.WithFormat(ext))
+	}
+	opts = append(opts, extra...)
+
+	out, err := sh.client.Create(ctx, remote, opts...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sh.display(remote), err)
+	}
+
+	p := sh.progress(sh.display(remote), size, quiet)
+	_, err = io.Copy(out, io.TeeReader(in, p))
+	p.done(err)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %w", sh.display(remote), err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("%s: %w", sh.display(remote), err)
+	}
+	return nil
+}
+
+func (sh *shell) get(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	recursive := flags.Bool("r", false, "download directories recursively")
+	quiet := flags.Bool("q", false, "do not show progress")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		return fmt.Errorf("usage: get [-r] [-q] remote... local")
+	}
+
+	srcs, dst := flags.Args()[:flags.NArg()-1], flags.Arg(flags.NArg()-1)
+	dstIsDir := strings.HasSuffix(dst, string(filepath.Separator))
+	if info, err := os.Stat(dst); err == nil {
+		dstIsDir = info.IsDir()
+	}
+	if len(srcs) > 1 && !dstIsDir {
+		return fmt.Errorf("%s: destination must be a directory for multiple sources", dst)
+	}
+
+	for _, srcArg := range srcs {
+		src, err := sh.resolve(srcArg)
+		if err != nil {
+			return err
+		}
+		info, err := sh.client.Stat(ctx, src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(src), err)
+		}
+		target := dst
+		if dstIsDir {
+			target = filepath.Join(dst, path.Base(src))
+		}
+
+		if !info.IsDir {
+			if err := sh.getFile(ctx, src, target, info.Size, *quiet); err != nil {
+				return err
+			}
+			continue
+		}
+		if !*recursive {
+			return fmt.Errorf("%s: is a directory (use -r)", sh.display(src))
+		}
+		files, err := sh.listTree(ctx, src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sh.display(src), err)
+		}
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+		for _, f := range files {
+			rel := strings.TrimPrefix(f.Name, src+"/")
+			if err := sh.getFile(ctx, f.Name, filepath.Join(target, filepath.FromSlash(rel)), f.Size, *quiet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getFile downloads remote into a temporary file next to local and renames it
+// into place once complete, so an interrupted download leaves nothing behind.
+func (sh *shell) getFile(ctx context.Context, remote, local string, size int64, quiet bool) error {
+	in, err := sh.client.Open(ctx, remote)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sh.display(remote), err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	p := sh.progress(sh.display(remote), size, quiet)
+	_, err = io.Copy(out, io.TeeReader(in, p))
+	p.done(err)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", sh.display(remote), err)
+	}
+	return os.Rename(out.Name(), local)
+}
+
+// progress reports transfer progress on stderr. It is silent when quiet is
+// set or stderr is not a terminal.
+type progress struct {
+	w     io.Writer
+	name  string
+	total int64
+	n     int64
+	last  time.Time
+	start time.Time
+}
+
+func (sh *shell) progress(name string, total int64, quiet bool) *progress {
+	p := &progress{name: name, total: total, start: time.Now()}
+	if !quiet && isTerminal(sh.stderr) {
+		p.w = sh.stderr
+	}
+	return p
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	if p.w != nil && time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.print(p.n)
+	}
+	return len(b), nil
+}
+
+func (p *progress) print(n int64) {
+	pct := 100.0
+	if p.total > 0 {
+		pct = float64(n) * 100 / float64(p.total)
+	}
+	fmt.Fprintf(p.w, "\r%s  %s / %s  %3.0f%%", p.name, formatBytes(n), formatBytes(p.total), pct)
+}
+
+func (p *progress) done(err error) {
+	if p.w == nil {
+		return
+	}
+	p.print(p.n)
+	if err != nil {
+		fmt.Fprintln(p.w, "  failed")
+		return
+	}
+	fmt.Fprintf(p.w, "  %s\n", time.Since(p.start).Round(time.Millisecond))
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
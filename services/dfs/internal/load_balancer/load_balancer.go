@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -13,11 +16,55 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// AdminState is the operator-controlled lifecycle state of a worker.
+type AdminState int
+
+const (
+	InService AdminState = iota
+	Decommissioning
+	Decommissioned
+)
+
+func (s AdminState) String() string {
+	switch s {
+	case InService:
+		return "in_service"
+	case Decommissioning:
+		return "decommissioning"
+	case Decommissioned:
+		return "decommissioned"
+	default:
+		return "unknown"
+	}
+}
+
+// Storage types a worker can advertise. Workers that do not report one are
+// treated as DISK.
+const (
+	StorageSSD     = "SSD"
+	StorageDisk    = "DISK"
+	StorageArchive = "ARCHIVE"
+)
+
 type WorkerMetadata struct {
 	Client     datanodev1.DataNodeServiceClient
 	Ip         string
 	Port       int32
 	BatchCount int
+
+	// AdminState controls whether new blocks may be placed on the worker.
+	AdminState AdminState
+
+	// Populated from the periodic GetWorkerInfo heartbeat.
+	Alive          bool
+	LastHeartbeat  time.Time
+	NodeName       string
+	CapacityBytes  int64
+	UsedBytes      int64
+	RemainingBytes int64
+	BlockCount     int64
+	CorruptBlocks  []string
+	StorageType    string
 }
 
 func NewWorkerMetadata(client datanodev1.DataNodeServiceClient, ip string, port int32, bc int) *WorkerMetadata {
@@ -33,55 +80,88 @@ type LoadBalancer struct {
 	workerInfo map[string]WorkerMetadata
 	currentIdx int
 	mu         sync.Mutex
+
+	// dialOpts carry the transport credentials for worker connections.
+	dialOpts []grpc.DialOption
 }
 
 const workerAddress = "worker"
 const MAXIMUM_BATCHES_PER_WORKER = 100
 
-func NewLoadBalancer(numWorkers int, basePort int) *LoadBalancer {
+// NewLoadBalancer connects to the first numWorkers workers. Without dial
+// options the connections are plaintext.
+func NewLoadBalancer(numWorkers int, basePort int, dialOpts ...grpc.DialOption) *LoadBalancer {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
 	lb := &LoadBalancer{
 		workerInfo: make(map[string]WorkerMetadata),
+		dialOpts:   dialOpts,
 	}
 
-	for i := 0; i < numWorkers; i++ {
-		conn, err := grpc.Dial(
-			fmt.Sprintf("%s-%d.worker-headless:%d", workerAddress, i, basePort),
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithDefaultCallOptions(
-				grpc.MaxCallRecvMsgSize(64*1024*1024),
-				grpc.MaxCallSendMsgSize(64*1024*1024),
-			),
-		)
+	for i, address := range DefaultWorkerAddresses(numWorkers, basePort) {
+		workerID, wMetadata, err := lb.connectWorker(address)
 		if err != nil {
 			log.Printf("did not connect to worker %d: %v", i+1, err)
 			continue
 		}
 
-		client := datanodev1.NewDataNodeServiceClient(conn)
+		lb.workerInfo[workerID] = *wMetadata
+		log.Printf("Successfully connected to worker node %d with UUID %s", i+1, workerID)
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	return lb
+}
 
-		req := &datanodev1.GetWorkerInfoRequest{}
-		resp, err := client.GetWorkerInfo(ctx, req)
-		cancel()
+// DefaultWorkerAddresses returns the StatefulSet DNS names of the first numWorkers workers.
+func DefaultWorkerAddresses(numWorkers int, basePort int) []string {
+	addresses := make([]string, 0, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		addresses = append(addresses, fmt.Sprintf("%s-%d.worker-headless:%d", workerAddress, i, basePort))
+	}
+	return addresses
+}
 
-		if err != nil {
-			log.Printf("failed to get worker info for worker %d: %v", i+1, err)
-			continue
-		}
+func (lb *LoadBalancer) connectWorker(address string) (string, *WorkerMetadata, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid worker address %q: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid worker port %q: %w", portStr, err)
+	}
 
-		workerID := resp.WorkerId
-		address := fmt.Sprintf("%s-%d.worker-headless", workerAddress, i)
+	opts := append([]grpc.DialOption{
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(64*1024*1024),
+			grpc.MaxCallSendMsgSize(64*1024*1024),
+		),
+	}, lb.dialOpts...)
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return "", nil, err
+	}
 
-		wMetadata := NewWorkerMetadata(client, address, int32(basePort), 0)
-		lb.workerInfo[workerID] = *wMetadata
-		log.Printf("Successfully connected to worker node %d with UUID %s", i+1, workerID)
+	client := datanodev1.NewDataNodeServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	resp, err := client.GetWorkerInfo(ctx, &datanodev1.GetWorkerInfoRequest{})
+	cancel()
+
+	if err != nil {
+		conn.Close()
+		return "", nil, fmt.Errorf("failed to get worker info from %s: %w", address, err)
 	}
 
-	return lb
+	wMetadata := NewWorkerMetadata(client, host, int32(port), 0)
+	wMetadata.applyHeartbeat(resp)
+	return resp.WorkerId, wMetadata, nil
 }
 
-func (lb *LoadBalancer) GetNextClient() (string, WorkerMetadata) {
+// GetNextClient returns the next in-service worker in round-robin order,
+// skipping any worker in exclude.
+func (lb *LoadBalancer) GetNextClient(exclude ...string) (string, WorkerMetadata) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
@@ -89,10 +169,24 @@ func (lb *LoadBalancer) GetNextClient() (string, WorkerMetadata) {
 		return "", WorkerMetadata{}
 	}
 
+	skip := make(map[string]struct{}, len(exclude))
+	for _, id := range exclude {
+		skip[id] = struct{}{}
+	}
+
 	keys := make([]string, 0, len(lb.workerInfo))
-	for key := range lb.workerInfo {
-		keys = append(keys, key)
+	for key, wm := range lb.workerInfo {
+		if _, excluded := skip[key]; excluded {
+			continue
+		}
+		if wm.AdminState == InService {
+			keys = append(keys, key)
+		}
 	}
+	if len(keys) == 0 {
+		return "", WorkerMetadata{}
+	}
+	sort.Strings(keys)
 
 	lb.currentIdx = (lb.currentIdx + 1) % len(keys)
 	clientKey := keys[lb.currentIdx]
@@ -101,6 +195,49 @@ func (lb *LoadBalancer) GetNextClient() (string, WorkerMetadata) {
 	return clientKey, wMetadata
 }
 
+// GetNextClients returns up to n distinct in-service workers outside exclude.
+// Workers are taken in round-robin order, first those whose storage type comes
+// earliest in preferred and then any others, so a block still gets placed
+// when no worker of a preferred type is left.
+func (lb *LoadBalancer) GetNextClients(n int, preferred []string, exclude ...string) ([]string, []WorkerMetadata) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	skip := make(map[string]struct{}, len(exclude))
+	for _, id := range exclude {
+		skip[id] = struct{}{}
+	}
+
+	keys := make([]string, 0, len(lb.workerInfo))
+	for key, wm := range lb.workerInfo {
+		if _, excluded := skip[key]; excluded {
+			continue
+		}
+		if wm.AdminState == InService {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 || n <= 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+
+	lb.currentIdx = (lb.currentIdx + 1) % len(keys)
+	rotated := make([]string, 0, len(keys))
+	rotated = append(rotated, keys[lb.currentIdx:]...)
+	rotated = append(rotated, keys[:lb.currentIdx]...)
+	sort.SliceStable(rotated, func(i, j int) bool {
+		return lb.workerInfo[rotated[i]].StorageRank(preferred) < lb.workerInfo[rotated[j]].StorageRank(preferred)
+	})
+
+	ids := rotated[:min(n, len(rotated))]
+	workers := make([]WorkerMetadata, len(ids))
+	for i, id := range ids {
+		workers[i] = lb.workerInfo[id]
+	}
+	return ids, workers
+}
+
 func (lb *LoadBalancer) Rotate() (string, WorkerMetadata) {
 	return lb.GetNextClient()
 }
@@ -119,3 +256,215 @@ func (lb *LoadBalancer) GetClientByWorkerID(workerID string) (datanodev1.DataNod
 	}
 	return wm.Client, wm, wm.Ip, wm.Port, nil
 }
+
+func (wm *WorkerMetadata) applyHeartbeat(resp *datanodev1.GetWorkerInfoResponse) {
+	wm.Alive = true
+	wm.LastHeartbeat = time.Now()
+	wm.NodeName = resp.NodeName
+	wm.CapacityBytes = resp.CapacityBytes
+	wm.UsedBytes = resp.UsedBytes
+	wm.RemainingBytes = resp.RemainingBytes
+	wm.BlockCount = resp.BlockCount
+	wm.CorruptBlocks = resp.CorruptBlockIds
+	wm.StorageType = resp.StorageType
+}
+
+// RefreshWorkerStats polls every known worker for its capacity and marks
+// workers that do not answer as dead.
+func (lb *LoadBalancer) RefreshWorkerStats(ctx context.Context) {
+	lb.mu.Lock()
+	clients := make(map[string]datanodev1.DataNodeServiceClient, len(lb.workerInfo))
+	for id, wm := range lb.workerInfo {
+		clients[id] = wm.Client
+	}
+	lb.mu.Unlock()
+
+	for workerID, client := range clients {
+		if client == nil {
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		resp, err := client.GetWorkerInfo(reqCtx, &datanodev1.GetWorkerInfoRequest{})
+		cancel()
+
+		lb.mu.Lock()
+		wm, exists := lb.workerInfo[workerID]
+		if exists {
+			if err != nil {
+				if wm.Alive {
+					log.Printf("worker %s stopped responding: %v", workerID, err)
+				}
+				wm.Alive = false
+			} else {
+				wm.applyHeartbeat(resp)
+			}
+			lb.workerInfo[workerID] = wm
+		}
+		lb.mu.Unlock()
+	}
+}
+
+// Workers returns a snapshot of all known workers keyed by worker ID.
+func (lb *LoadBalancer) Workers() map[string]WorkerMetadata {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	workers := make(map[string]WorkerMetadata, len(lb.workerInfo))
+	for id, wm := range lb.workerInfo {
+		workers[id] = wm
+	}
+	return workers
+}
+
+func (lb *LoadBalancer) IsAlive(workerID string) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	wm, exists := lb.workerInfo[workerID]
+	return exists && wm.Alive
+}
+
+func (lb *LoadBalancer) LiveWorkerCount() int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	count := 0
+	for _, wm := range lb.workerInfo {
+		if wm.Alive {
+			count++
+		}
+	}
+	return count
+}
+
+// Storage returns the worker's storage type, DISK if it did not report one.
+func (wm WorkerMetadata) Storage() string {
+	if wm.StorageType == "" {
+		return StorageDisk
+	}
+	return wm.StorageType
+}
+
+// StorageRank is the position of the worker's storage type in preferred, or
+// len(preferred) if it is not listed. Lower is better.
+func (wm WorkerMetadata) StorageRank(preferred []string) int {
+	for i, t := range preferred {
+		if wm.Storage() == t {
+			return i
+		}
+	}
+	return len(preferred)
+}
+
+// Address returns the host:port the worker serves gRPC on.
+func (wm WorkerMetadata) Address() string {
+	return net.JoinHostPort(wm.Ip, strconv.Itoa(int(wm.Port)))
+}
+
+// AddWorker registers a worker, replacing any previous entry with the same ID.
+func (lb *LoadBalancer) AddWorker(workerID string, wm WorkerMetadata) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if existing, ok := lb.workerInfo[workerID]; ok {
+		wm.AdminState = existing.AdminState
+	}
+	lb.workerInfo[workerID] = wm
+}
+
+// ResolveWorker maps a worker ID, host or host:port to a known worker ID.
+func (lb *LoadBalancer) ResolveWorker(worker string) (string, bool) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if _, ok := lb.workerInfo[worker]; ok {
+		return worker, true
+	}
+	for id, wm := range lb.workerInfo {
+		if wm.Ip == worker || wm.Address() == worker {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (lb *LoadBalancer) SetAdminState(workerID string, state AdminState) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	wm, exists := lb.workerInfo[workerID]
+	if !exists {
+		return fmt.Errorf("worker %s not found", workerID)
+	}
+	if wm.AdminState != state {
+		log.Printf("worker %s admin state %s -> %s", workerID, wm.AdminState, state)
+	}
+	wm.AdminState = state
+	lb.workerInfo[workerID] = wm
+	return nil
+}
+
+// PickTarget returns a live, in-service worker that is not in exclude,
+// preferring storage types that come earlier in preferred.
+func (lb *LoadBalancer) PickTarget(exclude []string, preferred ...string) (string, WorkerMetadata, bool) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	skip := make(map[string]struct{}, len(exclude))
+	for _, id := range exclude {
+		skip[id] = struct{}{}
+	}
+
+	var bestID string
+	var best WorkerMetadata
+	for id, wm := range lb.workerInfo {
+		if _, excluded := skip[id]; excluded || !wm.Alive || wm.AdminState != InService {
+			continue
+		}
+		if bestID == "" || betterTarget(id, wm, bestID, best, preferred) {
+			bestID, best = id, wm
+		}
+	}
+	return bestID, best, bestID != ""
+}
+
+func betterTarget(id string, wm WorkerMetadata, bestID string, best WorkerMetadata, preferred []string) bool {
+	if rank, bestRank := wm.StorageRank(preferred), best.StorageRank(preferred); rank != bestRank {
+		return rank < bestRank
+	}
+	// Prefer the emptiest worker so moved blocks spread out.
+	if wm.UsedBytes != best.UsedBytes {
+		return wm.UsedBytes < best.UsedBytes
+	}
+	return id < bestID
+}
+
+// Refresh connects to any address in addresses that is not already a known
+// worker and returns the IDs of the newly added workers.
+func (lb *LoadBalancer) Refresh(addresses []string) []string {
+	lb.mu.Lock()
+	known := make(map[string]struct{}, len(lb.workerInfo))
+	for _, wm := range lb.workerInfo {
+		known[wm.Address()] = struct{}{}
+	}
+	lb.mu.Unlock()
+
+	added := make([]string, 0)
+	for _, address := range addresses {
+		if _, ok := known[address]; ok {
+			continue
+		}
+
+		workerID, wMetadata, err := lb.connectWorker(address)
+		if err != nil {
+			log.Printf("refresh: could not connect to worker %s: %v", address, err)
+			continue
+		}
+
+		lb.AddWorker(workerID, *wMetadata)
+		added = append(added, workerID)
+		log.Printf("refresh: added worker %s at %s", workerID, address)
+	}
+	return added
+}
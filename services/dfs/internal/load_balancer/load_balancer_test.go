@@ -1,12 +1,25 @@
 package load_balancer
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
 )
 
+type fakeDataNodeClient struct {
+	datanodev1.DataNodeServiceClient
+	resp *datanodev1.GetWorkerInfoResponse
+	err  error
+}
+
+func (f *fakeDataNodeClient) GetWorkerInfo(ctx context.Context, in *datanodev1.GetWorkerInfoRequest, opts ...grpc.CallOption) (*datanodev1.GetWorkerInfoResponse, error) {
+	return f.resp, f.err
+}
+
 func TestNewWorkerMetadata(t *testing.T) {
 	wm := NewWorkerMetadata(nil, "worker-0", 50051, 0)
 	assert.NotNil(t, wm)
@@ -38,6 +51,18 @@ func TestLoadBalancer_GetNextClient(t *testing.T) {
 		assert.NotEqual(t, id1, id2)
 	})
 
+	t.Run("skips excluded workers", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			id, _ := lb.GetNextClient("worker-1")
+			assert.Equal(t, "worker-2", id)
+		}
+	})
+
+	t.Run("returns nothing when every worker is excluded", func(t *testing.T) {
+		id, _ := lb.GetNextClient("worker-1", "worker-2")
+		assert.Empty(t, id)
+	})
+
 	t.Run("handles empty worker list", func(t *testing.T) {
 		emptyLB := &LoadBalancer{
 			workerInfo: make(map[string]WorkerMetadata),
@@ -115,3 +140,132 @@ func TestLoadBalancer_ConcurrentAccess(t *testing.T) {
 		<-done
 	}
 }
+
+func TestLoadBalancer_RefreshWorkerStats(t *testing.T) {
+	lb := &LoadBalancer{
+		workerInfo: make(map[string]WorkerMetadata),
+	}
+
+	healthy := NewWorkerMetadata(&fakeDataNodeClient{resp: &datanodev1.GetWorkerInfoResponse{
+		WorkerId:        "healthy",
+		CapacityBytes:   1000,
+		UsedBytes:       400,
+		RemainingBytes:  600,
+		BlockCount:      4,
+		CorruptBlockIds: []string{"block-1"},
+	}}, "worker-0", 50051, 0)
+	down := NewWorkerMetadata(&fakeDataNodeClient{err: fmt.Errorf("connection refused")}, "worker-1", 50051, 0)
+	down.Alive = true
+
+	lb.workerInfo["healthy"] = *healthy
+	lb.workerInfo["down"] = *down
+
+	lb.RefreshWorkerStats(context.Background())
+
+	t.Run("records heartbeat stats", func(t *testing.T) {
+		wm := lb.Workers()["healthy"]
+		assert.True(t, wm.Alive)
+		assert.False(t, wm.LastHeartbeat.IsZero())
+		assert.Equal(t, int64(1000), wm.CapacityBytes)
+		assert.Equal(t, int64(400), wm.UsedBytes)
+		assert.Equal(t, int64(4), wm.BlockCount)
+		assert.Equal(t, []string{"block-1"}, wm.CorruptBlocks)
+	})
+
+	t.Run("marks unreachable workers dead", func(t *testing.T) {
+		assert.False(t, lb.IsAlive("down"))
+		assert.Equal(t, 1, lb.LiveWorkerCount())
+	})
+}
+
+func TestLoadBalancer_AdminState(t *testing.T) {
+	lb := NewLoadBalancer(0, 50051)
+	lb.AddWorker("worker-a", WorkerMetadata{Ip: "worker-0.worker-headless", Port: 50051, Alive: true, UsedBytes: 100})
+	lb.AddWorker("worker-b", WorkerMetadata{Ip: "worker-1.worker-headless", Port: 50051, Alive: true, UsedBytes: 10})
+	lb.AddWorker("worker-c", WorkerMetadata{Ip: "worker-2.worker-headless", Port: 50051, Alive: false})
+
+	t.Run("resolves workers by id, host or address", func(t *testing.T) {
+		for _, name := range []string{"worker-a", "worker-0.worker-headless", "worker-0.worker-headless:50051"} {
+			id, ok := lb.ResolveWorker(name)
+			assert.True(t, ok, name)
+			assert.Equal(t, "worker-a", id)
+		}
+		_, ok := lb.ResolveWorker("worker-9")
+		assert.False(t, ok)
+	})
+
+	t.Run("picks the emptiest live in-service target", func(t *testing.T) {
+		id, wm, ok := lb.PickTarget(nil)
+		assert.True(t, ok)
+		assert.Equal(t, "worker-b", id)
+		assert.Equal(t, "worker-1.worker-headless:50051", wm.Address())
+
+		id, _, ok = lb.PickTarget([]string{"worker-b"})
+		assert.True(t, ok)
+		assert.Equal(t, "worker-a", id)
+	})
+
+	t.Run("decommissioning workers are skipped", func(t *testing.T) {
+		assert.NoError(t, lb.SetAdminState("worker-b", Decommissioning))
+		_, _, ok := lb.PickTarget([]string{"worker-a"})
+		assert.False(t, ok)
+
+		for i := 0; i < 4; i++ {
+			id, _ := lb.GetNextClient()
+			assert.NotEqual(t, "worker-b", id)
+		}
+	})
+
+	t.Run("re-adding a worker keeps its admin state", func(t *testing.T) {
+		lb.AddWorker("worker-b", WorkerMetadata{Ip: "worker-1.worker-headless", Port: 50051, Alive: true})
+		assert.Equal(t, Decommissioning, lb.Workers()["worker-b"].AdminState)
+	})
+
+	t.Run("unknown worker", func(t *testing.T) {
+		assert.Error(t, lb.SetAdminState("worker-9", Decommissioned))
+	})
+}
+
+func TestLoadBalancer_StorageTypes(t *testing.T) {
+	lb := NewLoadBalancer(0, 50051)
+	lb.AddWorker("ssd-a", WorkerMetadata{Alive: true, StorageType: StorageSSD, UsedBytes: 50})
+	lb.AddWorker("ssd-b", WorkerMetadata{Alive: true, StorageType: StorageSSD, UsedBytes: 10})
+	lb.AddWorker("disk-a", WorkerMetadata{Alive: true, StorageType: StorageDisk})
+	lb.AddWorker("legacy", WorkerMetadata{Alive: true})
+
+	t.Run("workers without a storage type count as disk", func(t *testing.T) {
+		assert.Equal(t, StorageDisk, lb.Workers()["legacy"].Storage())
+	})
+
+	t.Run("preferred types are picked first", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			ids, workers := lb.GetNextClients(2, []string{StorageSSD})
+			assert.ElementsMatch(t, []string{"ssd-a", "ssd-b"}, ids)
+			assert.Len(t, workers, 2)
+		}
+	})
+
+	t.Run("falls back to other types", func(t *testing.T) {
+		ids, _ := lb.GetNextClients(3, []string{StorageSSD, StorageDisk})
+		assert.Len(t, ids, 3)
+		assert.ElementsMatch(t, []string{"ssd-a", "ssd-b"}, ids[:2])
+
+		ids, _ = lb.GetNextClients(1, []string{StorageArchive}, "disk-a", "legacy", "ssd-a")
+		assert.Equal(t, []string{"ssd-b"}, ids)
+	})
+
+	t.Run("returns at most the available workers", func(t *testing.T) {
+		ids, _ := lb.GetNextClients(10, nil)
+		assert.Len(t, ids, 4)
+	})
+
+	t.Run("target picking ranks storage type before usage", func(t *testing.T) {
+		id, _, ok := lb.PickTarget(nil, StorageSSD)
+		assert.True(t, ok)
+		assert.Equal(t, "ssd-b", id)
+
+		id, _, ok = lb.PickTarget([]string{"legacy"}, StorageDisk)
+		assert.True(t, ok)
+		assert.Equal(t, "disk-a", id)
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/razvanmarinn/datalake/pkg/logging"
+	adminv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/admin/v1"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+type adminServer struct {
+	adminv1.UnimplementedAdminServiceServer
+	masterNode *nodes.MasterNode
+	leader     *leaderState
+	logger     *logging.Logger
+}
+
+func (s *adminServer) requireActive() error {
+	if !s.masterNode.IsActive {
+		return notLeaderError(s.leader)
+	}
+	return nil
+}
+
+func (s *adminServer) GetReport(ctx context.Context, req *adminv1.GetReportRequest) (*adminv1.GetReportResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	stats := s.masterNode.Stats()
+	safeMode, _ := s.masterNode.SafeMode()
+
+	resp := &adminv1.GetReportResponse{
+		LiveWorkers:           int32(stats.LiveWorkers),
+		Files:                 int64(stats.Files),
+		Directories:           int64(stats.Directories),
+		Blocks:                int64(stats.Blocks),
+		UnderReplicatedBlocks: int64(stats.UnderReplicatedBlocks),
+		MissingBlocks:         int64(stats.MissingBlocks),
+		CorruptBlocks:         int64(stats.CorruptBlocks),
+		SafeMode:              safeMode,
+	}
+
+	for _, wr := range s.masterNode.WorkerReports() {
+		if !wr.Alive {
+			resp.DeadWorkers++
+		}
+		resp.CapacityBytes += wr.CapacityBytes
+		resp.UsedBytes += wr.UsedBytes
+		resp.RemainingBytes += wr.RemainingBytes
+
+		var lastHeartbeat int64
+		if !wr.LastHeartbeat.IsZero() {
+			lastHeartbeat = wr.LastHeartbeat.Unix()
+		}
+		resp.Workers = append(resp.Workers, &adminv1.WorkerReport{
+			WorkerId:          wr.WorkerID,
+			Address:           wr.Address,
+			AdminState:        wr.AdminState,
+			Alive:             wr.Alive,
+			LastHeartbeatUnix: lastHeartbeat,
+			CapacityBytes:     wr.CapacityBytes,
+			UsedBytes:         wr.UsedBytes,
+			RemainingBytes:    wr.RemainingBytes,
+			BlockCount:        wr.BlockCount,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *adminServer) SetSafeMode(ctx context.Context, req *adminv1.SetSafeModeRequest) (*adminv1.SetSafeModeResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	switch req.Action {
+	case adminv1.SafeModeAction_SAFE_MODE_ACTION_GET:
+	case adminv1.SafeModeAction_SAFE_MODE_ACTION_ENTER:
+		s.logger.Info("Safe mode entered by operator")
+		s.masterNode.EnterSafeMode("entered manually by operator")
+	case adminv1.SafeModeAction_SAFE_MODE_ACTION_LEAVE:
+		s.logger.Info("Safe mode left by operator")
+		s.masterNode.LeaveSafeMode()
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown safe mode action %v", req.Action)
+	}
+
+	safeMode := s.masterNode.SafeModeStatus()
+	return &adminv1.SetSafeModeResponse{
+		Enabled:         safeMode.Enabled,
+		Reason:          safeMode.Reason,
+		Startup:         safeMode.Startup,
+		Manual:          safeMode.Manual,
+		ConfirmedBlocks: int64(safeMode.ConfirmedBlocks),
+		TotalBlocks:     int64(safeMode.TotalBlocks),
+		Threshold:       safeMode.Threshold,
+	}, nil
+}
+
+func (s *adminServer) Decommission(ctx context.Context, req *adminv1.DecommissionRequest) (*adminv1.DecommissionResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	if req.Worker == "" {
+		return nil, status.Error(codes.InvalidArgument, "worker is required")
+	}
+
+	workerID, pending, err := s.masterNode.Decommission(req.Worker)
+	if err != nil {
+		s.logger.Error("Decommission failed", zap.String("worker", req.Worker), zap.Error(err))
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	adminState := ""
+	for _, wr := range s.masterNode.WorkerReports() {
+		if wr.WorkerID == workerID {
+			adminState = wr.AdminState
+		}
+	}
+
+	return &adminv1.DecommissionResponse{
+		WorkerId:          workerID,
+		AdminState:        adminState,
+		BlocksToReplicate: int64(pending),
+	}, nil
+}
+
+func (s *adminServer) RefreshNodes(ctx context.Context, req *adminv1.RefreshNodesRequest) (*adminv1.RefreshNodesResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	added, err := s.masterNode.RefreshNodes(ctx)
+	if err != nil {
+		s.logger.Error("RefreshNodes failed", zap.Error(err))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &adminv1.RefreshNodesResponse{AddedWorkers: added}
+	for _, wr := range s.masterNode.WorkerReports() {
+		if wr.Alive {
+			resp.LiveWorkers++
+		} else {
+			resp.DeadWorkers++
+		}
+	}
+	return resp, nil
+}
+
+func (s *adminServer) SaveNamespace(ctx context.Context, req *adminv1.SaveNamespaceRequest) (*adminv1.SaveNamespaceResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	path, inodes, blocks, err := s.masterNode.SaveNamespace()
+	if err != nil {
+		s.logger.Error("SaveNamespace failed", zap.Error(err))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &adminv1.SaveNamespaceResponse{
+		CheckpointPath: path,
+		Inodes:         int64(inodes),
+		Blocks:         int64(blocks),
+	}, nil
+}
+
+func (s *adminServer) MetaSave(ctx context.Context, req *adminv1.MetaSaveRequest) (*adminv1.MetaSaveResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	return &adminv1.MetaSaveResponse{Dump: s.masterNode.MetaSave()}, nil
+}
+
+func (s *adminServer) Fsck(ctx context.Context, req *adminv1.FsckRequest) (*adminv1.FsckResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Received Fsck request",
+		zap.String("path", req.Path),
+		zap.String("action", req.Action.String()))
+
+	resp, err := s.masterNode.Fsck(ctx, req)
+	if err != nil {
+		s.logger.Error("Fsck failed", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	return resp, nil
+}
+
+// grpcError maps namespace errors to gRPC status codes clients can act on.
+// Safe mode rejections are Unavailable with a RetryInfo hint, so writers back
+// off and retry instead of failing.
+func grpcError(err error) error {
+	if errors.Is(err, nodes.ErrSafeMode) {
+		st := status.New(codes.Unavailable, err.Error())
+		if detailed, detailErr := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(safeModeRetryDelay),
+		}); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	if errors.Is(err, nodes.ErrBlockUnknown) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, nodes.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, nodes.ErrExists) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, nodes.ErrChecksumUnverified) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, nodes.ErrChecksumMismatch) {
+		return status.Error(codes.DataLoss, err.Error())
+	}
+	if errors.Is(err, nodes.ErrGenerationMismatch) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, nodes.ErrChecksumUnavailable) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, nodes.ErrInvalidStoragePolicy) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, nodes.ErrPermissionDenied) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, nodes.ErrWatchExpired) {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	return err
+}
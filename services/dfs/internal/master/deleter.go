@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+const releasedBlocksInterval = time.Minute
+
+// runReleasedBlocksLoop drops the provisional references of dedup hits that
+// were never committed, and deletes the replicas of blocks no file uses any
+// more once their grace period is over.
+func runReleasedBlocksLoop(ctx context.Context, masterNode *nodes.MasterNode) {
+	ticker := time.NewTicker(releasedBlocksInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			masterNode.ExpireDedupHolds(now)
+			masterNode.DeleteReleasedBlocks(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	defaultMasterNamespace = "datalake"
+	defaultClusterDomain   = "cluster.local"
+)
+
+// masterGroup is the master group this master belongs to. Each group owns a
+// part of the namespace, as mapped by the clients' mount table, with its own
+// op log, leader election and standbys. The default group is unnamed.
+type masterGroup struct {
+	name string
+	// namespace is the Kubernetes namespace of the group's pods and lease,
+	// clusterDomain the cluster's DNS domain. Empty means the defaults.
+	namespace     string
+	clusterDomain string
+}
+
+// masterGroupFromEnv reads the group from $DFS_MASTER_GROUP, its Kubernetes
+// namespace from $POD_NAMESPACE and the cluster's DNS domain from
+// $DFS_CLUSTER_DOMAIN.
+func masterGroupFromEnv() masterGroup {
+	return masterGroup{
+		name:          os.Getenv("DFS_MASTER_GROUP"),
+		namespace:     os.Getenv("POD_NAMESPACE"),
+		clusterDomain: os.Getenv("DFS_CLUSTER_DOMAIN"),
+	}
+}
+
+func (g masterGroup) qualify(name string) string {
+	if g.name == "" {
+		return name
+	}
+	return name + "-" + g.name
+}
+
+// kubeNamespace is the namespace of the group's pods and lease.
+func (g masterGroup) kubeNamespace() string {
+	if g.namespace == "" {
+		return defaultMasterNamespace
+	}
+	return g.namespace
+}
+
+// statefulSet is the name of the group's master pods, "master" or
+// "master-<group>", which their headless service shares as a prefix.
+func (g masterGroup) statefulSet() string {
+	return g.qualify("master")
+}
+
+func (g masterGroup) leaseName() string {
+	return g.qualify("dfs-master-lock")
+}
+
+// address returns the gRPC address of the group's master pod podName.
+func (g masterGroup) address(podName string) string {
+	domain := g.clusterDomain
+	if domain == "" {
+		domain = defaultClusterDomain
+	}
+	return fmt.Sprintf("%s.%s-headless.%s.svc.%s%s", podName, g.statefulSet(), g.kubeNamespace(), domain, port)
+}
+
+// peers returns the addresses of the group's masters other than podName.
+func (g masterGroup) peers(podName string, replicas int) []string {
+	var peers []string
+	for i := 0; i < replicas; i++ {
+		pod := fmt.Sprintf("%s-%d", g.statefulSet(), i)
+		if pod != podName {
+			peers = append(peers, g.address(pod))
+		}
+	}
+	return peers
+}
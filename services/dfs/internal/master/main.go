@@ -6,14 +6,21 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/razvanmarinn/datalake/pkg/dfsmeta"
 	"github.com/razvanmarinn/datalake/pkg/logging"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -22,97 +29,423 @@ import (
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 
+	adminv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/admin/v1"
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
 	replicationv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/replication/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+	"github.com/razvanmarinn/dfs/internal/metrics"
 	"github.com/razvanmarinn/dfs/internal/nodes"
 )
 
 const (
-	port = ":50055"
+	port            = ":50055"
+	defaultHTTPPort = ":8080"
+
+	// masterReplicas is the size of a master group.
+	masterReplicas = 3
 )
 
+// callerPolicy limits the admin RPCs to dfsadmin and log replication to the
+// other masters.
+var callerPolicy = tlsconfig.Policy{
+	"/admin.v1.AdminService/":                                    {"admin"},
+	replicationv1.ReplicationService_ReplicateLog_FullMethodName: {"master"},
+}
+
 type server struct {
 	coordinatorv1.UnimplementedCoordinatorServiceServer
 	masterNode *nodes.MasterNode
+	leader     *leaderState
 	logger     *logging.Logger
+	// staleReads lets a standby serve namespace reads to callers that allow
+	// them.
+	staleReads  bool
+	permissions permissionConfig
 }
 
-func (s *server) AllocateBlock(ctx context.Context, req *coordinatorv1.AllocateBlockRequest) (*coordinatorv1.AllocateBlockResponse, error) {
+func (s *server) requireActive() error {
 	if !s.masterNode.IsActive {
-		return nil, fmt.Errorf("node is standby, not active leader")
+		return notLeaderError(s.leader)
+	}
+	return nil
+}
+
+// requireReadable is requireActive for namespace reads. A standby with stale
+// reads enabled serves callers that allow them, once it has applied the
+// TxID they require.
+func (s *server) requireReadable(ctx context.Context) error {
+	if s.masterNode.IsActive {
+		return nil
+	}
+	if !s.staleReads {
+		return notLeaderError(s.leader)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if allow := md.Get(dfsmeta.AllowStaleHeader); len(allow) == 0 || allow[0] != "true" {
+		return notLeaderError(s.leader)
+	}
+	// A standby that missed an operation may lack any part of the namespace.
+	if s.masterNode.MissedOps() {
+		return notLeaderError(s.leader)
+	}
+	if minTxID := md.Get(dfsmeta.MinTxIDHeader); len(minTxID) > 0 {
+		txID, err := strconv.ParseInt(minTxID[0], 10, 64)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid %s %q", dfsmeta.MinTxIDHeader, minTxID[0])
+		}
+		if s.masterNode.AppliedTxID() < txID {
+			return notLeaderError(s.leader)
+		}
+	}
+	return nil
+}
+
+func (s *server) AllocateBlock(ctx context.Context, req *coordinatorv1.AllocateBlockRequest) (*coordinatorv1.AllocateBlockResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
 	}
 
 	s.logger.Info("Received AllocateBlock request",
 		zap.String("project_id", req.ProjectId),
-		zap.Int64("size", req.SizeBytes))
+		zap.Int64("size", req.SizeBytes),
+		zap.Int32("replication", req.Replication),
+		zap.String("storage_policy", req.StoragePolicy),
+		zap.Bool("dedup", req.ContentHash != ""),
+		zap.Strings("excluded_workers", req.ExcludedWorkerIds))
+
+	if req.FilePath != "" {
+		if err := s.checkCreate(s.permissions.caller(ctx), req.FilePath); err != nil {
+			return nil, err
+		}
+	}
 
 	resp, err := s.masterNode.AllocateBlock(req)
 	if err != nil {
 		s.logger.Error("Allocation failed", zap.Error(err))
-		return nil, err
+		return nil, grpcError(err)
 	}
 	return resp, nil
 }
 
 func (s *server) CommitFile(ctx context.Context, req *coordinatorv1.CommitFileRequest) (*coordinatorv1.CommitFileResponse, error) {
-	if !s.masterNode.IsActive {
-		return &coordinatorv1.CommitFileResponse{Success: false}, fmt.Errorf("node is standby")
+	if err := s.requireActive(); err != nil {
+		return &coordinatorv1.CommitFileResponse{Success: false}, err
 	}
 	s.logger.Info("Received CommitFile request", zap.String("file_path", req.FilePath))
 
-	_, err := s.masterNode.CommitFile(req)
+	inode, err := s.masterNode.CommitFile(s.permissions.caller(ctx), req)
 	if err != nil {
 		s.logger.Error("Commit failed", zap.Error(err))
-		return &coordinatorv1.CommitFileResponse{Success: false}, err
+		return &coordinatorv1.CommitFileResponse{Success: false}, grpcError(err)
 	}
 
-	return &coordinatorv1.CommitFileResponse{Success: true}, nil
+	return &coordinatorv1.CommitFileResponse{Success: true, Generation: inode.Generation}, nil
 }
 
 func (s *server) GetFileMetadata(ctx context.Context, req *coordinatorv1.GetFileMetadataRequest) (*coordinatorv1.GetFileMetadataResponse, error) {
-	if !s.masterNode.IsActive {
-		return nil, fmt.Errorf("node is standby")
+	if err := s.requireReadable(ctx); err != nil {
+		return nil, err
 	}
 	s.logger.Info("Received GetFileMetadata request", zap.String("file_path", req.FilePath))
 
-	resp, err := s.masterNode.GetFileMetadata(req.ProjectId, req.FilePath)
+	if err := s.checkAccess(s.permissions.caller(ctx), req.FilePath, nodes.AccessRead); err != nil {
+		return nil, err
+	}
+
+	// Standbys get no block reports, so they send clients to the leader
+	// unless they happen to know every block's replicas.
+	if !s.masterNode.IsActive && !s.masterNode.BlockLocationsKnown(req.FilePath) {
+		return nil, notLeaderError(s.leader)
+	}
+
+	resp, err := s.masterNode.GetFileMetadata(req.ProjectId, req.FilePath, req.ClientHost)
 	if err != nil {
 		s.logger.Error("Metadata retrieval failed", zap.Error(err))
-		return nil, err
+		return nil, grpcError(err)
+	}
+	if s.masterNode.IsActive {
+		s.masterNode.TouchAccessTime(req.FilePath)
 	}
 	return resp, nil
 }
 
 func (s *server) ListFiles(ctx context.Context, req *coordinatorv1.ListFilesRequest) (*coordinatorv1.ListFilesResponse, error) {
-	if !s.masterNode.IsActive {
-		return nil, fmt.Errorf("node is standby")
-	}
-	files, err := s.masterNode.ListFiles(req.ProjectId, req.DirectoryPrefix)
-	if err != nil {
-		s.logger.Error("ListFiles failed", zap.Error(err))
+	if err := s.requireReadable(ctx); err != nil {
 		return nil, err
 	}
-	return &coordinatorv1.ListFilesResponse{FilePaths: files}, nil
+	infos := s.masterNode.ListFileInfos(req.ProjectId, req.DirectoryPrefix)
+	caller := s.permissions.caller(ctx)
+
+	resp := &coordinatorv1.ListFilesResponse{}
+	for _, info := range infos {
+		relPath, _ := filepath.Rel(req.ProjectId, info.Path)
+		if req.StartAfter != "" && relPath <= req.StartAfter {
+			continue
+		}
+		// Files the caller may not read are left out.
+		if !caller.Superuser && s.masterNode.CheckAccess(caller, info.Path, nodes.AccessRead) != nil {
+			continue
+		}
+		if req.Limit > 0 && len(resp.Files) == int(req.Limit) {
+			resp.Truncated = true
+			break
+		}
+		resp.FilePaths = append(resp.FilePaths, relPath)
+		resp.Files = append(resp.Files, fileStatus(info))
+	}
+	return resp, nil
 }
 
 func (s *server) CommitCompaction(ctx context.Context, req *coordinatorv1.CommitCompactionRequest) (*coordinatorv1.CommitCompactionResponse, error) {
-	if !s.masterNode.IsActive {
-		return &coordinatorv1.CommitCompactionResponse{Success: false}, fmt.Errorf("node is standby")
+	if err := s.requireActive(); err != nil {
+		return &coordinatorv1.CommitCompactionResponse{Success: false}, err
 	}
 
 	s.logger.Info("Received CommitCompaction request",
 		zap.String("project_id", req.ProjectId),
 		zap.Int("old_files_count", len(req.OldFilePaths)))
 
-	err := s.masterNode.CommitCompaction(req)
+	err := s.masterNode.CommitCompaction(s.permissions.caller(ctx), req)
 	if err != nil {
 		s.logger.Error("Compaction Commit failed", zap.Error(err))
-		return &coordinatorv1.CommitCompactionResponse{Success: false}, err
+		return &coordinatorv1.CommitCompactionResponse{Success: false}, grpcError(err)
 	}
 
 	return &coordinatorv1.CommitCompactionResponse{Success: true}, nil
 }
 
+func (s *server) ReportBadReplica(ctx context.Context, req *coordinatorv1.ReportBadReplicaRequest) (*coordinatorv1.ReportBadReplicaResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	blockID, err := uuid.Parse(req.BlockId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid block id %q", req.BlockId)
+	}
+	workerID, err := uuid.Parse(req.WorkerId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid worker id %q", req.WorkerId)
+	}
+
+	s.logger.Warn("Client reported bad replica",
+		zap.String("block_id", req.BlockId),
+		zap.String("worker_id", req.WorkerId),
+		zap.String("reason", req.Reason))
+
+	s.masterNode.MarkReplicaCorrupt(blockID, workerID)
+	return &coordinatorv1.ReportBadReplicaResponse{}, nil
+}
+
+func (s *server) AbandonBlock(ctx context.Context, req *coordinatorv1.AbandonBlockRequest) (*coordinatorv1.AbandonBlockResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+
+	blockID, err := uuid.Parse(req.BlockId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid block id %q", req.BlockId)
+	}
+
+	s.logger.Info("Received AbandonBlock request", zap.String("block_id", req.BlockId))
+
+	if err := s.masterNode.AbandonBlock(blockID); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &coordinatorv1.AbandonBlockResponse{}, nil
+}
+
+func (s *server) GetFileInfo(ctx context.Context, req *coordinatorv1.GetFileInfoRequest) (*coordinatorv1.GetFileInfoResponse, error) {
+	if err := s.requireReadable(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAccess(s.permissions.caller(ctx), req.Path, 0); err != nil {
+		return nil, err
+	}
+
+	info, err := s.masterNode.StatPath(req.Path)
+	if err != nil {
+		return nil, grpcError(fmt.Errorf("%s: %w", req.Path, err))
+	}
+	return &coordinatorv1.GetFileInfoResponse{Status: fileStatus(info)}, nil
+}
+
+func (s *server) ListDirectory(ctx context.Context, req *coordinatorv1.ListDirectoryRequest) (*coordinatorv1.ListDirectoryResponse, error) {
+	if err := s.requireReadable(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAccess(s.permissions.caller(ctx), req.Path, nodes.AccessRead|nodes.AccessExecute); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.masterNode.ListDirectory(req.Path)
+	if err != nil {
+		return nil, grpcError(fmt.Errorf("%s: %w", req.Path, err))
+	}
+
+	resp := &coordinatorv1.ListDirectoryResponse{Entries: make([]*coordinatorv1.FileStatus, 0, len(entries))}
+	for _, info := range entries {
+		resp.Entries = append(resp.Entries, fileStatus(info))
+	}
+	return resp, nil
+}
+
+func (s *server) DeleteFile(ctx context.Context, req *coordinatorv1.DeleteFileRequest) (*coordinatorv1.DeleteFileResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received DeleteFile request", zap.String("file_path", req.FilePath))
+
+	if err := s.masterNode.DeleteFile(s.permissions.caller(ctx), req.FilePath, req.Recursive); err != nil {
+		return nil, grpcError(fmt.Errorf("%s: %w", req.FilePath, err))
+	}
+	return &coordinatorv1.DeleteFileResponse{}, nil
+}
+
+func (s *server) ConcatFiles(ctx context.Context, req *coordinatorv1.ConcatFilesRequest) (*coordinatorv1.ConcatFilesResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received ConcatFiles request",
+		zap.String("target_path", req.TargetPath),
+		zap.Int("sources", len(req.SourcePaths)))
+
+	if err := s.masterNode.ConcatFiles(s.permissions.caller(ctx), req); err != nil {
+		s.logger.Error("Concat failed", zap.Error(err))
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.ConcatFilesResponse{}, nil
+}
+
+func (s *server) Rename(ctx context.Context, req *coordinatorv1.RenameRequest) (*coordinatorv1.RenameResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received Rename request",
+		zap.String("src_path", req.SrcPath),
+		zap.String("dst_path", req.DstPath))
+
+	if err := s.masterNode.Rename(s.permissions.caller(ctx), req.SrcPath, req.DstPath); err != nil {
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.RenameResponse{}, nil
+}
+
+func (s *server) Mkdirs(ctx context.Context, req *coordinatorv1.MkdirsRequest) (*coordinatorv1.MkdirsResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received Mkdirs request", zap.String("path", req.Path))
+
+	if err := s.masterNode.Mkdirs(s.permissions.caller(ctx), req.Path, req.ProjectId, req.OwnerId); err != nil {
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.MkdirsResponse{}, nil
+}
+
+func (s *server) GetFileChecksum(ctx context.Context, req *coordinatorv1.GetFileChecksumRequest) (*coordinatorv1.GetFileChecksumResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received GetFileChecksum request", zap.String("file_path", req.FilePath))
+
+	if err := s.checkAccess(s.permissions.caller(ctx), req.FilePath, nodes.AccessRead); err != nil {
+		return nil, err
+	}
+
+	checksum, length, err := s.masterNode.FileChecksum(req.FilePath)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.GetFileChecksumResponse{
+		Algorithm: nodes.FileChecksumAlgorithm,
+		Checksum:  checksum,
+		Length:    length,
+	}, nil
+}
+
+func (s *server) SetStoragePolicy(ctx context.Context, req *coordinatorv1.SetStoragePolicyRequest) (*coordinatorv1.SetStoragePolicyResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received SetStoragePolicy request",
+		zap.String("path", req.Path),
+		zap.String("storage_policy", req.StoragePolicy))
+
+	if err := s.masterNode.SetStoragePolicy(s.permissions.caller(ctx), req.Path, req.StoragePolicy); err != nil {
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.SetStoragePolicyResponse{}, nil
+}
+
+func (s *server) SetAttr(ctx context.Context, req *coordinatorv1.SetAttrRequest) (*coordinatorv1.SetAttrResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received SetAttr request", zap.String("path", req.Path))
+
+	attrs := nodes.Attrs{Mode: req.Mode, Owner: req.Owner, Group: req.Group}
+	if req.ModTime != nil {
+		t := time.Unix(0, *req.ModTime)
+		attrs.ModTime = &t
+	}
+	if req.AccessTime != nil {
+		t := time.Unix(0, *req.AccessTime)
+		attrs.AccessTime = &t
+	}
+	if err := s.masterNode.SetAttr(s.permissions.caller(ctx), req.Path, attrs); err != nil {
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.SetAttrResponse{}, nil
+}
+
+func (s *server) SetXAttr(ctx context.Context, req *coordinatorv1.SetXAttrRequest) (*coordinatorv1.SetXAttrResponse, error) {
+	if err := s.requireActive(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Received SetXAttr request",
+		zap.String("path", req.Path),
+		zap.String("name", req.Name),
+		zap.Bool("remove", req.Remove))
+
+	if err := s.masterNode.SetXAttr(s.permissions.caller(ctx), req.Path, req.Name, req.Value, req.Remove); err != nil {
+		return nil, grpcError(err)
+	}
+	return &coordinatorv1.SetXAttrResponse{}, nil
+}
+
+func fileStatus(info nodes.PathInfo) *coordinatorv1.FileStatus {
+	st := &coordinatorv1.FileStatus{
+		Path:       info.Path,
+		Name:       info.Name,
+		IsDir:      info.IsDir,
+		Size:       info.Size,
+		Generation: info.Generation,
+
+		BlockSize:     info.BlockSize,
+		Replication:   info.Replication,
+		StoragePolicy: info.StoragePolicy,
+
+		Mode:   info.Mode,
+		Owner:  info.Owner,
+		Group:  info.Group,
+		Xattrs: info.XAttrs,
+	}
+	if !info.ModTime.IsZero() {
+		st.ModTime = info.ModTime.UnixNano()
+	}
+	if !info.ATime.IsZero() {
+		st.AccessTime = info.ATime.UnixNano()
+	}
+	if !info.CTime.IsZero() {
+		st.ChangeTime = info.CTime.UnixNano()
+	}
+	return st
+}
+
 type replicationServer struct {
 	replicationv1.UnimplementedReplicationServiceServer
 	masterNode *nodes.MasterNode
@@ -126,7 +459,7 @@ func (s *replicationServer) ReplicateLog(ctx context.Context, req *replicationv1
 
 	s.logger.Debug("Received Replication Log", zap.Int32("op_type", req.OpType))
 
-	err := s.masterNode.ApplyReplicatedLog(nodes.OpType(req.OpType), req.Payload)
+	err := s.masterNode.ApplyReplicatedLog(nodes.OpType(req.OpType), req.Payload, req.TxId, req.Timestamp)
 	return &replicationv1.ReplicateLogResponse{Success: err == nil}, err
 }
 
@@ -142,16 +475,35 @@ func main() {
 	k8sClient := kubernetes.NewForConfigOrDie(k8sConfig)
 
 	state := nodes.NewMasterNodeState()
-	_ = state.LoadStateFromFile()
+	_ = state.LoadStateFromFile(nodes.MasterStateFile)
 	masterNode := nodes.GetMasterNodeInstance()
 	masterNode.IsActive = false
 
+	blockTokens, err := blocktoken.FromEnv()
+	if err != nil {
+		logger.Fatal("Failed to load block token keys", zap.Error(err))
+	}
+	masterNode.BlockTokens = blockTokens
+
+	permissions, err := permissionConfigFromEnv()
+	if err != nil {
+		logger.Fatal("Invalid permission settings", zap.Error(err))
+	}
+	if masterNode.Umask, err = umaskFromEnv(); err != nil {
+		logger.Fatal("Invalid permission settings", zap.Error(err))
+	}
+
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		logger.Fatal("Failed to listen", zap.Error(err))
 	}
 
-	grpcServer := grpc.NewServer()
+	tlsSource := tlsconfig.MustLoad(tlsconfig.FromEnv("master"))
+
+	serverOpts := append(tlsSource.ServerOptions(callerPolicy),
+		grpc.UnaryInterceptor(metricsUnaryInterceptor),
+		grpc.ChainUnaryInterceptor(txIDUnaryInterceptor(masterNode)))
+	grpcServer := grpc.NewServer(serverOpts...)
 	healthServer := health.NewServer()
 
 	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
@@ -159,9 +511,22 @@ func main() {
 	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
 	log.Println("Health check service registered successfully")
 
+	group := masterGroupFromEnv()
+	if group.name != "" {
+		logger.Info("Serving master group", zap.String("group", group.name))
+	}
+	leader := &leaderState{group: group}
+	staleReads := os.Getenv("DFS_STALE_READS") == "true"
+	if staleReads {
+		logger.Info("Stale reads enabled: serving namespace reads while standby")
+	}
 	coordinatorv1.RegisterCoordinatorServiceServer(grpcServer, &server{
 		masterNode: masterNode,
+		leader:     leader,
 		logger:     logger,
+		staleReads: staleReads,
+
+		permissions: permissions,
 	})
 
 	replicationv1.RegisterReplicationServiceServer(grpcServer, &replicationServer{
@@ -169,6 +534,12 @@ func main() {
 		logger:     logger,
 	})
 
+	adminv1.RegisterAdminServiceServer(grpcServer, &adminServer{
+		masterNode: masterNode,
+		leader:     leader,
+		logger:     logger,
+	})
+
 	go func() {
 		logger.Info("gRPC Server listening (waiting for election)", zap.String("address", port))
 		if err := grpcServer.Serve(lis); err != nil {
@@ -181,10 +552,21 @@ func main() {
 	}
 	id := fmt.Sprintf("%s-%s", hostname, uuid.New().String())
 
+	httpPort := os.Getenv("HTTP_PORT")
+	if httpPort == "" {
+		httpPort = defaultHTTPPort
+	}
+	startStatusServer(httpPort, &statusServer{
+		masterNode: masterNode,
+		identity:   id,
+		leader:     leader,
+	})
+	go runStatsLoop(context.Background(), masterNode)
+
 	lock := &resourcelock.LeaseLock{
 		LeaseMeta: metav1.ObjectMeta{
-			Name:      "dfs-master-lock",
-			Namespace: "datalake",
+			Name:      group.leaseName(),
+			Namespace: group.kubeNamespace(),
 		},
 		Client: k8sClient.CoordinationV1(),
 		LockConfig: resourcelock.ResourceLockConfig{
@@ -204,13 +586,20 @@ func main() {
 			OnStartedLeading: func(ctx context.Context) {
 				logger.Info(">>> I AM THE MASTER NOW <<<")
 
+				// Stay read-only until workers have reported the blocks we know about.
+				masterNode.EnterStartupSafeMode(safeModeThreshold())
 				masterNode.IsActive = true
+				metrics.MasterIsLeader.Set(1)
 
-				masterNode.Replicator = nodes.NewReplicator(id)
+				masterNode.Replicator = nodes.NewReplicator(group.peers(hostname, masterReplicas), tlsSource.DialOption("master"))
 
-				if err := masterNode.InitializeLoadBalancer(3, 50051); err != nil {
+				if err := masterNode.InitializeLoadBalancer(3, 50051, tlsSource.DialOption("worker")); err != nil {
 					logger.Error("Failed to init load balancer", zap.Error(err))
 				}
+				go runSafeModeLoop(ctx, masterNode)
+				go runMoverLoop(ctx, masterNode)
+				go runPackerLoop(ctx, masterNode)
+				go runReleasedBlocksLoop(ctx, masterNode)
 
 				if err := promoteSelf(k8sClient, hostname, "datalake"); err != nil {
 					logger.Error("Failed to patch pod label", zap.Error(err))
@@ -221,6 +610,7 @@ func main() {
 				os.Exit(1)
 			},
 			OnNewLeader: func(identity string) {
+				leader.set(identity)
 				if identity == hostname {
 					return
 				}
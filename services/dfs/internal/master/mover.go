@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+const defaultMoverInterval = 10 * time.Minute
+
+// moverInterval reads how often the mover checks block placement against
+// storage policies from DFS_MOVER_INTERVAL; 0 disables the periodic run.
+func moverInterval() time.Duration {
+	value := os.Getenv("DFS_MOVER_INTERVAL")
+	if value == "" {
+		return defaultMoverInterval
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		log.Printf("Invalid DFS_MOVER_INTERVAL %q, using %v", value, defaultMoverInterval)
+		return defaultMoverInterval
+	}
+	return interval
+}
+
+// runMoverLoop periodically moves blocks that are on the wrong kind of
+// storage for their file's policy, e.g. after a rename into a COLD directory
+// or once a worker of a preferred type joins.
+func runMoverLoop(ctx context.Context, masterNode *nodes.MasterNode) {
+	interval := moverInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			masterNode.MoveBlocks("")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
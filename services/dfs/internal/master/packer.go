@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+const defaultPackerInterval = 30 * time.Minute
+
+// packerInterval reads how often small files are packed into container blocks
+// from DFS_PACKER_INTERVAL; 0 disables packing.
+func packerInterval() time.Duration {
+	value := os.Getenv("DFS_PACKER_INTERVAL")
+	if value == "" {
+		return defaultPackerInterval
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		log.Printf("Invalid DFS_PACKER_INTERVAL %q, using %v", value, defaultPackerInterval)
+		return defaultPackerInterval
+	}
+	return interval
+}
+
+// runPackerLoop periodically packs small files, such as the Avro files of
+// ingestion flushes, into container blocks and rewrites containers whose
+// files were mostly deleted.
+func runPackerLoop(ctx context.Context, masterNode *nodes.MasterNode) {
+	interval := packerInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			masterNode.PackSmallFiles()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
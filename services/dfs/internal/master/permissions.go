@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+// defaultSuperusers may do anything in the namespace: the masters themselves
+// and dfsadmin.
+var defaultSuperusers = []string{"master", "admin"}
+
+// permissionConfig maps caller identities to the users and groups that mode
+// bits are checked against.
+type permissionConfig struct {
+	superusers []string
+	// groups lists the groups of each identity.
+	groups map[string][]string
+	// disabled makes every caller a superuser.
+	disabled bool
+}
+
+// permissionConfigFromEnv reads the superusers from $DFS_SUPERUSERS, a comma
+// separated list added to the defaults, and group memberships from
+// $DFS_GROUPS, as in "analytics=query-service,compactor;ingest=ingestion-consumer".
+// DFS_PERMISSIONS=false turns permission checks off.
+func permissionConfigFromEnv() (permissionConfig, error) {
+	cfg := permissionConfig{
+		superusers: slices.Clone(defaultSuperusers),
+		groups:     make(map[string][]string),
+	}
+	if v := os.Getenv("DFS_PERMISSIONS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return permissionConfig{}, fmt.Errorf("invalid DFS_PERMISSIONS %q", v)
+		}
+		cfg.disabled = !enabled
+	}
+	for _, user := range strings.Split(os.Getenv("DFS_SUPERUSERS"), ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			cfg.superusers = append(cfg.superusers, user)
+		}
+	}
+
+	for _, entry := range strings.Split(os.Getenv("DFS_GROUPS"), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		group, members, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return permissionConfig{}, fmt.Errorf("invalid DFS_GROUPS entry %q, want group=user,...", entry)
+		}
+		for _, user := range strings.Split(members, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				cfg.groups[user] = append(cfg.groups[user], group)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// caller returns who a coordinator RPC is made by. Without TLS there is no
+// identity: such callers only get the access mode bits give everyone.
+func (c permissionConfig) caller(ctx context.Context) nodes.Caller {
+	identity, _ := tlsconfig.PeerIdentity(ctx)
+	return nodes.Caller{
+		User:      identity,
+		Groups:    c.groups[identity],
+		Superuser: c.disabled || slices.Contains(c.superusers, identity),
+	}
+}
+
+// checkAccess is nodes.MasterNode.CheckAccess with a gRPC status.
+func (s *server) checkAccess(caller nodes.Caller, path string, access nodes.Access) error {
+	return grpcError(s.masterNode.CheckAccess(caller, path, access))
+}
+
+// checkCreate checks that caller may create or replace the file at path.
+func (s *server) checkCreate(caller nodes.Caller, path string) error {
+	return grpcError(s.masterNode.CheckCreate(caller, path))
+}
+
+// umaskFromEnv reads the octal umask of new inodes from $DFS_UMASK.
+func umaskFromEnv() (uint32, error) {
+	v := os.Getenv("DFS_UMASK")
+	if v == "" {
+		return nodes.DefaultUmask, nil
+	}
+	umask, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || umask > 0o777 {
+		return 0, fmt.Errorf("invalid DFS_UMASK %q", v)
+	}
+	return uint32(umask), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+const (
+	safeModeReportInterval = 5 * time.Second
+	// Delay suggested to clients whose writes were rejected by safe mode.
+	safeModeRetryDelay = 10 * time.Second
+)
+
+// safeModeThreshold reads the fraction of blocks that must be reported before
+// leaving startup safe mode from DFS_SAFEMODE_THRESHOLD.
+func safeModeThreshold() float64 {
+	value := os.Getenv("DFS_SAFEMODE_THRESHOLD")
+	if value == "" {
+		return nodes.DefaultSafeModeThreshold
+	}
+
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		log.Printf("Invalid DFS_SAFEMODE_THRESHOLD %q, using %.3f", value, nodes.DefaultSafeModeThreshold)
+		return nodes.DefaultSafeModeThreshold
+	}
+	return threshold
+}
+
+// runSafeModeLoop collects block reports until the master leaves startup safe mode.
+func runSafeModeLoop(ctx context.Context, masterNode *nodes.MasterNode) {
+	ticker := time.NewTicker(safeModeReportInterval)
+	defer ticker.Stop()
+
+	for masterNode.InStartupSafeMode() {
+		masterNode.CollectBlockReports(ctx)
+		if !masterNode.InStartupSafeMode() {
+			return
+		}
+
+		log.Printf("Safe mode: %s", masterNode.SafeModeStatus().Reason)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
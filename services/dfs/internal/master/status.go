@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/razvanmarinn/datalake/pkg/dfsmeta"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+
+	"github.com/razvanmarinn/dfs/internal/metrics"
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+const statsRefreshInterval = 15 * time.Second
+
+// leaderState tracks the current leader as reported by leader election.
+type leaderState struct {
+	mu     sync.RWMutex
+	leader string
+	since  time.Time
+	group  masterGroup
+}
+
+func (l *leaderState) set(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leader = identity
+	l.since = time.Now()
+}
+
+func (l *leaderState) get() (string, time.Time) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.leader, l.since
+}
+
+// address returns the gRPC address of the current leader, or "" while it is
+// unknown. Election identities are "<pod name>-<uuid>", and every master pod
+// is reachable through the headless service of its group.
+func (l *leaderState) address() string {
+	identity, _ := l.get()
+	n := len(identity) - len(uuid.Nil.String())
+	if n < 2 || identity[n-1] != '-' {
+		return ""
+	}
+	if _, err := uuid.Parse(identity[n:]); err != nil {
+		return ""
+	}
+	return l.group.address(identity[:n-1])
+}
+
+// notLeaderError is what coordinator and admin RPCs return on a standby. Its
+// NotLeader detail lets clients retry against the leader.
+func notLeaderError(leader *leaderState) error {
+	st := status.New(codes.FailedPrecondition, "node is standby, not active leader")
+	if detailed, err := st.WithDetails(&coordinatorv1.NotLeader{LeaderAddress: leader.address()}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+type workerTotals struct {
+	CapacityBytes  int64 `json:"capacity_bytes"`
+	UsedBytes      int64 `json:"used_bytes"`
+	RemainingBytes int64 `json:"remaining_bytes"`
+}
+
+type statusResponse struct {
+	MasterID       string               `json:"master_id"`
+	Identity       string               `json:"identity"`
+	Role           string               `json:"role"`
+	Leader         string               `json:"leader"`
+	LeaderSince    *time.Time           `json:"leader_since,omitempty"`
+	SafeMode       bool                 `json:"safe_mode"`
+	SafeModeReason string               `json:"safe_mode_reason,omitempty"`
+	Namespace      nodes.NamespaceStats `json:"namespace"`
+	Totals         workerTotals         `json:"totals"`
+	Workers        []nodes.WorkerReport `json:"workers"`
+}
+
+type statusServer struct {
+	masterNode *nodes.MasterNode
+	identity   string
+	leader     *leaderState
+}
+
+func (s *statusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := statusResponse{
+		MasterID:  s.masterNode.ID,
+		Identity:  s.identity,
+		Role:      "standby",
+		Namespace: s.masterNode.Stats(),
+		Workers:   s.masterNode.WorkerReports(),
+	}
+	if s.masterNode.IsActive {
+		resp.Role = "active"
+	}
+
+	resp.SafeMode, resp.SafeModeReason = s.masterNode.SafeMode()
+
+	leader, since := s.leader.get()
+	resp.Leader = leader
+	if !since.IsZero() {
+		resp.LeaderSince = &since
+	}
+
+	for _, wr := range resp.Workers {
+		resp.Totals.CapacityBytes += wr.CapacityBytes
+		resp.Totals.UsedBytes += wr.UsedBytes
+		resp.Totals.RemainingBytes += wr.RemainingBytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to encode status response: %v", err)
+	}
+}
+
+func startStatusServer(addr string, s *statusServer) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/status", s.handleStatus)
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		log.Printf("Status server listening on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Status server failed: %v", err)
+		}
+	}()
+	return srv
+}
+
+// runStatsLoop periodically heartbeats workers and refreshes the master gauges.
+func runStatsLoop(ctx context.Context, masterNode *nodes.MasterNode) {
+	ticker := time.NewTicker(statsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		masterNode.RefreshWorkers(ctx)
+		masterNode.UpdateMetrics()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// txIDUnaryInterceptor reports in the response header the TxID of the
+// namespace state a coordinator call saw: the latest once it is done on the
+// leader, and what was applied when it started on a standby.
+func txIDUnaryInterceptor(mn *nodes.MasterNode) grpc.UnaryServerInterceptor {
+	service := "/" + coordinatorv1.CoordinatorService_ServiceDesc.ServiceName + "/"
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, service) {
+			return handler(ctx, req)
+		}
+		txID := mn.AppliedTxID()
+		resp, err := handler(ctx, req)
+		if mn.IsActive {
+			txID = mn.AppliedTxID()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(dfsmeta.TxIDHeader, strconv.FormatInt(txID, 10)))
+		return resp, err
+	}
+}
+
+func metricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	metrics.MasterRPCDuration.
+		WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+	return resp, err
+}
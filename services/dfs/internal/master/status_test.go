@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+)
+
+func TestNotLeaderError(t *testing.T) {
+	leader := &leaderState{}
+
+	for _, tc := range []struct {
+		identity string
+		want     string
+	}{
+		{"", ""},
+		{"master-1", ""},
+		{"master-1-not-a-uuid-at-all-but-just-as-long-xx", ""},
+		{"master-1-6f1c2a3e-9a0b-4c1d-8e2f-3a4b5c6d7e8f", "master-1.master-headless.datalake.svc.cluster.local:50055"},
+	} {
+		leader.set(tc.identity)
+
+		st := status.Convert(notLeaderError(leader))
+		if st.Code() != codes.FailedPrecondition {
+			t.Fatalf("%q: got code %v, want FailedPrecondition", tc.identity, st.Code())
+		}
+		details := st.Details()
+		if len(details) != 1 {
+			t.Fatalf("%q: got details %v, want a NotLeader", tc.identity, details)
+		}
+		notLeader, ok := details[0].(*coordinatorv1.NotLeader)
+		if !ok {
+			t.Fatalf("%q: got detail %T, want *NotLeader", tc.identity, details[0])
+		}
+		if notLeader.LeaderAddress != tc.want {
+			t.Errorf("%q: got leader address %q, want %q", tc.identity, notLeader.LeaderAddress, tc.want)
+		}
+	}
+}
+
+func TestMasterGroup(t *testing.T) {
+	if got, want := (masterGroup{}).leaseName(), "dfs-master-lock"; got != want {
+		t.Errorf("default group lease: got %q, want %q", got, want)
+	}
+	group := masterGroup{name: "orders"}
+	if got, want := group.leaseName(), "dfs-master-lock-orders"; got != want {
+		t.Errorf("group lease: got %q, want %q", got, want)
+	}
+
+	leader := &leaderState{group: group}
+	leader.set("master-orders-2-6f1c2a3e-9a0b-4c1d-8e2f-3a4b5c6d7e8f")
+	if got, want := leader.address(), "master-orders-2.master-orders-headless.datalake.svc.cluster.local:50055"; got != want {
+		t.Errorf("leader address: got %q, want %q", got, want)
+	}
+
+	peers := group.peers("master-orders-1", 3)
+	want := []string{
+		"master-orders-0.master-orders-headless.datalake.svc.cluster.local:50055",
+		"master-orders-2.master-orders-headless.datalake.svc.cluster.local:50055",
+	}
+	if len(peers) != len(want) || peers[0] != want[0] || peers[1] != want[1] {
+		t.Errorf("peers: got %v, want %v", peers, want)
+	}
+
+	elsewhere := masterGroup{name: "orders", namespace: "storage", clusterDomain: "corp.example"}
+	if got, want := elsewhere.address("master-orders-0"), "master-orders-0.master-orders-headless.storage.svc.corp.example:50055"; got != want {
+		t.Errorf("address in another namespace and domain: got %q, want %q", got, want)
+	}
+	if got, want := elsewhere.kubeNamespace(), "storage"; got != want {
+		t.Errorf("lease namespace: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/razvanmarinn/dfs/internal/nodes"
+)
+
+var eventTypes = map[nodes.EventType]coordinatorv1.NamespaceEventType{
+	nodes.EventCreate:   coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_CREATE,
+	nodes.EventDelete:   coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_DELETE,
+	nodes.EventRename:   coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_RENAME,
+	nodes.EventMetadata: coordinatorv1.NamespaceEventType_NAMESPACE_EVENT_TYPE_METADATA,
+}
+
+// WatchNamespace streams the namespace events below req.Prefix until the
+// caller goes away.
+func (s *server) WatchNamespace(req *coordinatorv1.WatchNamespaceRequest, stream grpc.ServerStreamingServer[coordinatorv1.NamespaceEvent]) error {
+	if err := s.requireActive(); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	s.logger.Info("Received WatchNamespace request",
+		zap.String("prefix", req.Prefix),
+		zap.Int64("from_tx_id", req.FromTxId))
+
+	prefix := filepath.Clean(req.Prefix)
+	if err := s.checkAccess(s.permissions.caller(ctx), prefix, nodes.AccessRead|nodes.AccessExecute); err != nil {
+		return err
+	}
+
+	txID := req.FromTxId
+	if txID == 0 {
+		txID = s.masterNode.AppliedTxID()
+	}
+	for {
+		events, changed, err := s.masterNode.NamespaceEvents(txID)
+		if err != nil {
+			return grpcError(err)
+		}
+		for _, e := range events {
+			txID = e.TxID
+			if !e.Matches(prefix) {
+				continue
+			}
+			if err := stream.Send(namespaceEvent(e)); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-changed:
+		}
+	}
+}
+
+func namespaceEvent(e nodes.NamespaceEvent) *coordinatorv1.NamespaceEvent {
+	return &coordinatorv1.NamespaceEvent{
+		TxId:       e.TxID,
+		Type:       eventTypes[e.Type],
+		Path:       e.Path,
+		DstPath:    e.DstPath,
+		IsDir:      e.IsDir,
+		Generation: e.Generation,
+		Timestamp:  e.Time.UnixNano(),
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Counter metrics
+	MasterReplicationQuorumFailuresTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dfs_master_replication_quorum_failures_total",
+			Help: "Total number of op-log entries that failed to reach a replication quorum",
+		},
+	)
+
+	// Histogram metrics
+	MasterRPCDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dfs_master_rpc_duration_seconds",
+			Help:    "Latency of gRPC calls served by the master",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "code"},
+	)
+
+	MasterOpLogAppendDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "dfs_master_oplog_append_duration_seconds",
+			Help:    "Time taken to append and fsync an entry to the master op log",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		},
+	)
+
+	// Gauge metrics
+	MasterIsLeader = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_is_leader",
+			Help: "1 if this master currently holds leadership, 0 otherwise",
+		},
+	)
+
+	MasterSafeMode = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_safe_mode",
+			Help: "1 if the namespace is in read-only safe mode, 0 otherwise",
+		},
+	)
+
+	MasterInodesTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_inodes_total",
+			Help: "Number of inodes in the namespace",
+		},
+		[]string{"type"},
+	)
+
+	MasterBlocksTotal = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_blocks_total",
+			Help: "Number of blocks tracked in the block map",
+		},
+	)
+
+	MasterDedupHitsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dfs_master_dedup_hits_total",
+			Help: "Total number of block allocations answered with an existing block of the same content",
+		},
+	)
+
+	MasterUnderReplicatedBlocks = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_under_replicated_blocks",
+			Help: "Number of blocks with fewer healthy live replicas than required",
+		},
+	)
+
+	MasterMissingBlocks = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_missing_blocks",
+			Help: "Number of blocks with no replica on a live worker",
+		},
+	)
+
+	MasterCorruptBlocks = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_corrupt_blocks",
+			Help: "Number of blocks whose live replicas are all corrupt",
+		},
+	)
+
+	MasterLiveWorkers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dfs_master_live_workers",
+			Help: "Number of workers that answered the last heartbeat",
+		},
+	)
+)
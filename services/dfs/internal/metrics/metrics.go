@@ -47,6 +47,21 @@ var (
 		[]string{"status"},
 	)
 
+	BlockScannerBlocksScannedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dfs_block_scanner_blocks_scanned_total",
+			Help: "Total number of blocks verified by the background block scanner",
+		},
+		[]string{"result"},
+	)
+
+	BlockScannerBytesScannedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dfs_block_scanner_bytes_scanned_total",
+			Help: "Total number of bytes read by the background block scanner",
+		},
+	)
+
 	// Histogram metrics
 	ChecksumCalculationDuration = promauto.NewHistogram(
 		prometheus.HistogramOpts{
@@ -113,10 +128,68 @@ var (
 		[]string{"worker_id"},
 	)
 
+	BlockScannerPendingBlocks = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dfs_block_scanner_pending_blocks",
+			Help: "Number of blocks due for verification in the current scan period",
+		},
+		[]string{"worker_id"},
+	)
+
+	BlockScannerProgressRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dfs_block_scanner_period_progress_ratio",
+			Help: "Fraction of stored blocks verified within the current scan period",
+		},
+		[]string{"worker_id"},
+	)
+
 	LastIntegrityCheckTimestamp = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "dfs_last_integrity_check_timestamp",
 			Help: "Unix timestamp of last completed integrity check",
 		},
 	)
+
+	// I/O QoS metrics, by request class
+	IOBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dfs_io_bytes_total",
+			Help: "Bytes read or written by worker requests",
+		},
+		[]string{"class"},
+	)
+
+	IOActiveRequests = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dfs_io_active_requests",
+			Help: "Worker requests currently holding an I/O slot",
+		},
+		[]string{"class"},
+	)
+
+	IOAdmissionWaitDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dfs_io_admission_wait_duration_seconds",
+			Help:    "Time worker requests waited for an I/O slot",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+		[]string{"class"},
+	)
+
+	IORejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dfs_io_rejected_total",
+			Help: "Worker requests turned away because their class had no free I/O slot",
+		},
+		[]string{"class"},
+	)
+
+	IOThrottledSecondsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dfs_io_throttled_seconds_total",
+			Help: "Time worker requests spent waiting on their class's bandwidth budget",
+		},
+		[]string{"class"},
+	)
 )
@@ -0,0 +1,367 @@
+package nodes
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+)
+
+const (
+	// Optional file listing extra worker addresses (host:port, one per line)
+	// that refreshNodes should connect to.
+	workersFileEnv = "DFS_WORKERS_FILE"
+
+	drainRetryInterval = 30 * time.Second
+	transferTimeout    = 5 * time.Minute
+)
+
+// Decommission stops placing new blocks on a worker and starts copying the
+// blocks it holds to other workers. It returns the resolved worker ID and the
+// number of blocks that need a new replica.
+func (mn *MasterNode) Decommission(worker string) (string, int, error) {
+	if mn.LoadBalancer == nil {
+		return "", 0, fmt.Errorf("no workers registered")
+	}
+
+	workerID, ok := mn.LoadBalancer.ResolveWorker(worker)
+	if !ok {
+		return "", 0, fmt.Errorf("worker %s not found", worker)
+	}
+
+	_, wm, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerID)
+	if err != nil {
+		return "", 0, err
+	}
+	if wm.AdminState != load_balancer.InService {
+		return workerID, len(mn.blocksToDrain(workerID)), nil
+	}
+
+	if err := mn.LoadBalancer.SetAdminState(workerID, load_balancer.Decommissioning); err != nil {
+		return "", 0, err
+	}
+
+	pending := mn.blocksToDrain(workerID)
+	log.Printf("Decommissioning worker %s: %d blocks to re-replicate", workerID, len(pending))
+
+	go mn.drainWorker(workerID)
+	return workerID, len(pending), nil
+}
+
+// blocksToDrain lists blocks stored on workerID that have no healthy copy on
+// any other live, in-service worker.
+func (mn *MasterNode) blocksToDrain(workerID string) []uuid.UUID {
+	workerUUID, err := uuid.Parse(workerID)
+	if err != nil {
+		return nil
+	}
+
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	pending := make([]uuid.UUID, 0)
+	for blockID, meta := range mn.BlockMap {
+		held, covered := false, false
+		for _, replica := range meta.Replicas {
+			if replica == workerUUID {
+				held = true
+				continue
+			}
+			if isCorruptReplica(meta, replica) || !mn.isReplicaLive(replica) {
+				continue
+			}
+			if _, wm, _, _, err := mn.LoadBalancer.GetClientByWorkerID(replica.String()); err == nil && wm.AdminState == load_balancer.InService {
+				covered = true
+			}
+		}
+		if held && !covered {
+			pending = append(pending, blockID)
+		}
+	}
+	return pending
+}
+
+func (mn *MasterNode) drainWorker(workerID string) {
+	for {
+		// A master that lost the lead leaves the drain to the new leader.
+		if !mn.IsActive {
+			log.Printf("Decommission %s: no longer the leader, stopping", workerID)
+			return
+		}
+		pending := mn.blocksToDrain(workerID)
+		failed := 0
+
+		for _, blockID := range pending {
+			if err := mn.replicateFrom(blockID, workerID); err != nil {
+				log.Printf("Decommission %s: failed to move block %s: %v", workerID, blockID, err)
+				failed++
+			}
+		}
+
+		if failed == 0 {
+			if err := mn.removeReplicas(workerID); err != nil {
+				log.Printf("Decommission %s: %v, retrying in %v", workerID, err, drainRetryInterval)
+				time.Sleep(drainRetryInterval)
+				continue
+			}
+			if err := mn.LoadBalancer.SetAdminState(workerID, load_balancer.Decommissioned); err != nil {
+				log.Printf("Decommission %s: %v", workerID, err)
+			}
+			log.Printf("✅ Worker %s decommissioned (%d blocks moved)", workerID, len(pending))
+			return
+		}
+
+		log.Printf("Decommission %s: %d blocks still pending, retrying in %v", workerID, failed, drainRetryInterval)
+		time.Sleep(drainRetryInterval)
+	}
+}
+
+// replicateFrom asks sourceID to copy blockID to a worker that does not yet
+// hold it and records the new replica.
+func (mn *MasterNode) replicateFrom(blockID uuid.UUID, sourceID string) error {
+	exclude := make([]string, 0)
+	for _, replica := range mn.GetBatchLocations(blockID) {
+		exclude = append(exclude, replica.String())
+	}
+
+	targetID, target, ok := mn.LoadBalancer.PickTarget(exclude)
+	if !ok {
+		return fmt.Errorf("no live in-service worker available")
+	}
+	return mn.transferBlock(blockID, sourceID, targetID, target)
+}
+
+// transferBlock asks sourceID to copy blockID to target and records the new
+// replica.
+func (mn *MasterNode) transferBlock(blockID uuid.UUID, sourceID, targetID string, target load_balancer.WorkerMetadata) error {
+	source, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(sourceID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+
+	resp, err := source.TransferBlock(ctx, &datanodev1.TransferBlockRequest{
+		BlockId:       blockID.String(),
+		TargetAddress: target.Address(),
+		BlockToken:    mn.blockToken(blockID, "", blocktoken.OpRead, blocktoken.OpWrite),
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("transfer failed: %s", resp.Message)
+	}
+
+	mn.UpdateBlockLocation(blockID, targetID)
+	return nil
+}
+
+// RemoveReplicasPayload is the op-log payload of OpRemoveReplicas: replicas
+// the leader forgot, so standbys do not count them. No Blocks means every
+// block.
+type RemoveReplicasPayload struct {
+	WorkerID uuid.UUID   `json:"workerId"`
+	Blocks   []uuid.UUID `json:"blocks,omitempty"`
+}
+
+// removeReplicas forgets every replica held by workerID.
+func (mn *MasterNode) removeReplicas(workerID string) error {
+	workerUUID, err := uuid.Parse(workerID)
+	if err != nil {
+		return err
+	}
+
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	p := RemoveReplicasPayload{WorkerID: workerUUID}
+	if err := mn.appendToLog(OperationLogEntry{OpType: OpRemoveReplicas, Timestamp: time.Now().Unix(), Payload: p}); err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	mn.applyRemoveReplicasLocked(p)
+	return nil
+}
+
+// applyRemoveReplicasLocked forgets the replicas of p. mn.lock must be held.
+func (mn *MasterNode) applyRemoveReplicasLocked(p RemoveReplicasPayload) {
+	forget := func(meta *BlockMetadata) {
+		meta.Replicas = removeUUID(meta.Replicas, p.WorkerID)
+		meta.CorruptReplicas = removeUUID(meta.CorruptReplicas, p.WorkerID)
+	}
+	if len(p.Blocks) == 0 {
+		for _, meta := range mn.BlockMap {
+			forget(meta)
+		}
+		return
+	}
+	for _, blockID := range p.Blocks {
+		if meta, ok := mn.BlockMap[blockID]; ok {
+			forget(meta)
+		}
+	}
+}
+
+func removeUUID(ids []uuid.UUID, target uuid.UUID) []uuid.UUID {
+	kept := ids[:0]
+	for _, id := range ids {
+		if id != target {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// RefreshNodes connects to workers that were unreachable when the load
+// balancer was created, plus any listed in $DFS_WORKERS_FILE, then refreshes
+// worker stats. It returns the IDs of newly added workers.
+func (mn *MasterNode) RefreshNodes(ctx context.Context) ([]string, error) {
+	if mn.LoadBalancer == nil {
+		return nil, fmt.Errorf("load balancer not initialized")
+	}
+
+	addresses := load_balancer.DefaultWorkerAddresses(mn.workerCount, mn.workerPort)
+	if path := os.Getenv(workersFileEnv); path != "" {
+		extra, err := readWorkersFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read workers file: %w", err)
+		}
+		addresses = append(addresses, extra...)
+	}
+
+	added := mn.LoadBalancer.Refresh(addresses)
+	mn.RefreshWorkers(ctx)
+	return added, nil
+}
+
+func readWorkersFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	addresses := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addresses = append(addresses, line)
+	}
+	return addresses, scanner.Err()
+}
+
+// SaveNamespace writes a consistent image of the namespace and block map to
+// the checkpoint file that is loaded on startup.
+func (mn *MasterNode) SaveNamespace() (string, int, int, error) {
+	path := mn.checkpointPath
+	if path == "" {
+		path = filepath.Join(storageDir, MasterStateFile)
+	}
+
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	state := &MasterNodeState{
+		ID:             mn.ID,
+		Namespace:      mn.Namespace,
+		BlockMap:       mn.BlockMap,
+		LastGeneration: mn.LastGeneration,
+		LastTxID:       mn.lastTxID.Load(),
+	}
+	if err := state.SaveState(path); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	log.Printf("💾 Namespace checkpoint written to %s (%d inodes, %d blocks)", path, len(mn.Namespace), len(mn.BlockMap))
+	return path, len(mn.Namespace), len(mn.BlockMap), nil
+}
+
+// MetaSave renders the worker table and the block map, with the owning file
+// and the state of every replica, as a human-readable dump.
+func (mn *MasterNode) MetaSave() string {
+	workers := map[string]load_balancer.WorkerMetadata{}
+	if mn.LoadBalancer != nil {
+		workers = mn.LoadBalancer.Workers()
+	}
+
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	owners := make(map[uuid.UUID]string, len(mn.BlockMap))
+	for path, inode := range mn.Namespace {
+		for _, blockID := range inode.Blocks {
+			owners[blockID] = path
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Metasave generated %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "%d inodes, %d blocks, %d workers\n\n", len(mn.Namespace), len(mn.BlockMap), len(workers))
+
+	tw := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKER\tADDRESS\tSTATE\tALIVE\tBLOCKS")
+	workerIDs := make([]string, 0, len(workers))
+	for id := range workers {
+		workerIDs = append(workerIDs, id)
+	}
+	sort.Strings(workerIDs)
+	for _, id := range workerIDs {
+		wm := workers[id]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%d\n", id, wm.Address(), wm.AdminState, wm.Alive, wm.BlockCount)
+	}
+	tw.Flush()
+	sb.WriteString("\n")
+
+	blockIDs := make([]uuid.UUID, 0, len(mn.BlockMap))
+	for id := range mn.BlockMap {
+		blockIDs = append(blockIDs, id)
+	}
+	sort.Slice(blockIDs, func(i, j int) bool {
+		return blockIDs[i].String() < blockIDs[j].String()
+	})
+
+	tw = tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BLOCK\tSIZE\tCHECKSUM\tFILE\tREPLICAS")
+	for _, blockID := range blockIDs {
+		meta := mn.BlockMap[blockID]
+
+		replicas := make([]string, 0, len(meta.Replicas))
+		for _, replica := range meta.Replicas {
+			state := "live"
+			switch {
+			case isCorruptReplica(meta, replica):
+				state = "corrupt"
+			case !mn.isReplicaLive(replica):
+				state = "dead"
+			}
+			replicas = append(replicas, fmt.Sprintf("%s(%s)", replica, state))
+		}
+		if len(replicas) == 0 {
+			replicas = append(replicas, "none")
+		}
+
+		owner := owners[blockID]
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", blockID, meta.Size, meta.Checksum, owner, strings.Join(replicas, ","))
+	}
+	tw.Flush()
+
+	return sb.String()
+}
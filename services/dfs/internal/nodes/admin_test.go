@@ -0,0 +1,124 @@
+package nodes
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type fakeTransferClient struct {
+	datanodev1.DataNodeServiceClient
+	transfers []*datanodev1.TransferBlockRequest
+}
+
+func (f *fakeTransferClient) TransferBlock(ctx context.Context, in *datanodev1.TransferBlockRequest, opts ...grpc.CallOption) (*datanodev1.TransferBlockResponse, error) {
+	f.transfers = append(f.transfers, in)
+	return &datanodev1.TransferBlockResponse{Success: true}, nil
+}
+
+func TestMasterNode_SafeModeBlocksWrites(t *testing.T) {
+	master := setupTestMaster(t)
+	master.EnterSafeMode("maintenance")
+
+	enabled, reason := master.SafeMode()
+	assert.True(t, enabled)
+	assert.Equal(t, "maintenance", reason)
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "p", FilePath: "p/s/f.avro"})
+	assert.ErrorIs(t, err, ErrSafeMode)
+
+	master.LeaveSafeMode()
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "p", FilePath: "p/s/f.avro"})
+	assert.NoError(t, err)
+}
+
+func TestMasterNode_SaveNamespace(t *testing.T) {
+	master := setupTestMaster(t)
+	master.checkpointPath = filepath.Join(t.TempDir(), MasterStateFile)
+
+	blockID := uuid.New()
+	master.Namespace["p/f.avro"] = &Inode{ID: "f", Path: "p/f.avro", Type: FileType, Blocks: []uuid.UUID{blockID}}
+	master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Size: 42, Checksum: 7}
+
+	path, inodes, blocks, err := master.SaveNamespace()
+	require.NoError(t, err)
+	assert.Equal(t, master.checkpointPath, path)
+	assert.Equal(t, 1, inodes)
+	assert.Equal(t, 1, blocks)
+
+	state := NewMasterNodeState()
+	require.NoError(t, state.LoadStateFromFile(path))
+	assert.Equal(t, "test-master", state.ID)
+	require.Contains(t, state.BlockMap, blockID)
+	assert.Equal(t, uint32(7), state.BlockMap[blockID].Checksum)
+}
+
+func TestMasterNode_MetaSave(t *testing.T) {
+	master := setupTestMaster(t)
+
+	blockID := uuid.New()
+	worker := uuid.New()
+	master.Namespace["p/f.avro"] = &Inode{ID: "f", Path: "p/f.avro", Type: FileType, Blocks: []uuid.UUID{blockID}}
+	master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Size: 42, Replicas: []uuid.UUID{worker}}
+	master.MarkReplicaCorrupt(blockID, worker)
+
+	dump := master.MetaSave()
+	assert.Contains(t, dump, blockID.String())
+	assert.Contains(t, dump, "p/f.avro")
+	assert.Contains(t, dump, worker.String()+"(corrupt)")
+}
+
+func TestMasterNode_Decommission(t *testing.T) {
+	master := setupTestMaster(t)
+
+	source := &fakeTransferClient{}
+	leaving, staying := uuid.New(), uuid.New()
+
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	lb.AddWorker(leaving.String(), load_balancer.WorkerMetadata{Client: source, Ip: "worker-0.worker-headless", Port: 50051, Alive: true})
+	lb.AddWorker(staying.String(), load_balancer.WorkerMetadata{Client: &fakeTransferClient{}, Ip: "worker-1.worker-headless", Port: 50051, Alive: true})
+	master.LoadBalancer = lb
+
+	onlyOnLeaving, onBoth := uuid.New(), uuid.New()
+	master.BlockMap[onlyOnLeaving] = &BlockMetadata{BlockID: onlyOnLeaving, Replicas: []uuid.UUID{leaving}}
+	master.BlockMap[onBoth] = &BlockMetadata{BlockID: onBoth, Replicas: []uuid.UUID{leaving, staying}}
+
+	require.NoError(t, lb.SetAdminState(leaving.String(), load_balancer.Decommissioning))
+
+	pending := master.blocksToDrain(leaving.String())
+	assert.Equal(t, []uuid.UUID{onlyOnLeaving}, pending)
+
+	t.Run("new blocks are not placed on the draining worker", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			id, _ := lb.GetNextClient()
+			assert.Equal(t, staying.String(), id)
+		}
+	})
+
+	t.Run("blocks are copied to an in-service worker", func(t *testing.T) {
+		require.NoError(t, master.replicateFrom(onlyOnLeaving, leaving.String()))
+		require.Len(t, source.transfers, 1)
+		assert.Equal(t, "worker-1.worker-headless:50051", source.transfers[0].TargetAddress)
+		assert.Contains(t, master.BlockMap[onlyOnLeaving].Replicas, staying)
+		assert.Empty(t, master.blocksToDrain(leaving.String()))
+	})
+
+	t.Run("replicas on the retired worker are forgotten", func(t *testing.T) {
+		require.NoError(t, master.removeReplicas(leaving.String()))
+		assert.Equal(t, []uuid.UUID{staying}, master.BlockMap[onlyOnLeaving].Replicas)
+		assert.Equal(t, []uuid.UUID{staying}, master.BlockMap[onBoth].Replicas)
+
+		standby := setupTestMaster(t)
+		standby.BlockMap[onBoth] = &BlockMetadata{BlockID: onBoth, Replicas: []uuid.UUID{leaving, staying}}
+		replayLog(t, master, standby)
+		assert.Equal(t, []uuid.UUID{staying}, standby.BlockMap[onBoth].Replicas, "standbys forget them too")
+	})
+}
@@ -0,0 +1,361 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+const (
+	blockScannerCursorFile = "block_scanner_cursor.json"
+
+	// How often the scan queue is rebuilt so newly written blocks can jump ahead.
+	scannerRequeueInterval = time.Minute
+	// How often the cursor is flushed to disk while a pass is running.
+	scannerCursorSaveInterval = 30 * time.Second
+)
+
+type BlockScannerConfig struct {
+	// BytesPerSecond caps the disk read rate of the scanner. <= 0 disables throttling.
+	BytesPerSecond int64
+	// ScanPeriod is the minimum time between two verifications of the same block.
+	ScanPeriod time.Duration
+	// IdleInterval is how long the scanner sleeps when no block is due.
+	IdleInterval time.Duration
+	// CursorPath overrides the location of the persisted cursor.
+	CursorPath string
+}
+
+func DefaultBlockScannerConfig() BlockScannerConfig {
+	return BlockScannerConfig{
+		BytesPerSecond: 8 * 1024 * 1024,
+		ScanPeriod:     24 * time.Hour,
+		IdleInterval:   5 * time.Minute,
+	}
+}
+
+// scannerCursor is the persisted scanner progress: when each block was last
+// verified. It lets the scanner resume after a restart without re-reading
+// blocks that were already verified during the current period.
+type scannerCursor struct {
+	UpdatedAt   int64            `json:"updated_at"`
+	LastScanned map[string]int64 `json:"last_scanned"`
+}
+
+type scanCandidate struct {
+	blockID     string
+	size        int64
+	modTime     time.Time
+	lastScanned time.Time
+}
+
+// BlockScanner verifies stored blocks in the background at a bounded read rate.
+type BlockScanner struct {
+	worker  *WorkerNode
+	cfg     BlockScannerConfig
+	limiter *rate.Limiter
+
+	mu       sync.Mutex
+	cursor   scannerCursor
+	lastSave time.Time
+
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+func NewBlockScanner(worker *WorkerNode, cfg BlockScannerConfig) *BlockScanner {
+	defaults := DefaultBlockScannerConfig()
+	if cfg.ScanPeriod <= 0 {
+		cfg.ScanPeriod = defaults.ScanPeriod
+	}
+	if cfg.IdleInterval <= 0 {
+		cfg.IdleInterval = defaults.IdleInterval
+	}
+	if cfg.CursorPath == "" {
+		cfg.CursorPath = filepath.Join(worker.StorageDir, blockScannerCursorFile)
+	}
+
+	bs := &BlockScanner{
+		worker:   worker,
+		cfg:      cfg,
+		limiter:  newByteLimiter(cfg.BytesPerSecond),
+		cursor:   scannerCursor{LastScanned: make(map[string]int64)},
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if err := bs.loadCursor(); err != nil {
+		log.Printf("Block scanner: could not load cursor, starting from scratch: %v", err)
+	}
+	return bs
+}
+
+func (bs *BlockScanner) Start() {
+	go bs.run()
+	log.Printf("🔍 Block scanner started (period: %v, rate: %d B/s)", bs.cfg.ScanPeriod, bs.cfg.BytesPerSecond)
+}
+
+func (bs *BlockScanner) Stop() {
+	close(bs.stopChan)
+	<-bs.done
+
+	if err := bs.saveCursor(); err != nil {
+		log.Printf("Block scanner: failed to save cursor: %v", err)
+	}
+	log.Println("🛑 Block scanner stopped")
+}
+
+// CheckBlock verifies a single block immediately, bypassing the throttle.
+func (bs *BlockScanner) CheckBlock(ctx context.Context, blockID string) error {
+	return bs.worker.verifyBlockIntegrity(blockID)
+}
+
+func (bs *BlockScanner) run() {
+	defer close(bs.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-bs.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	passStart := time.Time{}
+	for {
+		queue, err := bs.pendingBlocks(time.Now())
+		if err != nil {
+			log.Printf("Block scanner: error reading storage directory: %v", err)
+			metrics.IntegrityChecksTotal.WithLabelValues("failed").Inc()
+		}
+
+		if len(queue) == 0 {
+			if !passStart.IsZero() {
+				bs.completePass(passStart)
+				passStart = time.Time{}
+			}
+			select {
+			case <-time.After(bs.cfg.IdleInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if passStart.IsZero() {
+			passStart = time.Now()
+			log.Printf("🔍 Block scanner pass started: %d blocks due", len(queue))
+		}
+
+		requeueAt := time.Now().Add(scannerRequeueInterval)
+		for _, c := range queue {
+			if ctx.Err() != nil {
+				return
+			}
+			bs.scanBlock(ctx, c)
+			if time.Now().After(requeueAt) {
+				break
+			}
+		}
+	}
+}
+
+// pendingBlocks lists the blocks due for verification, most urgent first:
+// blocks never verified, then blocks rewritten since their last verification,
+// then the rest ordered by how long ago they were verified.
+func (bs *BlockScanner) pendingBlocks(now time.Time) ([]scanCandidate, error) {
+	files, err := os.ReadDir(bs.worker.StorageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	onDisk := make(map[string]struct{})
+	due := make([]scanCandidate, 0)
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".bin") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		blockID := strings.TrimSuffix(file.Name(), ".bin")
+		onDisk[blockID] = struct{}{}
+
+		var last time.Time
+		if ts, ok := bs.cursor.LastScanned[blockID]; ok {
+			last = time.Unix(ts, 0)
+		}
+		if !last.IsZero() && now.Sub(last) < bs.cfg.ScanPeriod {
+			continue
+		}
+
+		due = append(due, scanCandidate{
+			blockID:     blockID,
+			size:        info.Size(),
+			modTime:     info.ModTime(),
+			lastScanned: last,
+		})
+	}
+
+	for blockID := range bs.cursor.LastScanned {
+		if _, ok := onDisk[blockID]; !ok {
+			delete(bs.cursor.LastScanned, blockID)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		pi, pj := scanPriority(due[i]), scanPriority(due[j])
+		if pi != pj {
+			return pi < pj
+		}
+		if pi < 2 {
+			return due[i].modTime.After(due[j].modTime)
+		}
+		return due[i].lastScanned.Before(due[j].lastScanned)
+	})
+
+	total := len(onDisk)
+	metrics.BlocksStoredTotal.WithLabelValues(bs.worker.ID).Set(float64(total))
+	metrics.BlockScannerPendingBlocks.WithLabelValues(bs.worker.ID).Set(float64(len(due)))
+	progress := 1.0
+	if total > 0 {
+		progress = float64(total-len(due)) / float64(total)
+	}
+	metrics.BlockScannerProgressRatio.WithLabelValues(bs.worker.ID).Set(progress)
+
+	return due, nil
+}
+
+func scanPriority(c scanCandidate) int {
+	switch {
+	case c.lastScanned.IsZero():
+		return 0
+	case c.modTime.After(c.lastScanned):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (bs *BlockScanner) scanBlock(ctx context.Context, c scanCandidate) {
+	// Scans share the background I/O budget with transfers; a block that
+	// gets no slot stays due for the next pass.
+	release, err := bs.worker.QoS.Admit(ctx, IOClassBackground)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	err = bs.worker.verifyBlockIntegrityThrottled(contextWithIOClass(ctx, IOClassBackground), c.blockID, bs.limiter)
+	if ctx.Err() != nil {
+		return
+	}
+
+	metrics.BlockScannerBytesScannedTotal.Add(float64(c.size))
+
+	bs.mu.Lock()
+	bs.cursor.LastScanned[c.blockID] = time.Now().Unix()
+	switch {
+	case err == nil:
+		metrics.BlockScannerBlocksScannedTotal.WithLabelValues("valid").Inc()
+	case errors.Is(err, ErrChecksumMismatch):
+		metrics.BlockScannerBlocksScannedTotal.WithLabelValues("corrupted").Inc()
+	default:
+		metrics.BlockScannerBlocksScannedTotal.WithLabelValues("error").Inc()
+	}
+	saveDue := time.Since(bs.lastSave) > scannerCursorSaveInterval
+	bs.mu.Unlock()
+
+	metrics.CorruptedBlocksCurrent.WithLabelValues(bs.worker.ID).Set(float64(len(bs.worker.CorruptBlocks())))
+
+	if errors.Is(err, ErrChecksumMismatch) {
+		log.Printf("❌ CORRUPTION DETECTED: Block %s failed integrity check: %v", c.blockID, err)
+		bs.handleCorruptedBlock(c.blockID)
+	} else if err != nil {
+		log.Printf("⚠️ Block scanner could not verify block %s: %v", c.blockID, err)
+	}
+
+	if saveDue {
+		if err := bs.saveCursor(); err != nil {
+			log.Printf("Block scanner: failed to save cursor: %v", err)
+		}
+	}
+}
+
+func (bs *BlockScanner) completePass(passStart time.Time) {
+	metrics.IntegrityCheckDuration.Observe(time.Since(passStart).Seconds())
+	metrics.LastIntegrityCheckTimestamp.SetToCurrentTime()
+	metrics.IntegrityChecksTotal.WithLabelValues("completed").Inc()
+
+	if err := bs.saveCursor(); err != nil {
+		log.Printf("Block scanner: failed to save cursor: %v", err)
+	}
+
+	if corrupt := len(bs.worker.CorruptBlocks()); corrupt > 0 {
+		log.Printf("⚠️ Block scanner pass complete in %v: %d CORRUPTED blocks", time.Since(passStart), corrupt)
+	} else {
+		log.Printf("✅ Block scanner pass complete in %v, all healthy", time.Since(passStart))
+	}
+}
+
+func (bs *BlockScanner) handleCorruptedBlock(blockID string) {
+	log.Printf("🚨 Handling corrupted block %s - marking for replication", blockID)
+}
+
+func (bs *BlockScanner) loadCursor() error {
+	data, err := os.ReadFile(bs.cfg.CursorPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var cursor scannerCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return err
+	}
+	if cursor.LastScanned == nil {
+		cursor.LastScanned = make(map[string]int64)
+	}
+
+	bs.mu.Lock()
+	bs.cursor = cursor
+	bs.mu.Unlock()
+	return nil
+}
+
+func (bs *BlockScanner) saveCursor() error {
+	bs.mu.Lock()
+	bs.cursor.UpdatedAt = time.Now().Unix()
+	data, err := json.Marshal(bs.cursor)
+	bs.lastSave = time.Now()
+	bs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := bs.cfg.CursorPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, bs.cfg.CursorPath)
+}
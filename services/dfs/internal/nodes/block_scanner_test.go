@@ -0,0 +1,116 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestBlock(t *testing.T, dir, blockID string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	blockPath := filepath.Join(dir, fmt.Sprintf("%s.bin", blockID))
+	require.NoError(t, os.WriteFile(blockPath, data, 0644))
+
+	checksumPath := filepath.Join(dir, fmt.Sprintf("%s.checksum", blockID))
+	require.NoError(t, os.WriteFile(checksumPath, []byte(fmt.Sprintf("%d", crc32.ChecksumIEEE(data))), 0644))
+
+	require.NoError(t, os.Chtimes(blockPath, modTime, modTime))
+}
+
+func TestBlockScanner_PendingBlocksPriority(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+	now := time.Now()
+
+	writeTestBlock(t, tmpDir, "old-verified", []byte("a"), now.Add(-72*time.Hour))
+	writeTestBlock(t, tmpDir, "older-verified", []byte("b"), now.Add(-72*time.Hour))
+	writeTestBlock(t, tmpDir, "fresh-verified", []byte("c"), now.Add(-72*time.Hour))
+	writeTestBlock(t, tmpDir, "never-old", []byte("d"), now.Add(-48*time.Hour))
+	writeTestBlock(t, tmpDir, "never-new", []byte("e"), now.Add(-time.Minute))
+
+	scanner := NewBlockScanner(worker, BlockScannerConfig{ScanPeriod: 24 * time.Hour})
+	scanner.cursor.LastScanned["old-verified"] = now.Add(-30 * time.Hour).Unix()
+	scanner.cursor.LastScanned["older-verified"] = now.Add(-40 * time.Hour).Unix()
+	scanner.cursor.LastScanned["fresh-verified"] = now.Add(-time.Hour).Unix()
+	scanner.cursor.LastScanned["deleted-block"] = now.Add(-time.Hour).Unix()
+
+	queue, err := scanner.pendingBlocks(now)
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(queue))
+	for _, c := range queue {
+		ids = append(ids, c.blockID)
+	}
+
+	t.Run("never verified blocks come first, newest first", func(t *testing.T) {
+		assert.Equal(t, []string{"never-new", "never-old", "older-verified", "old-verified"}, ids)
+	})
+
+	t.Run("blocks verified within the period are skipped", func(t *testing.T) {
+		assert.NotContains(t, ids, "fresh-verified")
+	})
+
+	t.Run("cursor entries for deleted blocks are pruned", func(t *testing.T) {
+		assert.NotContains(t, scanner.cursor.LastScanned, "deleted-block")
+	})
+}
+
+func TestBlockScanner_CursorPersistence(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+
+	writeTestBlock(t, tmpDir, "block-1", []byte("first block"), time.Now())
+	writeTestBlock(t, tmpDir, "block-2", []byte("second block"), time.Now())
+
+	scanner := NewBlockScanner(worker, BlockScannerConfig{ScanPeriod: time.Hour})
+	queue, err := scanner.pendingBlocks(time.Now())
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+
+	scanner.scanBlock(context.Background(), queue[0])
+	require.NoError(t, scanner.saveCursor())
+
+	restarted := NewBlockScanner(worker, BlockScannerConfig{ScanPeriod: time.Hour})
+	queue, err = restarted.pendingBlocks(time.Now())
+	require.NoError(t, err)
+	require.Len(t, queue, 1, "already verified block should not be rescanned after restart")
+}
+
+func TestBlockScanner_DetectsCorruption(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+
+	writeTestBlock(t, tmpDir, "corrupt-block", []byte("original"), time.Now())
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "corrupt-block.bin"), []byte("tampered"), 0644))
+
+	scanner := NewBlockScanner(worker, BlockScannerConfig{ScanPeriod: time.Hour})
+	queue, err := scanner.pendingBlocks(time.Now())
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+
+	scanner.scanBlock(context.Background(), queue[0])
+	assert.Equal(t, []string{"corrupt-block"}, worker.CorruptBlocks())
+}
+
+func TestBlockScanner_Throttle(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+
+	data := make([]byte, 160*1024)
+	writeTestBlock(t, tmpDir, "big-block", data, time.Now())
+
+	// 64KiB/s with a 64KiB burst: reading 160KiB takes about 1.5 seconds.
+	limiter := newByteLimiter(64 * 1024)
+	start := time.Now()
+	err := worker.verifyBlockIntegrityThrottled(context.Background(), "big-block", limiter)
+	require.NoError(t, err)
+	assert.Greater(t, time.Since(start), time.Second)
+}
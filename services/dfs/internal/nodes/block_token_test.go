@@ -0,0 +1,149 @@
+package nodes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testKeyRing(t *testing.T, lifetime time.Duration) *blocktoken.KeyRing {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "block-token-keys")
+	require.NoError(t, os.WriteFile(path, []byte("k1 MDEyMzQ1Njc4OWFiY2RlZg==\n"), 0o600))
+	keys, err := blocktoken.Load(path, lifetime)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestWorkerNode_BlockTokens(t *testing.T) {
+	keys := testKeyRing(t, time.Hour)
+	worker := NewWorkerNode(t.TempDir(), 50051)
+	worker.BlockTokens = keys
+
+	push := func(token string) error {
+		return worker.PushBlock(&mockPushBlockServer{
+			requests: []*datanodev1.PushBlockRequest{
+				{Data: &datanodev1.PushBlockRequest_Metadata{Metadata: &datanodev1.BlockMetadata{BlockId: "blk-1", BlockToken: token}}},
+				{Data: &datanodev1.PushBlockRequest_Chunk{Chunk: []byte("data")}},
+			},
+		})
+	}
+	issue := func(blockID string, ops ...blocktoken.Op) string {
+		token, err := keys.Issue(blockID, "proj", ops...)
+		require.NoError(t, err)
+		return token
+	}
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(push("")), "no token")
+	assert.Equal(t, codes.PermissionDenied, status.Code(push(issue("blk-1", blocktoken.OpRead))), "read token")
+	assert.Equal(t, codes.PermissionDenied, status.Code(push(issue("blk-2", blocktoken.OpWrite))), "other block")
+	require.NoError(t, push(issue("blk-1", blocktoken.OpWrite)))
+
+	_, err := worker.GetBlockChecksum(context.Background(), &datanodev1.GetBlockChecksumRequest{BlockId: "blk-1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	resp, err := worker.GetBlockChecksum(context.Background(), &datanodev1.GetBlockChecksumRequest{
+		BlockId:    "blk-1",
+		BlockToken: issue("blk-1", blocktoken.OpRead),
+	})
+	require.NoError(t, err)
+	assert.True(t, resp.Exists)
+
+	_, err = worker.DeleteBlock(context.Background(), &datanodev1.DeleteBlockRequest{
+		BlockId:    "blk-1",
+		BlockToken: issue("blk-1", blocktoken.OpRead, blocktoken.OpWrite),
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = worker.DeleteBlock(context.Background(), &datanodev1.DeleteBlockRequest{
+		BlockId:    "blk-1",
+		BlockToken: issue("blk-1", blocktoken.OpDelete),
+	})
+	require.NoError(t, err)
+
+	worker.BlockTokens = testKeyRing(t, -time.Second)
+	expired, err := worker.BlockTokens.Issue("blk-1", "proj", blocktoken.OpWrite)
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(push(expired)), "expired tokens ask for renewal")
+}
+
+func TestWorkerNode_BlockTokenProject(t *testing.T) {
+	keys := testKeyRing(t, time.Hour)
+	worker := NewWorkerNode(t.TempDir(), 50051)
+	worker.BlockTokens = keys
+
+	issue := func(blockID, project string, ops ...blocktoken.Op) string {
+		token, err := keys.Issue(blockID, project, ops...)
+		require.NoError(t, err)
+		return token
+	}
+	push := func(blockID, token, project string) error {
+		return worker.PushBlock(&mockPushBlockServer{
+			requests: []*datanodev1.PushBlockRequest{
+				{Data: &datanodev1.PushBlockRequest_Metadata{Metadata: &datanodev1.BlockMetadata{BlockId: blockID, BlockToken: token, ProjectId: project}}},
+				{Data: &datanodev1.PushBlockRequest_Chunk{Chunk: []byte("data")}},
+			},
+		})
+	}
+	checksum := func(blockID, token string) error {
+		_, err := worker.GetBlockChecksum(context.Background(), &datanodev1.GetBlockChecksumRequest{BlockId: blockID, BlockToken: token})
+		return err
+	}
+
+	require.NoError(t, push("blk-1", issue("blk-1", "proj", blocktoken.OpWrite), "other"))
+	assert.Equal(t, "proj", StoredBlockProject(worker.StorageDir, "blk-1"), "the token's project wins over the metadata")
+
+	require.NoError(t, checksum("blk-1", issue("blk-1", "proj", blocktoken.OpRead)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(checksum("blk-1", issue("blk-1", "other", blocktoken.OpRead))), "token of another project")
+	require.NoError(t, checksum("blk-1", issue("blk-1", "", blocktoken.OpRead)), "the master's own tokens")
+	assert.Equal(t, codes.PermissionDenied, status.Code(push("blk-1", issue("blk-1", "other", blocktoken.OpWrite), "")), "overwrite from another project")
+
+	// Transfers carry the project with the master's token.
+	require.NoError(t, push("blk-2", issue("blk-2", "", blocktoken.OpRead, blocktoken.OpWrite), "proj"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(checksum("blk-2", issue("blk-2", "other", blocktoken.OpRead))))
+
+	_, err := worker.DeleteBlock(context.Background(), &datanodev1.DeleteBlockRequest{BlockId: "blk-1", BlockToken: issue("blk-1", "", blocktoken.OpDelete)})
+	require.NoError(t, err)
+	assert.NoFileExists(t, blockProjectPath(worker.StorageDir, "blk-1"))
+}
+
+func TestMasterNode_IssuesBlockTokens(t *testing.T) {
+	master := setupTestMaster(t)
+	tieredWorkers(master)
+	master.BlockTokens = testKeyRing(t, time.Hour)
+
+	alloc, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{ProjectId: "proj", FilePath: "proj/a.bin"})
+	require.NoError(t, err)
+	claims, err := master.BlockTokens.Verify(alloc.BlockToken, alloc.BlockId, blocktoken.OpWrite)
+	require.NoError(t, err)
+	assert.Equal(t, "proj", claims.ProjectID)
+	_, err = master.BlockTokens.Verify(alloc.BlockToken, alloc.BlockId, blocktoken.OpRead)
+	assert.ErrorIs(t, err, blocktoken.ErrInvalid, "allocation tokens only allow writes")
+
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+		ProjectId: "proj",
+		FilePath:  "proj/a.bin",
+		Blocks:    []*commonv1.BlockInfo{{BlockId: alloc.BlockId, Size: 4}},
+	})
+	require.NoError(t, err)
+
+	meta, err := master.GetFileMetadata("proj", "proj/a.bin", "")
+	require.NoError(t, err)
+	require.Contains(t, meta.BlockTokens, alloc.BlockId)
+	_, err = master.BlockTokens.Verify(meta.BlockTokens[alloc.BlockId], alloc.BlockId, blocktoken.OpRead)
+	assert.NoError(t, err)
+
+	master.BlockTokens = nil
+	meta, err = master.GetFileMetadata("proj", "proj/a.bin", "")
+	require.NoError(t, err)
+	assert.Empty(t, meta.BlockTokens, "no tokens when they are off")
+}
@@ -2,6 +2,8 @@ package nodes
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -18,6 +20,7 @@ type mockPushBlockServer struct {
 	datanodev1.DataNodeService_PushBlockServer
 	requests []*datanodev1.PushBlockRequest
 	response *datanodev1.PushBlockResponse
+	ctx      context.Context
 }
 
 func (m *mockPushBlockServer) Recv() (*datanodev1.PushBlockRequest, error) {
@@ -34,6 +37,13 @@ func (m *mockPushBlockServer) SendAndClose(resp *datanodev1.PushBlockResponse) e
 	return nil
 }
 
+func (m *mockPushBlockServer) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
 func TestChecksumCalculationOnWrite(t *testing.T) {
 	tmpDir := t.TempDir()
 	worker := NewWorkerNode(tmpDir, 50051)
@@ -64,6 +74,7 @@ func TestChecksumCalculationOnWrite(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, mockServer.response)
 	assert.True(t, mockServer.response.Success)
+	assert.Equal(t, expectedChecksum, mockServer.response.Checksum, "Response should report the stored checksum")
 
 	storedChecksum, err := worker.getStoredChecksum(blockID)
 	require.NoError(t, err)
@@ -74,6 +85,12 @@ func TestChecksumCalculationOnWrite(t *testing.T) {
 
 	checksumFilePath := filepath.Join(tmpDir, fmt.Sprintf("%s.checksum", blockID))
 	assert.FileExists(t, checksumFilePath)
+
+	sum := sha256.Sum256(testData)
+	assert.Equal(t, hex.EncodeToString(sum[:]), mockServer.response.ContentHash, "Response should report the content hash")
+	resp, err := worker.GetBlockChecksum(context.Background(), &datanodev1.GetBlockChecksumRequest{BlockId: blockID})
+	require.NoError(t, err)
+	assert.Equal(t, mockServer.response.ContentHash, resp.ContentHash)
 }
 
 func TestChecksumVerificationOnRead(t *testing.T) {
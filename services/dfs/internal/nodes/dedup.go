@@ -0,0 +1,313 @@
+package nodes
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/razvanmarinn/dfs/internal/metrics"
+)
+
+// DedupHoldTimeout is how long a dedup hit keeps its block referenced for
+// the file being written before the reference is dropped.
+const DedupHoldTimeout = 30 * time.Minute
+
+// dedupKey identifies the content of a dedup block. Blocks are only shared
+// within a project, so knowing a hash never grants access to another
+// project's data.
+type dedupKey struct {
+	projectID string
+	hash      string
+}
+
+// BlockRefsPayload is the reference changes of dedup blocks an operation
+// makes, logged in its entry so standbys count them with it. Standbys only
+// learn of blocks from these and from block reports, so they carry what
+// standbys need to count and index the blocks. On its own it is the payload
+// of OpRefBlocks, for the provisional references of dedup hits.
+type BlockRefsPayload struct {
+	Blocks []BlockRef `json:"blocks"`
+}
+
+// BlockRef is the state of a dedup block after an operation. A RefCount of 0
+// means the block was forgotten.
+type BlockRef struct {
+	BlockID     uuid.UUID `json:"blockId"`
+	ProjectID   string    `json:"projectId"`
+	ContentHash string    `json:"contentHash"`
+	Size        int64     `json:"size"`
+	Checksum    uint32    `json:"checksum"`
+	RefCount    int       `json:"refCount"`
+	Holds       []int64   `json:"holds,omitempty"`
+}
+
+// refChanges collects the state dedup blocks are to have after an
+// operation, so it can be logged with the operation and applied once the
+// entry is written.
+type refChanges map[uuid.UUID]*BlockRef
+
+// validContentHash reports whether h is a hex-encoded SHA-256.
+func validContentHash(h string) bool {
+	b, err := hex.DecodeString(h)
+	return err == nil && len(b) == 32
+}
+
+// dedupIndexLocked returns the committed dedup blocks by content, building
+// the index from the block map on first use. mn.lock must be held.
+func (mn *MasterNode) dedupIndexLocked() map[dedupKey]uuid.UUID {
+	if mn.dedupIndex == nil {
+		mn.dedupIndex = make(map[dedupKey]uuid.UUID)
+		for blockID, meta := range mn.BlockMap {
+			if meta.ContentHash != "" && meta.RefCount > 0 {
+				mn.dedupIndex[dedupKey{meta.ProjectID, meta.ContentHash}] = blockID
+			}
+		}
+	}
+	return mn.dedupIndex
+}
+
+// allocateDedupLocked answers an AllocateBlock for content the project
+// already stores, or returns nil if the block has to be written. The hit
+// holds a provisional reference to the block until the file is committed,
+// the block is abandoned or DedupHoldTimeout passes, so the block outlives
+// files deleted meanwhile. mn.lock must be held.
+func (mn *MasterNode) allocateDedupLocked(req *coordinatorv1.AllocateBlockRequest) *coordinatorv1.AllocateBlockResponse {
+	blockID, ok := mn.dedupIndexLocked()[dedupKey{req.ProjectId, req.ContentHash}]
+	if !ok {
+		return nil
+	}
+	meta, ok := mn.BlockMap[blockID]
+	if !ok || meta.Size != req.SizeBytes {
+		return nil
+	}
+	locations := mn.orderReplicasLocked(meta, "")
+	if len(locations) == 0 {
+		return nil
+	}
+
+	changes := make(refChanges)
+	ref := mn.refStateLocked(changes, blockID)
+	ref.RefCount++
+	ref.Holds = append(ref.Holds, time.Now().Add(DedupHoldTimeout).UnixNano())
+	if err := mn.logRefChangesLocked(changes); err != nil {
+		log.Printf("Error logging the dedup hit on %s for %s: %v", blockID, req.FilePath, err)
+		return nil
+	}
+
+	metrics.MasterDedupHitsTotal.Inc()
+	log.Printf("Deduplicated block for %s to %s (%d references)", req.FilePath, blockID, meta.RefCount)
+	return &coordinatorv1.AllocateBlockResponse{
+		BlockId:         blockID.String(),
+		TargetDatanodes: locations,
+		Deduplicated:    true,
+	}
+}
+
+// checkDedupBlockLocked verifies that a block committed with a content hash
+// may be referenced by a file of projectID. mn.lock must be held.
+func (mn *MasterNode) checkDedupBlockLocked(blockID uuid.UUID, projectID string, b *commonv1.BlockInfo) error {
+	if !validContentHash(b.ContentHash) {
+		return fmt.Errorf("block %s: invalid content hash %q", blockID, b.ContentHash)
+	}
+	meta, ok := mn.BlockMap[blockID]
+	if !ok {
+		return fmt.Errorf("dedup block %s: %w", blockID, ErrNotFound)
+	}
+	if meta.ContentHash == "" {
+		// Freshly written; the commit records its hash.
+		return nil
+	}
+	if meta.ContentHash != b.ContentHash || meta.ProjectID != projectID ||
+		meta.Size != b.Size || meta.Checksum != uint32(b.Checksum) {
+		return fmt.Errorf("block %s does not hold content %s of project %s", blockID, b.ContentHash, projectID)
+	}
+	return nil
+}
+
+// refStateLocked returns the state blockID is to have after the operation
+// collecting changes, starting from the block map, or nil if the block is
+// unknown. mn.lock must be held.
+func (mn *MasterNode) refStateLocked(changes refChanges, blockID uuid.UUID) *BlockRef {
+	if ref, ok := changes[blockID]; ok {
+		return ref
+	}
+	meta, ok := mn.BlockMap[blockID]
+	if !ok {
+		return nil
+	}
+	ref := &BlockRef{
+		BlockID:     blockID,
+		ProjectID:   meta.ProjectID,
+		ContentHash: meta.ContentHash,
+		Size:        meta.Size,
+		Checksum:    meta.Checksum,
+		RefCount:    meta.RefCount,
+		Holds:       slices.Clone(meta.Holds),
+	}
+	changes[blockID] = ref
+	return ref
+}
+
+// addRefLocked records one more file referencing a dedup block, which makes
+// freshly written content available to later allocations. The file takes
+// over the provisional reference of a dedup hit if there is one.
+// mn.lock must be held.
+func (mn *MasterNode) addRefLocked(changes refChanges, blockID uuid.UUID, projectID, hash string) {
+	ref := mn.refStateLocked(changes, blockID)
+	if ref == nil {
+		return
+	}
+	if ref.ContentHash == "" {
+		ref.ContentHash, ref.ProjectID = hash, projectID
+	}
+	if len(ref.Holds) > 0 {
+		ref.Holds = ref.Holds[1:]
+		return
+	}
+	ref.RefCount++
+}
+
+// dropRefLocked records one file fewer referencing blockID and reports
+// whether it is a dedup block; other blocks are left to the caller.
+// mn.lock must be held.
+func (mn *MasterNode) dropRefLocked(changes refChanges, blockID uuid.UUID) bool {
+	ref := mn.refStateLocked(changes, blockID)
+	if ref == nil || ref.ContentHash == "" {
+		return false
+	}
+	ref.RefCount--
+	return true
+}
+
+// payload returns the changed dedup blocks in block ID order, nil when there
+// are none.
+func (changes refChanges) payload() *BlockRefsPayload {
+	var payload BlockRefsPayload
+	for _, ref := range changes {
+		if ref.ContentHash == "" {
+			continue
+		}
+		ref.RefCount = max(ref.RefCount, 0)
+		payload.Blocks = append(payload.Blocks, *ref)
+	}
+	if len(payload.Blocks) == 0 {
+		return nil
+	}
+	slices.SortFunc(payload.Blocks, func(a, b BlockRef) int { return slices.Compare(a.BlockID[:], b.BlockID[:]) })
+	return &payload
+}
+
+// logRefChangesLocked logs changes that are not part of a namespace
+// operation as an OpRefBlocks and applies them. Blocks whose last reference
+// went are deleted after BlockDeleteDelay. mn.lock must be held.
+func (mn *MasterNode) logRefChangesLocked(changes refChanges) error {
+	payload := changes.payload()
+	if payload == nil {
+		return nil
+	}
+	op := OperationLogEntry{
+		OpType:    OpRefBlocks,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	if err := mn.appendToLog(op); err != nil {
+		return err
+	}
+	for _, meta := range mn.applyBlockRefsLocked(payload) {
+		mn.deleteLaterLocked(meta)
+	}
+	return nil
+}
+
+// applyBlockRefsLocked brings the dedup blocks of p to their logged state,
+// adding the ones this master has not seen yet. It returns the blocks whose
+// last reference went, which it forgets. p may be nil. mn.lock must be held.
+func (mn *MasterNode) applyBlockRefsLocked(p *BlockRefsPayload) []*BlockMetadata {
+	if p == nil {
+		return nil
+	}
+	var forgotten []*BlockMetadata
+	for _, ref := range p.Blocks {
+		key := dedupKey{ref.ProjectID, ref.ContentHash}
+		if ref.RefCount <= 0 {
+			if meta, ok := mn.BlockMap[ref.BlockID]; ok {
+				delete(mn.BlockMap, ref.BlockID)
+				forgotten = append(forgotten, meta)
+			}
+			if mn.dedupIndex != nil && mn.dedupIndex[key] == ref.BlockID {
+				delete(mn.dedupIndex, key)
+			}
+			continue
+		}
+
+		meta, ok := mn.BlockMap[ref.BlockID]
+		if !ok {
+			meta = &BlockMetadata{BlockID: ref.BlockID, Replicas: make([]uuid.UUID, 0)}
+			mn.BlockMap[ref.BlockID] = meta
+		}
+		meta.Size, meta.Checksum = ref.Size, ref.Checksum
+		meta.ContentHash, meta.ProjectID, meta.RefCount = ref.ContentHash, ref.ProjectID, ref.RefCount
+		meta.Holds = ref.Holds
+
+		if _, ok := mn.dedupIndexLocked()[key]; !ok {
+			mn.dedupIndex[key] = ref.BlockID
+		}
+	}
+	return forgotten
+}
+
+// releaseHoldLocked drops the oldest provisional reference to blockID, for a
+// dedup hit whose write was abandoned. mn.lock must be held.
+func (mn *MasterNode) releaseHoldLocked(blockID uuid.UUID) error {
+	changes := make(refChanges)
+	ref := mn.refStateLocked(changes, blockID)
+	if ref == nil || len(ref.Holds) == 0 {
+		return nil
+	}
+	ref.Holds = ref.Holds[1:]
+	ref.RefCount--
+	if err := mn.logRefChangesLocked(changes); err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	log.Printf("Released a dedup hold on block %s (%d references)", blockID, ref.RefCount)
+	return nil
+}
+
+// ExpireDedupHolds drops the provisional references of dedup hits whose
+// files were not committed within DedupHoldTimeout of now, and returns how
+// many it dropped.
+func (mn *MasterNode) ExpireDedupHolds(now time.Time) int {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if mn.checkWritableLocked() != nil {
+		return 0
+	}
+	changes := make(refChanges)
+	expired := 0
+	for _, blockID := range mn.dedupIndexLocked() {
+		meta, ok := mn.BlockMap[blockID]
+		if !ok || len(meta.Holds) == 0 || meta.Holds[0] > now.UnixNano() {
+			continue
+		}
+		ref := mn.refStateLocked(changes, blockID)
+		for len(ref.Holds) > 0 && ref.Holds[0] <= now.UnixNano() {
+			ref.Holds = ref.Holds[1:]
+			ref.RefCount--
+			expired++
+		}
+	}
+	if err := mn.logRefChangesLocked(changes); err != nil {
+		log.Printf("Error logging %d expired dedup holds: %v", expired, err)
+		return 0
+	}
+	if expired > 0 {
+		log.Printf("Dropped %d dedup holds of uncommitted files", expired)
+	}
+	return expired
+}
@@ -0,0 +1,337 @@
+package nodes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contentHash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func dedupBlockInfo(blockID, data string) *commonv1.BlockInfo {
+	return &commonv1.BlockInfo{
+		BlockId:     blockID,
+		Size:        int64(len(data)),
+		Checksum:    int64(crc32.ChecksumIEEE([]byte(data))),
+		ContentHash: contentHash(data),
+	}
+}
+
+// writeDedupFile stores data as a single-block file at path the way clients
+// in dedup mode do, and returns the allocation it got.
+func writeDedupFile(t *testing.T, master *MasterNode, store *fakeBlockStore, path, data string, mode coordinatorv1.WriteMode) *coordinatorv1.AllocateBlockResponse {
+	t.Helper()
+	projectID := strings.SplitN(path, "/", 2)[0]
+
+	alloc, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{
+		ProjectId:   projectID,
+		SizeBytes:   int64(len(data)),
+		FilePath:    path,
+		ContentHash: contentHash(data),
+	})
+	require.NoError(t, err)
+	if !alloc.Deduplicated {
+		store.mu.Lock()
+		store.blocks[alloc.BlockId] = []byte(data)
+		store.mu.Unlock()
+	}
+
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+		ProjectId: projectID,
+		FilePath:  path,
+		Blocks:    []*commonv1.BlockInfo{dedupBlockInfo(alloc.BlockId, data)},
+		Mode:      mode,
+	})
+	require.NoError(t, err)
+	return alloc
+}
+
+func refCount(master *MasterNode, blockID string) int {
+	master.lock.RLock()
+	defer master.lock.RUnlock()
+	meta, ok := master.BlockMap[uuid.MustParse(blockID)]
+	if !ok {
+		return 0
+	}
+	return meta.RefCount
+}
+
+func TestMasterNode_DedupBlocks(t *testing.T) {
+	master, store := packingMaster(t)
+	data := strings.Repeat("same content ", 100)
+
+	first := writeDedupFile(t, master, store, "proj/a.avro", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	assert.False(t, first.Deduplicated)
+
+	second := writeDedupFile(t, master, store, "proj/b.avro", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	assert.True(t, second.Deduplicated, "second write is answered with the stored block")
+	assert.Equal(t, first.BlockId, second.BlockId)
+	assert.Empty(t, second.BlockToken)
+	require.NotEmpty(t, second.TargetDatanodes)
+	assert.Equal(t, 2, refCount(master, first.BlockId))
+
+	meta, err := master.GetFileMetadata("proj", "proj/b.avro", "")
+	require.NoError(t, err)
+	require.Len(t, meta.Blocks, 1)
+	assert.Equal(t, contentHash(data), meta.Blocks[0].ContentHash)
+
+	other := writeDedupFile(t, master, store, "other/c.avro", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	assert.False(t, other.Deduplicated, "blocks are not shared across projects")
+	assert.NotEqual(t, first.BlockId, other.BlockId)
+
+	require.NoError(t, master.DeleteFile(Superuser, "proj/a.avro", false))
+	assert.Equal(t, 1, refCount(master, first.BlockId))
+	_, stored := store.block(first.BlockId)
+	assert.True(t, stored, "a block still referenced is kept")
+
+	require.NoError(t, master.DeleteFile(Superuser, "proj/b.avro", false))
+	assert.Equal(t, 0, refCount(master, first.BlockId))
+	_, stored = store.block(first.BlockId)
+	assert.False(t, stored, "the block is deleted with its last reference")
+
+	again := writeDedupFile(t, master, store, "proj/d.avro", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	assert.False(t, again.Deduplicated)
+	assert.NotEqual(t, first.BlockId, again.BlockId)
+}
+
+func TestMasterNode_DedupOverwriteAndConcat(t *testing.T) {
+	master, store := packingMaster(t)
+	data := strings.Repeat("part ", 200)
+
+	first := writeDedupFile(t, master, store, "proj/part-1", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	writeDedupFile(t, master, store, "proj/part-1", data, coordinatorv1.WriteMode_WRITE_MODE_OVERWRITE)
+	assert.Equal(t, 1, refCount(master, first.BlockId), "overwriting a file with its own content keeps one reference")
+
+	writeDedupFile(t, master, store, "proj/part-2", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	assert.Equal(t, 2, refCount(master, first.BlockId))
+
+	require.NoError(t, master.ConcatFiles(Superuser, &coordinatorv1.ConcatFilesRequest{
+		ProjectId:   "proj",
+		TargetPath:  "proj/whole",
+		SourcePaths: []string{"proj/part-1", "proj/part-2"},
+	}))
+	assert.Equal(t, 2, refCount(master, first.BlockId), "the target takes over the sources' references")
+
+	require.NoError(t, master.DeleteFile(Superuser, "proj/whole", false))
+	assert.Equal(t, 0, refCount(master, first.BlockId))
+	_, stored := store.block(first.BlockId)
+	assert.False(t, stored)
+}
+
+func TestMasterNode_DedupRejects(t *testing.T) {
+	master, store := packingMaster(t)
+	data := strings.Repeat("x", 4096)
+	first := writeDedupFile(t, master, store, "proj/a", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+
+	t.Run("invalid hash", func(t *testing.T) {
+		_, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{ProjectId: "proj", SizeBytes: 1, ContentHash: "abc"})
+		assert.Error(t, err)
+	})
+
+	t.Run("size mismatch allocates", func(t *testing.T) {
+		alloc, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{
+			ProjectId:   "proj",
+			SizeBytes:   1,
+			ContentHash: contentHash(data),
+		})
+		require.NoError(t, err)
+		assert.False(t, alloc.Deduplicated)
+	})
+
+	t.Run("other content", func(t *testing.T) {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: "proj",
+			FilePath:  "proj/b",
+			Blocks:    []*commonv1.BlockInfo{dedupBlockInfo(first.BlockId, strings.Repeat("y", 4096))},
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, refCount(master, first.BlockId))
+	})
+
+	t.Run("other project", func(t *testing.T) {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: "other",
+			FilePath:  "other/b",
+			Blocks:    []*commonv1.BlockInfo{dedupBlockInfo(first.BlockId, data)},
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, refCount(master, first.BlockId))
+	})
+
+	t.Run("content damaged on the worker", func(t *testing.T) {
+		alloc, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{
+			ProjectId:   "proj",
+			SizeBytes:   8,
+			ContentHash: contentHash("expected"),
+		})
+		require.NoError(t, err)
+		store.mu.Lock()
+		store.blocks[alloc.BlockId] = []byte("damaged!")
+		store.mu.Unlock()
+
+		info := dedupBlockInfo(alloc.BlockId, "expected")
+		info.Checksum = int64(crc32.ChecksumIEEE([]byte("damaged!")))
+		_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: "proj",
+			FilePath:  "proj/c",
+			Blocks:    []*commonv1.BlockInfo{info},
+		})
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+}
+
+func TestMasterNode_PackSkipsSharedDedupBlocks(t *testing.T) {
+	master, store := packingMaster(t)
+	data := strings.Repeat("shared ", 50)
+
+	shared := writeDedupFile(t, master, store, "proj/a", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	writeDedupFile(t, master, store, "proj/b", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+
+	assert.Equal(t, 0, master.PackSmallFiles())
+	assert.Equal(t, 2, refCount(master, shared.BlockId))
+}
+
+func TestMasterNode_ReplicatedDedupRefs(t *testing.T) {
+	leader, store := packingMaster(t)
+	shared := strings.Repeat("shared ", 100)
+
+	first := writeDedupFile(t, leader, store, "proj/a", shared, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	writeDedupFile(t, leader, store, "proj/b", shared, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	writeDedupFile(t, leader, store, "proj/c", shared, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	gone := writeDedupFile(t, leader, store, "proj/d", "only once", coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	require.NoError(t, leader.DeleteFile(Superuser, "proj/c", false))
+	require.NoError(t, leader.DeleteFile(Superuser, "proj/d", false))
+	require.NoError(t, leader.ConcatFiles(Superuser, &coordinatorv1.ConcatFilesRequest{
+		ProjectId:   "proj",
+		TargetPath:  "proj/ab",
+		SourcePaths: []string{"proj/a", "proj/b"},
+	}))
+	require.Equal(t, 2, refCount(leader, first.BlockId))
+
+	standby, _ := packingMaster(t)
+	replayLog(t, leader, standby)
+
+	assert.Equal(t, 2, refCount(standby, first.BlockId), "standbys count the references the leader logged")
+	_, known := standby.BlockMap[uuid.MustParse(gone.BlockId)]
+	assert.False(t, known, "blocks whose last reference went are forgotten")
+	meta := standby.BlockMap[uuid.MustParse(first.BlockId)]
+	require.NotNil(t, meta)
+	assert.Equal(t, contentHash(shared), meta.ContentHash)
+	assert.Equal(t, "proj", meta.ProjectID)
+
+	// After a failover the new leader learns the replicas from block reports
+	// and keeps deduplicating against and counting the same block.
+	standby.LoadBalancer = leader.LoadBalancer
+	for workerID := range standby.LoadBalancer.Workers() {
+		standby.ProcessBlockReport(workerID, []string{first.BlockId})
+	}
+	again := writeDedupFile(t, standby, store, "proj/e", shared, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	assert.True(t, again.Deduplicated)
+	assert.Equal(t, first.BlockId, again.BlockId)
+	assert.Equal(t, 3, refCount(standby, first.BlockId))
+
+	require.NoError(t, standby.DeleteFile(Superuser, "proj/ab", false))
+	require.NoError(t, standby.DeleteFile(Superuser, "proj/e", false))
+	assert.Equal(t, 0, refCount(standby, first.BlockId))
+	_, stored := store.block(first.BlockId)
+	assert.False(t, stored, "the block is deleted with its last reference")
+}
+
+// loggedOps returns the types of the operations in the op log of master.
+func loggedOps(t *testing.T, master *MasterNode) []OpType {
+	t.Helper()
+	data, err := os.ReadFile(master.opLogFile.Name())
+	require.NoError(t, err)
+	var ops []OpType
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry opLogRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		ops = append(ops, entry.OpType)
+	}
+	return ops
+}
+
+func TestMasterNode_DedupRefsInTheirOperation(t *testing.T) {
+	master, store := packingMaster(t)
+	data := strings.Repeat("logged ", 100)
+
+	writeDedupFile(t, master, store, "proj/a", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	writeDedupFile(t, master, store, "proj/a", data+"!", coordinatorv1.WriteMode_WRITE_MODE_OVERWRITE)
+	require.NoError(t, master.DeleteFile(Superuser, "proj/a", false))
+
+	assert.NotContains(t, loggedOps(t, master), OpRefBlocks, "commits and deletes log their references themselves")
+}
+
+func TestMasterNode_DedupHolds(t *testing.T) {
+	master, store := packingMaster(t)
+	data := strings.Repeat("held ", 100)
+	first := writeDedupFile(t, master, store, "proj/a", data, coordinatorv1.WriteMode_WRITE_MODE_CREATE)
+	hit := func() *coordinatorv1.AllocateBlockResponse {
+		alloc, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{
+			ProjectId:   "proj",
+			SizeBytes:   int64(len(data)),
+			FilePath:    "proj/b",
+			ContentHash: contentHash(data),
+		})
+		require.NoError(t, err)
+		require.True(t, alloc.Deduplicated)
+		return alloc
+	}
+
+	t.Run("a hit keeps the block past the deletion of its files", func(t *testing.T) {
+		hit()
+		assert.Equal(t, 2, refCount(master, first.BlockId))
+		require.NoError(t, master.DeleteFile(Superuser, "proj/a", false))
+		assert.Equal(t, 1, refCount(master, first.BlockId))
+		_, stored := store.block(first.BlockId)
+		assert.True(t, stored)
+
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: "proj",
+			FilePath:  "proj/b",
+			Blocks:    []*commonv1.BlockInfo{dedupBlockInfo(first.BlockId, data)},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, refCount(master, first.BlockId), "the file takes the hold over")
+		assert.Empty(t, master.BlockMap[uuid.MustParse(first.BlockId)].Holds)
+	})
+
+	t.Run("abandoning a hit drops its reference", func(t *testing.T) {
+		hit()
+		assert.Equal(t, 2, refCount(master, first.BlockId))
+		require.NoError(t, master.AbandonBlock(uuid.MustParse(first.BlockId)))
+		assert.Equal(t, 1, refCount(master, first.BlockId))
+		assert.Empty(t, master.BlockMap[uuid.MustParse(first.BlockId)].Holds)
+	})
+
+	t.Run("holds expire", func(t *testing.T) {
+		hit()
+		assert.Equal(t, 0, master.ExpireDedupHolds(time.Now()))
+		assert.Equal(t, 2, refCount(master, first.BlockId))
+
+		standby := setupTestMaster(t)
+		replayLog(t, master, standby)
+		assert.Equal(t, 2, refCount(standby, first.BlockId), "standbys count holds")
+
+		require.NoError(t, master.DeleteFile(Superuser, "proj/b", false))
+		assert.Equal(t, 1, master.ExpireDedupHolds(time.Now().Add(DedupHoldTimeout)))
+		assert.Equal(t, 0, refCount(master, first.BlockId))
+		assert.Equal(t, 1, master.DeleteReleasedBlocks(time.Now().Add(BlockDeleteDelay)))
+		_, stored := store.block(first.BlockId)
+		assert.False(t, stored, "the block goes with its last reference")
+	})
+}
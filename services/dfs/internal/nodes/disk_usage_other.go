@@ -0,0 +1,9 @@
+//go:build !unix
+
+package nodes
+
+import "errors"
+
+func diskUsage(path string) (capacity int64, remaining int64, err error) {
+	return 0, 0, errors.New("disk usage not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package nodes
+
+import "syscall"
+
+// diskUsage reports the total and available bytes of the filesystem holding path.
+func diskUsage(path string) (capacity int64, remaining int64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	blockSize := int64(stat.Bsize)
+	return int64(stat.Blocks) * blockSize, int64(stat.Bavail) * blockSize, nil
+}
@@ -0,0 +1,247 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+)
+
+// FileChecksumAlgorithm names the checksum returned by FileChecksum.
+const FileChecksumAlgorithm = "COMPOSITE-CRC32"
+
+const commitChecksumTimeout = 5 * time.Second
+
+// ErrChecksumUnavailable is returned for files with blocks committed without a
+// checksum, e.g. by clients that predate end-to-end checksums.
+var ErrChecksumUnavailable = errors.New("checksum unavailable")
+
+// ErrChecksumUnverified is returned for commits whose block checksums no
+// worker could confirm in time.
+var ErrChecksumUnverified = errors.New("checksum not verified")
+
+// FileChecksum returns the CRC-32 (IEEE) of a whole file and its length,
+// combined from the committed block checksums without reading any data.
+func (mn *MasterNode) FileChecksum(path string) (uint32, int64, error) {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	fullPath := filepath.Clean(path)
+	inode, exists := mn.Namespace[fullPath]
+	if !exists {
+		return 0, 0, fmt.Errorf("file %s: %w", fullPath, ErrNotFound)
+	}
+	if inode.Type != FileType {
+		return 0, 0, fmt.Errorf("%s is a directory", fullPath)
+	}
+
+	var crc uint32
+	var length int64
+	for i, blockID := range inode.Blocks {
+		meta, ok := mn.BlockMap[blockID]
+		if !ok {
+			return 0, 0, fmt.Errorf("block %s of %s: %w", blockID, fullPath, ErrNotFound)
+		}
+		checksum, size := meta.Checksum, meta.Size
+		if ext := inode.extent(i); ext != nil {
+			checksum, size = ext.Checksum, ext.Length
+		}
+		// A checksum of 0 means the writer did not supply one; only an empty
+		// block really has it.
+		if checksum == 0 && size > 0 {
+			return 0, 0, fmt.Errorf("block %s of %s: %w", blockID, fullPath, ErrChecksumUnavailable)
+		}
+		crc = crc32Combine(crc, checksum, size)
+		length += size
+	}
+	return crc, length, nil
+}
+
+// verifyCommitChecksums asks the workers holding each block for the checksum
+// they computed while storing it, all at once and within
+// commitChecksumTimeout. It rejects the commit if a replica disagrees with
+// the client's checksum, or if no replica of a block could confirm it.
+// Blocks without a client checksum are left to fsck. It must be called
+// without mn.lock held.
+func (mn *MasterNode) verifyCommitChecksums(blocks []*commonv1.BlockInfo) error {
+	if mn.LoadBalancer == nil {
+		return nil
+	}
+
+	type pending struct {
+		blockID  uuid.UUID
+		want     uint32
+		hash     string
+		replicas []uuid.UUID
+	}
+
+	mn.lock.RLock()
+	checks := make([]pending, 0, len(blocks))
+	for _, b := range blocks {
+		// Workers checksum a container block as a whole, not its extents.
+		if b.Checksum == 0 || b.Packed {
+			continue
+		}
+		blockID, err := uuid.Parse(b.BlockId)
+		if err != nil {
+			continue
+		}
+		if meta, ok := mn.BlockMap[blockID]; ok {
+			checks = append(checks, pending{blockID: blockID, want: uint32(b.Checksum), hash: b.ContentHash, replicas: append([]uuid.UUID(nil), meta.Replicas...)})
+		}
+	}
+	mn.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commitChecksumTimeout)
+	defer cancel()
+
+	// confirmed counts the replicas of each check that returned the
+	// client's checksum; once every check has one the rest are cancelled.
+	confirmed := make([]int, len(checks))
+	unconfirmed := len(checks)
+	var mu sync.Mutex
+	var mismatch, lastErr error
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		for _, workerID := range c.replicas {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := mn.verifyStoredChecksum(ctx, c.blockID, workerID, c.want, c.hash)
+
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					confirmed[i]++
+					if confirmed[i] == 1 {
+						if unconfirmed--; unconfirmed == 0 {
+							cancel()
+						}
+					}
+				case unconfirmed == 0 || mismatch != nil:
+					// Cancelled after the outcome was known.
+				case errors.Is(err, ErrChecksumMismatch):
+					if mismatch == nil {
+						mismatch = err
+						// One mismatch rejects the commit.
+						cancel()
+					}
+				default:
+					log.Printf("Could not check the checksum of block %s on worker %s: %v", c.blockID, workerID, err)
+					lastErr = err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if mismatch != nil {
+		return mismatch
+	}
+	for i, c := range checks {
+		if confirmed[i] == 0 {
+			return fmt.Errorf("block %s: %w: no replica could be checked: %v", c.blockID, ErrChecksumUnverified, lastErr)
+		}
+	}
+	return nil
+}
+
+func (mn *MasterNode) verifyStoredChecksum(ctx context.Context, blockID, workerID uuid.UUID, want uint32, wantHash string) error {
+	client, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerID.String())
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return fmt.Errorf("worker %s has no client", workerID)
+	}
+
+	resp, err := client.GetBlockChecksum(ctx, &datanodev1.GetBlockChecksumRequest{
+		BlockId:    blockID.String(),
+		BlockToken: mn.blockToken(blockID, "", blocktoken.OpRead),
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Exists {
+		return fmt.Errorf("block %s is not stored on worker %s: %w", blockID, workerID, ErrChecksumMismatch)
+	}
+	if resp.Checksum != want {
+		return fmt.Errorf("block %s on worker %s: %w: worker has %08x, client sent %08x",
+			blockID, workerID, ErrChecksumMismatch, resp.Checksum, want)
+	}
+	// Workers that stored the block before content hashes were kept report none.
+	if wantHash != "" && resp.ContentHash != "" && resp.ContentHash != wantHash {
+		return fmt.Errorf("block %s on worker %s: %w: worker has content %s, client sent %s",
+			blockID, workerID, ErrChecksumMismatch, resp.ContentHash, wantHash)
+	}
+	return nil
+}
+
+// crc32Combine returns the CRC-32 (IEEE) of the concatenation of two byte
+// sequences given the CRC of each and the length of the second, as zlib's
+// crc32_combine does.
+func crc32Combine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	var even, odd [32]uint32
+
+	// odd is the operator for one zero bit.
+	odd[0] = 0xedb88320
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(&even, &odd) // two zero bits
+	gf2MatrixSquare(&odd, &even) // four zero bits
+
+	// Apply len2 zero bytes to crc1, squaring the operator for each bit of
+	// len2.
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := range square {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
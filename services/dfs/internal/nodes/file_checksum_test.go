@@ -0,0 +1,184 @@
+package nodes
+
+import (
+	"context"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCrc32Combine(t *testing.T) {
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(data)
+	want := crc32.ChecksumIEEE(data)
+
+	for _, split := range []int{0, 1, 7, 4096, 9999, 10000} {
+		a, b := data[:split], data[split:]
+		got := crc32Combine(crc32.ChecksumIEEE(a), crc32.ChecksumIEEE(b), int64(len(b)))
+		assert.Equal(t, want, got, "split at %d", split)
+	}
+}
+
+// commitBlocks registers data as a file split into blocks of the given sizes.
+func commitBlocks(t *testing.T, master *MasterNode, path string, data []byte, sizes ...int) {
+	t.Helper()
+
+	var blocks []*commonv1.BlockInfo
+	for _, size := range sizes {
+		chunk := data[:size]
+		data = data[size:]
+		blocks = append(blocks, &commonv1.BlockInfo{
+			BlockId:  uuid.New().String(),
+			Size:     int64(size),
+			Checksum: int64(crc32.ChecksumIEEE(chunk)),
+		})
+	}
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: path, Blocks: blocks})
+	require.NoError(t, err)
+}
+
+func TestMasterNode_FileChecksum(t *testing.T) {
+	master := setupTestMaster(t)
+	data := []byte("the same bytes stored with two different block layouts")
+
+	commitBlocks(t, master, "proj/a.bin", data, 10, 20, len(data)-30)
+	commitBlocks(t, master, "proj/b.bin", data, len(data))
+
+	t.Run("matches the CRC of the content regardless of block layout", func(t *testing.T) {
+		for _, path := range []string{"proj/a.bin", "proj/b.bin"} {
+			checksum, length, err := master.FileChecksum(path)
+			require.NoError(t, err)
+			assert.Equal(t, crc32.ChecksumIEEE(data), checksum, path)
+			assert.Equal(t, int64(len(data)), length, path)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, _, err := master.FileChecksum("proj/missing.bin")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("block without checksum", func(t *testing.T) {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: "proj",
+			FilePath:  "proj/legacy.bin",
+			Blocks:    []*commonv1.BlockInfo{{BlockId: uuid.New().String(), Size: 5}},
+		})
+		require.NoError(t, err)
+
+		_, _, err = master.FileChecksum("proj/legacy.bin")
+		assert.ErrorIs(t, err, ErrChecksumUnavailable)
+	})
+}
+
+func TestMasterNode_CommitFileVerifiesChecksums(t *testing.T) {
+	master := setupTestMaster(t)
+	worker := uuid.New()
+	client := &fakeChecksumClient{checksums: make(map[string]uint32)}
+
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	lb.AddWorker(worker.String(), load_balancer.WorkerMetadata{Client: client, Ip: "worker-0.worker-headless", Port: 50051, Alive: true})
+	master.LoadBalancer = lb
+
+	allocate := func(stored uint32, exists bool) uuid.UUID {
+		blockID := uuid.New()
+		master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Replicas: []uuid.UUID{worker}}
+		if exists {
+			client.checksums[blockID.String()] = stored
+		}
+		return blockID
+	}
+	commit := func(path string, blockID uuid.UUID, checksum uint32) error {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: "proj",
+			FilePath:  path,
+			Blocks:    []*commonv1.BlockInfo{{BlockId: blockID.String(), Size: 4, Checksum: int64(checksum)}},
+		})
+		return err
+	}
+
+	t.Run("accepts matching checksums", func(t *testing.T) {
+		require.NoError(t, commit("proj/ok.bin", allocate(0x1234, true), 0x1234))
+		assert.Contains(t, master.Namespace, "proj/ok.bin")
+	})
+
+	t.Run("rejects a checksum the worker disagrees with", func(t *testing.T) {
+		err := commit("proj/bad.bin", allocate(0x1234, true), 0x4321)
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+		assert.NotContains(t, master.Namespace, "proj/bad.bin")
+	})
+
+	t.Run("rejects a block the worker does not have", func(t *testing.T) {
+		err := commit("proj/gone.bin", allocate(0, false), 0x1234)
+		assert.Error(t, err)
+		assert.NotContains(t, master.Namespace, "proj/gone.bin")
+	})
+
+	t.Run("skips blocks committed without a checksum", func(t *testing.T) {
+		require.NoError(t, commit("proj/legacy.bin", allocate(0x1234, true), 0))
+	})
+
+	t.Run("rejects a block no replica could check", func(t *testing.T) {
+		down := uuid.New()
+		lb.AddWorker(down.String(), load_balancer.WorkerMetadata{Client: &unreachableClient{}, Ip: "worker-1.worker-headless", Port: 50051, Alive: true})
+		blockID := uuid.New()
+		master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Replicas: []uuid.UUID{down}}
+
+		err := commit("proj/unchecked.bin", blockID, 0x1234)
+		assert.ErrorIs(t, err, ErrChecksumUnverified)
+		assert.NotContains(t, master.Namespace, "proj/unchecked.bin")
+
+		t.Run("unless another replica confirms it", func(t *testing.T) {
+			blockID := allocate(0x1234, true)
+			master.BlockMap[blockID].Replicas = append(master.BlockMap[blockID].Replicas, down)
+			require.NoError(t, commit("proj/checked.bin", blockID, 0x1234))
+		})
+	})
+
+	t.Run("does not wait for slow replicas once every block is confirmed", func(t *testing.T) {
+		slow := uuid.New()
+		lb.AddWorker(slow.String(), load_balancer.WorkerMetadata{Client: &hangingClient{}, Ip: "worker-2.worker-headless", Port: 50051, Alive: true})
+		blocks := make([]*commonv1.BlockInfo, 0, 20)
+		for range 20 {
+			blockID := allocate(0x1234, true)
+			master.BlockMap[blockID].Replicas = append(master.BlockMap[blockID].Replicas, slow)
+			blocks = append(blocks, &commonv1.BlockInfo{BlockId: blockID.String(), Size: 4, Checksum: 0x1234})
+		}
+
+		start := time.Now()
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/many.bin", Blocks: blocks})
+		require.NoError(t, err)
+		assert.Less(t, time.Since(start), commitChecksumTimeout)
+	})
+}
+
+// unreachableClient is a worker whose RPCs all fail.
+type unreachableClient struct {
+	datanodev1.DataNodeServiceClient
+}
+
+func (unreachableClient) GetBlockChecksum(ctx context.Context, in *datanodev1.GetBlockChecksumRequest, opts ...grpc.CallOption) (*datanodev1.GetBlockChecksumResponse, error) {
+	return nil, status.Error(codes.Unavailable, "connection refused")
+}
+
+// hangingClient is a worker that answers nothing until the call is cancelled.
+type hangingClient struct {
+	datanodev1.DataNodeServiceClient
+}
+
+func (hangingClient) GetBlockChecksum(ctx context.Context, in *datanodev1.GetBlockChecksumRequest, opts ...grpc.CallOption) (*datanodev1.GetBlockChecksumResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
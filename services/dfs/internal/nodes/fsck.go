@@ -0,0 +1,380 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	adminv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/admin/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+)
+
+const (
+	lostAndFoundDir = "lost+found"
+
+	defaultFsckPrefixDepth = 2
+	fsckChecksumTimeout    = 5 * time.Second
+)
+
+type fsckFile struct {
+	path   string
+	blocks []uuid.UUID
+}
+
+type fsckBlock struct {
+	exists   bool
+	checksum uint32
+	replicas []uuid.UUID
+	corrupt  []uuid.UUID
+	// replication is the replica count wanted by the file owning the block.
+	replication int
+}
+
+// Fsck checks every file under req.Path: all of its blocks must be in the
+// block map, have live replicas, and every live replica must hold the
+// committed checksum. Corrupt and missing files are optionally moved to
+// lost+found or deleted.
+func (mn *MasterNode) Fsck(ctx context.Context, req *adminv1.FsckRequest) (*adminv1.FsckResponse, error) {
+	if req.Action != adminv1.FsckAction_FSCK_ACTION_UNSPECIFIED {
+		if err := mn.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	depth := int(req.PrefixDepth)
+	if depth <= 0 {
+		depth = defaultFsckPrefixDepth
+	}
+
+	files, blocks := mn.fsckSnapshot(fsckRoot(req.Path))
+
+	reports := make(map[uuid.UUID]*adminv1.FsckBlockReport, len(blocks))
+	for blockID, block := range blocks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		reports[blockID] = mn.checkBlock(ctx, blockID, block)
+	}
+
+	resp := &adminv1.FsckResponse{
+		Healthy:       true,
+		FilesChecked:  int64(len(files)),
+		BlocksChecked: int64(len(blocks)),
+	}
+	summaries := make(map[string]*adminv1.FsckPrefixSummary)
+	damaged := make([]string, 0)
+
+	for _, file := range files {
+		fileReport := &adminv1.FsckFileReport{
+			Path:   file.path,
+			Status: adminv1.HealthStatus_HEALTH_STATUS_HEALTHY,
+		}
+		for _, blockID := range file.blocks {
+			blockReport := reports[blockID]
+			if healthRank[blockReport.Status] > healthRank[fileReport.Status] {
+				fileReport.Status = blockReport.Status
+			}
+			if blockReport.Status != adminv1.HealthStatus_HEALTH_STATUS_HEALTHY {
+				fileReport.Blocks = append(fileReport.Blocks, blockReport)
+			}
+		}
+
+		prefix := fsckPrefix(file.path, depth)
+		summary, ok := summaries[prefix]
+		if !ok {
+			summary = &adminv1.FsckPrefixSummary{Prefix: prefix}
+			summaries[prefix] = summary
+		}
+		summary.Files++
+
+		switch fileReport.Status {
+		case adminv1.HealthStatus_HEALTH_STATUS_HEALTHY:
+			summary.HealthyFiles++
+			continue
+		case adminv1.HealthStatus_HEALTH_STATUS_UNDER_REPLICATED:
+			summary.UnderReplicatedFiles++
+		case adminv1.HealthStatus_HEALTH_STATUS_UNVERIFIED:
+			summary.UnverifiedFiles++
+		case adminv1.HealthStatus_HEALTH_STATUS_CORRUPT:
+			summary.CorruptFiles++
+			damaged = append(damaged, file.path)
+		case adminv1.HealthStatus_HEALTH_STATUS_MISSING:
+			summary.MissingFiles++
+			damaged = append(damaged, file.path)
+		}
+
+		resp.Healthy = false
+		resp.Files = append(resp.Files, fileReport)
+	}
+
+	for _, summary := range summaries {
+		resp.Prefixes = append(resp.Prefixes, summary)
+	}
+	sort.Slice(resp.Prefixes, func(i, j int) bool {
+		return resp.Prefixes[i].Prefix < resp.Prefixes[j].Prefix
+	})
+
+	if len(damaged) > 0 {
+		switch req.Action {
+		case adminv1.FsckAction_FSCK_ACTION_MOVE:
+			resp.MovedFiles = mn.moveToLostFound(damaged)
+		case adminv1.FsckAction_FSCK_ACTION_DELETE:
+			resp.DeletedFiles = mn.deleteFiles(damaged)
+		}
+	}
+
+	log.Printf("fsck %q: %d files, %d blocks, %d unhealthy files", req.Path, len(files), len(blocks), len(resp.Files))
+	return resp, nil
+}
+
+func fsckRoot(path string) string {
+	return strings.Trim(filepath.Clean("/"+path), "/")
+}
+
+// fsckPrefix groups a file under the first depth components of its directory.
+func fsckPrefix(path string, depth int) string {
+	parts := strings.Split(filepath.Dir(path), string(filepath.Separator))
+	if len(parts) == 1 && parts[0] == "." {
+		return "/"
+	}
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, string(filepath.Separator))
+}
+
+// fsckSnapshot copies the files under root and the metadata of their blocks,
+// so replicas can be probed without holding the namespace lock.
+func (mn *MasterNode) fsckSnapshot(root string) ([]fsckFile, map[uuid.UUID]fsckBlock) {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	files := make([]fsckFile, 0)
+	blocks := make(map[uuid.UUID]fsckBlock)
+
+	for path, inode := range mn.Namespace {
+		if inode.Type != FileType {
+			continue
+		}
+		if root != "" && path != root && !strings.HasPrefix(path, root+"/") {
+			continue
+		}
+
+		files = append(files, fsckFile{
+			path:   path,
+			blocks: append([]uuid.UUID(nil), inode.Blocks...),
+		})
+
+		for _, blockID := range inode.Blocks {
+			meta, exists := mn.BlockMap[blockID]
+			if !exists {
+				blocks[blockID] = fsckBlock{}
+				continue
+			}
+
+			block := fsckBlock{
+				exists:      true,
+				checksum:    meta.Checksum,
+				replicas:    append([]uuid.UUID(nil), meta.Replicas...),
+				corrupt:     append([]uuid.UUID(nil), meta.CorruptReplicas...),
+				replication: inode.ReplicationFactor(),
+			}
+			for i, replica := range block.replicas {
+				if !mn.isReplicaLive(replica) {
+					block.replicas[i] = uuid.Nil
+				}
+			}
+			blocks[blockID] = block
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	return files, blocks
+}
+
+// healthRank orders the statuses from healthy to worst; a file takes the
+// worst status of its blocks.
+var healthRank = map[adminv1.HealthStatus]int{
+	adminv1.HealthStatus_HEALTH_STATUS_HEALTHY:          0,
+	adminv1.HealthStatus_HEALTH_STATUS_UNDER_REPLICATED: 1,
+	adminv1.HealthStatus_HEALTH_STATUS_UNVERIFIED:       2,
+	adminv1.HealthStatus_HEALTH_STATUS_CORRUPT:          3,
+	adminv1.HealthStatus_HEALTH_STATUS_MISSING:          4,
+}
+
+// replicaCheck is the outcome of checking a replica against its block's
+// committed checksum.
+type replicaCheck int
+
+const (
+	replicaHealthy replicaCheck = iota
+	replicaCorrupt
+	// replicaUnverified is present on its worker, but there is no committed
+	// checksum to compare it with.
+	replicaUnverified
+	// replicaUnchecked could not be checked or is gone.
+	replicaUnchecked
+)
+
+func (mn *MasterNode) checkBlock(ctx context.Context, blockID uuid.UUID, block fsckBlock) *adminv1.FsckBlockReport {
+	report := &adminv1.FsckBlockReport{BlockId: blockID.String()}
+
+	if !block.exists {
+		report.Status = adminv1.HealthStatus_HEALTH_STATUS_MISSING
+		report.Message = "block not in block map"
+		return report
+	}
+
+	problems := make([]string, 0)
+	unverified := 0
+	for _, replica := range block.replicas {
+		if replica == uuid.Nil {
+			continue
+		}
+		report.LiveReplicas++
+
+		corrupt := false
+		for _, id := range block.corrupt {
+			if id == replica {
+				corrupt = true
+			}
+		}
+
+		if !corrupt {
+			result, problem := mn.verifyReplicaChecksum(ctx, blockID, replica, block.checksum)
+			switch result {
+			case replicaHealthy:
+				report.HealthyReplicas++
+				continue
+			case replicaUnverified:
+				unverified++
+				problems = append(problems, fmt.Sprintf("%s: %s", replica, problem))
+				continue
+			case replicaUnchecked:
+				problems = append(problems, fmt.Sprintf("%s: %s", replica, problem))
+				continue
+			}
+		}
+
+		report.CorruptReplicas = append(report.CorruptReplicas, replica.String())
+		mn.MarkReplicaCorrupt(blockID, replica)
+	}
+
+	switch {
+	case report.HealthyReplicas > 0 && int(report.HealthyReplicas) >= block.replication:
+		report.Status = adminv1.HealthStatus_HEALTH_STATUS_HEALTHY
+	case report.HealthyReplicas > 0:
+		report.Status = adminv1.HealthStatus_HEALTH_STATUS_UNDER_REPLICATED
+	case unverified > 0:
+		report.Status = adminv1.HealthStatus_HEALTH_STATUS_UNVERIFIED
+	case len(report.CorruptReplicas) > 0:
+		report.Status = adminv1.HealthStatus_HEALTH_STATUS_CORRUPT
+	default:
+		report.Status = adminv1.HealthStatus_HEALTH_STATUS_MISSING
+	}
+
+	if report.LiveReplicas == 0 {
+		problems = append(problems, "no live replicas")
+	}
+	if len(report.CorruptReplicas) > 0 {
+		problems = append(problems, fmt.Sprintf("%d corrupt replicas", len(report.CorruptReplicas)))
+	}
+	report.Message = strings.Join(problems, "; ")
+	return report
+}
+
+// verifyReplicaChecksum compares a worker's stored checksum with the committed
+// one. Unless the replica is healthy or corrupt, it also returns the problem.
+func (mn *MasterNode) verifyReplicaChecksum(ctx context.Context, blockID, workerID uuid.UUID, committed uint32) (replicaCheck, string) {
+	if mn.LoadBalancer == nil {
+		return replicaHealthy, ""
+	}
+
+	client, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerID.String())
+	if err != nil {
+		return replicaUnchecked, err.Error()
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, fsckChecksumTimeout)
+	defer cancel()
+
+	resp, err := client.GetBlockChecksum(reqCtx, &datanodev1.GetBlockChecksumRequest{
+		BlockId:    blockID.String(),
+		BlockToken: mn.blockToken(blockID, "", blocktoken.OpRead),
+	})
+	if err != nil {
+		return replicaUnchecked, fmt.Sprintf("checksum unavailable: %v", err)
+	}
+	if !resp.Exists {
+		return replicaUnchecked, "block not found on worker"
+	}
+
+	// A committed checksum of 0 means the writer did not supply one, so the
+	// replica cannot be told apart from a corrupt one.
+	if committed == 0 {
+		return replicaUnverified, "checksum unavailable: no committed checksum"
+	}
+	if resp.Checksum != committed {
+		return replicaCorrupt, ""
+	}
+	return replicaHealthy, ""
+}
+
+// moveToLostFound moves files under lost+found, keeping their original path
+// below it, and returns their new paths.
+func (mn *MasterNode) moveToLostFound(paths []string) []string {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		log.Printf("fsck: %v", err)
+		return nil
+	}
+	moved := make([]string, 0, len(paths))
+	for _, path := range paths {
+		inode, exists := mn.Namespace[path]
+		if !exists {
+			continue
+		}
+
+		target := filepath.Join(lostAndFoundDir, path)
+		if _, taken := mn.Namespace[target]; taken {
+			target = fmt.Sprintf("%s.%d", target, time.Now().UnixNano())
+		}
+
+		if err := mn.renameLocked(path, target, inode.OwnerID, inode.ProjectID); err != nil {
+			log.Printf("fsck: failed to move %s: %v", path, err)
+			continue
+		}
+
+		log.Printf("fsck: moved %s to %s", path, target)
+		moved = append(moved, target)
+	}
+	return moved
+}
+
+func (mn *MasterNode) deleteFiles(paths []string) []string {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		log.Printf("fsck: %v", err)
+		return nil
+	}
+	deleted := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if mn.deleteFileLocked(path) {
+			log.Printf("fsck: deleted %s", path)
+			deleted = append(deleted, path)
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,170 @@
+package nodes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	adminv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/admin/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type fakeChecksumClient struct {
+	datanodev1.DataNodeServiceClient
+	checksums map[string]uint32
+	deleted   []string
+}
+
+func (f *fakeChecksumClient) GetBlockChecksum(ctx context.Context, in *datanodev1.GetBlockChecksumRequest, opts ...grpc.CallOption) (*datanodev1.GetBlockChecksumResponse, error) {
+	checksum, ok := f.checksums[in.BlockId]
+	return &datanodev1.GetBlockChecksumResponse{Checksum: checksum, Exists: ok}, nil
+}
+
+func (f *fakeChecksumClient) DeleteBlock(ctx context.Context, in *datanodev1.DeleteBlockRequest, opts ...grpc.CallOption) (*datanodev1.DeleteBlockResponse, error) {
+	f.deleted = append(f.deleted, in.BlockId)
+	return &datanodev1.DeleteBlockResponse{Success: true}, nil
+}
+
+type fsckFixture struct {
+	master  *MasterNode
+	client  *fakeChecksumClient
+	healthy uuid.UUID
+	corrupt uuid.UUID
+	lost    uuid.UUID
+}
+
+func setupFsckMaster(t *testing.T) *fsckFixture {
+	t.Helper()
+
+	master := setupTestMaster(t)
+	worker := uuid.New()
+	client := &fakeChecksumClient{checksums: make(map[string]uint32)}
+
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	lb.AddWorker(worker.String(), load_balancer.WorkerMetadata{Client: client, Ip: "worker-0.worker-headless", Port: 50051, Alive: true})
+	master.LoadBalancer = lb
+
+	f := &fsckFixture{master: master, client: client, healthy: uuid.New(), corrupt: uuid.New(), lost: uuid.New()}
+
+	master.BlockMap[f.healthy] = &BlockMetadata{BlockID: f.healthy, Checksum: 111, Replicas: []uuid.UUID{worker}}
+	master.BlockMap[f.corrupt] = &BlockMetadata{BlockID: f.corrupt, Checksum: 222, Replicas: []uuid.UUID{worker}}
+	client.checksums[f.healthy.String()] = 111
+	client.checksums[f.corrupt.String()] = 999
+
+	master.Namespace["proj"] = &Inode{ID: "proj", Path: "proj", Type: DirType}
+	master.Namespace["proj/a"] = &Inode{ID: "a", Path: "proj/a", Type: DirType, Children: []string{"ok", "bad"}}
+	master.Namespace["proj/b"] = &Inode{ID: "b", Path: "proj/b", Type: DirType, Children: []string{"gone"}}
+	master.Namespace["proj/a/ok.avro"] = &Inode{ID: "ok", Path: "proj/a/ok.avro", Type: FileType, ProjectID: "proj", Blocks: []uuid.UUID{f.healthy}}
+	master.Namespace["proj/a/bad.avro"] = &Inode{ID: "bad", Path: "proj/a/bad.avro", Type: FileType, ProjectID: "proj", Blocks: []uuid.UUID{f.corrupt}}
+	master.Namespace["proj/b/gone.avro"] = &Inode{ID: "gone", Path: "proj/b/gone.avro", Type: FileType, ProjectID: "proj", Blocks: []uuid.UUID{f.lost}}
+
+	return f
+}
+
+func TestMasterNode_Fsck(t *testing.T) {
+	f := setupFsckMaster(t)
+
+	resp, err := f.master.Fsck(context.Background(), &adminv1.FsckRequest{})
+	require.NoError(t, err)
+
+	t.Run("reports totals", func(t *testing.T) {
+		assert.False(t, resp.Healthy)
+		assert.Equal(t, int64(3), resp.FilesChecked)
+		assert.Equal(t, int64(3), resp.BlocksChecked)
+	})
+
+	t.Run("lists unhealthy files with their bad blocks", func(t *testing.T) {
+		require.Len(t, resp.Files, 2)
+		assert.Equal(t, "proj/a/bad.avro", resp.Files[0].Path)
+		assert.Equal(t, adminv1.HealthStatus_HEALTH_STATUS_CORRUPT, resp.Files[0].Status)
+		require.Len(t, resp.Files[0].Blocks, 1)
+		assert.Equal(t, f.corrupt.String(), resp.Files[0].Blocks[0].BlockId)
+
+		assert.Equal(t, "proj/b/gone.avro", resp.Files[1].Path)
+		assert.Equal(t, adminv1.HealthStatus_HEALTH_STATUS_MISSING, resp.Files[1].Status)
+	})
+
+	t.Run("summarises per prefix", func(t *testing.T) {
+		require.Len(t, resp.Prefixes, 2)
+		assert.Equal(t, "proj/a", resp.Prefixes[0].Prefix)
+		assert.Equal(t, int64(2), resp.Prefixes[0].Files)
+		assert.Equal(t, int64(1), resp.Prefixes[0].HealthyFiles)
+		assert.Equal(t, int64(1), resp.Prefixes[0].CorruptFiles)
+		assert.Equal(t, "proj/b", resp.Prefixes[1].Prefix)
+		assert.Equal(t, int64(1), resp.Prefixes[1].MissingFiles)
+	})
+
+	t.Run("records the corrupt replica in the block map", func(t *testing.T) {
+		assert.Len(t, f.master.BlockMap[f.corrupt].CorruptReplicas, 1)
+	})
+
+	t.Run("path restricts the check", func(t *testing.T) {
+		resp, err := f.master.Fsck(context.Background(), &adminv1.FsckRequest{Path: "/proj/b"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), resp.FilesChecked)
+	})
+}
+
+func TestMasterNode_FsckMove(t *testing.T) {
+	f := setupFsckMaster(t)
+
+	resp, err := f.master.Fsck(context.Background(), &adminv1.FsckRequest{Action: adminv1.FsckAction_FSCK_ACTION_MOVE})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"lost+found/proj/a/bad.avro", "lost+found/proj/b/gone.avro"}, resp.MovedFiles)
+	assert.NotContains(t, f.master.Namespace, "proj/a/bad.avro")
+	require.Contains(t, f.master.Namespace, "lost+found/proj/a/bad.avro")
+	assert.Equal(t, "bad.avro", f.master.Namespace["lost+found/proj/a/bad.avro"].Name)
+	assert.Contains(t, f.master.Namespace["lost+found/proj/a"].Children, "bad")
+	assert.Equal(t, []string{"ok"}, f.master.Namespace["proj/a"].Children)
+	assert.Empty(t, f.client.deleted)
+}
+
+func TestMasterNode_FsckDelete(t *testing.T) {
+	f := setupFsckMaster(t)
+
+	resp, err := f.master.Fsck(context.Background(), &adminv1.FsckRequest{Action: adminv1.FsckAction_FSCK_ACTION_DELETE})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"proj/a/bad.avro", "proj/b/gone.avro"}, resp.DeletedFiles)
+	assert.NotContains(t, f.master.Namespace, "proj/a/bad.avro")
+	assert.NotContains(t, f.master.BlockMap, f.corrupt)
+	assert.Contains(t, f.client.deleted, f.corrupt.String())
+	assert.Contains(t, f.master.Namespace, "proj/a/ok.avro")
+}
+
+func TestMasterNode_FsckUnverified(t *testing.T) {
+	f := setupFsckMaster(t)
+
+	legacy := uuid.New()
+	f.master.BlockMap[legacy] = &BlockMetadata{BlockID: legacy, Replicas: f.master.BlockMap[f.healthy].Replicas}
+	f.client.checksums[legacy.String()] = 12345
+	f.master.Namespace["proj/c"] = &Inode{ID: "c", Path: "proj/c", Type: DirType, Children: []string{"legacy"}}
+	f.master.Namespace["proj/c/legacy.avro"] = &Inode{ID: "legacy", Path: "proj/c/legacy.avro", Type: FileType, ProjectID: "proj", Blocks: []uuid.UUID{legacy}}
+
+	resp, err := f.master.Fsck(context.Background(), &adminv1.FsckRequest{Path: "proj/c", Action: adminv1.FsckAction_FSCK_ACTION_MOVE})
+	require.NoError(t, err)
+
+	assert.False(t, resp.Healthy, "a block without a committed checksum is not reported healthy")
+	require.Len(t, resp.Files, 1)
+	assert.Equal(t, adminv1.HealthStatus_HEALTH_STATUS_UNVERIFIED, resp.Files[0].Status)
+	require.Len(t, resp.Files[0].Blocks, 1)
+	assert.Zero(t, resp.Files[0].Blocks[0].HealthyReplicas)
+	assert.Contains(t, resp.Files[0].Blocks[0].Message, "checksum unavailable")
+	require.Len(t, resp.Prefixes, 1)
+	assert.Equal(t, int64(1), resp.Prefixes[0].UnverifiedFiles)
+
+	assert.Empty(t, resp.MovedFiles, "unverified files are not moved")
+	assert.Contains(t, f.master.Namespace, "proj/c/legacy.avro")
+	assert.Empty(t, f.master.BlockMap[legacy].CorruptReplicas)
+}
+
+func TestFsckPrefix(t *testing.T) {
+	assert.Equal(t, "p/s", fsckPrefix("p/s/x/f.avro", 2))
+	assert.Equal(t, "p", fsckPrefix("p/f.avro", 2))
+	assert.Equal(t, "/", fsckPrefix("f.avro", 2))
+}
@@ -0,0 +1,238 @@
+package nodes
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/metrics"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// IOClass is the kind of traffic a worker request belongs to. Each class has
+// its own bandwidth and concurrency budget, so replication, balancing and
+// scanning cannot starve client reads and writes.
+type IOClass string
+
+const (
+	IOClassForegroundRead  IOClass = "foreground_read"
+	IOClassForegroundWrite IOClass = "foreground_write"
+	IOClassBackground      IOClass = "background"
+)
+
+// IOClassHeader carries the class of a request, as gRPC metadata and as an
+// HTTP header. Requests without it are foreground.
+const IOClassHeader = "x-dfs-io-class"
+
+// ioAdmissionTimeout bounds the wait for an I/O slot, so clients try another
+// replica rather than queue behind a busy one.
+const ioAdmissionTimeout = 5 * time.Second
+
+// ParseIOClass returns the class named s.
+func ParseIOClass(s string) (IOClass, bool) {
+	switch class := IOClass(s); class {
+	case IOClassForegroundRead, IOClassForegroundWrite, IOClassBackground:
+		return class, true
+	}
+	return "", false
+}
+
+// IOBudget limits the I/O of one class.
+type IOBudget struct {
+	// BytesPerSecond caps the class's disk and network rate. <= 0 is unlimited.
+	BytesPerSecond int64
+	// MaxConcurrent caps the class's requests in progress. <= 0 is unlimited.
+	MaxConcurrent int
+}
+
+type IOQoSConfig struct {
+	ForegroundRead  IOBudget
+	ForegroundWrite IOBudget
+	Background      IOBudget
+}
+
+// DefaultIOQoSConfig leaves client traffic unlimited and keeps background work
+// to a share of a disk.
+func DefaultIOQoSConfig() IOQoSConfig {
+	return IOQoSConfig{
+		Background: IOBudget{BytesPerSecond: 64 * 1024 * 1024, MaxConcurrent: 4},
+	}
+}
+
+type ioClassBudget struct {
+	class   IOClass
+	limiter *rate.Limiter
+	slots   chan struct{}
+	// pushSlots admit blocks pushed to this worker by other workers'
+	// transfers. A sending worker holds one of its slots until the target
+	// admits the push, so if pushes took from slots too, two workers
+	// transferring to each other could each wait for the other's.
+	pushSlots chan struct{}
+}
+
+// IOQoS admits and paces the I/O of worker requests by class. A nil IOQoS
+// only counts it.
+type IOQoS struct {
+	classes map[IOClass]*ioClassBudget
+}
+
+func NewIOQoS(cfg IOQoSConfig) *IOQoS {
+	q := &IOQoS{classes: make(map[IOClass]*ioClassBudget)}
+	for class, budget := range map[IOClass]IOBudget{
+		IOClassForegroundRead:  cfg.ForegroundRead,
+		IOClassForegroundWrite: cfg.ForegroundWrite,
+		IOClassBackground:      cfg.Background,
+	} {
+		b := &ioClassBudget{class: class, limiter: newByteLimiter(budget.BytesPerSecond)}
+		if budget.MaxConcurrent > 0 {
+			b.slots = make(chan struct{}, budget.MaxConcurrent)
+			if class == IOClassBackground {
+				b.pushSlots = make(chan struct{}, budget.MaxConcurrent)
+			}
+		}
+		q.classes[class] = b
+	}
+	return q
+}
+
+func (q *IOQoS) budget(class IOClass) *ioClassBudget {
+	if q != nil {
+		if b, ok := q.classes[class]; ok {
+			return b
+		}
+	}
+	return &ioClassBudget{class: class}
+}
+
+// Admit takes an I/O slot of class for a request, waiting up to
+// ioAdmissionTimeout for one. The returned func gives the slot back. When none
+// frees up in time it fails with ResourceExhausted, so clients try another
+// replica and background senders retry later.
+func (q *IOQoS) Admit(ctx context.Context, class IOClass) (func(), error) {
+	b := q.budget(class)
+	return b.admit(ctx, b.slots)
+}
+
+// AdmitPush is Admit for a block pushed to this worker. Background pushes,
+// which come from other workers' transfers, take from their own slots.
+func (q *IOQoS) AdmitPush(ctx context.Context, class IOClass) (func(), error) {
+	b := q.budget(class)
+	slots := b.slots
+	if b.pushSlots != nil {
+		slots = b.pushSlots
+	}
+	return b.admit(ctx, slots)
+}
+
+func (b *ioClassBudget) admit(ctx context.Context, slots chan struct{}) (func(), error) {
+	class := b.class
+	start := time.Now()
+	if slots != nil {
+		timer := time.NewTimer(ioAdmissionTimeout)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+		case <-timer.C:
+			metrics.IORejectedTotal.WithLabelValues(string(class)).Inc()
+			return nil, status.Errorf(codes.ResourceExhausted, "%s I/O budget exhausted: %d requests in progress", class, cap(slots))
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	metrics.IOAdmissionWaitDuration.WithLabelValues(string(class)).Observe(time.Since(start).Seconds())
+	metrics.IOActiveRequests.WithLabelValues(string(class)).Inc()
+
+	return func() {
+		metrics.IOActiveRequests.WithLabelValues(string(class)).Dec()
+		if slots != nil {
+			<-slots
+		}
+	}, nil
+}
+
+// WaitN accounts n bytes of class I/O, blocking while the class is over its
+// rate.
+func (q *IOQoS) WaitN(ctx context.Context, class IOClass, n int) error {
+	b := q.budget(class)
+	metrics.IOBytesTotal.WithLabelValues(string(class)).Add(float64(n))
+	if b.limiter == nil {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.IOThrottledSecondsTotal.WithLabelValues(string(class)).Add(time.Since(start).Seconds())
+	}()
+	for n > 0 {
+		step := min(n, b.limiter.Burst())
+		if err := b.limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// Reader paces the reads from r as class I/O.
+func (q *IOQoS) Reader(ctx context.Context, class IOClass, r io.Reader) io.Reader {
+	return &qosReader{ctx: ctx, q: q, class: class, r: r}
+}
+
+// ReadSeeker is Reader for sources that are also seeked, e.g. by HTTP range
+// requests.
+func (q *IOQoS) ReadSeeker(ctx context.Context, class IOClass, rs io.ReadSeeker) io.ReadSeeker {
+	return struct {
+		io.Reader
+		io.Seeker
+	}{q.Reader(ctx, class, rs), rs}
+}
+
+type qosReader struct {
+	ctx   context.Context
+	q     *IOQoS
+	class IOClass
+	r     io.Reader
+}
+
+func (r *qosReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.q.WaitN(r.ctx, r.class, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type ioClassKey struct{}
+
+// contextWithIOClass marks ctx as serving a request of class, so block reads
+// made for it are paced accordingly.
+func contextWithIOClass(ctx context.Context, class IOClass) context.Context {
+	return context.WithValue(ctx, ioClassKey{}, class)
+}
+
+func ioClassFromContext(ctx context.Context) (IOClass, bool) {
+	class, ok := ctx.Value(ioClassKey{}).(IOClass)
+	return class, ok
+}
+
+// requestIOClass returns the class a gRPC request declared in its metadata,
+// or def.
+func requestIOClass(ctx context.Context, def IOClass) IOClass {
+	if values := metadata.ValueFromIncomingContext(ctx, IOClassHeader); len(values) > 0 {
+		if class, ok := ParseIOClass(values[0]); ok {
+			return class
+		}
+	}
+	return def
+}
+
+// outgoingIOClass declares class on the gRPC calls made with the returned
+// context.
+func outgoingIOClass(ctx context.Context, class IOClass) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IOClassHeader, string(class))
+}
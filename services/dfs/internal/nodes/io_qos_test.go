@@ -0,0 +1,141 @@
+package nodes
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestIOQoS_Admit(t *testing.T) {
+	qos := NewIOQoS(IOQoSConfig{Background: IOBudget{MaxConcurrent: 1}})
+
+	release, err := qos.Admit(context.Background(), IOClassBackground)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = qos.Admit(ctx, IOClassBackground)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "the class has no free slot")
+
+	fg, err := qos.Admit(context.Background(), IOClassForegroundRead)
+	require.NoError(t, err, "foreground requests have their own budget")
+	fg()
+
+	push, err := qos.AdmitPush(context.Background(), IOClassBackground)
+	require.NoError(t, err, "pushes from other workers' transfers have their own slots")
+	push()
+
+	release()
+	again, err := qos.Admit(context.Background(), IOClassBackground)
+	require.NoError(t, err)
+	again()
+}
+
+func TestIOQoS_WaitN(t *testing.T) {
+	qos := NewIOQoS(IOQoSConfig{ForegroundWrite: IOBudget{BytesPerSecond: 128 * 1024}})
+	ctx := context.Background()
+
+	start := time.Now()
+	require.NoError(t, qos.WaitN(ctx, IOClassForegroundWrite, 128*1024))
+	require.NoError(t, qos.WaitN(ctx, IOClassForegroundRead, 1<<20))
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "the burst and other classes are not throttled")
+
+	require.NoError(t, qos.WaitN(ctx, IOClassForegroundWrite, 64*1024))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	var unlimited *IOQoS
+	require.NoError(t, unlimited.WaitN(ctx, IOClassBackground, 1<<30))
+}
+
+func TestRequestIOClass(t *testing.T) {
+	incoming := func(class string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(IOClassHeader, class))
+	}
+
+	assert.Equal(t, IOClassBackground, requestIOClass(incoming("background"), IOClassForegroundRead))
+	assert.Equal(t, IOClassForegroundRead, requestIOClass(incoming("bulk"), IOClassForegroundRead))
+	assert.Equal(t, IOClassForegroundWrite, requestIOClass(context.Background(), IOClassForegroundWrite))
+
+	outgoing, _ := metadata.FromOutgoingContext(outgoingIOClass(context.Background(), IOClassBackground))
+	assert.Equal(t, []string{"background"}, outgoing.Get(IOClassHeader))
+}
+
+func TestWorkerNode_PushBlockIOClass(t *testing.T) {
+	worker := NewWorkerNode(t.TempDir(), 50051)
+	worker.QoS = NewIOQoS(IOQoSConfig{Background: IOBudget{MaxConcurrent: 1}})
+
+	push := func(ctx context.Context, blockID string) error {
+		return worker.PushBlock(&mockPushBlockServer{
+			ctx: ctx,
+			requests: []*datanodev1.PushBlockRequest{
+				{Data: &datanodev1.PushBlockRequest_Metadata{Metadata: &datanodev1.BlockMetadata{BlockId: blockID}}},
+				{Data: &datanodev1.PushBlockRequest_Chunk{Chunk: []byte("data")}},
+			},
+		})
+	}
+
+	// A transfer from another worker holds the only slot for background
+	// pushes.
+	release, err := worker.QoS.AdmitPush(context.Background(), IOClassBackground)
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs(IOClassHeader, string(IOClassBackground))),
+		50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, push(ctx, "replica"), "a second background write waits for the slot")
+	assert.NoFileExists(t, filepath.Join(worker.StorageDir, "replica.bin"))
+
+	require.NoError(t, push(context.Background(), "client"), "client writes are not held up by background work")
+	assert.FileExists(t, filepath.Join(worker.StorageDir, "client.bin"))
+}
+
+func TestWorkerNode_PushBlockAdmission(t *testing.T) {
+	worker := NewWorkerNode(t.TempDir(), 50051)
+	worker.QoS = NewIOQoS(IOQoSConfig{Background: IOBudget{MaxConcurrent: 1}})
+	background := metadata.NewIncomingContext(context.Background(), metadata.Pairs(IOClassHeader, string(IOClassBackground)))
+
+	// This worker's own transfer holds its only background slot while it
+	// waits for the target, which may be transferring back to it.
+	release, err := worker.QoS.Admit(context.Background(), IOClassBackground)
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(background, time.Second)
+	defer cancel()
+	require.NoError(t, worker.PushBlock(&mockPushBlockServer{
+		ctx: ctx,
+		requests: []*datanodev1.PushBlockRequest{
+			{Data: &datanodev1.PushBlockRequest_Metadata{Metadata: &datanodev1.BlockMetadata{BlockId: "incoming"}}},
+			{Data: &datanodev1.PushBlockRequest_Chunk{Chunk: []byte("data")}},
+		},
+	}), "the incoming transfer is not stuck behind the outgoing one")
+
+	t.Run("refuses a bad token before taking a slot", func(t *testing.T) {
+		worker.BlockTokens = testKeyRing(t, time.Hour)
+		defer func() { worker.BlockTokens = nil }()
+
+		held, err := worker.QoS.AdmitPush(context.Background(), IOClassBackground)
+		require.NoError(t, err)
+		defer held()
+
+		start := time.Now()
+		err = worker.PushBlock(&mockPushBlockServer{
+			ctx: background,
+			requests: []*datanodev1.PushBlockRequest{
+				{Data: &datanodev1.PushBlockRequest_Metadata{Metadata: &datanodev1.BlockMetadata{BlockId: "forged", BlockToken: "forged"}}},
+			},
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Less(t, time.Since(start), ioAdmissionTimeout)
+	})
+}
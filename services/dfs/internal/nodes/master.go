@@ -1,14 +1,18 @@
 package nodes
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
@@ -16,13 +20,19 @@ import (
 	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
 
 	"github.com/google/uuid"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
 	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/razvanmarinn/dfs/internal/metrics"
+	"google.golang.org/grpc"
 )
 
 var singleInstance *MasterNode
 
 const storageDir = "/data"
 
+// DefaultReplicationFactor is the number of healthy replicas the master expects per block.
+const DefaultReplicationFactor = 1
+
 type OpType int
 
 const (
@@ -30,18 +40,68 @@ const (
 	OpDeleteFile
 	OpRegisterDir
 	OpRenameFile
+	OpSetStoragePolicy
+	OpSetAttr
+	OpPackFiles
+	OpRefBlocks
+	OpConcatFiles
+	OpRemoveReplicas
 )
 
+// RenamePayload is the op-log payload of OpRenameFile.
+type RenamePayload struct {
+	OldPath string `json:"oldPath"`
+	NewPath string `json:"newPath"`
+}
+
+// RegisterFilePayload is the op-log payload of OpRegisterFile: the file's
+// inode and the metadata of the blocks it does not share with other files,
+// which standbys have no other way to learn. Entries written before it
+// carry the inode alone.
+type RegisterFilePayload struct {
+	*Inode
+	BlockMeta []BlockMetadata `json:"blockMeta,omitempty"`
+	// Refs are the dedup blocks whose references the commit changed.
+	Refs *BlockRefsPayload `json:"refs,omitempty"`
+}
+
+// DeleteFilePayload is the op-log payload of OpDeleteFile: the deleted inode
+// and the dedup blocks whose references its deletion changed.
+type DeleteFilePayload struct {
+	*Inode
+	Refs *BlockRefsPayload `json:"refs,omitempty"`
+}
+
+// ConcatPayload is the op-log payload of OpConcatFiles: the registration of
+// the target and the source files it replaces, which are removed with it.
+type ConcatPayload struct {
+	RegisterFilePayload
+	Sources []string `json:"sources"`
+}
+
 type OperationLogEntry struct {
+	// TxID numbers operations in log order. The leader assigns it and
+	// standbys keep the leader's.
+	TxID      int64       `json:"txId,omitempty"`
 	OpType    OpType      `json:"opType"`
 	Timestamp int64       `json:"timestamp"`
 	Payload   interface{} `json:"payload"`
 }
 
+// opLogRecord is an OperationLogEntry as read back from the op log.
+type opLogRecord struct {
+	TxID      int64           `json:"txId,omitempty"`
+	OpType    OpType          `json:"opType"`
+	Timestamp int64           `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
 type MasterNode struct {
 	ID string
 
 	Namespace map[string]*Inode
+	// LastGeneration is the generation of the latest file commit.
+	LastGeneration int64
 
 	BlockMap     map[uuid.UUID]*BlockMetadata
 	opLogFile    *os.File
@@ -50,81 +110,343 @@ type MasterNode struct {
 	lock         sync.RWMutex
 	IsActive     bool
 	Replicator   *Replicator
+	// BlockTokens signs the block tokens handed to clients and workers; nil
+	// when block tokens are off.
+	BlockTokens *blocktoken.KeyRing
+	// Umask is cleared from the mode of new inodes.
+	Umask uint32
+
+	safeMode safeModeState
+
+	// lastTxID is the TxID of the latest operation in the op log.
+	lastTxID atomic.Int64
+	// appliedTxID is the TxID up to which every operation is applied. It
+	// stays behind lastTxID on a standby that missed an entry.
+	appliedTxID atomic.Int64
+	// events keeps the recent namespace changes for watches.
+	events eventJournal
+	// inodes indexes the namespace for walking Children lists; nil until
+	// first used.
+	inodes *inodeIndex
+
+	// moverLock serializes runs of MoveBlocks.
+	moverLock sync.Mutex
+	// packerLock serializes runs of PackSmallFiles.
+	packerLock sync.Mutex
+
+	// releasedBlocks are the blocks waiting for their replicas to be
+	// deleted, in the order they were released.
+	releasedBlocks []releasedBlock
+
+	// dedupIndex maps the content of dedup blocks to them; built lazily.
+	dedupIndex map[dedupKey]uuid.UUID
+
+	// checkpointPath overrides where SaveNamespace writes the namespace image.
+	checkpointPath string
+	workerCount    int
+	workerPort     int
 }
 
 func (mn *MasterNode) appendToLog(op OperationLogEntry) error {
 	mn.opLock.Lock()
 	defer mn.opLock.Unlock()
 
+	assigned := op.TxID == 0
+	if assigned {
+		op.TxID = mn.lastTxID.Load() + 1
+	}
 	data, _ := json.Marshal(op)
 
+	start := time.Now()
 	if _, err := mn.opLogFile.Write(append(data, '\n')); err != nil {
 		return err
 	}
 	mn.opLogFile.Sync()
+	metrics.MasterOpLogAppendDuration.Observe(time.Since(start).Seconds())
+	mn.advanceTxID(op.TxID, assigned)
+
 	if mn.IsActive && mn.Replicator != nil {
 		if err := mn.Replicator.SendToQuorum(context.Background(), op); err != nil {
+			// The entry is already in the local log but may never reach the
+			// quorum, so the master exits rather than serve it; a standby with
+			// the quorum's view takes over.
+			metrics.MasterReplicationQuorumFailuresTotal.Inc()
 			log.Fatalf("Critical: Lost Quorum during write: %v", err)
 		}
 	}
 
+	mn.events.record(op.TxID, namespaceEvents(op))
 	return nil
 }
 
-func NewMasterNode() *MasterNode {
-	logPath := filepath.Join(storageDir, "master_op.log")
+// advanceTxID records that the operation txID is in the op log. The applied
+// TxID only moves over contiguous TxIDs, except for those the leader assigns:
+// a standby that missed an entry keeps the TxID before it.
+func (mn *MasterNode) advanceTxID(txID int64, assigned bool) {
+	mn.lastTxID.Store(max(mn.lastTxID.Load(), txID))
+	if assigned || txID == mn.appliedTxID.Load()+1 {
+		mn.appliedTxID.Store(txID)
+	}
+}
 
+func NewMasterNode() *MasterNode {
 	if err := os.MkdirAll(storageDir, 0755); err != nil {
 		log.Printf("Warning: could not create storage dir: %v", err)
 	}
+	return openMasterNode(filepath.Join(storageDir, "master_op.log"), NewMasterNodeState())
+}
 
-	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Failed to open operation log at %s: %v", logPath, err)
-	}
+// AppliedTxID returns the TxID up to which the master has applied every
+// operation. On the leader every operation up to it is visible to reads that
+// start afterwards; on a standby it is the latest operation replicated from
+// the leader without a gap before it.
+func (mn *MasterNode) AppliedTxID() int64 {
+	return mn.appliedTxID.Load()
+}
 
-	return &MasterNode{
-		ID:        uuid.New().String(),
-		Namespace: make(map[string]*Inode),
-		BlockMap:  make(map[uuid.UUID]*BlockMetadata),
-		opLogFile: f,
-	}
+// MissedOps reports whether a standby received operations past one it never
+// got, so its namespace lacks an operation that later ones build on.
+func (mn *MasterNode) MissedOps() bool {
+	return mn.appliedTxID.Load() < mn.lastTxID.Load()
 }
 
-func (mn *MasterNode) ApplyReplicatedLog(opType OpType, payload []byte) error {
+// ApplyReplicatedLog applies an operation the leader logged and appends it to
+// the local op log. Operations already in the log are skipped, so a resent
+// entry is applied once.
+func (mn *MasterNode) ApplyReplicatedLog(opType OpType, payload []byte, txID, timestamp int64) error {
 	mn.lock.Lock()
 	defer mn.lock.Unlock()
 
-	if opType == OpRegisterFile {
+	if txID != 0 && txID <= mn.lastTxID.Load() {
+		return nil
+	}
+	if last := mn.lastTxID.Load(); txID > last+1 {
+		log.Printf("Replication gap: received TxID %d after %d", txID, last)
+	}
+
+	mn.applyOpLocked(opType, payload)
+	op := OperationLogEntry{TxID: txID, OpType: opType, Timestamp: timestamp, Payload: json.RawMessage(payload)}
+	return mn.appendToLog(op)
+}
+
+// applyOpLocked applies a logged operation to the namespace. Standbys apply
+// the leader's operations with it and restarted masters the tail of their op
+// log. mn.lock must be held.
+func (mn *MasterNode) applyOpLocked(opType OpType, payload []byte) {
+	switch opType {
+	case OpRegisterFile:
+		var p RegisterFilePayload
+		json.Unmarshal(payload, &p)
+		if p.Inode != nil {
+			mn.applyRegisterFileLocked(p)
+		}
+	case OpConcatFiles:
+		var p ConcatPayload
+		json.Unmarshal(payload, &p)
+		if p.Inode == nil {
+			break
+		}
+		mn.applyRegisterFileLocked(p.RegisterFilePayload)
+		for _, src := range p.Sources {
+			if inode, ok := mn.Namespace[src]; ok && src != p.Path {
+				delete(mn.Namespace, src)
+				mn.removeChildLocked(filepath.Dir(src), inode.ID)
+			}
+		}
+	case OpRegisterDir:
 		var inode Inode
 		json.Unmarshal(payload, &inode)
-		mn.Namespace[inode.Path] = &inode
+		if _, ok := mn.Namespace[inode.Path]; !ok {
+			mn.Namespace[inode.Path] = &inode
+			mn.addChildLocked(filepath.Dir(inode.Path), &inode)
+		}
+	case OpDeleteFile:
+		var p DeleteFilePayload
+		json.Unmarshal(payload, &p)
+		if p.Inode == nil {
+			break
+		}
+		if existing, ok := mn.Namespace[p.Path]; ok {
+			mn.forgetBlocksLocked(existing, nil)
+		}
+		mn.applyBlockRefsLocked(p.Refs)
+		delete(mn.Namespace, p.Path)
+		mn.removeChildLocked(filepath.Dir(p.Path), p.ID)
+	case OpRenameFile:
+		// Entries written before subtree renames moved one inode each, parents
+		// first; the first moves the whole subtree and the rest find nothing.
+		var p RenamePayload
+		json.Unmarshal(payload, &p)
+		mn.applyRenameLocked(p.OldPath, p.NewPath)
+	case OpSetStoragePolicy:
+		var p StoragePolicyPayload
+		json.Unmarshal(payload, &p)
+		if inode, ok := mn.Namespace[p.Path]; ok {
+			inode.StoragePolicy = p.Policy
+		}
+	case OpSetAttr:
+		var p AttrPayload
+		json.Unmarshal(payload, &p)
+		if inode, ok := mn.Namespace[p.Path]; ok {
+			applyAttrs(inode, p)
+		}
+	case OpPackFiles:
+		var p PackPayload
+		json.Unmarshal(payload, &p)
+		// The leader deletes the old blocks once they are due; a standby
+		// takes over their deletion if it leads by then.
+		mn.releasePackedLocked(mn.applyPackLocked(p), p.Refs)
+	case OpRefBlocks:
+		var p BlockRefsPayload
+		json.Unmarshal(payload, &p)
+		for _, meta := range mn.applyBlockRefsLocked(&p) {
+			mn.deleteLaterLocked(meta)
+		}
+	case OpRemoveReplicas:
+		var p RemoveReplicasPayload
+		json.Unmarshal(payload, &p)
+		mn.applyRemoveReplicasLocked(p)
+	}
+}
+
+// applyRegisterFileLocked adds the file of p to the namespace, replacing any
+// file at its path, and the metadata of its blocks to the block map. mn.lock
+// must be held.
+func (mn *MasterNode) applyRegisterFileLocked(p RegisterFilePayload) {
+	inode := p.Inode
+	if existing, ok := mn.Namespace[inode.Path]; ok {
+		mn.removeChildLocked(filepath.Dir(inode.Path), existing.ID)
+		// A standby that leads before the old generation's blocks are due
+		// deletes them.
+		for _, meta := range mn.forgetBlocksLocked(existing, inode.Blocks) {
+			mn.deleteLaterLocked(meta)
+		}
 	}
+	for _, meta := range p.BlockMeta {
+		if known, ok := mn.BlockMap[meta.BlockID]; ok {
+			known.Size, known.Checksum = meta.Size, meta.Checksum
+			continue
+		}
+		meta := meta
+		if meta.Replicas == nil {
+			meta.Replicas = make([]uuid.UUID, 0)
+		}
+		mn.BlockMap[meta.BlockID] = &meta
+	}
+	for _, meta := range mn.applyBlockRefsLocked(p.Refs) {
+		mn.deleteLaterLocked(meta)
+	}
+	mn.Namespace[inode.Path] = inode
+	mn.addChildLocked(filepath.Dir(inode.Path), inode)
+	mn.LastGeneration = max(mn.LastGeneration, inode.Generation)
+}
+
+// replayOpLog applies the operations of the op log at path that come after
+// the image the master was loaded from, so a restarted master has every
+// operation it logged and continues their TxIDs. The events of the log seed
+// the watch journal. Entries without a TxID predate TxIDs and are in the
+// image.
+func (mn *MasterNode) replayOpLog(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	op := OperationLogEntry{OpType: opType, Payload: payload /* logic needed */}
-	mn.appendToLog(op)
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
 
+	image := mn.lastTxID.Load()
+	replayed := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec opLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A crash mid-append leaves a torn last line.
+			log.Printf("Skipping unreadable op log entry: %v", err)
+			continue
+		}
+		if rec.TxID == 0 {
+			continue
+		}
+		op := OperationLogEntry{TxID: rec.TxID, OpType: rec.OpType, Timestamp: rec.Timestamp, Payload: rec.Payload}
+		mn.events.record(rec.TxID, namespaceEvents(op))
+		if rec.TxID <= image {
+			continue
+		}
+		mn.applyOpLocked(rec.OpType, rec.Payload)
+		mn.advanceTxID(rec.TxID, false)
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if replayed > 0 {
+		log.Printf("Replayed %d operations after TxID %d from %s", replayed, image, path)
+	}
 	return nil
 }
 
+// forgetBlocksLocked drops the blocks of a replaced or deleted file that
+// are not in keep from the block map of a standby and returns them. Dedup
+// blocks follow their logged reference counts and container blocks the
+// packer instead. mn.lock must be held.
+func (mn *MasterNode) forgetBlocksLocked(old *Inode, keep []uuid.UUID) []*BlockMetadata {
+	var forgotten []*BlockMetadata
+	for _, blockID := range old.Blocks {
+		meta, ok := mn.BlockMap[blockID]
+		if !ok || meta.Container || meta.ContentHash != "" || slices.Contains(keep, blockID) {
+			continue
+		}
+		delete(mn.BlockMap, blockID)
+		forgotten = append(forgotten, meta)
+	}
+	return forgotten
+}
+
 func NewMasterNodeWithState(state *MasterNodeState) *MasterNode {
 	if state.ID == "" {
 		return NewMasterNode()
 	}
+	return openMasterNode(filepath.Join(storageDir, "master_op.log"), state)
+}
 
-	logPath := filepath.Join(storageDir, "master_op.log")
+// openMasterNode loads a master from its namespace image and the op log at
+// logPath, which it keeps appending to.
+func openMasterNode(logPath string, state *MasterNodeState) *MasterNode {
+	id := state.ID
+	if id == "" {
+		id = uuid.New().String()
+	}
+	mn := &MasterNode{
+		ID:             id,
+		Namespace:      state.Namespace,
+		BlockMap:       state.BlockMap,
+		LastGeneration: state.LastGeneration,
+	}
+	mn.lastTxID.Store(state.LastTxID)
+	mn.appliedTxID.Store(state.LastTxID)
+	if err := mn.replayOpLog(logPath); err != nil {
+		log.Fatalf("Failed to replay operation log at %s: %v", logPath, err)
+	}
+	mn.events.skipTo(mn.lastTxID.Load())
 
 	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatalf("Failed to open operation log at %s: %v", logPath, err)
 	}
+	mn.opLogFile = f
 
-	return &MasterNode{
-		ID:        state.ID,
-		Namespace: state.Namespace,
-		BlockMap:  state.BlockMap,
-		opLogFile: f,
+	// Images written before generations were tracked have no counter.
+	for _, inode := range mn.Namespace {
+		mn.LastGeneration = max(mn.LastGeneration, inode.Generation)
 	}
+	mn.repairTreeLocked()
+	return mn
 }
 
 func GetMasterNodeInstance() *MasterNode {
@@ -133,7 +455,7 @@ func GetMasterNodeInstance() *MasterNode {
 		defer lock.Unlock()
 		if singleInstance == nil {
 			state := NewMasterNodeState()
-			if err := state.LoadStateFromFile(); err != nil {
+			if err := state.LoadStateFromFile(filepath.Join(storageDir, MasterStateFile)); err != nil {
 				log.Fatalf("Failed to load master node state: %v", err)
 			}
 			singleInstance = NewMasterNodeWithState(state)
@@ -222,12 +544,24 @@ func (mn *MasterNode) GetWorkerAllocatedForBatch(batchID uuid.UUID) (string, err
 	return workerID.String(), nil
 }
 
-func (mn *MasterNode) InitializeLoadBalancer(numWorkers int, basePort int) error {
-	lb := load_balancer.NewLoadBalancer(numWorkers, basePort)
+func (mn *MasterNode) InitializeLoadBalancer(numWorkers int, basePort int, dialOpts ...grpc.DialOption) error {
+	lb := load_balancer.NewLoadBalancer(numWorkers, basePort, dialOpts...)
 	mn.LoadBalancer = lb
+	mn.workerCount = numWorkers
+	mn.workerPort = basePort
 	return nil
 }
 
+// blockToken returns a token for ops on blockID, or "" when block tokens are
+// off or signing fails.
+func (mn *MasterNode) blockToken(blockID uuid.UUID, projectID string, ops ...blocktoken.Op) string {
+	token, err := mn.BlockTokens.Issue(blockID.String(), projectID, ops...)
+	if err != nil {
+		log.Printf("Failed to issue block token for %s: %v", blockID, err)
+	}
+	return token
+}
+
 func (mn *MasterNode) CloseLoadBalancer() {
 	if mn.LoadBalancer != nil {
 		mn.LoadBalancer.Close()
@@ -238,15 +572,22 @@ func (mn *MasterNode) ensureDirectory(fullPath, name, ownerID, projectID string)
 		return
 	}
 
+	now := time.Now()
 	dirInode := &Inode{
 		ID:        uuid.New().String(),
 		Name:      name,
 		Path:      fullPath,
+		OwnerID:   ownerID,
 		Type:      DirType,
 		ProjectID: projectID,
 		Size:      0,
 		Blocks:    nil,
 		Children:  make([]string, 0),
+		ModTime:   now,
+		ATime:     now,
+		CTime:     now,
+		Mode:      mn.newMode(DirType, ownerID),
+		Group:     mn.newGroup(filepath.Dir(fullPath), projectID),
 	}
 
 	op := OperationLogEntry{
@@ -257,6 +598,7 @@ func (mn *MasterNode) ensureDirectory(fullPath, name, ownerID, projectID string)
 	_ = mn.appendToLog(op)
 
 	mn.Namespace[fullPath] = dirInode
+	mn.addChildLocked(filepath.Dir(fullPath), dirInode)
 	log.Printf("Created directory: %s", fullPath)
 }
 
@@ -264,60 +606,178 @@ func (mn *MasterNode) AllocateBlock(req *coordinatorv1.AllocateBlockRequest) (*c
 	mn.lock.Lock()
 	defer mn.lock.Unlock()
 
-	newBlockID := uuid.New()
-
-	workerID, workerMeta := mn.LoadBalancer.GetNextClient()
+	if err := mn.checkWritableLocked(); err != nil {
+		return nil, err
+	}
 
-	workerUUID, err := uuid.Parse(workerID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse worker uuid: %v", err)
+	replication := int(req.Replication)
+	if replication <= 0 {
+		replication = DefaultReplicationFactor
+	}
+	if err := checkStoragePolicy(req.StoragePolicy); err != nil {
+		return nil, err
+	}
+	policy := req.StoragePolicy
+	if policy == "" {
+		policy = mn.storagePolicyLocked(filepath.Dir(filepath.Clean(req.FilePath)))
+	}
+	if req.ContentHash != "" {
+		if !validContentHash(req.ContentHash) {
+			return nil, fmt.Errorf("invalid content hash %q", req.ContentHash)
+		}
+		if resp := mn.allocateDedupLocked(req); resp != nil {
+			return resp, nil
+		}
 	}
 
-	fullAddress := fmt.Sprintf("%s:%d", workerMeta.Ip, workerMeta.Port)
+	newBlockID := uuid.New()
 
-	targetNodes := []*commonv1.BlockLocation{
-		{
+	workerIDs, workers := mn.LoadBalancer.GetNextClients(replication, storagePolicyTypes[policy], req.ExcludedWorkerIds...)
+	// Excluded workers failed an earlier attempt at the block. They fill the
+	// replicas that no other worker is left for, so retries keep the
+	// replication, but never take the primary.
+	if len(workerIDs) > 0 && len(workerIDs) < replication && len(req.ExcludedWorkerIds) > 0 {
+		moreIDs, more := mn.LoadBalancer.GetNextClients(replication-len(workerIDs), storagePolicyTypes[policy], workerIDs...)
+		workerIDs, workers = append(workerIDs, moreIDs...), append(workers, more...)
+	}
+	if len(workerIDs) == 0 {
+		if len(req.ExcludedWorkerIds) > 0 {
+			return nil, fmt.Errorf("no in-service workers available outside %d excluded", len(req.ExcludedWorkerIds))
+		}
+		return nil, fmt.Errorf("no in-service workers available")
+	}
+
+	targetNodes := make([]*commonv1.BlockLocation, 0, len(workerIDs))
+	replicas := make([]uuid.UUID, 0, len(workerIDs))
+	for i, workerID := range workerIDs {
+		workerUUID, err := uuid.Parse(workerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse worker uuid: %v", err)
+		}
+		replicas = append(replicas, workerUUID)
+		targetNodes = append(targetNodes, &commonv1.BlockLocation{
 			BlockId:  newBlockID.String(),
 			WorkerId: workerID,
-			Address:  fullAddress,
-		},
+			Address:  fmt.Sprintf("%s:%d", workers[i].Ip, workers[i].Port),
+		})
+	}
+	if len(workerIDs) < replication {
+		log.Printf("Block %s gets %d of %d replicas: not enough workers", newBlockID, len(workerIDs), replication)
 	}
 
 	mn.BlockMap[newBlockID] = &BlockMetadata{
 		BlockID:  newBlockID,
 		Size:     req.SizeBytes,
 		Checksum: 0,
-		Replicas: []uuid.UUID{workerUUID},
+		Replicas: replicas,
 	}
 
-	log.Printf("Allocated block %s to worker %s", newBlockID, workerID)
+	log.Printf("Allocated block %s to workers %v (%s)", newBlockID, workerIDs, policy)
 
 	return &coordinatorv1.AllocateBlockResponse{
 		BlockId:         newBlockID.String(),
 		TargetDatanodes: targetNodes,
+		BlockToken:      mn.blockToken(newBlockID, req.ProjectId, blocktoken.OpWrite),
 	}, nil
 }
+
+// AbandonBlock forgets a block that was allocated but never committed, e.g.
+// because the client failed to write it. Blocks referenced by a file are kept.
+func (mn *MasterNode) AbandonBlock(blockID uuid.UUID) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	meta, exists := mn.BlockMap[blockID]
+	if !exists {
+		return nil
+	}
+	// A dedup hit gives its provisional reference back.
+	if len(meta.Holds) > 0 {
+		if err := mn.checkWritableLocked(); err != nil {
+			return err
+		}
+		return mn.releaseHoldLocked(blockID)
+	}
+
+	for _, inode := range mn.Namespace {
+		for _, b := range inode.Blocks {
+			if b == blockID {
+				return fmt.Errorf("block %s is committed to %s", blockID, inode.Path)
+			}
+		}
+	}
+
+	delete(mn.BlockMap, blockID)
+	log.Printf("Abandoned block %s (replicas %v)", blockID, meta.Replicas)
+
+	if mn.LoadBalancer == nil {
+		return nil
+	}
+	// The partial copy may still sit on the worker; remove it if reachable.
+	for _, replica := range meta.Replicas {
+		go mn.deleteReplica(blockID, replica)
+	}
+	return nil
+}
+
+func (mn *MasterNode) deleteReplica(blockID, workerID uuid.UUID) {
+	client, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerID.String())
+	if err != nil || client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req := &datanodev1.DeleteBlockRequest{
+		BlockId:    blockID.String(),
+		BlockToken: mn.blockToken(blockID, "", blocktoken.OpDelete),
+	}
+	if _, err := client.DeleteBlock(ctx, req); err != nil {
+		log.Printf("Failed to delete abandoned block %s on worker %s: %v", blockID, workerID, err)
+	}
+}
+
 func (mn *MasterNode) commitFileInternal(req *coordinatorv1.CommitFileRequest) (*Inode, error) {
+	return mn.registerFileLocked(req, nil)
+}
+
+// registerFileLocked commits the file of req. The sources of a concat are
+// removed in the same op-log entry, so the target never exists next to them
+// and they are never gone without it. mn.lock must be held.
+func (mn *MasterNode) registerFileLocked(req *coordinatorv1.CommitFileRequest, sources []*Inode) (*Inode, error) {
 	if req.ProjectId == "" || req.FilePath == "" {
 		return nil, fmt.Errorf("invalid project_id or file_path")
 	}
 	fullPath := filepath.Clean(req.FilePath)
 	dirPath := filepath.Dir(fullPath)
 
+	if req.BlockSize < 0 || req.Replication < 0 {
+		return nil, fmt.Errorf("invalid block_size %d or replication %d", req.BlockSize, req.Replication)
+	}
+	if err := checkStoragePolicy(req.StoragePolicy); err != nil {
+		return nil, err
+	}
+
+	existing := mn.Namespace[fullPath]
+	if err := checkWriteMode(fullPath, existing, req.Mode, req.IfGenerationMatch); err != nil {
+		return nil, err
+	}
+
 	parts := strings.Split(fullPath, string(filepath.Separator))
 	if len(parts) > 0 {
 		rootDir := parts[0]
-		mn.ensureDirectory(rootDir, rootDir, "system", req.ProjectId)
+		mn.ensureDirectory(rootDir, rootDir, req.OwnerId, req.ProjectId)
 	}
 
 	if len(parts) > 2 {
-		mn.ensureDirectory(dirPath, filepath.Base(dirPath), "system", req.ProjectId)
+		mn.ensureDirectory(dirPath, filepath.Base(dirPath), req.OwnerId, req.ProjectId)
 	}
 
 	var totalSize int64
 	blockUUIDs := make([]uuid.UUID, 0, len(req.Blocks))
+	var extents []*Extent
 
-	for _, b := range req.Blocks {
+	for i, b := range req.Blocks {
 		bid, err := uuid.Parse(b.BlockId)
 		if err != nil {
 			return nil, fmt.Errorf("invalid block uuid %s: %v", b.BlockId, err)
@@ -326,6 +786,24 @@ func (mn *MasterNode) commitFileInternal(req *coordinatorv1.CommitFileRequest) (
 		blockUUIDs = append(blockUUIDs, bid)
 		totalSize += b.Size
 
+		// Extents of container blocks, e.g. from concatenated packed files,
+		// are references into a block stored as a whole elsewhere.
+		if b.Packed {
+			if extents == nil {
+				extents = make([]*Extent, len(req.Blocks))
+			}
+			if err := mn.checkExtentLocked(bid, req.ProjectId, b); err != nil {
+				return nil, err
+			}
+			extents[i] = &Extent{Offset: b.Offset, Length: b.Size, Checksum: uint32(b.Checksum)}
+			continue
+		}
+		if b.ContentHash != "" {
+			if err := mn.checkDedupBlockLocked(bid, req.ProjectId, b); err != nil {
+				return nil, err
+			}
+		}
+
 		if _, exists := mn.BlockMap[bid]; !exists {
 			mn.BlockMap[bid] = &BlockMetadata{
 				BlockID:  bid,
@@ -334,51 +812,196 @@ func (mn *MasterNode) commitFileInternal(req *coordinatorv1.CommitFileRequest) (
 				Replicas: make([]uuid.UUID, 0),
 			}
 		} else {
+			// Streamed blocks are allocated before their final size is known.
+			mn.BlockMap[bid].Size = b.Size
 			mn.BlockMap[bid].Checksum = uint32(b.Checksum)
 		}
 	}
 
+	now := time.Now()
 	inode := &Inode{
-		ID:        uuid.New().String(),
-		Name:      filepath.Base(fullPath),
-		Path:      fullPath,
-		Type:      FileType,
-		ProjectID: req.ProjectId,
-		OwnerID:   req.OwnerId,
-		Size:      totalSize,
-		Blocks:    blockUUIDs,
+		ID:         uuid.New().String(),
+		Name:       filepath.Base(fullPath),
+		Path:       fullPath,
+		Type:       FileType,
+		ProjectID:  req.ProjectId,
+		OwnerID:    req.OwnerId,
+		Size:       totalSize,
+		Blocks:     blockUUIDs,
+		Extents:    extents,
+		ModTime:    now,
+		ATime:      now,
+		CTime:      now,
+		Generation: mn.LastGeneration + 1,
+
+		Mode:  mn.newMode(FileType, req.OwnerId),
+		Group: mn.newGroup(dirPath, req.ProjectId),
+
+		BlockSize:     req.BlockSize,
+		Replication:   req.Replication,
+		StoragePolicy: req.StoragePolicy,
 	}
 
+	changes := make(refChanges)
+	for i, b := range req.Blocks {
+		if b.ContentHash != "" && !b.Packed {
+			mn.addRefLocked(changes, blockUUIDs[i], req.ProjectId, b.ContentHash)
+		}
+	}
+	// The target of a concat takes the sources' blocks over; only their
+	// references move to it.
+	replaced := slices.DeleteFunc(slices.Clone(sources), func(src *Inode) bool { return src.Path == fullPath })
+	if existing != nil {
+		replaced = append(replaced, existing)
+	}
+	for _, old := range replaced {
+		for _, blockID := range old.Blocks {
+			mn.dropRefLocked(changes, blockID)
+		}
+	}
+
+	payload := RegisterFilePayload{Inode: inode, Refs: changes.payload()}
+	for i, bid := range blockUUIDs {
+		if meta, ok := mn.BlockMap[bid]; ok && !req.Blocks[i].Packed {
+			payload.BlockMeta = append(payload.BlockMeta, BlockMetadata{
+				BlockID:  bid,
+				Size:     meta.Size,
+				Checksum: meta.Checksum,
+				Replicas: slices.Clone(meta.Replicas),
+			})
+		}
+	}
 	op := OperationLogEntry{
 		OpType:    OpRegisterFile,
 		Timestamp: time.Now().Unix(),
-		Payload:   inode,
+		Payload:   payload,
+	}
+	if sources != nil {
+		concat := ConcatPayload{RegisterFilePayload: payload}
+		for _, src := range sources {
+			concat.Sources = append(concat.Sources, src.Path)
+		}
+		op.OpType, op.Payload = OpConcatFiles, concat
 	}
 	if err := mn.appendToLog(op); err != nil {
 		return nil, fmt.Errorf("failed to write operation log: %w", err)
 	}
 
+	forgotten := mn.applyBlockRefsLocked(payload.Refs)
 	mn.Namespace[inode.Path] = inode
+	mn.LastGeneration = inode.Generation
 
-	if parent, ok := mn.Namespace[dirPath]; ok {
-		parent.Children = append(parent.Children, inode.ID)
-	} else {
+	if existing != nil {
+		mn.removeChildLocked(dirPath, existing.ID)
+		mn.releaseBlocksLocked(existing, blockUUIDs)
+		log.Printf("Replaced %s generation %d with %d", fullPath, existing.Generation, inode.Generation)
+	}
+
+	if _, ok := mn.Namespace[dirPath]; !ok {
 		log.Printf("Warning: Parent directory %s not found for file %s", dirPath, inode.Path)
 	}
+	mn.addChildLocked(dirPath, inode)
+
+	for _, src := range sources {
+		// A source that was also the target has already been replaced.
+		if src.Path == inode.Path {
+			continue
+		}
+		delete(mn.Namespace, src.Path)
+		mn.removeChildLocked(filepath.Dir(src.Path), src.ID)
+	}
+	for _, meta := range forgotten {
+		mn.deleteLaterLocked(meta)
+	}
 
 	return inode, nil
 }
 
-func (mn *MasterNode) CommitFile(req *coordinatorv1.CommitFileRequest) (*Inode, error) {
+// checkWriteMode reports whether a commit in mode may replace existing, the
+// inode currently at path, if any.
+func checkWriteMode(path string, existing *Inode, mode coordinatorv1.WriteMode, ifGenerationMatch int64) error {
+	if existing != nil && existing.Type != FileType {
+		return fmt.Errorf("%s is a directory: %w", path, ErrExists)
+	}
+
+	switch mode {
+	case coordinatorv1.WriteMode_WRITE_MODE_CREATE:
+		if existing != nil {
+			return fmt.Errorf("file %s: %w", path, ErrExists)
+		}
+	case coordinatorv1.WriteMode_WRITE_MODE_IF_GENERATION_MATCH:
+		var current int64
+		if existing != nil {
+			current = existing.Generation
+		}
+		if current != ifGenerationMatch {
+			return fmt.Errorf("file %s is at generation %d, not %d: %w", path, current, ifGenerationMatch, ErrGenerationMismatch)
+		}
+	}
+	return nil
+}
+
+// releaseBlocksLocked forgets the blocks of a replaced file that are not in
+// keep and schedules their deletion on every replica after BlockDeleteDelay,
+// so readers of the old generation can finish. mn.lock must be held.
+func (mn *MasterNode) releaseBlocksLocked(old *Inode, keep []uuid.UUID) {
+	// Dedup blocks went with the references the commit logged.
+	for _, meta := range mn.forgetBlocksLocked(old, keep) {
+		mn.deleteLaterLocked(meta)
+	}
+}
+
+// CommitFile registers the file of req for caller, who owns it.
+func (mn *MasterNode) CommitFile(caller Caller, req *coordinatorv1.CommitFileRequest) (*Inode, error) {
+	// Denied commits cost no checksum calls.
+	if err := mn.CheckCreate(caller, req.FilePath); err != nil {
+		return nil, err
+	}
+	if err := mn.verifyCommitChecksums(req.Blocks); err != nil {
+		return nil, err
+	}
+
 	mn.lock.Lock()
 	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return nil, err
+	}
+	if err := mn.checkCreateLocked(caller, req.FilePath); err != nil {
+		return nil, err
+	}
+	req.OwnerId = caller.owner(req.OwnerId)
 	return mn.commitFileInternal(req)
 }
 
-func (mn *MasterNode) CommitCompaction(req *coordinatorv1.CommitCompactionRequest) error {
+func (mn *MasterNode) CommitCompaction(caller Caller, req *coordinatorv1.CommitCompactionRequest) error {
+	if req.NewFile != nil {
+		if err := mn.CheckCreate(caller, req.NewFile.FilePath); err != nil {
+			return err
+		}
+		if err := mn.verifyCommitChecksums(req.NewFile.Blocks); err != nil {
+			return err
+		}
+	}
+
 	mn.lock.Lock()
 	defer mn.lock.Unlock()
 
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+	for _, oldPath := range req.OldFilePaths {
+		if err := mn.checkEntryLocked(caller, oldPath); err != nil {
+			return err
+		}
+	}
+	if req.NewFile != nil {
+		if err := mn.checkCreateLocked(caller, req.NewFile.FilePath); err != nil {
+			return err
+		}
+		req.NewFile.OwnerId = caller.owner(req.NewFile.OwnerId)
+	}
+
 	log.Printf("Starting Atomic Swap for Compaction. New File: %s", req.NewFile.FilePath)
 
 	_, err := mn.commitFileInternal(req.NewFile)
@@ -388,117 +1011,420 @@ func (mn *MasterNode) CommitCompaction(req *coordinatorv1.CommitCompactionReques
 
 	for _, oldPath := range req.OldFilePaths {
 		path := filepath.Clean(oldPath)
-
-		inode, exists := mn.Namespace[path]
-		if !exists {
+		if !mn.deleteFileLocked(path) {
 			log.Printf("Warning: Compaction tried to delete non-existent file: %s", path)
+		}
+	}
+
+	log.Printf("Compaction Swap Complete. Removed %d files.", len(req.OldFilePaths))
+	return nil
+}
+
+// DeleteFile removes the file at path and deletes its blocks on every
+// replica. A directory is only removed, with everything below it, when
+// recursive is set.
+func (mn *MasterNode) DeleteFile(caller Caller, path string, recursive bool) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+	if err := mn.checkEntryLocked(caller, path); err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	inode, ok := mn.Namespace[path]
+	if ok && inode.Type == FileType {
+		mn.deleteFileLocked(path)
+		return nil
+	}
+
+	var paths []string
+	prefix := path + string(filepath.Separator)
+	for p := range mn.Namespace {
+		if p == path || strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return ErrNotFound
+	}
+	if !recursive {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	// Children before their directories.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, p := range paths {
+		mn.deleteFileLocked(p)
+	}
+	log.Printf("Deleted %s and %d paths below it", path, len(paths)-1)
+	return nil
+}
+
+// Rename moves the file or directory tree at src to dst. dst must not exist
+// and its parent directories are created as needed.
+func (mn *MasterNode) Rename(caller Caller, src, dst string) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+	if err := mn.checkEntryLocked(caller, src); err != nil {
+		return err
+	}
+	if err := mn.checkEntryLocked(caller, dst); err != nil {
+		return err
+	}
+
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	if src == dst {
+		return nil
+	}
+	if strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		return fmt.Errorf("cannot move %s into itself", src)
+	}
+	if _, ok := mn.Namespace[dst]; ok {
+		return fmt.Errorf("%s: %w", dst, ErrExists)
+	}
+
+	root, ok := mn.Namespace[src]
+	if !ok {
+		return fmt.Errorf("%s: %w", src, ErrNotFound)
+	}
+	if err := mn.renameLocked(src, dst, root.OwnerID, root.ProjectID); err != nil {
+		return err
+	}
+
+	log.Printf("Renamed %s to %s", src, dst)
+	return nil
+}
+
+// renameLocked moves src and everything below it to dst, creating dst's
+// parent directories for ownerID and projectID. mn.lock must be held.
+func (mn *MasterNode) renameLocked(src, dst, ownerID, projectID string) error {
+	dir := ""
+	for _, part := range strings.Split(filepath.Dir(dst), string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		mn.ensureDirectory(dir, part, ownerID, projectID)
+	}
+
+	// The whole subtree moves in one entry, so neither a restart nor a
+	// standby ever sees part of it moved.
+	op := OperationLogEntry{
+		OpType:    OpRenameFile,
+		Timestamp: time.Now().Unix(),
+		Payload:   RenamePayload{OldPath: src, NewPath: dst},
+	}
+	if err := mn.appendToLog(op); err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	mn.applyRenameLocked(src, dst)
+	return nil
+}
+
+// applyRenameLocked moves src and everything below it to dst. Directories
+// keep their children, which are listed by ID. mn.lock must be held.
+func (mn *MasterNode) applyRenameLocked(src, dst string) {
+	var moving []*Inode
+	prefix := src + string(filepath.Separator)
+	for p, inode := range mn.Namespace {
+		if p == src || strings.HasPrefix(p, prefix) {
+			moving = append(moving, inode)
+		}
+	}
+	for _, inode := range moving {
+		delete(mn.Namespace, inode.Path)
+	}
+	for _, inode := range moving {
+		inode.Path = dst + strings.TrimPrefix(inode.Path, src)
+		inode.Name = filepath.Base(inode.Path)
+		mn.Namespace[inode.Path] = inode
+		if inode.Path != dst {
 			continue
 		}
+		inode.CTime = time.Now()
+		mn.removeChildLocked(filepath.Dir(src), inode.ID)
+		mn.addChildLocked(filepath.Dir(dst), inode)
+	}
+}
 
-		for _, blockID := range inode.Blocks {
-			blockMeta, ok := mn.BlockMap[blockID]
-			if !ok {
-				continue
-			}
+// Mkdirs creates the directory path and any missing parents for caller,
+// owned by the caller or, for superusers, by ownerID. projectID defaults to
+// the first path component, the project's namespace root.
+func (mn *MasterNode) Mkdirs(caller Caller, path, projectID, ownerID string) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
 
-			for _, replicaWorkerID := range blockMeta.Replicas {
-				client, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(replicaWorkerID.String())
-				if err != nil {
-					log.Printf("Error getting client for worker %s to delete block %s: %v", replicaWorkerID, blockID, err)
-					continue
-				}
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+	if err := mn.checkEntryLocked(caller, path); err != nil {
+		return err
+	}
+	ownerID = caller.owner(ownerID)
 
-				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-				_, err = client.DeleteBlock(ctx, &datanodev1.DeleteBlockRequest{
-					BlockId: blockID.String(),
-				})
-				cancel()
+	path = filepath.Clean(path)
+	if path == "." || filepath.IsAbs(path) {
+		return fmt.Errorf("invalid directory path %q", path)
+	}
+	parts := strings.Split(path, string(filepath.Separator))
+	if projectID == "" {
+		projectID = parts[0]
+	}
 
-				if err != nil {
-					log.Printf("Failed to delete block %s on worker %s: %v", blockID, replicaWorkerID, err)
-				} else {
-					log.Printf("Physically deleted block %s on worker %s", blockID, replicaWorkerID)
-				}
+	dir := ""
+	for _, part := range parts {
+		dir = filepath.Join(dir, part)
+		if inode, ok := mn.Namespace[dir]; ok && inode.Type != DirType {
+			return fmt.Errorf("%s: %w", dir, ErrExists)
+		}
+	}
+
+	dir = ""
+	for _, part := range parts {
+		dir = filepath.Join(dir, part)
+		mn.ensureDirectory(dir, part, ownerID, projectID)
+	}
+	return nil
+}
+
+// ConcatFiles registers req.TargetPath as the blocks of req.SourcePaths in
+// order and drops the source files while keeping their blocks.
+func (mn *MasterNode) ConcatFiles(caller Caller, req *coordinatorv1.ConcatFilesRequest) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+	for _, src := range req.SourcePaths {
+		if err := mn.checkAccessLocked(caller, filepath.Clean(src), AccessRead); err != nil {
+			return err
+		}
+		if err := mn.checkEntryLocked(caller, src); err != nil {
+			return err
+		}
+	}
+	if err := mn.checkCreateLocked(caller, req.TargetPath); err != nil {
+		return err
+	}
+	req.OwnerId = caller.owner(req.OwnerId)
+
+	sources := make([]*Inode, 0, len(req.SourcePaths))
+	blocks := make([]*commonv1.BlockInfo, 0)
+	for _, p := range req.SourcePaths {
+		inode, ok := mn.Namespace[filepath.Clean(p)]
+		if !ok || inode.Type != FileType {
+			return fmt.Errorf("concat source %s: %w", p, ErrNotFound)
+		}
+		sources = append(sources, inode)
+
+		for i, blockID := range inode.Blocks {
+			info := &commonv1.BlockInfo{BlockId: blockID.String()}
+			if meta, ok := mn.BlockMap[blockID]; ok {
+				info.Size = meta.Size
+				info.Checksum = int64(meta.Checksum)
+				info.ContentHash = meta.ContentHash
+			}
+			if ext := inode.extent(i); ext != nil {
+				info.Size, info.Checksum = ext.Length, int64(ext.Checksum)
+				info.Offset, info.Packed = ext.Offset, true
 			}
+			blocks = append(blocks, info)
+		}
+	}
+
+	_, err := mn.registerFileLocked(&coordinatorv1.CommitFileRequest{
+		ProjectId:         req.ProjectId,
+		OwnerId:           req.OwnerId,
+		FilePath:          req.TargetPath,
+		FileFormat:        req.FileFormat,
+		Blocks:            blocks,
+		Mode:              req.Mode,
+		IfGenerationMatch: req.IfGenerationMatch,
+	}, sources)
+	if err != nil {
+		return err
+	}
 
+	log.Printf("Concatenated %d files into %s", len(sources), req.TargetPath)
+	return nil
+}
+
+// deleteFileLocked removes a file from the namespace and deletes its blocks on
+// every replica. It reports whether the file existed. mn.lock must be held.
+func (mn *MasterNode) deleteFileLocked(path string) bool {
+	inode, exists := mn.Namespace[path]
+	if !exists {
+		return false
+	}
+
+	changes := make(refChanges)
+	var dropped []*BlockMetadata
+	for _, blockID := range inode.Blocks {
+		if mn.dropRefLocked(changes, blockID) {
+			continue
+		}
+		if meta, ok := mn.BlockMap[blockID]; ok && !meta.Container {
 			delete(mn.BlockMap, blockID)
+			dropped = append(dropped, meta)
 		}
+	}
 
-		delete(mn.Namespace, path)
+	delete(mn.Namespace, path)
+	mn.removeChildLocked(filepath.Dir(path), inode.ID)
 
-		dirPath := filepath.Dir(path)
-		if parent, ok := mn.Namespace[dirPath]; ok {
-			newChildren := make([]string, 0)
-			for _, childID := range parent.Children {
-				if childID != inode.ID {
-					newChildren = append(newChildren, childID)
-				}
+	payload := DeleteFilePayload{Inode: inode, Refs: changes.payload()}
+	op := OperationLogEntry{
+		OpType:    OpDeleteFile,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	if err := mn.appendToLog(op); err != nil {
+		log.Printf("Error logging deletion for %s: %v", path, err)
+	}
+	dropped = append(dropped, mn.applyBlockRefsLocked(payload.Refs)...)
+
+	for _, meta := range dropped {
+		for _, replicaWorkerID := range meta.Replicas {
+			client, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(replicaWorkerID.String())
+			if err != nil {
+				log.Printf("Error getting client for worker %s to delete block %s: %v", replicaWorkerID, meta.BlockID, err)
+				continue
 			}
-			parent.Children = newChildren
-		}
 
-		op := OperationLogEntry{
-			OpType:    OpDeleteFile,
-			Timestamp: time.Now().Unix(),
-			Payload:   inode,
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			_, err = client.DeleteBlock(ctx, &datanodev1.DeleteBlockRequest{
+				BlockId:    meta.BlockID.String(),
+				BlockToken: mn.blockToken(meta.BlockID, "", blocktoken.OpDelete),
+			})
+			cancel()
+
+			if err != nil {
+				log.Printf("Failed to delete block %s on worker %s: %v", meta.BlockID, replicaWorkerID, err)
+			} else {
+				log.Printf("Physically deleted block %s on worker %s", meta.BlockID, replicaWorkerID)
+			}
 		}
-		if err := mn.appendToLog(op); err != nil {
-			log.Printf("Error logging deletion for %s: %v", path, err)
+	}
+	return true
+}
+
+// addChildLocked lists child in the directory dirPath, or among the
+// top-level inodes for ".". mn.lock must be held.
+func (mn *MasterNode) addChildLocked(dirPath string, child *Inode) {
+	if mn.inodes != nil {
+		mn.inodes.byID[child.ID] = child
+		if dirPath == "." && !slices.Contains(mn.inodes.root, child.ID) {
+			mn.inodes.root = append(mn.inodes.root, child.ID)
 		}
 	}
+	if parent, ok := mn.Namespace[dirPath]; ok && !slices.Contains(parent.Children, child.ID) {
+		parent.Children = append(parent.Children, child.ID)
+	}
+}
 
-	log.Printf("Compaction Swap Complete. Removed %d files.", len(req.OldFilePaths))
-	return nil
+func (mn *MasterNode) removeChildLocked(dirPath, childID string) {
+	if mn.inodes != nil {
+		delete(mn.inodes.byID, childID)
+		if dirPath == "." {
+			mn.inodes.root = slices.DeleteFunc(mn.inodes.root, func(id string) bool { return id == childID })
+		}
+	}
+	if parent, ok := mn.Namespace[dirPath]; ok {
+		newChildren := make([]string, 0)
+		for _, id := range parent.Children {
+			if id != childID {
+				newChildren = append(newChildren, id)
+			}
+		}
+		parent.Children = newChildren
+	}
 }
 
-func (mn *MasterNode) GetFileMetadata(projectID, filePath string) (*coordinatorv1.GetFileMetadataResponse, error) {
+func (mn *MasterNode) GetFileMetadata(projectID, filePath, clientHost string) (*coordinatorv1.GetFileMetadataResponse, error) {
 	mn.lock.RLock()
 	defer mn.lock.RUnlock()
 
 	fullPath := filepath.Clean(filePath)
 	inode, exists := mn.Namespace[fullPath]
 	if !exists {
-		return nil, fmt.Errorf("file not found: %s", fullPath)
+		return nil, fmt.Errorf("file %s: %w", fullPath, ErrNotFound)
 	}
 
 	blocks := make([]*commonv1.BlockInfo, 0)
 	locations := make(map[string]*commonv1.BlockLocation)
+	replicas := make(map[string]*coordinatorv1.BlockReplicas)
+	var tokens map[string]string
+	if mn.BlockTokens != nil {
+		tokens = make(map[string]string, len(inode.Blocks))
+	}
 
-	for _, blockUUID := range inode.Blocks {
+	for i, blockUUID := range inode.Blocks {
 		blockMeta, metaExists := mn.BlockMap[blockUUID]
 		if !metaExists {
-			continue
+			// Dropping the block would return a shorter file; the block
+			// is known again once a worker reports it.
+			return nil, fmt.Errorf("block %s of %s is not reported by any worker yet: %w", blockUUID, fullPath, ErrBlockUnknown)
 		}
 
-		blocks = append(blocks, &commonv1.BlockInfo{
-			BlockId:  blockUUID.String(),
-			Size:     blockMeta.Size,
-			Checksum: int64(blockMeta.Checksum),
-		})
-
-		if len(blockMeta.Replicas) > 0 {
-			workerUUID := blockMeta.Replicas[0]
-
-			_, workerInfo, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerUUID.String())
+		info := &commonv1.BlockInfo{
+			BlockId:     blockUUID.String(),
+			Size:        blockMeta.Size,
+			Checksum:    int64(blockMeta.Checksum),
+			ContentHash: blockMeta.ContentHash,
+		}
+		if ext := inode.extent(i); ext != nil {
+			info.Size, info.Checksum = ext.Length, int64(ext.Checksum)
+			info.Offset, info.Packed = ext.Offset, true
+		}
+		blocks = append(blocks, info)
 
-			if err == nil {
-				fullAddress := fmt.Sprintf("%s:%d", workerInfo.Ip, workerInfo.Port)
+		if tokens != nil {
+			tokens[blockUUID.String()] = mn.blockToken(blockUUID, inode.ProjectID, blocktoken.OpRead)
+		}
 
-				locations[blockUUID.String()] = &commonv1.BlockLocation{
-					BlockId:  blockUUID.String(),
-					WorkerId: workerUUID.String(),
-					Address:  fullAddress,
-				}
-			}
+		ordered := mn.orderReplicasLocked(blockMeta, clientHost)
+		if len(ordered) > 0 {
+			locations[blockUUID.String()] = ordered[0]
+			replicas[blockUUID.String()] = &coordinatorv1.BlockReplicas{Locations: ordered}
 		}
 	}
 
 	return &coordinatorv1.GetFileMetadataResponse{
-		Blocks:    blocks,
-		Locations: locations,
+		Blocks:      blocks,
+		Locations:   locations,
+		Replicas:    replicas,
+		ModTime:     unixNano(inode.ModTime),
+		Generation:  inode.Generation,
+		BlockTokens: tokens,
 	}, nil
 }
 
+// BlockLocationsKnown reports whether GetFileMetadata can locate every block
+// of the file at filePath. Standbys do not receive block reports, so they
+// usually cannot.
+func (mn *MasterNode) BlockLocationsKnown(filePath string) bool {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	inode, ok := mn.Namespace[filepath.Clean(filePath)]
+	if !ok {
+		return true
+	}
+	for _, blockUUID := range inode.Blocks {
+		blockMeta, ok := mn.BlockMap[blockUUID]
+		if !ok || len(mn.orderReplicasLocked(blockMeta, "")) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (mn *MasterNode) ListFiles(projectID, prefix string) ([]string, error) {
 	mn.lock.RLock()
 	defer mn.lock.RUnlock()
@@ -0,0 +1,200 @@
+package nodes
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razvanmarinn/dfs/internal/metrics"
+)
+
+// NamespaceStats is a point-in-time summary of the namespace and block map.
+type NamespaceStats struct {
+	Inodes                int `json:"inodes"`
+	Files                 int `json:"files"`
+	Directories           int `json:"directories"`
+	Blocks                int `json:"blocks"`
+	UnderReplicatedBlocks int `json:"under_replicated_blocks"`
+	MissingBlocks         int `json:"missing_blocks"`
+	CorruptBlocks         int `json:"corrupt_blocks"`
+	LiveWorkers           int `json:"live_workers"`
+}
+
+// WorkerReport describes one worker as last seen by the master.
+type WorkerReport struct {
+	WorkerID       string    `json:"worker_id"`
+	Address        string    `json:"address"`
+	AdminState     string    `json:"admin_state"`
+	Alive          bool      `json:"alive"`
+	LastHeartbeat  time.Time `json:"last_heartbeat"`
+	CapacityBytes  int64     `json:"capacity_bytes"`
+	UsedBytes      int64     `json:"used_bytes"`
+	RemainingBytes int64     `json:"remaining_bytes"`
+	BlockCount     int64     `json:"block_count"`
+}
+
+func (mn *MasterNode) isReplicaLive(workerID uuid.UUID) bool {
+	if mn.LoadBalancer == nil {
+		// Without a worker view (standby) every recorded replica is assumed live.
+		return true
+	}
+	return mn.LoadBalancer.IsAlive(workerID.String())
+}
+
+func isCorruptReplica(meta *BlockMetadata, workerID uuid.UUID) bool {
+	for _, id := range meta.CorruptReplicas {
+		if id == workerID {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats computes namespace and block health counters.
+func (mn *MasterNode) Stats() NamespaceStats {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	stats := NamespaceStats{
+		Inodes: len(mn.Namespace),
+		Blocks: len(mn.BlockMap),
+	}
+
+	wanted := make(map[uuid.UUID]int, len(mn.BlockMap))
+	for _, inode := range mn.Namespace {
+		if inode.Type == DirType {
+			stats.Directories++
+		} else {
+			stats.Files++
+		}
+		for _, blockID := range inode.Blocks {
+			wanted[blockID] = max(wanted[blockID], inode.ReplicationFactor())
+		}
+	}
+
+	for blockID, meta := range mn.BlockMap {
+		live, healthy := 0, 0
+		for _, replica := range meta.Replicas {
+			if !mn.isReplicaLive(replica) {
+				continue
+			}
+			live++
+			if !isCorruptReplica(meta, replica) {
+				healthy++
+			}
+		}
+
+		switch {
+		case live == 0:
+			stats.MissingBlocks++
+		case healthy == 0:
+			stats.CorruptBlocks++
+		case healthy < max(wanted[blockID], DefaultReplicationFactor):
+			stats.UnderReplicatedBlocks++
+		}
+	}
+
+	if mn.LoadBalancer != nil {
+		stats.LiveWorkers = mn.LoadBalancer.LiveWorkerCount()
+	}
+
+	return stats
+}
+
+// WorkerReports returns the per-worker capacity table, sorted by address.
+func (mn *MasterNode) WorkerReports() []WorkerReport {
+	if mn.LoadBalancer == nil {
+		return nil
+	}
+
+	workers := mn.LoadBalancer.Workers()
+	reports := make([]WorkerReport, 0, len(workers))
+	for id, wm := range workers {
+		reports = append(reports, WorkerReport{
+			WorkerID:       id,
+			Address:        wm.Ip,
+			AdminState:     wm.AdminState.String(),
+			Alive:          wm.Alive,
+			LastHeartbeat:  wm.LastHeartbeat,
+			CapacityBytes:  wm.CapacityBytes,
+			UsedBytes:      wm.UsedBytes,
+			RemainingBytes: wm.RemainingBytes,
+			BlockCount:     wm.BlockCount,
+		})
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Address < reports[j].Address
+	})
+	return reports
+}
+
+// MarkReplicaCorrupt records that a worker's copy of a block failed verification.
+func (mn *MasterNode) MarkReplicaCorrupt(blockID, workerID uuid.UUID) {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	mn.markReplicaCorruptLocked(blockID, workerID)
+}
+
+func (mn *MasterNode) markReplicaCorruptLocked(blockID, workerID uuid.UUID) {
+	meta, exists := mn.BlockMap[blockID]
+	if !exists || isCorruptReplica(meta, workerID) {
+		return
+	}
+
+	meta.CorruptReplicas = append(meta.CorruptReplicas, workerID)
+	log.Printf("Block %s marked corrupt on worker %s", blockID, workerID)
+}
+
+// RefreshWorkers polls workers for capacity and corrupt block reports.
+func (mn *MasterNode) RefreshWorkers(ctx context.Context) {
+	if mn.LoadBalancer == nil {
+		return
+	}
+
+	mn.LoadBalancer.RefreshWorkerStats(ctx)
+
+	mn.lock.Lock()
+	for workerID, wm := range mn.LoadBalancer.Workers() {
+		workerUUID, err := uuid.Parse(workerID)
+		if err != nil {
+			continue
+		}
+		for _, id := range wm.CorruptBlocks {
+			blockID, err := uuid.Parse(id)
+			if err != nil {
+				continue
+			}
+			mn.markReplicaCorruptLocked(blockID, workerUUID)
+		}
+	}
+	mn.lock.Unlock()
+}
+
+// UpdateMetrics publishes the current namespace stats as Prometheus gauges.
+func (mn *MasterNode) UpdateMetrics() {
+	stats := mn.Stats()
+
+	metrics.MasterInodesTotal.WithLabelValues("file").Set(float64(stats.Files))
+	metrics.MasterInodesTotal.WithLabelValues("directory").Set(float64(stats.Directories))
+	metrics.MasterBlocksTotal.Set(float64(stats.Blocks))
+	metrics.MasterUnderReplicatedBlocks.Set(float64(stats.UnderReplicatedBlocks))
+	metrics.MasterMissingBlocks.Set(float64(stats.MissingBlocks))
+	metrics.MasterCorruptBlocks.Set(float64(stats.CorruptBlocks))
+	metrics.MasterLiveWorkers.Set(float64(stats.LiveWorkers))
+
+	if enabled, _ := mn.SafeMode(); enabled {
+		metrics.MasterSafeMode.Set(1)
+	} else {
+		metrics.MasterSafeMode.Set(0)
+	}
+
+	if mn.IsActive {
+		metrics.MasterIsLeader.Set(1)
+	} else {
+		metrics.MasterIsLeader.Set(0)
+	}
+}
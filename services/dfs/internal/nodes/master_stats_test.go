@@ -0,0 +1,51 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasterNode_Stats(t *testing.T) {
+	master := setupTestMaster(t)
+
+	worker := uuid.New()
+	healthyBlock := uuid.New()
+	corruptBlock := uuid.New()
+	orphanBlock := uuid.New()
+
+	master.Namespace["project"] = &Inode{ID: "dir", Path: "project", Type: DirType}
+	master.Namespace["project/file.avro"] = &Inode{
+		ID:     "file",
+		Path:   "project/file.avro",
+		Type:   FileType,
+		Blocks: []uuid.UUID{healthyBlock, corruptBlock},
+	}
+
+	master.BlockMap[healthyBlock] = &BlockMetadata{BlockID: healthyBlock, Replicas: []uuid.UUID{worker}}
+	master.BlockMap[corruptBlock] = &BlockMetadata{BlockID: corruptBlock, Replicas: []uuid.UUID{worker}}
+	master.BlockMap[orphanBlock] = &BlockMetadata{BlockID: orphanBlock, Replicas: []uuid.UUID{}}
+
+	master.MarkReplicaCorrupt(corruptBlock, worker)
+	master.MarkReplicaCorrupt(corruptBlock, worker)
+
+	stats := master.Stats()
+
+	t.Run("counts inodes by type", func(t *testing.T) {
+		assert.Equal(t, 2, stats.Inodes)
+		assert.Equal(t, 1, stats.Files)
+		assert.Equal(t, 1, stats.Directories)
+	})
+
+	t.Run("classifies block health", func(t *testing.T) {
+		assert.Equal(t, 3, stats.Blocks)
+		assert.Equal(t, 1, stats.MissingBlocks)
+		assert.Equal(t, 1, stats.CorruptBlocks)
+		assert.Equal(t, 0, stats.UnderReplicatedBlocks)
+	})
+
+	t.Run("corrupt replica is recorded once", func(t *testing.T) {
+		assert.Len(t, master.BlockMap[corruptBlock].CorruptReplicas, 1)
+	})
+}
@@ -4,10 +4,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -57,6 +59,192 @@ func TestMasterNode_AllocateBlock(t *testing.T) {
 	})
 }
 
+func TestMasterNode_AllocateBlockExcludesWorkers(t *testing.T) {
+	master := setupTestMaster(t)
+	bad, good := uuid.New(), uuid.New()
+
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	lb.AddWorker(bad.String(), load_balancer.WorkerMetadata{Ip: "worker-0", Port: 50051, Alive: true})
+	lb.AddWorker(good.String(), load_balancer.WorkerMetadata{Ip: "worker-1", Port: 50051, Alive: true})
+	master.LoadBalancer = lb
+
+	for i := 0; i < 3; i++ {
+		resp, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{
+			ProjectId:         "p",
+			ExcludedWorkerIds: []string{bad.String()},
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.TargetDatanodes, 1)
+		assert.Equal(t, good.String(), resp.TargetDatanodes[0].WorkerId)
+	}
+
+	_, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{
+		ProjectId:         "p",
+		ExcludedWorkerIds: []string{bad.String(), good.String()},
+	})
+	assert.Error(t, err)
+}
+
+func TestMasterNode_AbandonBlock(t *testing.T) {
+	master := setupTestMaster(t)
+
+	abandoned, committed := uuid.New(), uuid.New()
+	master.BlockMap[abandoned] = &BlockMetadata{BlockID: abandoned, Replicas: []uuid.UUID{uuid.New()}}
+	master.BlockMap[committed] = &BlockMetadata{BlockID: committed, Replicas: []uuid.UUID{uuid.New()}}
+	master.Namespace["p/f.avro"] = &Inode{ID: "f", Path: "p/f.avro", Type: FileType, Blocks: []uuid.UUID{committed}}
+
+	require.NoError(t, master.AbandonBlock(abandoned))
+	assert.NotContains(t, master.BlockMap, abandoned)
+
+	assert.Error(t, master.AbandonBlock(committed), "committed blocks cannot be abandoned")
+	assert.Contains(t, master.BlockMap, committed)
+
+	assert.NoError(t, master.AbandonBlock(uuid.New()), "unknown blocks are a no-op")
+}
+
+func TestMasterNode_ConcatFiles(t *testing.T) {
+	master := setupTestMaster(t)
+
+	b1, b2, b3 := uuid.New(), uuid.New(), uuid.New()
+	for i, id := range []uuid.UUID{b1, b2, b3} {
+		master.BlockMap[id] = &BlockMetadata{BlockID: id, Size: int64(i + 1), Replicas: []uuid.UUID{uuid.New()}}
+	}
+	master.Namespace["p/.parts/1"] = &Inode{ID: "1", Path: "p/.parts/1", Type: FileType, Blocks: []uuid.UUID{b1, b2}}
+	master.Namespace["p/.parts/2"] = &Inode{ID: "2", Path: "p/.parts/2", Type: FileType, Blocks: []uuid.UUID{b3}}
+
+	err := master.ConcatFiles(Superuser, &coordinatorv1.ConcatFilesRequest{
+		ProjectId:   "p",
+		TargetPath:  "p/object.bin",
+		SourcePaths: []string{"p/.parts/1", "p/.parts/2"},
+	})
+	require.NoError(t, err)
+
+	target := master.Namespace["p/object.bin"]
+	require.NotNil(t, target)
+	assert.Equal(t, []uuid.UUID{b1, b2, b3}, target.Blocks)
+	assert.Equal(t, int64(6), target.Size)
+	assert.NotContains(t, master.Namespace, "p/.parts/1")
+	assert.NotContains(t, master.Namespace, "p/.parts/2")
+	assert.Len(t, master.BlockMap, 3, "source blocks are kept")
+
+	err = master.ConcatFiles(Superuser, &coordinatorv1.ConcatFilesRequest{
+		ProjectId:   "p",
+		TargetPath:  "p/other.bin",
+		SourcePaths: []string{"p/.parts/1"},
+	})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMasterNode_ConcatFilesIsOneOperation(t *testing.T) {
+	leader := setupTestMaster(t)
+	standby := setupTestMaster(t)
+
+	require.NoError(t, leader.Mkdirs(Superuser, "p/.parts", "p", ""))
+	for _, part := range []string{"p/.parts/1", "p/.parts/2"} {
+		_, err := leader.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "p", FilePath: part,
+			Blocks: []*commonv1.BlockInfo{{BlockId: uuid.NewString(), Size: 1}}})
+		require.NoError(t, err)
+	}
+	before := leader.AppliedTxID()
+	require.NoError(t, leader.ConcatFiles(Superuser, &coordinatorv1.ConcatFilesRequest{
+		ProjectId:   "p",
+		TargetPath:  "p/object.bin",
+		SourcePaths: []string{"p/.parts/1", "p/.parts/2"},
+	}))
+	assert.Equal(t, before+1, leader.AppliedTxID(), "the target and the source removals are one entry")
+
+	replayLog(t, leader, standby)
+	assert.Contains(t, standby.Namespace, "p/object.bin")
+	assert.NotContains(t, standby.Namespace, "p/.parts/1")
+	assert.NotContains(t, standby.Namespace, "p/.parts/2")
+	assert.Empty(t, standby.Namespace["p/.parts"].Children)
+	assert.Len(t, standby.BlockMap, 2)
+
+	events, _, err := leader.NamespaceEvents(before)
+	require.NoError(t, err)
+	var paths []string
+	for _, e := range events {
+		assert.Equal(t, before+1, e.TxID)
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"p/object.bin", "p/.parts/1", "p/.parts/2"}, paths)
+}
+
+func TestMasterNode_DeleteFile(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Namespace["p"] = &Inode{ID: "p", Path: "p", Type: DirType}
+	master.Namespace["p/f.avro"] = &Inode{ID: "f", Path: "p/f.avro", Type: FileType}
+
+	master.Namespace["p/d"] = &Inode{ID: "d", Path: "p/d", Type: DirType}
+	master.Namespace["p/d/g.avro"] = &Inode{ID: "g", Path: "p/d/g.avro", Type: FileType}
+	master.Namespace["p/e/h.avro"] = &Inode{ID: "h", Path: "p/e/h.avro", Type: FileType}
+
+	require.NoError(t, master.DeleteFile(Superuser, "p/f.avro", false))
+	assert.NotContains(t, master.Namespace, "p/f.avro")
+	assert.ErrorIs(t, master.DeleteFile(Superuser, "p/f.avro", false), ErrNotFound)
+	assert.Error(t, master.DeleteFile(Superuser, "p/d", false))
+	assert.Contains(t, master.Namespace, "p/d/g.avro")
+
+	require.NoError(t, master.DeleteFile(Superuser, "p/d", true))
+	assert.NotContains(t, master.Namespace, "p/d")
+	assert.NotContains(t, master.Namespace, "p/d/g.avro")
+
+	require.NoError(t, master.DeleteFile(Superuser, "p/e", true), "implicit directories can be removed")
+	assert.NotContains(t, master.Namespace, "p/e/h.avro")
+	assert.Contains(t, master.Namespace, "p")
+}
+
+func TestMasterNode_Rename(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Namespace["p"] = &Inode{ID: "p", Path: "p", Type: DirType}
+	master.Namespace["p/a"] = &Inode{ID: "a", Path: "p/a", Type: DirType, Children: []string{"f"}}
+	master.Namespace["p/a/f.avro"] = &Inode{ID: "f", Path: "p/a/f.avro", Name: "f.avro", Type: FileType}
+	master.Namespace["p/a/b/g.avro"] = &Inode{ID: "g", Path: "p/a/b/g.avro", Type: FileType}
+	master.Namespace["p/x.avro"] = &Inode{ID: "x", Path: "p/x.avro", Type: FileType}
+	// p/a/b has no inode, as in images written before directories had one.
+	master.repairTreeLocked()
+
+	require.NoError(t, master.Rename(Superuser, "p/x.avro", "p/y/x2.avro"))
+	assert.NotContains(t, master.Namespace, "p/x.avro")
+	require.Contains(t, master.Namespace, "p/y/x2.avro")
+	assert.Equal(t, "x2.avro", master.Namespace["p/y/x2.avro"].Name)
+	assert.Equal(t, DirType, master.Namespace["p/y"].Type)
+
+	require.NoError(t, master.Rename(Superuser, "p/a", "p/c"))
+	assert.NotContains(t, master.Namespace, "p/a")
+	assert.NotContains(t, master.Namespace, "p/a/f.avro")
+	assert.Equal(t, "f", master.Namespace["p/c/f.avro"].ID)
+	assert.Equal(t, "g", master.Namespace["p/c/b/g.avro"].ID)
+	assert.ElementsMatch(t, []string{"f", master.Namespace["p/c/b"].ID}, master.Namespace["p/c"].Children)
+
+	assert.ErrorIs(t, master.Rename(Superuser, "p/missing", "p/z"), ErrNotFound)
+	assert.ErrorIs(t, master.Rename(Superuser, "p/c/f.avro", "p/y/x2.avro"), ErrExists)
+	assert.ErrorIs(t, master.Rename(Superuser, "p/y/x2.avro", "p/c/b"), ErrExists, "directories of older images are taken")
+	assert.Error(t, master.Rename(Superuser, "p/c", "p/c/d"))
+}
+
+func TestMasterNode_Mkdirs(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Namespace["p/f.avro"] = &Inode{ID: "f", Path: "p/f.avro", Type: FileType}
+
+	require.NoError(t, master.Mkdirs(Superuser, "p/a/b", "", ""))
+	for _, dir := range []string{"p", "p/a", "p/a/b"} {
+		require.Contains(t, master.Namespace, dir)
+		assert.Equal(t, DirType, master.Namespace[dir].Type)
+		assert.Equal(t, "p", master.Namespace[dir].ProjectID)
+	}
+	assert.Equal(t, []string{master.Namespace["p/a/b"].ID}, master.Namespace["p/a"].Children, "directories are listed in their parent")
+
+	standby := setupTestMaster(t)
+	replayLog(t, master, standby)
+	assert.Equal(t, []string{standby.Namespace["p/a/b"].ID}, standby.Namespace["p/a"].Children, "on standbys too")
+	require.NoError(t, master.Mkdirs(Superuser, "p/a", "", ""), "existing directories are fine")
+
+	assert.ErrorIs(t, master.Mkdirs(Superuser, "p/f.avro/sub", "", ""), ErrExists)
+	assert.NotContains(t, master.Namespace, "p/f.avro/sub")
+	assert.Error(t, master.Mkdirs(Superuser, "", "", ""))
+}
+
 func TestMasterNode_GetFileBatches(t *testing.T) {
 	master := setupTestMaster(t)
 
@@ -267,6 +455,89 @@ func TestMasterNode_CommitFileInternal(t *testing.T) {
 	})
 }
 
+func TestMasterNode_CommitFileWriteModes(t *testing.T) {
+	master := setupTestMaster(t)
+
+	commit := func(mode coordinatorv1.WriteMode, generation int64) (*Inode, error) {
+		return master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId:         "proj",
+			FilePath:          "proj/data/file.bin",
+			Blocks:            []*commonv1.BlockInfo{{BlockId: uuid.New().String(), Size: 10}},
+			Mode:              mode,
+			IfGenerationMatch: generation,
+		})
+	}
+
+	first, err := commit(coordinatorv1.WriteMode_WRITE_MODE_CREATE, 0)
+	require.NoError(t, err)
+
+	t.Run("create fails when the file exists", func(t *testing.T) {
+		_, err := commit(coordinatorv1.WriteMode_WRITE_MODE_CREATE, 0)
+		assert.ErrorIs(t, err, ErrExists)
+		assert.Same(t, first, master.Namespace["proj/data/file.bin"])
+	})
+
+	t.Run("overwrite replaces the file and frees its blocks", func(t *testing.T) {
+		second, err := commit(coordinatorv1.WriteMode_WRITE_MODE_OVERWRITE, 0)
+		require.NoError(t, err)
+
+		assert.Greater(t, second.Generation, first.Generation)
+		assert.Same(t, second, master.Namespace["proj/data/file.bin"])
+		assert.NotContains(t, master.BlockMap, first.Blocks[0])
+		assert.Equal(t, []string{second.ID}, master.Namespace["proj/data"].Children)
+	})
+
+	t.Run("generation match", func(t *testing.T) {
+		current := master.Namespace["proj/data/file.bin"]
+
+		_, err := commit(coordinatorv1.WriteMode_WRITE_MODE_IF_GENERATION_MATCH, first.Generation)
+		assert.ErrorIs(t, err, ErrGenerationMismatch)
+		_, err = commit(coordinatorv1.WriteMode_WRITE_MODE_IF_GENERATION_MATCH, 0)
+		assert.ErrorIs(t, err, ErrGenerationMismatch)
+
+		next, err := commit(coordinatorv1.WriteMode_WRITE_MODE_IF_GENERATION_MATCH, current.Generation)
+		require.NoError(t, err)
+		assert.Greater(t, next.Generation, current.Generation)
+	})
+
+	t.Run("generations are not reused after a delete", func(t *testing.T) {
+		last := master.Namespace["proj/data/file.bin"].Generation
+		require.NoError(t, master.DeleteFile(Superuser, "proj/data/file.bin", false))
+
+		recreated, err := commit(coordinatorv1.WriteMode_WRITE_MODE_IF_GENERATION_MATCH, 0)
+		require.NoError(t, err)
+		assert.Greater(t, recreated.Generation, last)
+	})
+
+	t.Run("a directory is never replaced", func(t *testing.T) {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/data"})
+		assert.ErrorIs(t, err, ErrExists)
+	})
+}
+
+func TestMasterNode_OverwriteDeletesOldBlocksLater(t *testing.T) {
+	master, store := packingMaster(t)
+	standby := setupTestMaster(t)
+
+	writeSmallFile(t, master, store, "proj/data/file.bin", "first generation")
+	old := master.Namespace["proj/data/file.bin"].Blocks[0]
+	writeSmallFile(t, master, store, "proj/data/file.bin", "second generation")
+
+	assert.NotContains(t, master.BlockMap, old)
+	_, ok := store.block(old.String())
+	assert.True(t, ok, "readers of the old generation can still fetch its block")
+
+	assert.Equal(t, 0, master.DeleteReleasedBlocks(time.Now()))
+	assert.Equal(t, 1, master.DeleteReleasedBlocks(time.Now().Add(BlockDeleteDelay)))
+	_, ok = store.block(old.String())
+	assert.False(t, ok)
+
+	replayLog(t, master, standby)
+	assert.NotContains(t, standby.BlockMap, old)
+	require.Len(t, standby.releasedBlocks, 1, "a standby that takes over deletes the old block")
+	assert.Equal(t, old, standby.releasedBlocks[0].blockID)
+}
+
 func TestMasterNode_ListFiles(t *testing.T) {
 	master := setupTestMaster(t)
 
@@ -0,0 +1,259 @@
+package nodes
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned for paths that are neither a file nor a directory.
+var ErrNotFound = errors.New("path not found")
+
+// ErrExists is returned when a path that must be new is already taken.
+var ErrExists = errors.New("path already exists")
+
+// ErrGenerationMismatch is returned when a conditional commit finds a
+// different generation of the file than it expected.
+var ErrGenerationMismatch = errors.New("generation mismatch")
+
+// PathInfo describes a file or directory in the namespace.
+type PathInfo struct {
+	Path    string
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	// Generation is the file's generation, 0 for directories.
+	Generation int64
+	// BlockSize and Replication are the file's settings, 0 for directories.
+	BlockSize   int64
+	Replication int32
+	// StoragePolicy is the policy in effect for the path.
+	StoragePolicy string
+
+	ATime time.Time
+	CTime time.Time
+	// Mode holds the permission bits checks use, which for paths without an
+	// owner are the open defaults.
+	Mode   uint32
+	Owner  string
+	Group  string
+	XAttrs map[string]string
+}
+
+// StatPath returns information about a file or directory.
+func (mn *MasterNode) StatPath(path string) (PathInfo, error) {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	path = filepath.Clean(path)
+	inode, ok := mn.Namespace[path]
+	if !ok {
+		return PathInfo{}, ErrNotFound
+	}
+	info := inodeInfo(inode)
+	if info.IsDir {
+		info.ModTime = latest(info.ModTime, mn.subtreeModTimeLocked(inode))
+	}
+	info.StoragePolicy = mn.storagePolicyLocked(path)
+	return info, nil
+}
+
+// ListDirectory returns the direct children of a directory sorted by name.
+func (mn *MasterNode) ListDirectory(path string) ([]PathInfo, error) {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	path = filepath.Clean(path)
+	var children []*Inode
+	if path == "." {
+		children = mn.rootInodesLocked()
+	} else {
+		inode, ok := mn.Namespace[path]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		if inode.Type != DirType {
+			return nil, errors.New("not a directory")
+		}
+		children = mn.childrenLocked(inode)
+	}
+
+	entries := make([]PathInfo, 0, len(children))
+	for _, child := range children {
+		info := inodeInfo(child)
+		if info.IsDir {
+			info.ModTime = latest(info.ModTime, mn.subtreeModTimeLocked(child))
+		}
+		info.StoragePolicy = mn.storagePolicyLocked(info.Path)
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// ListFileInfos is ListFiles with the status of each file; paths in the result
+// are full namespace paths.
+func (mn *MasterNode) ListFileInfos(projectID, prefix string) []PathInfo {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	var files []PathInfo
+	for _, inode := range mn.Namespace {
+		if inode.Type != FileType {
+			continue
+		}
+		relPath, err := filepath.Rel(projectID, inode.Path)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+		if prefix == "" || strings.HasPrefix(relPath, prefix) {
+			info := inodeInfo(inode)
+			info.StoragePolicy = mn.storagePolicyLocked(inode.Path)
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// subtreeModTimeLocked returns the latest modification time below dir.
+// mn.lock must be held.
+func (mn *MasterNode) subtreeModTimeLocked(dir *Inode) time.Time {
+	var modTime time.Time
+	for _, child := range mn.childrenLocked(dir) {
+		modTime = latest(modTime, child.ModTime)
+		if child.Type == DirType {
+			modTime = latest(modTime, mn.subtreeModTimeLocked(child))
+		}
+	}
+	return modTime
+}
+
+// inodeIndex finds inodes by ID, to follow Children lists, and lists the
+// top-level inodes, which have no parent to list them.
+type inodeIndex struct {
+	byID map[string]*Inode
+	root []string
+}
+
+// inodeIndexLocked returns the index of the namespace, building it on first
+// use. mn.lock must be held.
+func (mn *MasterNode) inodeIndexLocked() *inodeIndex {
+	if mn.inodes == nil {
+		idx := &inodeIndex{byID: make(map[string]*Inode, len(mn.Namespace))}
+		for p, inode := range mn.Namespace {
+			idx.byID[inode.ID] = inode
+			if filepath.Dir(p) == "." {
+				idx.root = append(idx.root, inode.ID)
+			}
+		}
+		mn.inodes = idx
+	}
+	return mn.inodes
+}
+
+// inodesLocked resolves ids to the inodes still in the namespace. An ID the
+// index misses, e.g. of an inode added to a loaded image, rebuilds it once.
+// mn.lock must be held.
+func (mn *MasterNode) inodesLocked(ids []string) []*Inode {
+	inodes := make([]*Inode, 0, len(ids))
+	rebuilt := false
+	for _, id := range ids {
+		inode, ok := mn.inodeIndexLocked().byID[id]
+		if !ok && !rebuilt {
+			mn.inodes, rebuilt = nil, true
+			inode, ok = mn.inodeIndexLocked().byID[id]
+		}
+		if ok && mn.Namespace[inode.Path] == inode {
+			inodes = append(inodes, inode)
+		}
+	}
+	return inodes
+}
+
+// childrenLocked returns the inodes listed in dir. mn.lock must be held.
+func (mn *MasterNode) childrenLocked(dir *Inode) []*Inode {
+	return mn.inodesLocked(dir.Children)
+}
+
+// rootInodesLocked returns the top-level inodes. mn.lock must be held.
+func (mn *MasterNode) rootInodesLocked() []*Inode {
+	return mn.inodesLocked(mn.inodeIndexLocked().root)
+}
+
+// repairTreeLocked gives every inode a parent directory that lists it.
+// Images written before directories were listed in their parents lack those
+// entries, and older ones have files under directories with no inode, which
+// get one with an ID derived from their path so every master creates the
+// same. mn.lock must be held.
+func (mn *MasterNode) repairTreeLocked() {
+	paths := make([]string, 0, len(mn.Namespace))
+	for p := range mn.Namespace {
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		for dir := filepath.Dir(p); dir != "."; dir = filepath.Dir(dir) {
+			if _, ok := mn.Namespace[dir]; ok {
+				break
+			}
+			project, _, _ := strings.Cut(dir, string(filepath.Separator))
+			mn.Namespace[dir] = &Inode{
+				ID:        uuid.NewSHA1(uuid.NameSpaceURL, []byte("dfs:dir:"+dir)).String(),
+				Name:      filepath.Base(dir),
+				Path:      dir,
+				Type:      DirType,
+				ProjectID: project,
+				Children:  make([]string, 0),
+			}
+		}
+	}
+
+	mn.inodes = nil
+	for p, inode := range mn.Namespace {
+		mn.addChildLocked(filepath.Dir(p), inode)
+	}
+}
+
+func inodeInfo(inode *Inode) PathInfo {
+	info := PathInfo{
+		Path:       inode.Path,
+		Name:       filepath.Base(inode.Path),
+		IsDir:      inode.Type == DirType,
+		Size:       inode.Size,
+		ModTime:    inode.ModTime,
+		Generation: inode.Generation,
+
+		ATime:  inode.ATime,
+		CTime:  inode.CTime,
+		Mode:   inode.permissions(),
+		Owner:  inode.OwnerID,
+		Group:  inode.Group,
+		XAttrs: inode.XAttrs,
+	}
+	if inode.Type == FileType {
+		info.BlockSize = inode.BlockSize
+		info.Replication = int32(inode.ReplicationFactor())
+	}
+	return info
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// unixNano converts a modification time for the wire, mapping the zero time
+// of inodes written before timestamps were recorded to 0.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,94 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterNode_StatAndListDirectory(t *testing.T) {
+	master := setupTestMaster(t)
+
+	older := time.Unix(1000, 0)
+	newer := time.Unix(2000, 0)
+	master.Namespace["p"] = &Inode{ID: "p", Path: "p", Type: DirType, ModTime: older}
+	master.Namespace["p/a.avro"] = &Inode{ID: "a", Path: "p/a.avro", Type: FileType, Size: 10, ModTime: older}
+	// p/events has no inode of its own, as in images written before
+	// directories had one; loading the image gives it one.
+	master.Namespace["p/events/2024/b.avro"] = &Inode{ID: "b", Path: "p/events/2024/b.avro", Type: FileType, Size: 20, ModTime: newer}
+	master.repairTreeLocked()
+
+	t.Run("stat file", func(t *testing.T) {
+		info, err := master.StatPath("p/a.avro")
+		require.NoError(t, err)
+		assert.Equal(t, PathInfo{
+			Path:          "p/a.avro",
+			Name:          "a.avro",
+			Size:          10,
+			ModTime:       older,
+			Replication:   DefaultReplicationFactor,
+			StoragePolicy: StoragePolicyWarm,
+			Mode:          0o666,
+		}, info)
+	})
+
+	t.Run("stat directory of an older image", func(t *testing.T) {
+		info, err := master.StatPath("p/events")
+		require.NoError(t, err)
+		assert.True(t, info.IsDir)
+		assert.Equal(t, "events", info.Name)
+		assert.Equal(t, newer, info.ModTime)
+	})
+
+	t.Run("stat missing path", func(t *testing.T) {
+		_, err := master.StatPath("p/missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("list directory", func(t *testing.T) {
+		entries, err := master.ListDirectory("p")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "a.avro", entries[0].Name)
+		assert.False(t, entries[0].IsDir)
+		assert.Equal(t, "events", entries[1].Name)
+		assert.Equal(t, "p/events", entries[1].Path)
+		assert.True(t, entries[1].IsDir)
+	})
+
+	t.Run("list root", func(t *testing.T) {
+		entries, err := master.ListDirectory(".")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "p", entries[0].Name)
+		assert.Equal(t, newer, entries[0].ModTime)
+	})
+
+	t.Run("list file or missing directory", func(t *testing.T) {
+		_, err := master.ListDirectory("p/a.avro")
+		assert.Error(t, err)
+		_, err = master.ListDirectory("q")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestMasterNode_ListFileInfos(t *testing.T) {
+	master := setupTestMaster(t)
+
+	master.Namespace["p"] = &Inode{ID: "p", Path: "p", Type: DirType}
+	master.Namespace["p/b.avro"] = &Inode{ID: "b", Path: "p/b.avro", Type: FileType, Size: 2}
+	master.Namespace["p/a/x.avro"] = &Inode{ID: "x", Path: "p/a/x.avro", Type: FileType, Size: 1}
+	master.Namespace["q/a.avro"] = &Inode{ID: "q", Path: "q/a.avro", Type: FileType}
+
+	infos := master.ListFileInfos("p", "")
+	require.Len(t, infos, 2)
+	assert.Equal(t, "p/a/x.avro", infos[0].Path)
+	assert.Equal(t, int64(1), infos[0].Size)
+	assert.Equal(t, "p/b.avro", infos[1].Path)
+
+	infos = master.ListFileInfos("p", "a/")
+	require.Len(t, infos, 1)
+	assert.Equal(t, "p/a/x.avro", infos[0].Path)
+}
@@ -31,8 +31,47 @@ type Inode struct {
 	ProjectID string
 	Size      int64
 	Blocks    []uuid.UUID
-	Children  []string
+	// Extents, when set, has an entry for each of Blocks. A non-nil entry
+	// means the file's data is only that range of the block, a container
+	// block shared with other small files.
+	Extents  []*Extent
+	Children []string
+	// ModTime is when the file was committed or the directory created.
+	ModTime time.Time
+	// ATime is when the file was last read, at AccessTimePrecision. CTime is
+	// when the inode last changed, its content or its attributes.
+	ATime time.Time
+	CTime time.Time
+	// Mode holds the permission bits checked against OwnerID, Group and
+	// others. Inodes without an owner are open to everyone.
+	Mode  uint32
+	Group string
+	// XAttrs are user-defined attributes such as the file's format or row
+	// count.
+	XAttrs map[string]string
+	// Generation identifies this version of a file. Every commit gets a new,
+	// higher generation, and generations are never reused, not even after the
+	// file is deleted.
+	Generation int64
+	// BlockSize is the block size a file was written with, 0 if unknown.
+	BlockSize int64
+	// Replication is the number of replicas wanted for each block of a file,
+	// 0 for DefaultReplicationFactor.
+	Replication int32
+	// StoragePolicy is the policy set on this path, if any. Files without one
+	// inherit it from the closest parent directory that has one.
+	StoragePolicy string
 }
+
+// ReplicationFactor is the number of healthy replicas wanted for each block of
+// the file.
+func (inode *Inode) ReplicationFactor() int {
+	if inode.Replication > 0 {
+		return int(inode.Replication)
+	}
+	return DefaultReplicationFactor
+}
+
 type BlockMetadata struct {
 	BlockID     uuid.UUID   `json:"blockId"`
 	Size        int64       `json:"size"`
@@ -41,4 +80,22 @@ type BlockMetadata struct {
 	PrimaryNode string      `json:"primaryNode"`
 	LeaseExpiry time.Time   `json:"leaseExpiry"`
 	Replicas    []uuid.UUID `json:"replicas"`
+
+	// CorruptReplicas lists workers whose copy of the block failed verification.
+	CorruptReplicas []uuid.UUID `json:"corruptReplicas,omitempty"`
+
+	// Container is set for blocks that pack the data of several small files.
+	// They are shared, so deleting a file leaves them to the packer.
+	Container bool `json:"container,omitempty"`
+
+	// ContentHash is the hex SHA-256 of blocks written in dedup mode. Such
+	// blocks may be shared by several files of ProjectID; RefCount says how
+	// many reference them and the block is deleted when it drops to zero.
+	ContentHash string `json:"contentHash,omitempty"`
+	ProjectID   string `json:"projectId,omitempty"`
+	RefCount    int    `json:"refCount,omitempty"`
+	// Holds are the expiry times, in Unix nanoseconds and oldest first, of
+	// the provisional references of dedup hits whose files are not committed
+	// yet. RefCount includes them.
+	Holds []int64 `json:"holds,omitempty"`
 }
@@ -0,0 +1,547 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+)
+
+const (
+	// SmallFileThreshold is the size up to which single-block files are
+	// packed into container blocks.
+	SmallFileThreshold = 1 << 20
+	// ContainerBlockSize is the most file data the packer puts in one
+	// container block.
+	ContainerBlockSize = 16 << 20
+	// ContainerRewriteRatio is the share of a container's bytes still used by
+	// files below which the packer moves those files to a new container, so
+	// the old one can be deleted.
+	ContainerRewriteRatio = 0.5
+
+	packTimeout   = 2 * time.Minute
+	packChunkSize = 1 << 20
+)
+
+// Extent is the range of a container block that holds a packed file's data.
+type Extent struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	// Checksum is the CRC-32 (IEEE) of the range.
+	Checksum uint32 `json:"checksum"`
+}
+
+// extent returns the range of its i-th block the file uses, nil for the whole
+// block.
+func (inode *Inode) extent(i int) *Extent {
+	if i < len(inode.Extents) {
+		return inode.Extents[i]
+	}
+	return nil
+}
+
+// PackPayload is the op-log payload of OpPackFiles.
+type PackPayload struct {
+	Container uuid.UUID `json:"container"`
+	// ProjectID is the project of the packed files; empty in entries logged
+	// before containers recorded it.
+	ProjectID string       `json:"projectId,omitempty"`
+	Size      int64        `json:"size"`
+	Checksum  uint32       `json:"checksum"`
+	Files     []PackedFile `json:"files"`
+	// Refs are the dedup blocks whose references packing changed.
+	Refs *BlockRefsPayload `json:"refs,omitempty"`
+}
+
+// PackedFile is a file moved into a container, at the generation whose data
+// was copied.
+type PackedFile struct {
+	Path       string `json:"path"`
+	Generation int64  `json:"generation"`
+	Extent     Extent `json:"extent"`
+}
+
+// packGroup is what files must have in common to share a container: the
+// project, so a block token for one file never reads another project's data,
+// and how their blocks are replicated and placed.
+type packGroup struct {
+	projectID   string
+	replication int
+	policy      string
+}
+
+// packCandidate is a file to move into a new container, with where its data
+// is now.
+type packCandidate struct {
+	path       string
+	generation int64
+	block      uuid.UUID
+	// extent is the file's range of block when it is already packed.
+	extent   *Extent
+	size     int64
+	checksum uint32
+	replicas []uuid.UUID
+}
+
+// PackSmallFiles moves small single-block files into shared container blocks,
+// so each stops costing a block entry and a file on every replica. Files of
+// containers that are mostly unused after deletes are moved again, and
+// containers no file uses any more are deleted. It returns the number of
+// files moved.
+func (mn *MasterNode) PackSmallFiles() int {
+	if mn.LoadBalancer == nil || mn.checkWritable() != nil {
+		return 0
+	}
+
+	mn.packerLock.Lock()
+	defer mn.packerLock.Unlock()
+
+	packed := 0
+	for group, candidates := range mn.packCandidates() {
+		for _, batch := range packBatches(candidates) {
+			n, err := mn.packBatch(group, batch)
+			if err != nil {
+				log.Printf("Packer: failed to pack %d files of %s: %v", len(batch), group.projectID, err)
+				continue
+			}
+			packed += n
+		}
+	}
+	dropped := mn.dropUnusedContainers()
+	if packed > 0 || dropped > 0 {
+		log.Printf("Packer: packed %d files, deleted %d unused containers", packed, dropped)
+	}
+	return packed
+}
+
+// packCandidates returns the files to pack by the group they may share
+// containers with, in path order.
+func (mn *MasterNode) packCandidates() map[packGroup][]packCandidate {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	used := mn.containerUsageLocked()
+	groups := make(map[packGroup][]packCandidate)
+	for path, inode := range mn.Namespace {
+		if inode.Type != FileType || len(inode.Blocks) != 1 || inode.Size == 0 || inode.Size > SmallFileThreshold {
+			continue
+		}
+		blockID := inode.Blocks[0]
+		meta, ok := mn.BlockMap[blockID]
+		if !ok {
+			continue
+		}
+
+		c := packCandidate{
+			path:       path,
+			generation: inode.Generation,
+			block:      blockID,
+			extent:     inode.extent(0),
+			size:       meta.Size,
+			checksum:   meta.Checksum,
+		}
+		if c.extent != nil {
+			if float64(used[blockID]) >= ContainerRewriteRatio*float64(meta.Size) {
+				continue
+			}
+			c.size, c.checksum = c.extent.Length, c.extent.Checksum
+		} else if meta.Container || meta.RefCount > 1 {
+			// Dedup blocks shared with other files stay where they are.
+			continue
+		}
+
+		for _, replica := range meta.Replicas {
+			if mn.isReplicaLive(replica) && !isCorruptReplica(meta, replica) {
+				c.replicas = append(c.replicas, replica)
+			}
+		}
+		if len(c.replicas) == 0 {
+			continue
+		}
+
+		group := packGroup{
+			projectID:   inode.ProjectID,
+			replication: inode.ReplicationFactor(),
+			policy:      mn.storagePolicyLocked(path),
+		}
+		groups[group] = append(groups[group], c)
+	}
+
+	for group, candidates := range groups {
+		// A lone small file gains nothing from a container of its own.
+		if len(candidates) == 1 && candidates[0].extent == nil {
+			delete(groups, group)
+			continue
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].path < candidates[j].path })
+	}
+	return groups
+}
+
+// containerUsageLocked returns how many bytes of each container block files
+// still use. mn.lock must be held.
+func (mn *MasterNode) containerUsageLocked() map[uuid.UUID]int64 {
+	used := make(map[uuid.UUID]int64)
+	for _, inode := range mn.Namespace {
+		for i, blockID := range inode.Blocks {
+			if ext := inode.extent(i); ext != nil {
+				used[blockID] += ext.Length
+			}
+		}
+	}
+	return used
+}
+
+// packBatches splits candidates into batches that fit a container.
+func packBatches(candidates []packCandidate) [][]packCandidate {
+	var batches [][]packCandidate
+	var batch []packCandidate
+	var size int64
+	for _, c := range candidates {
+		if len(batch) > 0 && size+c.size > ContainerBlockSize {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, c)
+		size += c.size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// packBatch copies the data of batch into a new container block and points
+// the files that did not change meanwhile at their extents of it. It returns
+// the number of files moved.
+func (mn *MasterNode) packBatch(group packGroup, batch []packCandidate) (int, error) {
+	// Workers budget the packer's reads and writes as background I/O.
+	ctx, cancel := context.WithTimeout(outgoingIOClass(context.Background(), IOClassBackground), packTimeout)
+	defer cancel()
+
+	var data []byte
+	files := make([]PackedFile, 0, len(batch))
+	sources := make([]packCandidate, 0, len(batch))
+	for _, c := range batch {
+		chunk, err := mn.readCandidate(ctx, c)
+		if err != nil {
+			log.Printf("Packer: skipping %s: %v", c.path, err)
+			continue
+		}
+		files = append(files, PackedFile{
+			Path:       c.path,
+			Generation: c.generation,
+			Extent: Extent{
+				Offset:   int64(len(data)),
+				Length:   int64(len(chunk)),
+				Checksum: crc32.ChecksumIEEE(chunk),
+			},
+		})
+		sources = append(sources, c)
+		data = append(data, chunk...)
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+
+	containerID := uuid.New()
+	replicas, err := mn.storeContainer(ctx, containerID, data, group)
+	if err != nil {
+		return 0, err
+	}
+	discard := func() {
+		for _, replica := range replicas {
+			go mn.deleteReplica(containerID, replica)
+		}
+	}
+
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		discard()
+		return 0, err
+	}
+
+	payload := PackPayload{
+		Container: containerID,
+		ProjectID: group.projectID,
+		Size:      int64(len(data)),
+		Checksum:  crc32.ChecksumIEEE(data),
+	}
+	for i, f := range files {
+		if mn.unchangedLocked(sources[i]) {
+			payload.Files = append(payload.Files, f)
+		}
+	}
+	if len(payload.Files) == 0 {
+		discard()
+		return 0, nil
+	}
+	changes := make(refChanges)
+	for _, f := range payload.Files {
+		mn.dropRefLocked(changes, mn.Namespace[f.Path].Blocks[0])
+	}
+	payload.Refs = changes.payload()
+
+	op := OperationLogEntry{
+		OpType:    OpPackFiles,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	if err := mn.appendToLog(op); err != nil {
+		discard()
+		return 0, fmt.Errorf("failed to write operation log: %w", err)
+	}
+
+	mn.BlockMap[containerID] = &BlockMetadata{
+		BlockID:   containerID,
+		Size:      payload.Size,
+		Checksum:  payload.Checksum,
+		ProjectID: group.projectID,
+		Replicas:  replicas,
+		Container: true,
+	}
+	mn.releasePackedLocked(mn.applyPackLocked(payload), payload.Refs)
+	log.Printf("Packed %d files into container %s (%d bytes)", len(payload.Files), containerID, payload.Size)
+	return len(payload.Files), nil
+}
+
+// unchangedLocked reports whether the file of c still has the data c was
+// read from. mn.lock must be held.
+func (mn *MasterNode) unchangedLocked(c packCandidate) bool {
+	inode, ok := mn.Namespace[c.path]
+	if !ok || inode.Generation != c.generation || len(inode.Blocks) != 1 || inode.Blocks[0] != c.block {
+		return false
+	}
+	ext := inode.extent(0)
+	if ext == nil || c.extent == nil {
+		return ext == c.extent
+	}
+	return *ext == *c.extent
+}
+
+// applyPackLocked points the files of p at their extents of the container and
+// returns the blocks they used before. Files that changed since their data
+// was copied keep theirs. mn.lock must be held.
+func (mn *MasterNode) applyPackLocked(p PackPayload) []uuid.UUID {
+	if _, ok := mn.BlockMap[p.Container]; !ok {
+		projectID := p.ProjectID
+		for _, f := range p.Files {
+			if inode, ok := mn.Namespace[f.Path]; ok && projectID == "" {
+				projectID = inode.ProjectID
+			}
+		}
+		mn.BlockMap[p.Container] = &BlockMetadata{
+			BlockID:   p.Container,
+			Size:      p.Size,
+			Checksum:  p.Checksum,
+			ProjectID: projectID,
+			Replicas:  make([]uuid.UUID, 0),
+			Container: true,
+		}
+	}
+
+	released := make([]uuid.UUID, 0, len(p.Files))
+	for _, f := range p.Files {
+		inode, ok := mn.Namespace[f.Path]
+		if !ok || inode.Type != FileType || inode.Generation != f.Generation || len(inode.Blocks) != 1 {
+			continue
+		}
+		released = append(released, inode.Blocks[0])
+		extent := f.Extent
+		inode.Blocks = []uuid.UUID{p.Container}
+		inode.Extents = []*Extent{&extent}
+	}
+	return released
+}
+
+// releasePackedLocked forgets the blocks packed files used before, with the
+// logged references of dedup ones, and schedules the deletion of the blocks
+// no file uses any more. mn.lock must be held.
+func (mn *MasterNode) releasePackedLocked(blockIDs []uuid.UUID, refs *BlockRefsPayload) {
+	for _, blockID := range blockIDs {
+		if meta, ok := mn.BlockMap[blockID]; ok && !meta.Container && meta.ContentHash == "" {
+			delete(mn.BlockMap, blockID)
+			mn.deleteLaterLocked(meta)
+		}
+	}
+	for _, meta := range mn.applyBlockRefsLocked(refs) {
+		mn.deleteLaterLocked(meta)
+	}
+}
+
+// checkExtentLocked verifies that the extent b of a committed file lies
+// within a container block of projectID, so a commit cannot point a file at
+// another project's data or past the end of a container. mn.lock must be
+// held.
+func (mn *MasterNode) checkExtentLocked(blockID uuid.UUID, projectID string, b *commonv1.BlockInfo) error {
+	meta, ok := mn.BlockMap[blockID]
+	if !ok {
+		return fmt.Errorf("container %s: %w", blockID, ErrNotFound)
+	}
+	if !meta.Container || meta.ProjectID != projectID {
+		return fmt.Errorf("block %s is not a container of project %s", blockID, projectID)
+	}
+	if b.Offset < 0 || b.Size < 0 || b.Offset+b.Size > meta.Size {
+		return fmt.Errorf("extent %d+%d is outside container %s of %d bytes", b.Offset, b.Size, blockID, meta.Size)
+	}
+	return nil
+}
+
+// dropUnusedContainers deletes the container blocks no file has an extent of
+// and returns how many there were.
+func (mn *MasterNode) dropUnusedContainers() int {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if mn.checkWritableLocked() != nil {
+		return 0
+	}
+	used := mn.containerUsageLocked()
+	dropped := 0
+	for blockID, meta := range mn.BlockMap {
+		if !meta.Container {
+			continue
+		}
+		if _, ok := used[blockID]; ok {
+			continue
+		}
+		delete(mn.BlockMap, blockID)
+		mn.deleteLaterLocked(meta)
+		dropped++
+	}
+	return dropped
+}
+
+// readCandidate reads the data of c from the first replica that returns it
+// intact.
+func (mn *MasterNode) readCandidate(ctx context.Context, c packCandidate) ([]byte, error) {
+	req := &datanodev1.FetchBlockRequest{
+		BlockId:    c.block.String(),
+		BlockToken: mn.blockToken(c.block, "", blocktoken.OpRead),
+	}
+	if c.extent != nil {
+		req.Offset, req.Length = c.extent.Offset, c.extent.Length
+	}
+
+	var lastErr error
+	for _, replica := range c.replicas {
+		data, err := mn.fetchBlockData(ctx, replica, req)
+		if err == nil && int64(len(data)) != c.size {
+			err = fmt.Errorf("read %d bytes, want %d", len(data), c.size)
+		}
+		if err == nil && c.checksum != 0 && crc32.ChecksumIEEE(data) != c.checksum {
+			err = fmt.Errorf("checksum %08x, want %08x", crc32.ChecksumIEEE(data), c.checksum)
+		}
+		if err == nil {
+			return data, nil
+		}
+		lastErr = fmt.Errorf("block %s on worker %s: %w", c.block, replica, err)
+	}
+	return nil, lastErr
+}
+
+func (mn *MasterNode) fetchBlockData(ctx context.Context, workerID uuid.UUID, req *datanodev1.FetchBlockRequest) ([]byte, error) {
+	client, _, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerID.String())
+	if err != nil {
+		return nil, err
+	}
+	stream, err := client.FetchBlock(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, resp.Chunk...)
+	}
+}
+
+// storeContainer writes a container block to workers matching the group's
+// storage policy and returns the ones that stored it intact.
+func (mn *MasterNode) storeContainer(ctx context.Context, blockID uuid.UUID, data []byte, group packGroup) ([]uuid.UUID, error) {
+	workerIDs, workers := mn.LoadBalancer.GetNextClients(group.replication, storagePolicyTypes[group.policy])
+	if len(workerIDs) == 0 {
+		return nil, fmt.Errorf("no in-service workers available")
+	}
+
+	checksum := crc32.ChecksumIEEE(data)
+	token := mn.blockToken(blockID, group.projectID, blocktoken.OpWrite)
+	stored := make([]uuid.UUID, 0, len(workerIDs))
+	var lastErr error
+	for i, workerID := range workerIDs {
+		workerUUID, err := uuid.Parse(workerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse worker uuid: %v", err)
+		}
+		if err := pushBlock(ctx, workers[i].Client, blockID, token, data, checksum); err != nil {
+			lastErr = fmt.Errorf("push container %s to worker %s: %w", blockID, workerID, err)
+			continue
+		}
+		stored = append(stored, workerUUID)
+	}
+	if len(stored) == 0 {
+		return nil, lastErr
+	}
+	if len(stored) < group.replication {
+		log.Printf("Container %s gets %d of %d replicas", blockID, len(stored), group.replication)
+	}
+	return stored, nil
+}
+
+func pushBlock(ctx context.Context, client datanodev1.DataNodeServiceClient, blockID uuid.UUID, token string, data []byte, checksum uint32) error {
+	stream, err := client.PushBlock(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = stream.Send(&datanodev1.PushBlockRequest{
+		Data: &datanodev1.PushBlockRequest_Metadata{
+			Metadata: &datanodev1.BlockMetadata{
+				BlockId:    blockID.String(),
+				TotalSize:  int64(len(data)),
+				BlockToken: token,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for off := 0; off < len(data); off += packChunkSize {
+		chunk := data[off:min(off+packChunkSize, len(data))]
+		err := stream.Send(&datanodev1.PushBlockRequest{
+			Data: &datanodev1.PushBlockRequest_Chunk{Chunk: chunk},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Message)
+	}
+	if resp.Checksum != checksum {
+		return fmt.Errorf("checksum mismatch: worker stored %08x, sent %08x", resp.Checksum, checksum)
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package nodes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeBlockStore is a worker that keeps its blocks in memory.
+type fakeBlockStore struct {
+	datanodev1.DataNodeServiceClient
+	mu     sync.Mutex
+	blocks map[string][]byte
+}
+
+func (f *fakeBlockStore) block(id string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.blocks[id]
+	return data, ok
+}
+
+func (f *fakeBlockStore) FetchBlock(ctx context.Context, in *datanodev1.FetchBlockRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[datanodev1.FetchBlockResponse], error) {
+	data, ok := f.block(in.BlockId)
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", in.BlockId)
+	}
+	if in.Length > 0 {
+		data = data[in.Offset : in.Offset+in.Length]
+	}
+	return &fakeFetchStream{chunks: [][]byte{data}}, nil
+}
+
+func (f *fakeBlockStore) PushBlock(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[datanodev1.PushBlockRequest, datanodev1.PushBlockResponse], error) {
+	return &fakePushStream{store: f}, nil
+}
+
+func (f *fakeBlockStore) GetBlockChecksum(ctx context.Context, in *datanodev1.GetBlockChecksumRequest, opts ...grpc.CallOption) (*datanodev1.GetBlockChecksumResponse, error) {
+	data, ok := f.block(in.BlockId)
+	sum := sha256.Sum256(data)
+	return &datanodev1.GetBlockChecksumResponse{Checksum: crc32.ChecksumIEEE(data), Exists: ok, ContentHash: hex.EncodeToString(sum[:])}, nil
+}
+
+func (f *fakeBlockStore) DeleteBlock(ctx context.Context, in *datanodev1.DeleteBlockRequest, opts ...grpc.CallOption) (*datanodev1.DeleteBlockResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.blocks, in.BlockId)
+	return &datanodev1.DeleteBlockResponse{Success: true}, nil
+}
+
+type fakeFetchStream struct {
+	grpc.ServerStreamingClient[datanodev1.FetchBlockResponse]
+	chunks [][]byte
+}
+
+func (s *fakeFetchStream) Recv() (*datanodev1.FetchBlockResponse, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return &datanodev1.FetchBlockResponse{Chunk: chunk}, nil
+}
+
+type fakePushStream struct {
+	grpc.ClientStreamingClient[datanodev1.PushBlockRequest, datanodev1.PushBlockResponse]
+	store   *fakeBlockStore
+	blockID string
+	data    []byte
+}
+
+func (s *fakePushStream) Send(req *datanodev1.PushBlockRequest) error {
+	if meta := req.GetMetadata(); meta != nil {
+		s.blockID = meta.BlockId
+	}
+	s.data = append(s.data, req.GetChunk()...)
+	return nil
+}
+
+func (s *fakePushStream) CloseAndRecv() (*datanodev1.PushBlockResponse, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	s.store.blocks[s.blockID] = s.data
+	return &datanodev1.PushBlockResponse{Success: true, Checksum: crc32.ChecksumIEEE(s.data)}, nil
+}
+
+// packingMaster returns a master with a single in-memory worker.
+func packingMaster(t *testing.T) (*MasterNode, *fakeBlockStore) {
+	master := setupTestMaster(t)
+	store := &fakeBlockStore{blocks: make(map[string][]byte)}
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	lb.AddWorker(uuid.New().String(), load_balancer.WorkerMetadata{
+		Client:      store,
+		Ip:          "worker-0.worker-headless",
+		Port:        50051,
+		Alive:       true,
+		StorageType: load_balancer.StorageDisk,
+	})
+	master.LoadBalancer = lb
+	return master, store
+}
+
+// writeSmallFile stores data as a single-block file at path.
+func writeSmallFile(t *testing.T, master *MasterNode, store *fakeBlockStore, path, data string) {
+	t.Helper()
+	var workerID uuid.UUID
+	for id := range master.LoadBalancer.Workers() {
+		workerID = uuid.MustParse(id)
+	}
+
+	blockID := uuid.New()
+	store.mu.Lock()
+	store.blocks[blockID.String()] = []byte(data)
+	store.mu.Unlock()
+	master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Replicas: []uuid.UUID{workerID}}
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+		ProjectId: strings.SplitN(path, "/", 2)[0],
+		FilePath:  path,
+		Blocks: []*commonv1.BlockInfo{{
+			BlockId:  blockID.String(),
+			Size:     int64(len(data)),
+			Checksum: int64(crc32.ChecksumIEEE([]byte(data))),
+		}},
+	})
+	require.NoError(t, err)
+}
+
+// readPackedFile reads a file the way clients do, from its block's extent.
+func readPackedFile(t *testing.T, master *MasterNode, store *fakeBlockStore, path string) string {
+	t.Helper()
+	resp, err := master.GetFileMetadata("proj", path, "")
+	require.NoError(t, err)
+	require.Len(t, resp.Blocks, 1)
+	info := resp.Blocks[0]
+	require.True(t, info.Packed)
+
+	container, ok := store.block(info.BlockId)
+	require.True(t, ok, "container %s is stored", info.BlockId)
+	data := container[info.Offset : info.Offset+info.Size]
+	assert.Equal(t, uint32(info.Checksum), crc32.ChecksumIEEE(data))
+	return string(data)
+}
+
+func TestMasterNode_PackSmallFiles(t *testing.T) {
+	master, store := packingMaster(t)
+
+	files := map[string]string{
+		"proj/raw/a.avro": "first flush of the ingestion consumer",
+		"proj/raw/b.avro": "second",
+		"proj/raw/c.avro": "third flush of the day",
+	}
+	for path, data := range files {
+		writeSmallFile(t, master, store, path, data)
+	}
+	oldBlocks := make(map[string]uuid.UUID)
+	for path := range files {
+		oldBlocks[path] = master.Namespace[path].Blocks[0]
+	}
+
+	assert.Equal(t, 3, master.PackSmallFiles())
+
+	container := master.Namespace["proj/raw/a.avro"].Blocks[0]
+	require.True(t, master.BlockMap[container].Container)
+	for path, data := range files {
+		assert.Equal(t, []uuid.UUID{container}, master.Namespace[path].Blocks)
+		assert.Equal(t, data, readPackedFile(t, master, store, path))
+
+		crc, length, err := master.FileChecksum(path)
+		require.NoError(t, err)
+		assert.Equal(t, crc32.ChecksumIEEE([]byte(data)), crc)
+		assert.Equal(t, int64(len(data)), length)
+
+		assert.NotContains(t, master.BlockMap, oldBlocks[path], "the file's own block is released")
+		_, ok := store.block(oldBlocks[path].String())
+		assert.True(t, ok, "readers of the old block have a grace period")
+	}
+	assert.Equal(t, 0, master.DeleteReleasedBlocks(time.Now()))
+	assert.Equal(t, 3, master.DeleteReleasedBlocks(time.Now().Add(BlockDeleteDelay)))
+	for path := range files {
+		_, ok := store.block(oldBlocks[path].String())
+		assert.False(t, ok, "the old block of %s is deleted", path)
+	}
+
+	t.Run("packed files stay packed", func(t *testing.T) {
+		assert.Equal(t, 0, master.PackSmallFiles())
+	})
+
+	t.Run("concat keeps the extents", func(t *testing.T) {
+		require.NoError(t, master.ConcatFiles(Superuser, &coordinatorv1.ConcatFilesRequest{
+			ProjectId:   "proj",
+			TargetPath:  "proj/raw/ac.avro",
+			SourcePaths: []string{"proj/raw/a.avro", "proj/raw/c.avro"},
+		}))
+		resp, err := master.GetFileMetadata("proj", "proj/raw/ac.avro", "")
+		require.NoError(t, err)
+		require.Len(t, resp.Blocks, 2)
+		container, _ := store.block(resp.Blocks[0].BlockId)
+		var got string
+		for _, info := range resp.Blocks {
+			assert.True(t, info.Packed)
+			got += string(container[info.Offset : info.Offset+info.Size])
+		}
+		assert.Equal(t, files["proj/raw/a.avro"]+files["proj/raw/c.avro"], got)
+		assert.Equal(t, int64(len(got)), master.Namespace["proj/raw/ac.avro"].Size)
+	})
+
+	t.Run("deleting packed files keeps the container", func(t *testing.T) {
+		require.NoError(t, master.DeleteFile(Superuser, "proj/raw/ac.avro", false))
+		assert.Contains(t, master.BlockMap, container)
+		_, ok := store.block(container.String())
+		assert.True(t, ok)
+		assert.Equal(t, files["proj/raw/b.avro"], readPackedFile(t, master, store, "proj/raw/b.avro"))
+	})
+
+	t.Run("mostly unused containers are rewritten", func(t *testing.T) {
+		assert.Equal(t, 1, master.PackSmallFiles())
+
+		rewritten := master.Namespace["proj/raw/b.avro"].Blocks[0]
+		assert.NotEqual(t, container, rewritten)
+		assert.Equal(t, files["proj/raw/b.avro"], readPackedFile(t, master, store, "proj/raw/b.avro"))
+		assert.NotContains(t, master.BlockMap, container)
+		_, ok := store.block(container.String())
+		assert.True(t, ok)
+		master.DeleteReleasedBlocks(time.Now().Add(BlockDeleteDelay))
+		_, ok = store.block(container.String())
+		assert.False(t, ok)
+	})
+
+	t.Run("unused containers are deleted", func(t *testing.T) {
+		last := master.Namespace["proj/raw/b.avro"].Blocks[0]
+		require.NoError(t, master.DeleteFile(Superuser, "proj/raw/b.avro", false))
+		master.PackSmallFiles()
+		assert.NotContains(t, master.BlockMap, last)
+	})
+}
+
+func TestMasterNode_PackSmallFilesSkips(t *testing.T) {
+	master, store := packingMaster(t)
+
+	writeSmallFile(t, master, store, "proj/a.avro", "alone")
+	writeSmallFile(t, master, store, "other/b.avro", "another project")
+	assert.Equal(t, 0, master.PackSmallFiles(), "a lone file per project is not packed")
+
+	writeSmallFile(t, master, store, "proj/big.avro", string(make([]byte, SmallFileThreshold+1)))
+	assert.Equal(t, 0, master.PackSmallFiles(), "large files are not packed")
+	assert.Nil(t, master.Namespace["proj/big.avro"].Extents)
+}
+
+func TestMasterNode_ReplicatedPack(t *testing.T) {
+	leader, store := packingMaster(t)
+	standby := setupTestMaster(t)
+
+	writeSmallFile(t, leader, store, "proj/raw/a.avro", "first flush")
+	writeSmallFile(t, leader, store, "proj/raw/b.avro", "second flush")
+	require.Equal(t, 2, leader.PackSmallFiles())
+
+	replayLog(t, leader, standby)
+
+	for _, path := range []string{"proj/raw/a.avro", "proj/raw/b.avro"} {
+		assert.Equal(t, leader.Namespace[path].Blocks, standby.Namespace[path].Blocks)
+		assert.Equal(t, leader.Namespace[path].Extents, standby.Namespace[path].Extents)
+	}
+	container := leader.Namespace["proj/raw/a.avro"].Blocks[0]
+	require.Contains(t, standby.BlockMap, container)
+	assert.True(t, standby.BlockMap[container].Container)
+	assert.Equal(t, "proj", standby.BlockMap[container].ProjectID)
+
+	// The standby forgets the old blocks too, and deletes them if it leads
+	// before the leader did.
+	assert.Len(t, standby.BlockMap, 1)
+	assert.Len(t, standby.releasedBlocks, 2)
+}
+
+func TestMasterNode_CommitChecksExtents(t *testing.T) {
+	master, store := packingMaster(t)
+	writeSmallFile(t, master, store, "proj/raw/a.avro", "first flush")
+	writeSmallFile(t, master, store, "proj/raw/b.avro", "second flush")
+	writeSmallFile(t, master, store, "other/raw/c.avro", "another project")
+	writeSmallFile(t, master, store, "other/raw/d.avro", "and another")
+	require.Equal(t, 4, master.PackSmallFiles())
+	container := master.Namespace["proj/raw/a.avro"].Blocks[0]
+	plain := uuid.New()
+	master.BlockMap[plain] = &BlockMetadata{BlockID: plain, Size: 100}
+
+	commit := func(projectID string, blockID uuid.UUID, offset, size int64) error {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+			ProjectId: projectID,
+			FilePath:  projectID + "/stolen.avro",
+			Blocks:    []*commonv1.BlockInfo{{BlockId: blockID.String(), Packed: true, Offset: offset, Size: size}},
+		})
+		return err
+	}
+	size := master.BlockMap[container].Size
+	assert.NoError(t, commit("proj", container, 0, size))
+	assert.Error(t, commit("other", container, 0, size), "another project's container")
+	assert.Error(t, commit("proj", container, 1, size), "past the end of the container")
+	assert.Error(t, commit("proj", container, -1, 2))
+	assert.Error(t, commit("proj", plain, 0, 10), "not a container")
+	assert.ErrorIs(t, commit("proj", uuid.New(), 0, 10), ErrNotFound)
+}
+
+type mockFetchBlockServer struct {
+	datanodev1.DataNodeService_FetchBlockServer
+	data []byte
+}
+
+func (m *mockFetchBlockServer) Send(resp *datanodev1.FetchBlockResponse) error {
+	m.data = append(m.data, resp.Chunk...)
+	return nil
+}
+
+func (m *mockFetchBlockServer) Context() context.Context {
+	return context.Background()
+}
+
+func TestWorkerNode_FetchBlockRange(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+
+	data := []byte("aaaabbbbbbcc")
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "container.bin"), data, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "container.checksum"), []byte(fmt.Sprint(crc32.ChecksumIEEE(data))), 0644))
+
+	stream := &mockFetchBlockServer{}
+	require.NoError(t, worker.FetchBlock(&datanodev1.FetchBlockRequest{BlockId: "container", Offset: 4, Length: 6}, stream))
+	assert.Equal(t, "bbbbbb", string(stream.data))
+
+	stream = &mockFetchBlockServer{}
+	require.NoError(t, worker.FetchBlock(&datanodev1.FetchBlockRequest{BlockId: "container"}, stream))
+	assert.Equal(t, data, stream.data)
+
+	stream = &mockFetchBlockServer{}
+	require.NoError(t, worker.FetchBlock(&datanodev1.FetchBlockRequest{BlockId: "container", Offset: 10}, stream))
+	assert.Equal(t, "cc", string(stream.data), "a length of 0 reads to the end")
+
+	err := worker.FetchBlock(&datanodev1.FetchBlockRequest{BlockId: "container", Offset: 10, Length: 6}, &mockFetchBlockServer{})
+	assert.Error(t, err, "ranges past the end are rejected")
+	err = worker.FetchBlock(&datanodev1.FetchBlockRequest{BlockId: "container", Offset: 13}, &mockFetchBlockServer{})
+	assert.Error(t, err)
+}
+
+func TestWorkerNode_FetchBlockRangeVerifies(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+
+	data := []byte("aaaabbbbbbcc")
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "container.bin"), []byte("aaaaXbbbbbcc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "container.checksum"), []byte(fmt.Sprint(crc32.ChecksumIEEE(data))), 0644))
+
+	err := worker.FetchBlock(&datanodev1.FetchBlockRequest{BlockId: "container", Offset: 4, Length: 6}, &mockFetchBlockServer{})
+	assert.Equal(t, codes.DataLoss, status.Code(err), "a corrupt container fails ranged reads")
+	assert.Equal(t, []string{"container"}, worker.CorruptBlocks())
+}
@@ -0,0 +1,374 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ErrPermissionDenied is returned when the caller lacks the permission an
+// operation needs.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Access is a permission bit, as in the rwx triplets of a mode.
+type Access uint32
+
+const (
+	AccessRead    Access = 4
+	AccessWrite   Access = 2
+	AccessExecute Access = 1
+)
+
+const (
+	// DefaultUmask is cleared from the mode of new files and directories.
+	DefaultUmask = 0o022
+
+	// AccessTimePrecision is how stale a file's access time may get before a
+	// read updates it, so that reads do not write to the op log every time.
+	AccessTimePrecision = time.Hour
+
+	// openDirMode and openFileMode are the permissions of inodes without an
+	// owner.
+	openDirMode  = 0o777
+	openFileMode = 0o666
+
+	maxXAttrs     = 32
+	maxXAttrBytes = 16 << 10
+)
+
+// Caller is who an operation is checked against.
+type Caller struct {
+	User   string
+	Groups []string
+	// Superuser skips all permission checks.
+	Superuser bool
+}
+
+// Superuser is the Caller of operations the master makes on its own behalf.
+var Superuser = Caller{Superuser: true}
+
+// owner returns the owner of what the caller creates: its identity, or for
+// superusers without one the owner they name. Other callers without an
+// identity create inodes without an owner.
+func (c Caller) owner(requested string) string {
+	switch {
+	case c.User != "":
+		return c.User
+	case c.Superuser:
+		return requested
+	}
+	return ""
+}
+
+// Attrs are the attribute changes of a SetAttr; nil fields stay as they are.
+type Attrs struct {
+	Mode       *uint32
+	Owner      *string
+	Group      *string
+	ModTime    *time.Time
+	AccessTime *time.Time
+}
+
+// AttrPayload is the op-log payload of OpSetAttr: the attributes of Path
+// after the change.
+type AttrPayload struct {
+	Path    string            `json:"path"`
+	Mode    uint32            `json:"mode"`
+	OwnerID string            `json:"ownerId"`
+	Group   string            `json:"group"`
+	ModTime time.Time         `json:"modTime"`
+	ATime   time.Time         `json:"atime"`
+	CTime   time.Time         `json:"ctime"`
+	XAttrs  map[string]string `json:"xattrs,omitempty"`
+}
+
+// permissions returns the permission bits checks use. Inodes without an owner,
+// written before permissions existed or by clients without an identity, are
+// open to everyone.
+func (inode *Inode) permissions() uint32 {
+	if inode.OwnerID == "" || inode.Mode == 0 {
+		if inode.Type == DirType {
+			return openDirMode
+		}
+		return openFileMode
+	}
+	return inode.Mode & 0o777
+}
+
+// allows reports whether caller has access to inode.
+func (inode *Inode) allows(caller Caller, access Access) bool {
+	if caller.Superuser {
+		return true
+	}
+	perm := inode.permissions()
+	switch {
+	case inode.OwnerID != "" && caller.User == inode.OwnerID:
+		perm >>= 6
+	case slices.Contains(caller.Groups, inode.Group):
+		perm >>= 3
+	}
+	return Access(perm)&access == access
+}
+
+// newMode is the mode of an inode created by owner, 0 when there is no owner.
+func (mn *MasterNode) newMode(t InodeType, owner string) uint32 {
+	if owner == "" {
+		return 0
+	}
+	if t == DirType {
+		return 0o777 &^ mn.Umask
+	}
+	return 0o666 &^ mn.Umask
+}
+
+// newGroup is the group of an inode created below dirPath: the directory's,
+// or the project's for directories at the top of the namespace.
+func (mn *MasterNode) newGroup(dirPath, projectID string) string {
+	if parent, ok := mn.Namespace[dirPath]; ok && parent.Group != "" {
+		return parent.Group
+	}
+	return projectID
+}
+
+// CheckAccess checks that caller may traverse the directories above path and
+// has access to path itself, if it exists.
+func (mn *MasterNode) CheckAccess(caller Caller, path string, access Access) error {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+	return mn.checkAccessLocked(caller, filepath.Clean(path), access)
+}
+
+// CheckParentAccess is CheckAccess on the closest existing directory above
+// path, the one an operation creating or removing path changes.
+func (mn *MasterNode) CheckParentAccess(caller Caller, path string, access Access) error {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+	return mn.checkParentAccessLocked(caller, path, access)
+}
+
+// CheckCreate checks that caller may create or replace the file at path.
+// Operations that do check again under the lock they make the change in.
+func (mn *MasterNode) CheckCreate(caller Caller, path string) error {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+	return mn.checkCreateLocked(caller, path)
+}
+
+func (mn *MasterNode) checkCreateLocked(caller Caller, path string) error {
+	if err := mn.checkParentAccessLocked(caller, path, AccessWrite|AccessExecute); err != nil {
+		return err
+	}
+	return mn.checkAccessLocked(caller, filepath.Clean(path), AccessWrite)
+}
+
+// checkEntryLocked checks that caller may add or remove path in its
+// directory.
+func (mn *MasterNode) checkEntryLocked(caller Caller, path string) error {
+	return mn.checkParentAccessLocked(caller, path, AccessWrite|AccessExecute)
+}
+
+func (mn *MasterNode) checkParentAccessLocked(caller Caller, path string, access Access) error {
+	dir := filepath.Dir(filepath.Clean(path))
+	for dir != "." {
+		if _, ok := mn.Namespace[dir]; ok {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return mn.checkAccessLocked(caller, dir, access)
+}
+
+func (mn *MasterNode) checkAccessLocked(caller Caller, path string, access Access) error {
+	if caller.Superuser {
+		return nil
+	}
+	for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+		if inode, ok := mn.Namespace[dir]; ok && !inode.allows(caller, AccessExecute) {
+			return fmt.Errorf("%s: %w", dir, ErrPermissionDenied)
+		}
+	}
+	if inode, ok := mn.Namespace[path]; ok && !inode.allows(caller, access) {
+		return fmt.Errorf("%s: %w", path, ErrPermissionDenied)
+	}
+	return nil
+}
+
+// SetAttr changes the mode, owner, group or times of path.
+func (mn *MasterNode) SetAttr(caller Caller, path string, attrs Attrs) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	inode, err := mn.attrInodeLocked(caller, path)
+	if err != nil {
+		return err
+	}
+	isOwner := caller.Superuser || (inode.OwnerID != "" && caller.User == inode.OwnerID)
+
+	if attrs.Owner != nil && *attrs.Owner != inode.OwnerID && !caller.Superuser {
+		return fmt.Errorf("%s: only superusers may change the owner: %w", path, ErrPermissionDenied)
+	}
+	if attrs.Group != nil && !caller.Superuser && (!isOwner || !slices.Contains(caller.Groups, *attrs.Group)) {
+		return fmt.Errorf("%s: changing the group to %q: %w", path, *attrs.Group, ErrPermissionDenied)
+	}
+	if attrs.Mode != nil && !isOwner {
+		return fmt.Errorf("%s: only the owner may change the mode: %w", path, ErrPermissionDenied)
+	}
+	if (attrs.ModTime != nil || attrs.AccessTime != nil) && !isOwner && !inode.allows(caller, AccessWrite) {
+		return fmt.Errorf("%s: %w", path, ErrPermissionDenied)
+	}
+	if attrs.Mode != nil && *attrs.Mode&^0o777 != 0 {
+		return fmt.Errorf("invalid mode %#o", *attrs.Mode)
+	}
+
+	payload := attrPayload(inode)
+	if attrs.Mode != nil {
+		payload.Mode = *attrs.Mode
+	}
+	if attrs.Owner != nil {
+		payload.OwnerID = *attrs.Owner
+	}
+	if attrs.Group != nil {
+		payload.Group = *attrs.Group
+	}
+	if attrs.ModTime != nil {
+		payload.ModTime = *attrs.ModTime
+	}
+	if attrs.AccessTime != nil {
+		payload.ATime = *attrs.AccessTime
+	}
+	// An inode that gets an owner gets the default mode with it.
+	if inode.OwnerID == "" && payload.OwnerID != "" && attrs.Mode == nil {
+		payload.Mode = mn.newMode(inode.Type, payload.OwnerID)
+	}
+	payload.CTime = time.Now()
+	return mn.setAttrLocked(inode, payload)
+}
+
+// SetXAttr sets the extended attribute name of path to value, or removes it.
+func (mn *MasterNode) SetXAttr(caller Caller, path, name, value string, remove bool) error {
+	if name == "" {
+		return errors.New("empty extended attribute name")
+	}
+
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	inode, err := mn.attrInodeLocked(caller, path)
+	if err != nil {
+		return err
+	}
+	if !inode.allows(caller, AccessWrite) {
+		return fmt.Errorf("%s: %w", path, ErrPermissionDenied)
+	}
+
+	payload := attrPayload(inode)
+	payload.XAttrs = maps.Clone(inode.XAttrs)
+	if remove {
+		if _, ok := payload.XAttrs[name]; !ok {
+			return fmt.Errorf("%s: extended attribute %q: %w", path, name, ErrNotFound)
+		}
+		delete(payload.XAttrs, name)
+	} else {
+		if payload.XAttrs == nil {
+			payload.XAttrs = make(map[string]string)
+		}
+		payload.XAttrs[name] = value
+		size := 0
+		for k, v := range payload.XAttrs {
+			size += len(k) + len(v)
+		}
+		if len(payload.XAttrs) > maxXAttrs || size > maxXAttrBytes {
+			return fmt.Errorf("%s: extended attributes are limited to %d per inode of %d bytes in total", path, maxXAttrs, maxXAttrBytes)
+		}
+	}
+	payload.CTime = time.Now()
+	return mn.setAttrLocked(inode, payload)
+}
+
+// TouchAccessTime records a read of the file at path, if its access time is
+// older than AccessTimePrecision.
+func (mn *MasterNode) TouchAccessTime(path string) {
+	path = filepath.Clean(path)
+	now := time.Now()
+
+	mn.lock.RLock()
+	inode, ok := mn.Namespace[path]
+	stale := ok && now.Sub(inode.ATime) >= AccessTimePrecision
+	mn.lock.RUnlock()
+	if !stale {
+		return
+	}
+
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+	inode, ok = mn.Namespace[path]
+	if !ok || now.Sub(inode.ATime) < AccessTimePrecision {
+		return
+	}
+	payload := attrPayload(inode)
+	payload.ATime = now
+	if err := mn.setAttrLocked(inode, payload); err != nil {
+		log.Printf("Failed to update the access time of %s: %v", path, err)
+	}
+}
+
+// attrInodeLocked returns the inode of path for an attribute change.
+func (mn *MasterNode) attrInodeLocked(caller Caller, path string) (*Inode, error) {
+	if err := mn.checkAccessLocked(caller, path, 0); err != nil {
+		return nil, err
+	}
+	if inode, ok := mn.Namespace[path]; ok {
+		return inode, nil
+	}
+	return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+}
+
+func attrPayload(inode *Inode) AttrPayload {
+	return AttrPayload{
+		Path:    inode.Path,
+		Mode:    inode.Mode,
+		OwnerID: inode.OwnerID,
+		Group:   inode.Group,
+		ModTime: inode.ModTime,
+		ATime:   inode.ATime,
+		CTime:   inode.CTime,
+		XAttrs:  inode.XAttrs,
+	}
+}
+
+func (mn *MasterNode) setAttrLocked(inode *Inode, payload AttrPayload) error {
+	op := OperationLogEntry{
+		OpType:    OpSetAttr,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	if err := mn.appendToLog(op); err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	applyAttrs(inode, payload)
+	return nil
+}
+
+func applyAttrs(inode *Inode, p AttrPayload) {
+	inode.Mode = p.Mode
+	inode.OwnerID = p.OwnerID
+	inode.Group = p.Group
+	inode.ModTime = p.ModTime
+	inode.ATime = p.ATime
+	inode.CTime = p.CTime
+	inode.XAttrs = p.XAttrs
+}
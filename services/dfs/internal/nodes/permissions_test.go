@@ -0,0 +1,217 @@
+package nodes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestMasterNode_NewInodeAttributes(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Umask = DefaultUmask
+
+	require.NoError(t, master.Mkdirs(Superuser, "proj/raw", "proj", "alice"))
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/raw/a.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/raw/legacy.avro"})
+	require.NoError(t, err)
+
+	dir, err := master.StatPath("proj/raw")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0o755), dir.Mode)
+	assert.Equal(t, "alice", dir.Owner)
+	assert.Equal(t, "proj", dir.Group, "top-level directories take the project as group")
+
+	file, err := master.StatPath("proj/raw/a.avro")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0o644), file.Mode)
+	assert.Equal(t, "alice", file.Owner)
+	assert.Equal(t, "proj", file.Group, "files inherit their directory's group")
+	assert.False(t, file.CTime.IsZero())
+	assert.False(t, file.ATime.IsZero())
+
+	legacy, err := master.StatPath("proj/raw/legacy.avro")
+	require.NoError(t, err)
+	assert.Empty(t, legacy.Owner)
+	assert.Equal(t, uint32(0o666), legacy.Mode, "files without an owner are open")
+}
+
+func TestMasterNode_CheckAccess(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Umask = DefaultUmask
+
+	require.NoError(t, master.Mkdirs(Superuser, "proj/private", "proj", "alice"))
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/private/a.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	require.NoError(t, master.SetAttr(Superuser, "proj/private", Attrs{Mode: ptr(uint32(0o750))}))
+
+	alice := Caller{User: "alice"}
+	member := Caller{User: "bob", Groups: []string{"proj"}}
+	other := Caller{User: "eve"}
+
+	assert.NoError(t, master.CheckAccess(alice, "proj/private/a.avro", AccessRead|AccessWrite))
+	assert.NoError(t, master.CheckAccess(member, "proj/private/a.avro", AccessRead))
+	assert.ErrorIs(t, master.CheckAccess(member, "proj/private/a.avro", AccessWrite), ErrPermissionDenied)
+	assert.ErrorIs(t, master.CheckAccess(other, "proj/private/a.avro", AccessRead), ErrPermissionDenied,
+		"others may not traverse proj/private")
+	assert.NoError(t, master.CheckAccess(Superuser, "proj/private/a.avro", AccessWrite))
+
+	t.Run("parent of a new file", func(t *testing.T) {
+		assert.NoError(t, master.CheckParentAccess(alice, "proj/private/new/b.avro", AccessWrite))
+		assert.ErrorIs(t, master.CheckParentAccess(member, "proj/private/new/b.avro", AccessWrite), ErrPermissionDenied)
+	})
+
+	t.Run("missing paths only need their ancestors", func(t *testing.T) {
+		assert.NoError(t, master.CheckAccess(member, "proj/private/missing.avro", AccessWrite))
+	})
+}
+
+func TestMasterNode_MutationsCheckCaller(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Umask = DefaultUmask
+
+	alice := Caller{User: "alice"}
+	anonymous := Caller{}
+	require.NoError(t, master.Mkdirs(alice, "proj/raw", "proj", "mallory"))
+	_, err := master.CommitFile(alice, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/raw/a.avro", OwnerId: "mallory"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", master.Namespace["proj/raw"].OwnerID, "callers own what they create")
+	assert.Equal(t, "alice", master.Namespace["proj/raw/a.avro"].OwnerID)
+
+	// proj/raw is 0755: only alice may change what is in it.
+	_, err = master.CommitFile(anonymous, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/raw/b.avro", OwnerId: "alice"})
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.ErrorIs(t, master.DeleteFile(Caller{User: "bob"}, "proj/raw/a.avro", false), ErrPermissionDenied)
+	assert.ErrorIs(t, master.Rename(anonymous, "proj/raw/a.avro", "proj/a.avro"), ErrPermissionDenied)
+	assert.ErrorIs(t, master.Mkdirs(anonymous, "proj/raw/sub", "proj", ""), ErrPermissionDenied)
+	assert.ErrorIs(t, master.SetStoragePolicy(anonymous, "proj/raw/a.avro", StoragePolicyCold), ErrPermissionDenied)
+	assert.ErrorIs(t, master.ConcatFiles(anonymous, &coordinatorv1.ConcatFilesRequest{
+		ProjectId: "proj", TargetPath: "proj/c.avro", SourcePaths: []string{"proj/raw/a.avro"},
+	}), ErrPermissionDenied)
+	assert.Contains(t, master.Namespace, "proj/raw/a.avro")
+
+	// Without an identity, callers create inodes without an owner, whatever
+	// owner they name.
+	_, err = master.CommitFile(anonymous, &coordinatorv1.CommitFileRequest{ProjectId: "open", FilePath: "open/a.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	assert.Empty(t, master.Namespace["open/a.avro"].OwnerID)
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "open", FilePath: "open/b.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", master.Namespace["open/b.avro"].OwnerID, "superusers create files for others")
+}
+
+func TestMasterNode_SetAttr(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Umask = DefaultUmask
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/a.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	alice := Caller{User: "alice", Groups: []string{"analytics"}}
+	bob := Caller{User: "bob"}
+
+	assert.NoError(t, master.SetAttr(alice, "proj/a.avro", Attrs{Mode: ptr(uint32(0o600))}))
+	assert.ErrorIs(t, master.SetAttr(bob, "proj/a.avro", Attrs{Mode: ptr(uint32(0o777))}), ErrPermissionDenied)
+	assert.ErrorIs(t, master.SetAttr(alice, "proj/a.avro", Attrs{Owner: ptr("bob")}), ErrPermissionDenied,
+		"only superusers give files away")
+	assert.ErrorIs(t, master.SetAttr(alice, "proj/a.avro", Attrs{Group: ptr("ops")}), ErrPermissionDenied,
+		"owners may only pick groups they are in")
+	assert.NoError(t, master.SetAttr(alice, "proj/a.avro", Attrs{Group: ptr("analytics")}))
+	assert.Error(t, master.SetAttr(alice, "proj/a.avro", Attrs{Mode: ptr(uint32(0o4755))}))
+
+	mtime := time.Unix(1000, 0)
+	require.NoError(t, master.SetAttr(alice, "proj/a.avro", Attrs{ModTime: &mtime}))
+	require.NoError(t, master.SetAttr(Superuser, "proj/a.avro", Attrs{Owner: ptr("bob")}))
+
+	info, err := master.StatPath("proj/a.avro")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0o600), info.Mode)
+	assert.Equal(t, "bob", info.Owner)
+	assert.Equal(t, "analytics", info.Group)
+	assert.True(t, mtime.Equal(info.ModTime))
+
+	t.Run("implicit directory", func(t *testing.T) {
+		require.NoError(t, master.SetAttr(Superuser, "proj", Attrs{Owner: ptr("alice")}))
+		info, err := master.StatPath("proj")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", info.Owner)
+		assert.Equal(t, uint32(0o755), info.Mode, "a directory that gets an owner gets the default mode")
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		assert.ErrorIs(t, master.SetAttr(Superuser, "proj/missing", Attrs{Mode: ptr(uint32(0o644))}), ErrNotFound)
+	})
+}
+
+func TestMasterNode_SetXAttr(t *testing.T) {
+	master := setupTestMaster(t)
+	master.Umask = DefaultUmask
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/a.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	alice := Caller{User: "alice"}
+
+	require.NoError(t, master.SetXAttr(alice, "proj/a.avro", "user.schema", "v2", false))
+	assert.ErrorIs(t, master.SetXAttr(Caller{User: "bob"}, "proj/a.avro", "user.schema", "v3", false), ErrPermissionDenied)
+	assert.Error(t, master.SetXAttr(alice, "proj/a.avro", "user.big", strings.Repeat("x", maxXAttrBytes), false))
+	// The limit is on all attributes of the inode together.
+	half := strings.Repeat("x", maxXAttrBytes/2)
+	require.NoError(t, master.SetXAttr(alice, "proj/a.avro", "user.half", half, false))
+	assert.Error(t, master.SetXAttr(alice, "proj/a.avro", "user.other", half, false))
+	require.NoError(t, master.SetXAttr(alice, "proj/a.avro", "user.half", "", true))
+
+	info, err := master.StatPath("proj/a.avro")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user.schema": "v2"}, info.XAttrs)
+
+	require.NoError(t, master.SetXAttr(alice, "proj/a.avro", "user.schema", "", true))
+	assert.ErrorIs(t, master.SetXAttr(alice, "proj/a.avro", "user.schema", "", true), ErrNotFound)
+	info, err = master.StatPath("proj/a.avro")
+	require.NoError(t, err)
+	assert.Empty(t, info.XAttrs)
+}
+
+func TestMasterNode_TouchAccessTime(t *testing.T) {
+	master := setupTestMaster(t)
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/a.avro"})
+	require.NoError(t, err)
+	txID := master.AppliedTxID()
+
+	master.TouchAccessTime("proj/a.avro")
+	assert.Equal(t, txID, master.AppliedTxID(), "recent access times are not rewritten")
+
+	old := time.Now().Add(-2 * AccessTimePrecision)
+	master.Namespace["proj/a.avro"].ATime = old
+	master.TouchAccessTime("proj/a.avro")
+	assert.Greater(t, master.AppliedTxID(), txID)
+	assert.True(t, master.Namespace["proj/a.avro"].ATime.After(old))
+}
+
+func TestMasterNode_ReplicatedAttrs(t *testing.T) {
+	leader := setupTestMaster(t)
+	standby := setupTestMaster(t)
+	leader.Umask = DefaultUmask
+
+	_, err := leader.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/a.avro", OwnerId: "alice"})
+	require.NoError(t, err)
+	require.NoError(t, leader.SetAttr(Superuser, "proj/a.avro", Attrs{Mode: ptr(uint32(0o640)), Group: ptr("analytics")}))
+	require.NoError(t, leader.SetXAttr(Superuser, "proj/a.avro", "user.schema", "v2", false))
+
+	replayLog(t, leader, standby)
+
+	want, err := leader.StatPath("proj/a.avro")
+	require.NoError(t, err)
+	got, err := standby.StatPath("proj/a.avro")
+	require.NoError(t, err)
+	assert.Equal(t, want.Mode, got.Mode)
+	assert.Equal(t, want.Owner, got.Owner)
+	assert.Equal(t, want.Group, got.Group)
+	assert.Equal(t, want.XAttrs, got.XAttrs)
+	assert.True(t, want.CTime.Equal(got.CTime))
+}
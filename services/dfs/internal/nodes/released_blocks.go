@@ -0,0 +1,64 @@
+package nodes
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BlockDeleteDelay is how long the replicas of a block no file references
+// any more are kept before they are deleted, so readers that looked up the
+// block before its file was replaced or packed can still fetch it.
+const BlockDeleteDelay = 5 * time.Minute
+
+// releasedBlock is a block no file references any more, whose replicas are
+// deleted once it is due.
+type releasedBlock struct {
+	blockID  uuid.UUID
+	replicas []uuid.UUID
+	due      time.Time
+}
+
+// deleteLaterLocked schedules the deletion of the replicas of meta, a block
+// that was dropped from the block map, after BlockDeleteDelay. mn.lock must
+// be held.
+func (mn *MasterNode) deleteLaterLocked(meta *BlockMetadata) {
+	mn.releasedBlocks = append(mn.releasedBlocks, releasedBlock{
+		blockID:  meta.BlockID,
+		replicas: meta.Replicas,
+		due:      time.Now().Add(BlockDeleteDelay),
+	})
+}
+
+// DeleteReleasedBlocks deletes the replicas of the released blocks that are
+// due at now and returns how many blocks it deleted. Standbys keep theirs
+// until they lead.
+func (mn *MasterNode) DeleteReleasedBlocks(now time.Time) int {
+	if mn.LoadBalancer == nil {
+		return 0
+	}
+
+	mn.lock.Lock()
+	var due []releasedBlock
+	kept := mn.releasedBlocks[:0]
+	for _, b := range mn.releasedBlocks {
+		if now.Before(b.due) {
+			kept = append(kept, b)
+		} else {
+			due = append(due, b)
+		}
+	}
+	mn.releasedBlocks = kept
+	mn.lock.Unlock()
+
+	for _, b := range due {
+		for _, replica := range b.replicas {
+			mn.deleteReplica(b.blockID, replica)
+		}
+	}
+	if len(due) > 0 {
+		log.Printf("Deleted %d released blocks", len(due))
+	}
+	return len(due)
+}
@@ -0,0 +1,94 @@
+package nodes
+
+import (
+	"sort"
+	"strings"
+
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+)
+
+type rankedReplica struct {
+	location *commonv1.BlockLocation
+	health   int
+	distance int
+}
+
+// orderReplicasLocked returns the readable replicas of a block, healthiest
+// and closest to clientHost first. Replicas known to be corrupt are left out.
+// mn.lock must be held.
+func (mn *MasterNode) orderReplicasLocked(meta *BlockMetadata, clientHost string) []*commonv1.BlockLocation {
+	if mn.LoadBalancer == nil {
+		return nil
+	}
+
+	ranked := make([]rankedReplica, 0, len(meta.Replicas))
+	for _, replica := range meta.Replicas {
+		if isCorruptReplica(meta, replica) {
+			continue
+		}
+
+		_, wm, _, _, err := mn.LoadBalancer.GetClientByWorkerID(replica.String())
+		if err != nil {
+			continue
+		}
+
+		ranked = append(ranked, rankedReplica{
+			location: &commonv1.BlockLocation{
+				BlockId:  meta.BlockID.String(),
+				WorkerId: replica.String(),
+				Address:  wm.Address(),
+			},
+			health:   replicaHealth(wm),
+			distance: replicaDistance(wm, clientHost),
+		})
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].health != ranked[j].health {
+			return ranked[i].health < ranked[j].health
+		}
+		return ranked[i].distance < ranked[j].distance
+	})
+
+	locations := make([]*commonv1.BlockLocation, 0, len(ranked))
+	for _, r := range ranked {
+		locations = append(locations, r.location)
+	}
+	return locations
+}
+
+// replicaHealth ranks live in-service workers first, then draining ones, then
+// workers that missed their last heartbeat.
+func replicaHealth(wm load_balancer.WorkerMetadata) int {
+	switch {
+	case !wm.Alive:
+		return 2
+	case wm.AdminState != load_balancer.InService:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// replicaDistance is 0 when the worker runs on the client's host, 1 when it
+// shares the client's Kubernetes node and 2 otherwise.
+func replicaDistance(wm load_balancer.WorkerMetadata, clientHost string) int {
+	if clientHost == "" {
+		return 2
+	}
+	if shortHost(wm.Ip) == shortHost(clientHost) {
+		return 0
+	}
+	if wm.NodeName != "" && wm.NodeName == clientHost {
+		return 1
+	}
+	return 2
+}
+
+func shortHost(host string) string {
+	if i := strings.IndexByte(host, '.'); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
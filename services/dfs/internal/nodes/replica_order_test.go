@@ -0,0 +1,66 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterNode_GetFileMetadataOrdersReplicas(t *testing.T) {
+	master := setupTestMaster(t)
+
+	far, local, sameNode, dead, draining, corrupt := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	lb.AddWorker(far.String(), load_balancer.WorkerMetadata{Ip: "worker-0.worker-headless", Port: 50051, Alive: true})
+	lb.AddWorker(local.String(), load_balancer.WorkerMetadata{Ip: "worker-1.worker-headless", Port: 50051, Alive: true})
+	lb.AddWorker(sameNode.String(), load_balancer.WorkerMetadata{Ip: "worker-2.worker-headless", Port: 50051, Alive: true, NodeName: "node-a"})
+	lb.AddWorker(dead.String(), load_balancer.WorkerMetadata{Ip: "worker-3.worker-headless", Port: 50051, Alive: false})
+	lb.AddWorker(draining.String(), load_balancer.WorkerMetadata{Ip: "worker-4.worker-headless", Port: 50051, Alive: true})
+	lb.AddWorker(corrupt.String(), load_balancer.WorkerMetadata{Ip: "worker-5.worker-headless", Port: 50051, Alive: true})
+	require.NoError(t, lb.SetAdminState(draining.String(), load_balancer.Decommissioning))
+	master.LoadBalancer = lb
+
+	blockID := uuid.New()
+	master.BlockMap[blockID] = &BlockMetadata{
+		BlockID:         blockID,
+		Size:            10,
+		Replicas:        []uuid.UUID{dead, corrupt, draining, far, sameNode, local},
+		CorruptReplicas: []uuid.UUID{corrupt},
+	}
+	master.Namespace["p/f.avro"] = &Inode{ID: "f", Path: "p/f.avro", Type: FileType, Blocks: []uuid.UUID{blockID}}
+
+	order := func(clientHost string) []string {
+		resp, err := master.GetFileMetadata("p", "p/f.avro", clientHost)
+		require.NoError(t, err)
+		require.Contains(t, resp.Replicas, blockID.String())
+
+		ids := make([]string, 0)
+		for _, loc := range resp.Replicas[blockID.String()].Locations {
+			ids = append(ids, loc.WorkerId)
+		}
+		assert.Equal(t, ids[0], resp.Locations[blockID.String()].WorkerId, "preferred location is the first replica")
+		return ids
+	}
+
+	t.Run("closest healthy replica first, corrupt excluded", func(t *testing.T) {
+		assert.Equal(t,
+			[]string{local.String(), far.String(), sameNode.String(), draining.String(), dead.String()},
+			order("worker-1"))
+	})
+
+	t.Run("node name match ranks before remote workers", func(t *testing.T) {
+		assert.Equal(t,
+			[]string{sameNode.String(), far.String(), local.String(), draining.String(), dead.String()},
+			order("node-a"))
+	})
+
+	t.Run("without a client host the stored order is kept", func(t *testing.T) {
+		assert.Equal(t,
+			[]string{far.String(), sameNode.String(), local.String(), draining.String(), dead.String()},
+			order(""))
+	})
+}
@@ -12,18 +12,17 @@ import (
 )
 
 type Replicator struct {
-	peers []string
+	peers    []string
+	dialOpts []grpc.DialOption
 }
 
-func NewReplicator(myHostname string) *Replicator {
-	peers := []string{}
-	for i := 0; i < 3; i++ {
-		hostname := fmt.Sprintf("master-%d.master-headless.datalake.svc.cluster.local:50055", i)
-		if hostname != myHostname && fmt.Sprintf("master-%d", i) != myHostname {
-			peers = append(peers, hostname)
-		}
+// NewReplicator replicates to the other masters of the group, at the peers
+// addresses. Without dial options the connections are plaintext.
+func NewReplicator(peers []string, dialOpts ...grpc.DialOption) *Replicator {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
 	}
-	return &Replicator{peers: peers}
+	return &Replicator{peers: peers, dialOpts: dialOpts}
 }
 
 func (r *Replicator) SendToQuorum(ctx context.Context, op OperationLogEntry) error {
@@ -40,7 +39,7 @@ func (r *Replicator) SendToQuorum(ctx context.Context, op OperationLogEntry) err
 		go func(address string) {
 			defer wg.Done()
 
-			conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			conn, err := grpc.Dial(address, r.dialOpts...)
 			if err != nil {
 				return
 			}
@@ -48,6 +47,7 @@ func (r *Replicator) SendToQuorum(ctx context.Context, op OperationLogEntry) err
 
 			client := replicationv1.NewReplicationServiceClient(conn)
 			_, err = client.ReplicateLog(ctx, &replicationv1.ReplicateLogRequest{
+				TxId:      op.TxID,
 				Timestamp: op.Timestamp,
 				OpType:    int32(op.OpType),
 				Payload:   payloadBytes,
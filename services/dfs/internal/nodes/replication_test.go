@@ -0,0 +1,182 @@
+package nodes
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayLog applies the op log of leader to standby the way ReplicateLog
+// does.
+func replayLog(t *testing.T, leader, standby *MasterNode) {
+	t.Helper()
+	f, err := os.Open(leader.opLogFile.Name())
+	require.NoError(t, err)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry struct {
+			TxID      int64           `json:"txId"`
+			OpType    OpType          `json:"opType"`
+			Timestamp int64           `json:"timestamp"`
+			Payload   json.RawMessage `json:"payload"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		require.NoError(t, standby.ApplyReplicatedLog(entry.OpType, entry.Payload, entry.TxID, entry.Timestamp))
+	}
+	require.NoError(t, scanner.Err())
+}
+
+func TestMasterNode_ReplicatedNamespace(t *testing.T) {
+	leader := setupTestMaster(t)
+	standby := setupTestMaster(t)
+
+	require.NoError(t, leader.Mkdirs(Superuser, "proj/raw", "proj", ""))
+	for _, path := range []string{"proj/raw/a.avro", "proj/raw/b.avro", "proj/raw/c.avro"} {
+		_, err := leader.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: path})
+		require.NoError(t, err)
+	}
+	require.NoError(t, leader.Rename(Superuser, "proj/raw/b.avro", "proj/raw/b2.avro"))
+	require.NoError(t, leader.DeleteFile(Superuser, "proj/raw/c.avro", false))
+	require.NoError(t, leader.SetStoragePolicy(Superuser, "proj/raw/a.avro", StoragePolicyCold))
+
+	assert.Positive(t, leader.AppliedTxID())
+	replayLog(t, leader, standby)
+	assert.Equal(t, leader.AppliedTxID(), standby.AppliedTxID(), "standbys keep the leader's TxIDs")
+
+	files := func(mn *MasterNode) []string {
+		var paths []string
+		for _, info := range mn.ListFileInfos("proj", "") {
+			paths = append(paths, info.Path)
+		}
+		return paths
+	}
+	assert.ElementsMatch(t, []string{"proj/raw/a.avro", "proj/raw/b2.avro"}, files(standby))
+	assert.ElementsMatch(t, files(leader), files(standby))
+
+	info, err := standby.StatPath("proj/raw/a.avro")
+	require.NoError(t, err)
+	assert.Equal(t, StoragePolicyCold, info.StoragePolicy)
+
+	entries, err := standby.ListDirectory("proj")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].IsDir)
+}
+
+func TestMasterNode_ReplicatedSubtreeRename(t *testing.T) {
+	leader := setupTestMaster(t)
+	standby := setupTestMaster(t)
+
+	require.NoError(t, leader.Mkdirs(Superuser, "proj/raw/2024", "proj", ""))
+	require.NoError(t, leader.Mkdirs(Superuser, "proj/clean", "proj", ""))
+	for _, path := range []string{"proj/raw/2024/a.avro", "proj/raw/b.avro"} {
+		_, err := leader.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: path})
+		require.NoError(t, err)
+	}
+	before := leader.AppliedTxID()
+	require.NoError(t, leader.Rename(Superuser, "proj/raw", "proj/clean/raw"))
+	assert.Equal(t, before+1, leader.AppliedTxID(), "the subtree moves in one entry")
+
+	replayLog(t, leader, standby)
+	for _, mn := range []*MasterNode{leader, standby} {
+		for _, path := range []string{"proj/clean/raw", "proj/clean/raw/2024", "proj/clean/raw/2024/a.avro", "proj/clean/raw/b.avro"} {
+			require.Contains(t, mn.Namespace, path)
+			assert.Equal(t, filepath.Base(path), mn.Namespace[path].Name)
+		}
+		assert.NotContains(t, mn.Namespace, "proj/raw")
+		assert.NotContains(t, mn.Namespace, "proj/raw/b.avro")
+		assert.Equal(t, []string{mn.Namespace["proj/clean/raw"].ID}, mn.Namespace["proj/clean"].Children,
+			"the new parent lists the moved directory")
+		assert.NotContains(t, mn.Namespace["proj"].Children, mn.Namespace["proj/clean/raw"].ID)
+	}
+}
+
+func TestMasterNode_BlockLocationsKnown(t *testing.T) {
+	master := setupTestMaster(t)
+	tieredWorkers(master)
+
+	alloc, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{ProjectId: "proj", FilePath: "proj/a.bin"})
+	require.NoError(t, err)
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+		ProjectId: "proj",
+		FilePath:  "proj/a.bin",
+		Blocks:    []*commonv1.BlockInfo{{BlockId: alloc.BlockId, Size: 4}},
+	})
+	require.NoError(t, err)
+	assert.True(t, master.BlockLocationsKnown("proj/a.bin"))
+
+	master.LoadBalancer = nil
+	assert.False(t, master.BlockLocationsKnown("proj/a.bin"), "no workers, as on a standby")
+}
+
+func TestMasterNode_ReplicationGap(t *testing.T) {
+	standby := setupTestMaster(t)
+	dir := func(path string) []byte {
+		payload, err := json.Marshal(&Inode{ID: uuid.NewString(), Path: path, Name: path, Type: DirType})
+		require.NoError(t, err)
+		return payload
+	}
+
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("a"), 1, 0))
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("b"), 2, 0))
+	assert.Equal(t, int64(2), standby.AppliedTxID())
+
+	// A resent entry is applied once.
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("b-again"), 2, 0))
+	assert.NotContains(t, standby.Namespace, "b-again")
+
+	// TxID 3 never arrives: the applied TxID stays before it.
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("d"), 4, 0))
+	assert.Contains(t, standby.Namespace, "d")
+	assert.Equal(t, int64(2), standby.AppliedTxID())
+	assert.True(t, standby.MissedOps())
+
+	// Promoted, it continues after the latest TxID it has.
+	standby.IsActive = true
+	require.NoError(t, standby.Mkdirs(Superuser, "e", "", ""))
+	assert.Equal(t, int64(5), standby.AppliedTxID())
+}
+
+func TestMasterNode_RestartReplaysOpLog(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "master_op.log")
+	mn := openMasterNode(logPath, NewMasterNodeState())
+	mn.checkpointPath = filepath.Join(dir, MasterStateFile)
+
+	_, err := mn.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/a.avro"})
+	require.NoError(t, err)
+	_, _, _, err = mn.SaveNamespace()
+	require.NoError(t, err)
+	// The operations after the checkpoint are only in the op log.
+	_, err = mn.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/b.avro"})
+	require.NoError(t, err)
+	require.NoError(t, mn.Rename(Superuser, "proj/a.avro", "proj/c.avro"))
+	lastTxID := mn.AppliedTxID()
+	mn.opLogFile.Close()
+
+	state := NewMasterNodeState()
+	require.NoError(t, state.LoadStateFromFile(mn.checkpointPath))
+	restarted := openMasterNode(logPath, state)
+	t.Cleanup(func() { restarted.opLogFile.Close() })
+
+	assert.Equal(t, lastTxID, restarted.AppliedTxID())
+	assert.False(t, restarted.MissedOps())
+	assert.Contains(t, restarted.Namespace, "proj/b.avro")
+	assert.Contains(t, restarted.Namespace, "proj/c.avro")
+	assert.NotContains(t, restarted.Namespace, "proj/a.avro")
+
+	// New operations do not reuse the TxIDs of the replayed ones.
+	restarted.IsActive = true
+	require.NoError(t, restarted.Mkdirs(Superuser, "proj/raw", "proj", ""))
+	assert.Equal(t, lastTxID+1, restarted.AppliedTxID())
+}
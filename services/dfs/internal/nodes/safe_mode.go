@@ -0,0 +1,346 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/razvanmarinn/dfs/internal/metrics"
+)
+
+// ErrSafeMode is returned by mutating operations while the master is in safe mode.
+var ErrSafeMode = errors.New("master is in safe mode")
+
+// ErrBlockUnknown is returned for files with a block the master has no
+// metadata of until a worker reports it, e.g. on a standby that just took over.
+var ErrBlockUnknown = errors.New("block not reported yet")
+
+// DefaultSafeModeThreshold is the fraction of blocks that must be confirmed by
+// block reports before the master leaves startup safe mode.
+const DefaultSafeModeThreshold = 0.999
+
+// Safe mode has two independent causes. Startup safe mode is entered when the
+// master becomes leader and is left automatically once enough blocks have been
+// reported by workers. Manual safe mode is entered by an operator and is only
+// left by an explicit LeaveSafeMode, which also ends startup safe mode.
+type safeModeState struct {
+	mu           sync.RWMutex
+	manual       bool
+	manualReason string
+	startup      bool
+	threshold    float64
+	confirmed    map[uuid.UUID]struct{}
+}
+
+// SafeModeStatus describes the current safe mode state.
+type SafeModeStatus struct {
+	Enabled         bool
+	Startup         bool
+	Manual          bool
+	Reason          string
+	ConfirmedBlocks int
+	TotalBlocks     int
+	Threshold       float64
+}
+
+// SafeMode reports whether the namespace is read-only and why.
+func (mn *MasterNode) SafeMode() (bool, string) {
+	status := mn.SafeModeStatus()
+	return status.Enabled, status.Reason
+}
+
+func (mn *MasterNode) SafeModeStatus() SafeModeStatus {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+	return mn.safeModeStatusLocked()
+}
+
+// safeModeStatusLocked is SafeModeStatus for callers holding mn.lock.
+func (mn *MasterNode) safeModeStatusLocked() SafeModeStatus {
+	mn.safeMode.mu.RLock()
+	defer mn.safeMode.mu.RUnlock()
+
+	expected := mn.expectedBlocksLocked()
+	status := SafeModeStatus{
+		Enabled:     mn.safeMode.manual || mn.safeMode.startup,
+		Startup:     mn.safeMode.startup,
+		Manual:      mn.safeMode.manual,
+		TotalBlocks: len(expected),
+		Threshold:   mn.safeMode.threshold,
+	}
+	if mn.safeMode.startup {
+		status.ConfirmedBlocks = mn.confirmedBlocksLocked(expected)
+	}
+
+	switch {
+	case mn.safeMode.manual:
+		status.Reason = mn.safeMode.manualReason
+	case mn.safeMode.startup:
+		status.Reason = fmt.Sprintf("waiting for block reports: %d of %d blocks confirmed, threshold %.3f",
+			status.ConfirmedBlocks, status.TotalBlocks, status.Threshold)
+	}
+	return status
+}
+
+// EnterSafeMode puts the master in manual safe mode. It stays there until
+// LeaveSafeMode is called, regardless of block reports.
+func (mn *MasterNode) EnterSafeMode(reason string) {
+	mn.safeMode.mu.Lock()
+	defer mn.safeMode.mu.Unlock()
+
+	if !mn.safeMode.manual {
+		log.Printf("🔒 Entering safe mode: %s", reason)
+	}
+	mn.safeMode.manual = true
+	mn.safeMode.manualReason = reason
+	metrics.MasterSafeMode.Set(1)
+}
+
+// EnterStartupSafeMode makes the namespace read-only until threshold of the
+// blocks files reference have been confirmed by ProcessBlockReport.
+func (mn *MasterNode) EnterStartupSafeMode(threshold float64) {
+	if threshold <= 0 || threshold > 1 {
+		threshold = DefaultSafeModeThreshold
+	}
+
+	mn.safeMode.mu.Lock()
+	defer mn.safeMode.mu.Unlock()
+
+	mn.safeMode.startup = true
+	mn.safeMode.threshold = threshold
+	mn.safeMode.confirmed = make(map[uuid.UUID]struct{})
+	metrics.MasterSafeMode.Set(1)
+	log.Printf("🔒 Entering startup safe mode until %.1f%% of blocks are reported", threshold*100)
+}
+
+// LeaveSafeMode ends both manual and startup safe mode.
+func (mn *MasterNode) LeaveSafeMode() {
+	mn.safeMode.mu.Lock()
+	defer mn.safeMode.mu.Unlock()
+
+	if mn.safeMode.manual || mn.safeMode.startup {
+		log.Printf("🔓 Leaving safe mode")
+	}
+	mn.safeMode.manual = false
+	mn.safeMode.manualReason = ""
+	mn.safeMode.startup = false
+	mn.safeMode.confirmed = nil
+	metrics.MasterSafeMode.Set(0)
+}
+
+// checkWritableLocked fails mutations while the master is in safe mode.
+// Mutations check it under the same hold of mn.lock they change the
+// namespace in, so safe mode cannot be entered in between.
+func (mn *MasterNode) checkWritableLocked() error {
+	mn.safeMode.mu.RLock()
+	enabled := mn.safeMode.manual || mn.safeMode.startup
+	mn.safeMode.mu.RUnlock()
+	if !enabled {
+		return nil
+	}
+	if status := mn.safeModeStatusLocked(); status.Enabled {
+		return fmt.Errorf("%w: %s", ErrSafeMode, status.Reason)
+	}
+	return nil
+}
+
+// checkWritable is checkWritableLocked for background jobs that skip a run
+// early. The mutations they make check again under mn.lock.
+func (mn *MasterNode) checkWritable() error {
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+	return mn.checkWritableLocked()
+}
+
+// InStartupSafeMode reports whether the master is still waiting for block reports.
+func (mn *MasterNode) InStartupSafeMode() bool {
+	mn.safeMode.mu.RLock()
+	defer mn.safeMode.mu.RUnlock()
+	return mn.safeMode.startup
+}
+
+// expectedBlocksLocked returns the blocks files reference. The block map is
+// not enough: a standby taking over only learns where the blocks committed
+// since its image are once workers report them. mn.lock must be held.
+func (mn *MasterNode) expectedBlocksLocked() map[uuid.UUID]struct{} {
+	expected := make(map[uuid.UUID]struct{})
+	for _, inode := range mn.Namespace {
+		for _, blockID := range inode.Blocks {
+			expected[blockID] = struct{}{}
+		}
+	}
+	return expected
+}
+
+// confirmedBlocksLocked counts the expected blocks seen in a block report.
+// mn.lock and mn.safeMode.mu must be held.
+func (mn *MasterNode) confirmedBlocksLocked(expected map[uuid.UUID]struct{}) int {
+	confirmed := 0
+	for blockID := range expected {
+		if _, ok := mn.safeMode.confirmed[blockID]; ok {
+			confirmed++
+		}
+	}
+	return confirmed
+}
+
+// ProcessBlockReport records the blocks a worker holds. Blocks files
+// reference count towards leaving startup safe mode, and in-service workers
+// are added as replicas of blocks they hold but were not recorded for.
+// Referenced blocks missing from the block map are added to it.
+func (mn *MasterNode) ProcessBlockReport(workerID string, blockIDs []string) {
+	workerUUID, err := uuid.Parse(workerID)
+	if err != nil {
+		log.Printf("Ignoring block report from invalid worker ID %s: %v", workerID, err)
+		return
+	}
+
+	addReplicas := true
+	if mn.LoadBalancer != nil {
+		_, wm, _, _, err := mn.LoadBalancer.GetClientByWorkerID(workerID)
+		addReplicas = err == nil && wm.AdminState == load_balancer.InService
+	}
+
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	mn.safeMode.mu.Lock()
+	defer mn.safeMode.mu.Unlock()
+
+	var expected map[uuid.UUID]struct{}
+	for _, id := range blockIDs {
+		blockID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		meta, exists := mn.BlockMap[blockID]
+		if !exists {
+			if expected == nil {
+				expected = mn.expectedBlocksLocked()
+			}
+			if _, ok := expected[blockID]; !ok {
+				continue
+			}
+			meta = mn.recoverBlockLocked(blockID)
+		}
+
+		if mn.safeMode.startup {
+			mn.safeMode.confirmed[blockID] = struct{}{}
+		}
+
+		if addReplicas && !containsUUID(meta.Replicas, workerUUID) {
+			meta.Replicas = append(meta.Replicas, workerUUID)
+		}
+	}
+
+	mn.maybeLeaveStartupSafeModeLocked()
+}
+
+// recoverBlockLocked adds a block map entry for a block a file references but
+// the master has no metadata of, e.g. because the op log it replicated
+// predates block metadata in OpRegisterFile. The block's size is what the
+// file's other blocks leave of its size; its checksum is unknown.
+// mn.lock must be held.
+func (mn *MasterNode) recoverBlockLocked(blockID uuid.UUID) *BlockMetadata {
+	meta := &BlockMetadata{BlockID: blockID, Replicas: make([]uuid.UUID, 0)}
+	for _, inode := range mn.Namespace {
+		i := slices.Index(inode.Blocks, blockID)
+		if i < 0 {
+			continue
+		}
+		if ext := inode.extent(i); ext != nil {
+			// A container's size is known to be at least its last extent's end.
+			meta.Container, meta.ProjectID = true, inode.ProjectID
+			meta.Size = max(meta.Size, ext.Offset+ext.Length)
+			continue
+		}
+		size, known := inode.Size, true
+		for j, other := range inode.Blocks {
+			otherMeta, ok := mn.BlockMap[other]
+			if j != i && !ok {
+				known = false
+				break
+			}
+			if j != i {
+				size -= otherMeta.Size
+			}
+		}
+		if known {
+			meta.Size = size
+			break
+		}
+	}
+	mn.BlockMap[blockID] = meta
+	log.Printf("Recovered block %s of %d bytes from a block report", blockID, meta.Size)
+	return meta
+}
+
+// maybeLeaveStartupSafeModeLocked ends startup safe mode once the confirmed
+// fraction of blocks reaches the threshold. mn.lock and mn.safeMode.mu must be held.
+func (mn *MasterNode) maybeLeaveStartupSafeModeLocked() {
+	if !mn.safeMode.startup {
+		return
+	}
+
+	expected := mn.expectedBlocksLocked()
+	total := len(expected)
+	confirmed := mn.confirmedBlocksLocked(expected)
+	if total > 0 && float64(confirmed) < mn.safeMode.threshold*float64(total) {
+		return
+	}
+
+	mn.safeMode.startup = false
+	mn.safeMode.confirmed = nil
+	if mn.safeMode.manual {
+		log.Printf("Block reports complete (%d/%d), staying in manual safe mode", confirmed, total)
+		return
+	}
+	metrics.MasterSafeMode.Set(0)
+	log.Printf("🔓 Leaving startup safe mode: %d of %d blocks confirmed", confirmed, total)
+}
+
+// CollectBlockReports pulls a block report from every known worker.
+func (mn *MasterNode) CollectBlockReports(ctx context.Context) {
+	if mn.LoadBalancer == nil {
+		return
+	}
+
+	for workerID, wm := range mn.LoadBalancer.Workers() {
+		if wm.Client == nil {
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		resp, err := wm.Client.GetBlockReport(reqCtx, &datanodev1.GetBlockReportRequest{})
+		cancel()
+		if err != nil {
+			log.Printf("Block report from worker %s failed: %v", workerID, err)
+			continue
+		}
+
+		mn.ProcessBlockReport(workerID, resp.BlockIds)
+	}
+
+	// Re-check even without reports so an empty namespace leaves safe mode.
+	mn.lock.RLock()
+	mn.safeMode.mu.Lock()
+	mn.maybeLeaveStartupSafeModeLocked()
+	mn.safeMode.mu.Unlock()
+	mn.lock.RUnlock()
+}
+
+func containsUUID(ids []uuid.UUID, target uuid.UUID) bool {
+	for _, id := range ids {
+		if id == target {
+			return true
+		}
+	}
+	return false
+}
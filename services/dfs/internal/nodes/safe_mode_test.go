@@ -0,0 +1,169 @@
+package nodes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterNode_StartupSafeMode(t *testing.T) {
+	master := setupTestMaster(t)
+	worker := uuid.New()
+
+	blocks := make([]string, 0, 4)
+	inode := &Inode{ID: uuid.NewString(), Path: "p/data.avro", Type: FileType}
+	for i := 0; i < 4; i++ {
+		id := uuid.New()
+		master.BlockMap[id] = &BlockMetadata{BlockID: id, Replicas: []uuid.UUID{}}
+		inode.Blocks = append(inode.Blocks, id)
+		blocks = append(blocks, id.String())
+	}
+	master.Namespace[inode.Path] = inode
+	// Allocated blocks no file references yet do not hold safe mode up.
+	unused := uuid.New()
+	master.BlockMap[unused] = &BlockMetadata{BlockID: unused}
+
+	master.EnterStartupSafeMode(0.75)
+
+	t.Run("writes are rejected", func(t *testing.T) {
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "p", FilePath: "p/f.avro"})
+		assert.ErrorIs(t, err, ErrSafeMode)
+		_, err = master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{ProjectId: "p"})
+		assert.ErrorIs(t, err, ErrSafeMode)
+	})
+
+	t.Run("stays in safe mode below the threshold", func(t *testing.T) {
+		master.ProcessBlockReport(worker.String(), blocks[:2])
+		status := master.SafeModeStatus()
+		assert.True(t, status.Enabled)
+		assert.True(t, status.Startup)
+		assert.Equal(t, 2, status.ConfirmedBlocks)
+		assert.Equal(t, 4, status.TotalBlocks)
+	})
+
+	t.Run("reported replicas are recorded", func(t *testing.T) {
+		id := uuid.MustParse(blocks[0])
+		assert.Equal(t, []uuid.UUID{worker}, master.BlockMap[id].Replicas)
+	})
+
+	t.Run("leaves once the threshold is reached", func(t *testing.T) {
+		master.ProcessBlockReport(worker.String(), append(blocks[1:3], uuid.New().String()))
+		enabled, _ := master.SafeMode()
+		assert.False(t, enabled)
+
+		_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "p", FilePath: "p/f.avro"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestMasterNode_ManualSafeModeOverridesStartup(t *testing.T) {
+	master := setupTestMaster(t)
+	blockID := uuid.New()
+	master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID}
+	master.Namespace["p/f"] = &Inode{Path: "p/f", Type: FileType, Blocks: []uuid.UUID{blockID}}
+
+	master.EnterStartupSafeMode(1)
+	master.EnterSafeMode("maintenance")
+
+	master.ProcessBlockReport(uuid.New().String(), []string{blockID.String()})
+
+	status := master.SafeModeStatus()
+	assert.True(t, status.Enabled, "manual safe mode must not be left by block reports")
+	assert.False(t, status.Startup)
+	assert.Equal(t, "maintenance", status.Reason)
+
+	master.LeaveSafeMode()
+	enabled, _ := master.SafeMode()
+	assert.False(t, enabled)
+}
+
+func TestMasterNode_LeaveSafeModeEndsStartup(t *testing.T) {
+	master := setupTestMaster(t)
+	blockID := uuid.New()
+	master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID}
+	master.Namespace["p/f"] = &Inode{Path: "p/f", Type: FileType, Blocks: []uuid.UUID{blockID}}
+
+	master.EnterStartupSafeMode(1)
+	master.LeaveSafeMode()
+
+	assert.False(t, master.InStartupSafeMode())
+	require.NoError(t, master.checkWritable())
+}
+
+func TestMasterNode_CollectBlockReportsEmptyNamespace(t *testing.T) {
+	master := setupTestMaster(t)
+	master.LoadBalancer = load_balancer.NewLoadBalancer(0, 50051)
+
+	master.EnterStartupSafeMode(DefaultSafeModeThreshold)
+	master.CollectBlockReports(context.Background())
+
+	assert.False(t, master.InStartupSafeMode())
+}
+
+func TestMasterNode_StandbyTakeoverSafeMode(t *testing.T) {
+	leader := setupTestMaster(t)
+	tieredWorkers(leader)
+	standby := setupTestMaster(t)
+
+	var blocks []*commonv1.BlockInfo
+	for _, size := range []int64{64, 36} {
+		alloc, err := leader.AllocateBlock(&coordinatorv1.AllocateBlockRequest{ProjectId: "p", FilePath: "p/f.avro"})
+		require.NoError(t, err)
+		blocks = append(blocks, &commonv1.BlockInfo{BlockId: alloc.BlockId, Size: size})
+	}
+	_, err := leader.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "p", FilePath: "p/f.avro", Blocks: blocks})
+	require.NoError(t, err)
+	replayLog(t, leader, standby)
+
+	first, second := uuid.MustParse(blocks[0].BlockId), uuid.MustParse(blocks[1].BlockId)
+	require.Contains(t, standby.BlockMap, first, "commits carry their block metadata")
+	assert.Equal(t, int64(64), standby.BlockMap[first].Size)
+	entries, err := standby.ListDirectory("p")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "replicated files are listed in their directory")
+
+	// An op log from before block metadata was logged leaves the standby
+	// without the block.
+	delete(standby.BlockMap, second)
+	_, err = standby.GetFileMetadata("p", "p/f.avro", "")
+	assert.ErrorIs(t, err, ErrBlockUnknown, "files are not returned without their blocks")
+
+	standby.EnterStartupSafeMode(1)
+	status := standby.SafeModeStatus()
+	assert.Equal(t, 2, status.TotalBlocks, "blocks are expected from the namespace")
+
+	worker := uuid.New()
+	standby.ProcessBlockReport(worker.String(), []string{blocks[0].BlockId})
+	assert.True(t, standby.InStartupSafeMode())
+	standby.ProcessBlockReport(worker.String(), []string{blocks[1].BlockId})
+	assert.False(t, standby.InStartupSafeMode())
+
+	require.Contains(t, standby.BlockMap, second, "reported blocks of files are added back")
+	assert.Equal(t, int64(36), standby.BlockMap[second].Size)
+	assert.Equal(t, []uuid.UUID{worker}, standby.BlockMap[second].Replicas)
+	resp, err := standby.GetFileMetadata("p", "p/f.avro", "")
+	require.NoError(t, err)
+	assert.Len(t, resp.Blocks, 2)
+}
+
+func TestWorkerNode_GetBlockReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	worker := NewWorkerNode(tmpDir, 50051)
+
+	writeTestBlock(t, tmpDir, "good", []byte("good"), time.Now())
+	writeTestBlock(t, tmpDir, "bad", []byte("bad"), time.Now())
+	worker.setCorrupt("bad", true)
+
+	resp, err := worker.GetBlockReport(context.Background(), &datanodev1.GetBlockReportRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, worker.ID, resp.WorkerId)
+	assert.Equal(t, []string{"good"}, resp.BlockIds)
+}
@@ -11,9 +11,11 @@ import (
 	"github.com/google/uuid"
 )
 
+// The default file names of the saved states, relative to the working or
+// storage directory.
 const (
-	workerStateFile = "worker_node_state.json"
-	masterStateFile = "master_node_state.json"
+	WorkerStateFile = "worker_node_state.json"
+	MasterStateFile = "master_node_state.json"
 )
 
 type WorkerNodeState struct {
@@ -72,17 +74,12 @@ func (w *WorkerNodeState) UpdateState(workerNode *WorkerNode) error {
 	return nil
 }
 
-func (w *WorkerNodeState) SaveState(pathOverride ...string) error {
+func (w *WorkerNodeState) SaveState(path string) error {
 	data, err := w.GetState()
 	if err != nil {
 		return err
 	}
 
-	path := workerStateFile
-	if len(pathOverride) > 0 {
-		path = pathOverride[0]
-	}
-
 	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
 		return err
@@ -91,12 +88,7 @@ func (w *WorkerNodeState) SaveState(pathOverride ...string) error {
 	return os.Rename(tmpPath, path)
 }
 
-func (w *WorkerNodeState) LoadStateFromFile(pathOverride ...string) error {
-	path := workerStateFile
-	if len(pathOverride) > 0 {
-		path = pathOverride[0]
-	}
-
+func (w *WorkerNodeState) LoadStateFromFile(path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -109,9 +101,12 @@ func (w *WorkerNodeState) LoadStateFromFile(pathOverride ...string) error {
 }
 
 type MasterNodeState struct {
-	ID        string                       `json:"id"`
-	Namespace map[string]*Inode            `json:"namespace"`
-	BlockMap  map[uuid.UUID]*BlockMetadata `json:"block_map"`
+	ID             string                       `json:"id"`
+	Namespace      map[string]*Inode            `json:"namespace"`
+	BlockMap       map[uuid.UUID]*BlockMetadata `json:"block_map"`
+	LastGeneration int64                        `json:"last_generation"`
+	// LastTxID is the TxID of the latest operation the image includes.
+	LastTxID int64 `json:"last_tx_id"`
 }
 
 func NewMasterNodeState() *MasterNodeState {
@@ -143,6 +138,8 @@ func (m *MasterNodeState) UpdateState(masterNode *MasterNode) {
 	m.ID = masterNode.ID
 	m.Namespace = masterNode.Namespace
 	m.BlockMap = masterNode.BlockMap
+	m.LastGeneration = masterNode.LastGeneration
+	m.LastTxID = masterNode.lastTxID.Load()
 }
 
 func (m *MasterNodeState) GetState() ([]byte, error) {
@@ -153,22 +150,22 @@ func (m *MasterNodeState) GetState() ([]byte, error) {
 	return data, nil
 }
 
-func (m *MasterNodeState) SaveState() error {
+func (m *MasterNodeState) SaveState(path string) error {
 	data, err := m.GetState()
 	if err != nil {
 		return err
 	}
 
-	tmpPath := masterStateFile + ".tmp"
+	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
 		return err
 	}
 
-	return os.Rename(tmpPath, masterStateFile)
+	return os.Rename(tmpPath, path)
 }
 
-func (m *MasterNodeState) LoadStateFromFile() error {
-	data, err := os.ReadFile(masterStateFile)
+func (m *MasterNodeState) LoadStateFromFile(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -0,0 +1,229 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+)
+
+// Storage policies say which kind of worker storage a file's blocks belong
+// on. Paths without a policy of their own or on a parent directory are WARM.
+const (
+	StoragePolicyHot  = "HOT"
+	StoragePolicyWarm = "WARM"
+	StoragePolicyCold = "COLD"
+)
+
+// ErrInvalidStoragePolicy is returned for policy names other than HOT, WARM
+// and COLD.
+var ErrInvalidStoragePolicy = errors.New("invalid storage policy")
+
+// storagePolicyTypes lists the worker storage types each policy accepts, best
+// first. Blocks go to other types only when none of these are available.
+var storagePolicyTypes = map[string][]string{
+	StoragePolicyHot:  {load_balancer.StorageSSD, load_balancer.StorageDisk},
+	StoragePolicyWarm: {load_balancer.StorageDisk},
+	StoragePolicyCold: {load_balancer.StorageArchive, load_balancer.StorageDisk},
+}
+
+// StoragePolicyPayload is the op-log payload of OpSetStoragePolicy.
+type StoragePolicyPayload struct {
+	Path   string `json:"path"`
+	Policy string `json:"policy"`
+}
+
+// checkStoragePolicy accepts the known policies and "", meaning none.
+func checkStoragePolicy(policy string) error {
+	if _, ok := storagePolicyTypes[policy]; ok || policy == "" {
+		return nil
+	}
+	return fmt.Errorf("%w %q: want %s, %s or %s", ErrInvalidStoragePolicy, policy,
+		StoragePolicyHot, StoragePolicyWarm, StoragePolicyCold)
+}
+
+// storagePolicyLocked returns the policy that applies to path: its own, else
+// that of the closest parent directory with one, else WARM. mn.lock must be
+// held.
+func (mn *MasterNode) storagePolicyLocked(path string) string {
+	for p := filepath.Clean(path); p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if inode, ok := mn.Namespace[p]; ok && inode.StoragePolicy != "" {
+			return inode.StoragePolicy
+		}
+	}
+	return StoragePolicyWarm
+}
+
+// SetStoragePolicy sets or, with an empty policy, clears the storage policy of
+// a file or directory, then moves the affected blocks in the background.
+func (mn *MasterNode) SetStoragePolicy(caller Caller, path, policy string) error {
+	if err := checkStoragePolicy(policy); err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	if err := mn.setStoragePolicy(caller, path, policy); err != nil {
+		return err
+	}
+
+	if mn.LoadBalancer != nil {
+		go mn.MoveBlocks(path)
+	}
+	return nil
+}
+
+func (mn *MasterNode) setStoragePolicy(caller Caller, path, policy string) error {
+	mn.lock.Lock()
+	defer mn.lock.Unlock()
+
+	if err := mn.checkWritableLocked(); err != nil {
+		return err
+	}
+	if err := mn.checkAccessLocked(caller, path, AccessWrite); err != nil {
+		return err
+	}
+
+	inode, ok := mn.Namespace[path]
+	if !ok {
+		return fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+
+	op := OperationLogEntry{
+		OpType:    OpSetStoragePolicy,
+		Timestamp: time.Now().Unix(),
+		Payload:   StoragePolicyPayload{Path: path, Policy: policy},
+	}
+	if err := mn.appendToLog(op); err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+
+	inode.StoragePolicy = policy
+	inode.CTime = time.Now()
+	log.Printf("Storage policy of %s set to %q", path, policy)
+	return nil
+}
+
+// misplacedReplica is a replica on a worker whose storage type ranks below the
+// best one its file's policy accepts.
+type misplacedReplica struct {
+	blockID   uuid.UUID
+	source    uuid.UUID
+	rank      int
+	preferred []string
+}
+
+// MoveBlocks copies replicas of the files under root that sit on the wrong
+// kind of storage for their policy to a better matching worker and drops the
+// old copies. An empty root covers the whole namespace. It returns the number
+// of replicas moved; replicas with no better worker available stay put.
+func (mn *MasterNode) MoveBlocks(root string) int {
+	if mn.LoadBalancer == nil || mn.checkWritable() != nil {
+		return 0
+	}
+
+	mn.moverLock.Lock()
+	defer mn.moverLock.Unlock()
+
+	moved := 0
+	for _, m := range mn.misplacedReplicas(root) {
+		ok, err := mn.moveReplica(m)
+		if err != nil {
+			log.Printf("Mover: failed to move block %s off worker %s: %v", m.blockID, m.source, err)
+			continue
+		}
+		if ok {
+			moved++
+		}
+	}
+	if moved > 0 {
+		log.Printf("Mover: moved %d replicas under %q", moved, root)
+	}
+	return moved
+}
+
+func (mn *MasterNode) misplacedReplicas(root string) []misplacedReplica {
+	workers := mn.LoadBalancer.Workers()
+
+	mn.lock.RLock()
+	defer mn.lock.RUnlock()
+
+	seen := make(map[uuid.UUID]struct{})
+	misplaced := make([]misplacedReplica, 0)
+	for path, inode := range mn.Namespace {
+		if inode.Type != FileType {
+			continue
+		}
+		if root != "" && root != "." && path != root && !strings.HasPrefix(path, root+"/") {
+			continue
+		}
+
+		preferred := storagePolicyTypes[mn.storagePolicyLocked(path)]
+		for _, blockID := range inode.Blocks {
+			meta, ok := mn.BlockMap[blockID]
+			if !ok {
+				continue
+			}
+			if _, dup := seen[blockID]; dup {
+				continue
+			}
+			seen[blockID] = struct{}{}
+
+			for _, replica := range meta.Replicas {
+				wm, ok := workers[replica.String()]
+				if !ok || !wm.Alive || isCorruptReplica(meta, replica) {
+					continue
+				}
+				if rank := wm.StorageRank(preferred); rank > 0 {
+					misplaced = append(misplaced, misplacedReplica{
+						blockID:   blockID,
+						source:    replica,
+						rank:      rank,
+						preferred: preferred,
+					})
+				}
+			}
+		}
+	}
+	return misplaced
+}
+
+// moveReplica copies a misplaced replica to the best matching worker, if one
+// ranks higher than the current one, then forgets and deletes the old copy.
+func (mn *MasterNode) moveReplica(m misplacedReplica) (bool, error) {
+	exclude := make([]string, 0)
+	for _, replica := range mn.GetBatchLocations(m.blockID) {
+		exclude = append(exclude, replica.String())
+	}
+
+	targetID, target, ok := mn.LoadBalancer.PickTarget(exclude, m.preferred...)
+	if !ok || target.StorageRank(m.preferred) >= m.rank {
+		return false, nil
+	}
+	if err := mn.transferBlock(m.blockID, m.source.String(), targetID, target); err != nil {
+		return false, err
+	}
+
+	mn.lock.Lock()
+	// In safe mode the new copy is kept as an extra replica.
+	if err := mn.checkWritableLocked(); err != nil {
+		mn.lock.Unlock()
+		return false, err
+	}
+	// Standbys learn of the new copy from block reports, but only the log
+	// tells them the old one is gone.
+	p := RemoveReplicasPayload{WorkerID: m.source, Blocks: []uuid.UUID{m.blockID}}
+	if err := mn.appendToLog(OperationLogEntry{OpType: OpRemoveReplicas, Timestamp: time.Now().Unix(), Payload: p}); err != nil {
+		mn.lock.Unlock()
+		return false, fmt.Errorf("failed to write operation log: %w", err)
+	}
+	mn.applyRemoveReplicasLocked(p)
+	mn.lock.Unlock()
+
+	mn.deleteReplica(m.blockID, m.source)
+	return true, nil
+}
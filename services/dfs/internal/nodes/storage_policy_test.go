@@ -0,0 +1,198 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	commonv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/common/v1"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type fakeMoverClient struct {
+	fakeTransferClient
+	deleted []string
+}
+
+func (f *fakeMoverClient) DeleteBlock(ctx context.Context, in *datanodev1.DeleteBlockRequest, opts ...grpc.CallOption) (*datanodev1.DeleteBlockResponse, error) {
+	f.deleted = append(f.deleted, in.BlockId)
+	return &datanodev1.DeleteBlockResponse{Success: true}, nil
+}
+
+// tieredWorkers registers one live worker per storage type.
+func tieredWorkers(master *MasterNode) map[string]uuid.UUID {
+	lb := load_balancer.NewLoadBalancer(0, 50051)
+	ids := make(map[string]uuid.UUID)
+	for i, storage := range []string{load_balancer.StorageSSD, load_balancer.StorageDisk, load_balancer.StorageArchive} {
+		id := uuid.New()
+		ids[storage] = id
+		lb.AddWorker(id.String(), load_balancer.WorkerMetadata{
+			Client:      &fakeMoverClient{},
+			Ip:          fmt.Sprintf("worker-%d.worker-headless", i),
+			Port:        50051,
+			Alive:       true,
+			StorageType: storage,
+		})
+	}
+	master.LoadBalancer = lb
+	return ids
+}
+
+func TestMasterNode_StoragePolicyInheritance(t *testing.T) {
+	master := setupTestMaster(t)
+	require.NoError(t, master.Mkdirs(Superuser, "proj/archive/2024", "proj", ""))
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/archive/2024/a.parquet"})
+	require.NoError(t, err)
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/archive/2024/b.parquet", StoragePolicy: StoragePolicyHot})
+	require.NoError(t, err)
+
+	policy := func(path string) string {
+		info, err := master.StatPath(path)
+		require.NoError(t, err)
+		return info.StoragePolicy
+	}
+
+	assert.Equal(t, StoragePolicyWarm, policy("proj/archive/2024/a.parquet"))
+
+	require.NoError(t, master.SetStoragePolicy(Superuser, "proj/archive", StoragePolicyCold))
+	assert.Equal(t, StoragePolicyCold, policy("proj/archive/2024"))
+	assert.Equal(t, StoragePolicyCold, policy("proj/archive/2024/a.parquet"))
+	assert.Equal(t, StoragePolicyHot, policy("proj/archive/2024/b.parquet"), "a file's own policy wins")
+	assert.Equal(t, StoragePolicyWarm, policy("proj"))
+
+	require.NoError(t, master.SetStoragePolicy(Superuser, "proj/archive", ""))
+	assert.Equal(t, StoragePolicyWarm, policy("proj/archive/2024/a.parquet"))
+
+	assert.ErrorIs(t, master.SetStoragePolicy(Superuser, "proj/archive", "LUKEWARM"), ErrInvalidStoragePolicy)
+	assert.ErrorIs(t, master.SetStoragePolicy(Superuser, "proj/missing", StoragePolicyCold), ErrNotFound)
+}
+
+func TestMasterNode_AllocateBlockHonorsFileSettings(t *testing.T) {
+	master := setupTestMaster(t)
+	workers := tieredWorkers(master)
+
+	allocate := func(req *coordinatorv1.AllocateBlockRequest) []uuid.UUID {
+		t.Helper()
+		req.ProjectId = "proj"
+		resp, err := master.AllocateBlock(req)
+		require.NoError(t, err)
+		blockID := uuid.MustParse(resp.BlockId)
+		require.Len(t, resp.TargetDatanodes, len(master.BlockMap[blockID].Replicas))
+		return master.BlockMap[blockID].Replicas
+	}
+
+	t.Run("policies pick matching storage", func(t *testing.T) {
+		for policy, storage := range map[string]string{
+			StoragePolicyHot:  load_balancer.StorageSSD,
+			StoragePolicyWarm: load_balancer.StorageDisk,
+			StoragePolicyCold: load_balancer.StorageArchive,
+		} {
+			for i := 0; i < 3; i++ {
+				assert.Equal(t, []uuid.UUID{workers[storage]}, allocate(&coordinatorv1.AllocateBlockRequest{StoragePolicy: policy}), policy)
+			}
+		}
+	})
+
+	t.Run("the directory policy applies to new files", func(t *testing.T) {
+		require.NoError(t, master.Mkdirs(Superuser, "proj/cold", "proj", ""))
+		require.NoError(t, master.SetStoragePolicy(Superuser, "proj/cold", StoragePolicyCold))
+		replicas := allocate(&coordinatorv1.AllocateBlockRequest{FilePath: "proj/cold/new.avro"})
+		assert.Equal(t, []uuid.UUID{workers[load_balancer.StorageArchive]}, replicas)
+	})
+
+	t.Run("replication picks distinct workers, preferred types first", func(t *testing.T) {
+		replicas := allocate(&coordinatorv1.AllocateBlockRequest{Replication: 2, StoragePolicy: StoragePolicyHot})
+		assert.ElementsMatch(t, []uuid.UUID{workers[load_balancer.StorageSSD], workers[load_balancer.StorageDisk]}, replicas)
+
+		assert.Len(t, allocate(&coordinatorv1.AllocateBlockRequest{Replication: 5}), 3, "capped at the live workers")
+	})
+
+	t.Run("retries keep the replication", func(t *testing.T) {
+		failed := workers[load_balancer.StorageSSD]
+		replicas := allocate(&coordinatorv1.AllocateBlockRequest{Replication: 3, ExcludedWorkerIds: []string{failed.String()}})
+		require.Len(t, replicas, 3)
+		assert.Equal(t, failed, replicas[2], "the excluded worker comes last")
+
+		replicas = allocate(&coordinatorv1.AllocateBlockRequest{Replication: 2, ExcludedWorkerIds: []string{failed.String()}})
+		assert.NotContains(t, replicas, failed, "and only when needed")
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		_, err := master.AllocateBlock(&coordinatorv1.AllocateBlockRequest{ProjectId: "proj", StoragePolicy: "TEPID"})
+		assert.ErrorIs(t, err, ErrInvalidStoragePolicy)
+	})
+}
+
+func TestMasterNode_CommitFileRecordsSettings(t *testing.T) {
+	master := setupTestMaster(t)
+
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+		ProjectId:     "proj",
+		FilePath:      "proj/small.avro",
+		BlockSize:     1 << 20,
+		Replication:   3,
+		StoragePolicy: StoragePolicyHot,
+	})
+	require.NoError(t, err)
+
+	info, err := master.StatPath("proj/small.avro")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<20), info.BlockSize)
+	assert.Equal(t, int32(3), info.Replication)
+	assert.Equal(t, StoragePolicyHot, info.StoragePolicy)
+
+	_, err = master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/bad.avro", Replication: -1})
+	assert.Error(t, err)
+}
+
+func TestMasterNode_MoveBlocks(t *testing.T) {
+	master := setupTestMaster(t)
+	workers := tieredWorkers(master)
+	ssd, disk := workers[load_balancer.StorageSSD], workers[load_balancer.StorageDisk]
+
+	blockID := uuid.New()
+	master.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Size: 4, Replicas: []uuid.UUID{disk}}
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{
+		ProjectId: "proj",
+		FilePath:  "proj/hot/a.bin",
+		Blocks:    []*commonv1.BlockInfo{{BlockId: blockID.String(), Size: 4}},
+	})
+	require.NoError(t, err)
+
+	t.Run("well placed blocks stay", func(t *testing.T) {
+		assert.Equal(t, 0, master.MoveBlocks(""))
+		assert.Equal(t, []uuid.UUID{disk}, master.BlockMap[blockID].Replicas)
+	})
+
+	t.Run("a policy change moves the blocks", func(t *testing.T) {
+		require.NoError(t, master.setStoragePolicy(Superuser, "proj/hot", StoragePolicyHot))
+		assert.Equal(t, 1, master.MoveBlocks("proj/hot"))
+		assert.Equal(t, []uuid.UUID{ssd}, master.BlockMap[blockID].Replicas)
+
+		_, source, _, _, err := master.LoadBalancer.GetClientByWorkerID(disk.String())
+		require.NoError(t, err)
+		client := source.Client.(*fakeMoverClient)
+		require.Len(t, client.transfers, 1)
+		assert.Equal(t, "worker-0.worker-headless:50051", client.transfers[0].TargetAddress)
+		assert.Equal(t, []string{blockID.String()}, client.deleted)
+
+		standby := setupTestMaster(t)
+		standby.BlockMap[blockID] = &BlockMetadata{BlockID: blockID, Size: 4, Replicas: []uuid.UUID{disk, ssd}}
+		replayLog(t, master, standby)
+		assert.Equal(t, []uuid.UUID{ssd}, standby.BlockMap[blockID].Replicas, "standbys forget the moved replica")
+	})
+
+	t.Run("blocks stay on a fallback type without a better worker", func(t *testing.T) {
+		require.NoError(t, master.LoadBalancer.SetAdminState(ssd.String(), load_balancer.Decommissioning))
+		master.BlockMap[blockID].Replicas = []uuid.UUID{disk}
+		assert.Equal(t, 0, master.MoveBlocks(""))
+		assert.Equal(t, []uuid.UUID{disk}, master.BlockMap[blockID].Replicas)
+	})
+}
@@ -0,0 +1,187 @@
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchHistorySize is how many namespace events a master keeps for watches to
+// resume from.
+const WatchHistorySize = 10000
+
+// ErrWatchExpired is returned when a watch resumes from a TxID whose events
+// are no longer kept, or that this master has not applied.
+var ErrWatchExpired = errors.New("watch history expired")
+
+// EventType says what a NamespaceEvent did to its path.
+type EventType int
+
+const (
+	EventCreate EventType = iota + 1
+	EventDelete
+	EventRename
+	EventMetadata
+)
+
+// NamespaceEvent is a change to the namespace made by the operation TxID.
+type NamespaceEvent struct {
+	TxID int64
+	Type EventType
+	Path string
+	// DstPath is where a renamed path moved to.
+	DstPath string
+	// IsDir and Generation are set for creates and deletes.
+	IsDir      bool
+	Generation int64
+	Time       time.Time
+}
+
+// Matches reports whether the event touches prefix or a path below it. An
+// empty prefix matches everything.
+func (e NamespaceEvent) Matches(prefix string) bool {
+	return underPrefix(e.Path, prefix) || (e.DstPath != "" && underPrefix(e.DstPath, prefix))
+}
+
+func underPrefix(path, prefix string) bool {
+	if prefix == "" || prefix == "." {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+string(filepath.Separator))
+}
+
+// eventJournal keeps the latest namespace events. Leaders and standbys both
+// record the events of the operations they log, under the leader's TxIDs, so
+// a watch can resume on whichever master leads after a failover. A restarted
+// master seeds it from its op log.
+type eventJournal struct {
+	mu     sync.Mutex
+	events []NamespaceEvent
+	// floor is the TxID after which every event is kept.
+	floor int64
+	// last is the TxID of the latest operation recorded, with an event or not.
+	last int64
+	// changed is closed and replaced whenever events are added.
+	changed chan struct{}
+}
+
+// record notes that the operation txID was logged, and its events if it has
+// any. Operations must be recorded in TxID order; after a gap, as on a
+// standby that missed an entry, the events before it are no longer complete.
+func (j *eventJournal) record(txID int64, events []NamespaceEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if txID != j.last+1 {
+		j.floor = max(j.floor, txID-1)
+	}
+	j.last = max(j.last, txID)
+	if len(events) == 0 {
+		return
+	}
+
+	j.events = append(j.events, events...)
+	if over := len(j.events) - WatchHistorySize; over > 0 {
+		j.floor = j.events[over-1].TxID
+		j.events = append(j.events[:0], j.events[over:]...)
+	}
+	if j.changed != nil {
+		close(j.changed)
+		j.changed = nil
+	}
+}
+
+// skipTo starts the journal after txID when it has nothing newer, as for a
+// master loaded from an image its op log does not reach.
+func (j *eventJournal) skipTo(txID int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.last < txID {
+		j.events = nil
+		j.floor, j.last = txID, txID
+	}
+}
+
+// NamespaceEvents returns the events of operations after fromTxID, and a
+// channel that is closed once there are more. Watches loop over it, resuming
+// from the TxID of the last event returned.
+func (mn *MasterNode) NamespaceEvents(fromTxID int64) ([]NamespaceEvent, <-chan struct{}, error) {
+	j := &mn.events
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A TxID past the applied one comes from a master that was ahead of
+	// this one, with events this one may never see.
+	if fromTxID < j.floor || fromTxID > mn.AppliedTxID() {
+		return nil, nil, ErrWatchExpired
+	}
+	i := len(j.events)
+	for i > 0 && j.events[i-1].TxID > fromTxID {
+		i--
+	}
+	if j.changed == nil {
+		j.changed = make(chan struct{})
+	}
+	return append([]NamespaceEvent(nil), j.events[i:]...), j.changed, nil
+}
+
+// namespaceEvents derives the events of a logged operation. Most operations
+// have one, a concat one for the target and one for each source removed.
+func namespaceEvents(op OperationLogEntry) []NamespaceEvent {
+	data, err := json.Marshal(op.Payload)
+	if err != nil {
+		return nil
+	}
+	event := NamespaceEvent{TxID: op.TxID, Time: time.Unix(op.Timestamp, 0)}
+
+	switch op.OpType {
+	case OpRegisterFile, OpRegisterDir, OpDeleteFile:
+		var inode Inode
+		if json.Unmarshal(data, &inode) != nil {
+			return nil
+		}
+		event.Type = EventCreate
+		if op.OpType == OpDeleteFile {
+			event.Type = EventDelete
+		}
+		event.Path, event.IsDir, event.Generation = inode.Path, inode.Type == DirType, inode.Generation
+	case OpRenameFile:
+		var p RenamePayload
+		if json.Unmarshal(data, &p) != nil {
+			return nil
+		}
+		event.Type, event.Path, event.DstPath = EventRename, p.OldPath, p.NewPath
+	case OpSetStoragePolicy:
+		var p StoragePolicyPayload
+		if json.Unmarshal(data, &p) != nil {
+			return nil
+		}
+		event.Type, event.Path = EventMetadata, p.Path
+	case OpSetAttr:
+		var p AttrPayload
+		if json.Unmarshal(data, &p) != nil {
+			return nil
+		}
+		event.Type, event.Path = EventMetadata, p.Path
+	case OpConcatFiles:
+		var p ConcatPayload
+		if json.Unmarshal(data, &p) != nil || p.Inode == nil {
+			return nil
+		}
+		event.Type, event.Path, event.Generation = EventCreate, p.Path, p.Generation
+		events := []NamespaceEvent{event}
+		for _, src := range p.Sources {
+			if src != p.Path {
+				events = append(events, NamespaceEvent{TxID: op.TxID, Type: EventDelete, Path: src, Time: event.Time})
+			}
+		}
+		return events
+	default:
+		return nil
+	}
+	return []NamespaceEvent{event}
+}
@@ -0,0 +1,192 @@
+package nodes
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterNode_NamespaceEvents(t *testing.T) {
+	master := setupTestMaster(t)
+
+	require.NoError(t, master.Mkdirs(Superuser, "proj/raw", "proj", ""))
+	_, err := master.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/raw/a.avro"})
+	require.NoError(t, err)
+	require.NoError(t, master.Rename(Superuser, "proj/raw/a.avro", "proj/clean/a.avro"))
+	require.NoError(t, master.SetStoragePolicy(Superuser, "proj/clean/a.avro", StoragePolicyCold))
+	require.NoError(t, master.DeleteFile(Superuser, "proj/clean/a.avro", false))
+
+	events, _, err := master.NamespaceEvents(0)
+	require.NoError(t, err)
+
+	type change struct {
+		Type    EventType
+		Path    string
+		DstPath string
+	}
+	var changes []change
+	for i, e := range events {
+		if i > 0 {
+			assert.Greater(t, e.TxID, events[i-1].TxID, "events are in TxID order")
+		}
+		changes = append(changes, change{e.Type, e.Path, e.DstPath})
+	}
+	assert.Equal(t, []change{
+		{EventCreate, "proj", ""},
+		{EventCreate, "proj/raw", ""},
+		{EventCreate, "proj/raw/a.avro", ""},
+		{EventCreate, "proj/clean", ""},
+		{EventRename, "proj/raw/a.avro", "proj/clean/a.avro"},
+		{EventMetadata, "proj/clean/a.avro", ""},
+		{EventDelete, "proj/clean/a.avro", ""},
+	}, changes)
+	assert.True(t, events[1].IsDir)
+	assert.False(t, events[2].IsDir)
+	assert.Equal(t, master.AppliedTxID(), events[len(events)-1].TxID)
+
+	t.Run("resume", func(t *testing.T) {
+		rest, _, err := master.NamespaceEvents(events[4].TxID)
+		require.NoError(t, err)
+		assert.Equal(t, events[5:], rest)
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		var paths []string
+		for _, e := range events {
+			if e.Matches("proj/clean") {
+				paths = append(paths, e.Path)
+			}
+		}
+		assert.Equal(t, []string{"proj/clean", "proj/raw/a.avro", "proj/clean/a.avro", "proj/clean/a.avro"}, paths,
+			"renames into the prefix match")
+		assert.False(t, NamespaceEvent{Path: "proj/cleanup"}.Matches("proj/clean"))
+		assert.True(t, NamespaceEvent{Path: "proj/cleanup"}.Matches(""))
+	})
+}
+
+func TestMasterNode_NamespaceEventsWait(t *testing.T) {
+	master := setupTestMaster(t)
+
+	events, changed, err := master.NamespaceEvents(master.AppliedTxID())
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	select {
+	case <-changed:
+		t.Fatal("changed closed without a change")
+	default:
+	}
+
+	require.NoError(t, master.Mkdirs(Superuser, "proj", "proj", ""))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("changed not closed after a change")
+	}
+	events, _, err = master.NamespaceEvents(0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+}
+
+func TestMasterNode_NamespaceEventsExpire(t *testing.T) {
+	master := setupTestMaster(t)
+
+	for i := 0; i < WatchHistorySize+1; i++ {
+		txID := int64(i + 1)
+		master.events.record(txID, []NamespaceEvent{{TxID: txID, Type: EventCreate, Path: "p"}})
+	}
+	master.appliedTxID.Store(WatchHistorySize + 1)
+	_, _, err := master.NamespaceEvents(0)
+	assert.ErrorIs(t, err, ErrWatchExpired)
+
+	events, _, err := master.NamespaceEvents(1)
+	require.NoError(t, err)
+	assert.Len(t, events, WatchHistorySize)
+
+	// A watch from a master that was further along.
+	_, _, err = master.NamespaceEvents(WatchHistorySize + 2)
+	assert.ErrorIs(t, err, ErrWatchExpired)
+}
+
+func TestMasterNode_NamespaceEventsGap(t *testing.T) {
+	standby := setupTestMaster(t)
+	dir := func(path string) []byte {
+		payload, err := json.Marshal(&Inode{ID: uuid.NewString(), Path: path, Name: path, Type: DirType})
+		require.NoError(t, err)
+		return payload
+	}
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("a"), 1, 0))
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("b"), 2, 0))
+	// TxID 3 is missed.
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("d"), 4, 0))
+	require.NoError(t, standby.ApplyReplicatedLog(OpRegisterDir, dir("e"), 5, 0))
+	standby.IsActive = true
+	require.NoError(t, standby.Mkdirs(Superuser, "f", "", ""))
+
+	// Resuming before the gap would skip the missed event.
+	_, _, err := standby.NamespaceEvents(1)
+	assert.ErrorIs(t, err, ErrWatchExpired)
+
+	events, _, err := standby.NamespaceEvents(3)
+	require.NoError(t, err)
+	var paths []string
+	for _, e := range events {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"d", "e", "f"}, paths)
+}
+
+func TestMasterNode_NamespaceEventsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "master_op.log")
+	mn := openMasterNode(logPath, NewMasterNodeState())
+	mn.checkpointPath = filepath.Join(dir, MasterStateFile)
+
+	require.NoError(t, mn.Mkdirs(Superuser, "proj/raw", "proj", ""))
+	_, _, _, err := mn.SaveNamespace()
+	require.NoError(t, err)
+	require.NoError(t, mn.Rename(Superuser, "proj/raw", "proj/clean"))
+	want, _, err := mn.NamespaceEvents(0)
+	require.NoError(t, err)
+	mn.opLogFile.Close()
+
+	state := NewMasterNodeState()
+	require.NoError(t, state.LoadStateFromFile(mn.checkpointPath))
+	restarted := openMasterNode(logPath, state)
+	t.Cleanup(func() { restarted.opLogFile.Close() })
+
+	got, _, err := restarted.NamespaceEvents(0)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "the op log seeds the events, including those in the image")
+
+	// Without an op log, only watches from the image on can resume.
+	empty := openMasterNode(filepath.Join(t.TempDir(), "master_op.log"), state)
+	t.Cleanup(func() { empty.opLogFile.Close() })
+	_, _, err = empty.NamespaceEvents(0)
+	assert.ErrorIs(t, err, ErrWatchExpired)
+	events, _, err := empty.NamespaceEvents(state.LastTxID)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestMasterNode_ReplicatedNamespaceEvents(t *testing.T) {
+	leader := setupTestMaster(t)
+	standby := setupTestMaster(t)
+
+	require.NoError(t, leader.Mkdirs(Superuser, "proj/raw", "proj", ""))
+	_, err := leader.CommitFile(Superuser, &coordinatorv1.CommitFileRequest{ProjectId: "proj", FilePath: "proj/raw/a.avro"})
+	require.NoError(t, err)
+	require.NoError(t, leader.Rename(Superuser, "proj/raw/a.avro", "proj/raw/b.avro"))
+
+	replayLog(t, leader, standby)
+
+	want, _, err := leader.NamespaceEvents(0)
+	require.NoError(t, err)
+	got, _, err := standby.NamespaceEvents(0)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "a standby that takes over serves the same events")
+}
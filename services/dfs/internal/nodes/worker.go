@@ -3,26 +3,62 @@ package nodes
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+	"github.com/razvanmarinn/dfs/internal/load_balancer"
 	"github.com/razvanmarinn/dfs/internal/metrics"
 	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// rangedReadVerifyInterval is how long a block's last integrity check covers
+// ranged reads of it.
+const rangedReadVerifyInterval = 10 * time.Minute
+
 type WorkerNode struct {
 	ID         string
 	StorageDir string
 	Port       int
 	Address    string
-	lock       sync.Mutex
+	// NodeName is the Kubernetes node the worker runs on, if known.
+	NodeName string
+	// StorageType is the kind of storage behind StorageDir, matched against
+	// file storage policies by the master.
+	StorageType string
+	// DialOptions carry the transport credentials for connections to other
+	// workers; plaintext when empty.
+	DialOptions []grpc.DialOption
+	// BlockTokens verifies the block tokens sent with block operations; nil
+	// when block tokens are off.
+	BlockTokens *blocktoken.KeyRing
+	// QoS budgets the I/O of requests by class; nil leaves it unlimited.
+	QoS  *IOQoS
+	lock sync.Mutex
+
+	// Blocks that failed their last integrity check, reported to the master.
+	corruptBlocks map[string]struct{}
+	// verifiedBlocks holds when blocks last passed an integrity check.
+	verifiedBlocks map[string]time.Time
 
 	datanodev1.UnimplementedDataNodeServiceServer
 }
@@ -43,11 +79,27 @@ func NewWorkerNode(storageDir string, port int) *WorkerNode {
 	}
 
 	return &WorkerNode{
-		ID:         uuid.New().String(),
-		StorageDir: storageDir,
-		Port:       port,
-		Address:    dnsAddress,
+		ID:          uuid.New().String(),
+		StorageDir:  storageDir,
+		Port:        port,
+		Address:     dnsAddress,
+		NodeName:    os.Getenv("NODE_NAME"),
+		StorageType: storageTypeFromEnv(),
+	}
+}
+
+// storageTypeFromEnv reads the worker's storage type from $DFS_STORAGE_TYPE,
+// defaulting to DISK.
+func storageTypeFromEnv() string {
+	value := strings.ToUpper(os.Getenv("DFS_STORAGE_TYPE"))
+	switch value {
+	case load_balancer.StorageSSD, load_balancer.StorageDisk, load_balancer.StorageArchive:
+		return value
+	case "":
+	default:
+		log.Printf("Warning: unknown DFS_STORAGE_TYPE %q, using %s", value, load_balancer.StorageDisk)
 	}
+	return load_balancer.StorageDisk
 }
 
 func (wn *WorkerNode) Start() {
@@ -63,24 +115,175 @@ func (wn *WorkerNode) HealthCheck() bool {
 	return err == nil
 }
 
+// checkBlockToken verifies that token allows op on blockID. Expired tokens
+// fail with Unauthenticated, so clients know to fetch new ones.
+// checkBlockToken verifies a token for op on blockID and that it was issued
+// for the project the block was written for, and returns its claims.
+func (wn *WorkerNode) checkBlockToken(token, blockID string, op blocktoken.Op) (*blocktoken.Claims, error) {
+	claims, err := wn.BlockTokens.Verify(token, blockID, op)
+	if errors.Is(err, blocktoken.ErrExpired) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		log.Printf("Rejected %s of block %s: %v", op, blockID, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if project := StoredBlockProject(wn.StorageDir, blockID); !claims.AllowsProject(project) {
+		log.Printf("Rejected %s of block %s of project %s with a token of project %s", op, blockID, project, claims.ProjectID)
+		return nil, status.Errorf(codes.PermissionDenied, "block token of project %s does not cover block %s", claims.ProjectID, blockID)
+	}
+	return claims, nil
+}
+
 func (wn *WorkerNode) GetWorkerInfo(ctx context.Context, req *datanodev1.GetWorkerInfoRequest) (*datanodev1.GetWorkerInfoResponse, error) {
-	return &datanodev1.GetWorkerInfoResponse{
+	resp := &datanodev1.GetWorkerInfoResponse{
+		WorkerId:    wn.ID,
+		Address:     wn.Address,
+		NodeName:    wn.NodeName,
+		StorageType: wn.StorageType,
+	}
+
+	used, blocks, err := wn.blockUsage()
+	if err != nil {
+		log.Printf("Warning: failed to compute block usage: %v", err)
+	}
+	resp.UsedBytes = used
+	resp.BlockCount = blocks
+	metrics.StorageBytesUsed.WithLabelValues(wn.ID).Set(float64(used))
+
+	capacity, remaining, err := diskUsage(wn.StorageDir)
+	if err != nil {
+		log.Printf("Warning: failed to stat storage dir: %v", err)
+	}
+	resp.CapacityBytes = capacity
+	resp.RemainingBytes = remaining
+	resp.CorruptBlockIds = wn.CorruptBlocks()
+
+	return resp, nil
+}
+
+func (wn *WorkerNode) setCorrupt(blockID string, corrupt bool) {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+
+	if wn.corruptBlocks == nil {
+		wn.corruptBlocks = make(map[string]struct{})
+	}
+	if corrupt {
+		wn.corruptBlocks[blockID] = struct{}{}
+	} else {
+		delete(wn.corruptBlocks, blockID)
+	}
+	delete(wn.verifiedBlocks, blockID)
+}
+
+// setVerified records that a block just passed its integrity check.
+func (wn *WorkerNode) setVerified(blockID string) {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+
+	if wn.verifiedBlocks == nil {
+		wn.verifiedBlocks = make(map[string]time.Time)
+	}
+	wn.verifiedBlocks[blockID] = time.Now()
+}
+
+// recentlyVerified reports whether a block passed an integrity check within
+// rangedReadVerifyInterval.
+func (wn *WorkerNode) recentlyVerified(blockID string) bool {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+
+	at, ok := wn.verifiedBlocks[blockID]
+	return ok && time.Since(at) < rangedReadVerifyInterval
+}
+
+// CorruptBlocks returns the IDs of blocks that failed their last integrity check.
+func (wn *WorkerNode) CorruptBlocks() []string {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+
+	ids := make([]string, 0, len(wn.corruptBlocks))
+	for id := range wn.corruptBlocks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// blockUsage returns the bytes and number of blocks stored by this worker.
+func (wn *WorkerNode) blockUsage() (int64, int64, error) {
+	files, err := os.ReadDir(wn.StorageDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var used, count int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".bin") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		used += info.Size()
+		count++
+	}
+	return used, count, nil
+}
+
+// GetBlockReport lists the blocks this worker holds that are not known to be
+// corrupt. The master uses it to confirm blocks while in safe mode.
+func (wn *WorkerNode) GetBlockReport(ctx context.Context, req *datanodev1.GetBlockReportRequest) (*datanodev1.GetBlockReportResponse, error) {
+	files, err := os.ReadDir(wn.StorageDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage dir: %w", err)
+	}
+
+	corrupt := make(map[string]struct{})
+	for _, id := range wn.CorruptBlocks() {
+		corrupt[id] = struct{}{}
+	}
+
+	blockIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".bin") {
+			continue
+		}
+		blockID := strings.TrimSuffix(file.Name(), ".bin")
+		if _, bad := corrupt[blockID]; bad {
+			continue
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+
+	return &datanodev1.GetBlockReportResponse{
 		WorkerId: wn.ID,
-		Address:  wn.Address,
+		BlockIds: blockIDs,
 	}, nil
 }
 
 func (wn *WorkerNode) PushBlock(stream datanodev1.DataNodeService_PushBlockServer) error {
+	class := requestIOClass(stream.Context(), IOClassForegroundWrite)
+
 	var file *os.File
-	var blockID string
+	var blockID, project string
 	var totalBytes int64
 	hasher := crc32.NewIEEE()
+	contentHasher := sha256.New()
 	startTime := time.Now()
 
+	// The slot is taken once the block token checks out, so requests that
+	// are refused anyway do not queue for one.
+	var release func()
 	defer func() {
 		if file != nil {
 			file.Close()
 		}
+		if release != nil {
+			release()
+		}
 	}()
 
 	for {
@@ -97,14 +300,29 @@ func (wn *WorkerNode) PushBlock(stream datanodev1.DataNodeService_PushBlockServe
 				metrics.BlockWritesTotal.WithLabelValues("failure").Inc()
 				return err
 			}
+			contentHash := hex.EncodeToString(contentHasher.Sum(nil))
+			if err := os.WriteFile(wn.contentHashPath(blockID), []byte(contentHash), 0644); err != nil {
+				log.Printf("Warning: Failed to write content hash file for block %s: %v", blockID, err)
+				metrics.BlockWritesTotal.WithLabelValues("failure").Inc()
+				return err
+			}
+			if project != "" {
+				if err := os.WriteFile(blockProjectPath(wn.StorageDir, blockID), []byte(project), 0644); err != nil {
+					log.Printf("Warning: Failed to write project file for block %s: %v", blockID, err)
+					metrics.BlockWritesTotal.WithLabelValues("failure").Inc()
+					return err
+				}
+			}
 
 			metrics.BlockWritesTotal.WithLabelValues("success").Inc()
 			metrics.ChecksumCalculationDuration.Observe(checksumDuration)
 			metrics.BlockWriteSizeBytes.Observe(float64(totalBytes))
 
 			return stream.SendAndClose(&datanodev1.PushBlockResponse{
-				Success: true,
-				Message: fmt.Sprintf("Stored %d bytes, checksum: %d", totalBytes, checksum),
+				Success:     true,
+				Message:     fmt.Sprintf("Stored %d bytes, checksum: %d", totalBytes, checksum),
+				Checksum:    checksum,
+				ContentHash: contentHash,
 			})
 		}
 		if err != nil {
@@ -116,7 +334,24 @@ func (wn *WorkerNode) PushBlock(stream datanodev1.DataNodeService_PushBlockServe
 		switch payload := req.Data.(type) {
 
 		case *datanodev1.PushBlockRequest_Metadata:
+			if release != nil {
+				metrics.BlockWritesTotal.WithLabelValues("failure").Inc()
+				return status.Error(codes.InvalidArgument, "metadata sent twice")
+			}
 			blockID = payload.Metadata.BlockId
+			claims, err := wn.checkBlockToken(payload.Metadata.BlockToken, blockID, blocktoken.OpWrite)
+			if err != nil {
+				metrics.BlockWritesTotal.WithLabelValues("failure").Inc()
+				return err
+			}
+			project = payload.Metadata.ProjectId
+			if claims != nil && claims.ProjectID != "" {
+				project = claims.ProjectID
+			}
+			if release, err = wn.QoS.AdmitPush(stream.Context(), class); err != nil {
+				metrics.BlockWritesTotal.WithLabelValues("failure").Inc()
+				return err
+			}
 			filePath := filepath.Join(wn.StorageDir, fmt.Sprintf("%s.bin", blockID))
 
 			log.Printf("📥 Starting upload for Block %s", blockID)
@@ -131,12 +366,16 @@ func (wn *WorkerNode) PushBlock(stream datanodev1.DataNodeService_PushBlockServe
 			if file == nil {
 				return fmt.Errorf("received chunk before metadata")
 			}
+			if err := wn.QoS.WaitN(stream.Context(), class, len(payload.Chunk)); err != nil {
+				return err
+			}
 
 			n, err := file.Write(payload.Chunk)
 			if err != nil {
 				return fmt.Errorf("write failure: %w", err)
 			}
 			hasher.Write(payload.Chunk)
+			contentHasher.Write(payload.Chunk)
 			totalBytes += int64(n)
 		}
 	}
@@ -158,7 +397,45 @@ func (wn *WorkerNode) getStoredChecksum(blockID string) (uint32, error) {
 	return checksum, nil
 }
 
+// contentHashPath is where the hex SHA-256 of a block is kept next to it.
+func (wn *WorkerNode) contentHashPath(blockID string) string {
+	return filepath.Join(wn.StorageDir, fmt.Sprintf("%s.sha256", blockID))
+}
+
+// blockProjectPath is where the project a block was written for is kept next
+// to it.
+func blockProjectPath(storageDir, blockID string) string {
+	return filepath.Join(storageDir, fmt.Sprintf("%s.project", blockID))
+}
+
+// StoredBlockProject returns the project recorded for a block in storageDir,
+// or "" if the block was stored without one.
+func StoredBlockProject(storageDir, blockID string) string {
+	data, err := os.ReadFile(blockProjectPath(storageDir, blockID))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// getStoredContentHash returns the SHA-256 recorded for a block, or "" if
+// the block was stored before content hashes were kept.
+func (wn *WorkerNode) getStoredContentHash(blockID string) string {
+	data, err := os.ReadFile(wn.contentHashPath(blockID))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
 func (wn *WorkerNode) verifyBlockIntegrity(blockID string) error {
+	return wn.verifyBlockIntegrityThrottled(context.Background(), blockID, nil)
+}
+
+// verifyBlockIntegrityThrottled re-reads a block and compares it to its stored
+// checksum. When limiter is non-nil the disk read is paced by it, and when ctx
+// carries an I/O class by that class's budget as well.
+func (wn *WorkerNode) verifyBlockIntegrityThrottled(ctx context.Context, blockID string, limiter *rate.Limiter) error {
 	startTime := time.Now()
 	filePath := filepath.Join(wn.StorageDir, fmt.Sprintf("%s.bin", blockID))
 
@@ -169,8 +446,16 @@ func (wn *WorkerNode) verifyBlockIntegrity(blockID string) error {
 	}
 	defer file.Close()
 
+	var src io.Reader = file
+	if limiter != nil {
+		src = newThrottledReader(ctx, file, limiter)
+	}
+	if class, ok := ioClassFromContext(ctx); ok {
+		src = wn.QoS.Reader(ctx, class, src)
+	}
+
 	hasher := crc32.NewIEEE()
-	if _, err := io.Copy(hasher, file); err != nil {
+	if _, err := io.Copy(hasher, src); err != nil {
 		metrics.ChecksumVerificationsTotal.WithLabelValues("error").Inc()
 		return fmt.Errorf("failed to calculate checksum: %w", err)
 	}
@@ -186,12 +471,15 @@ func (wn *WorkerNode) verifyBlockIntegrity(blockID string) error {
 	metrics.ChecksumVerificationDuration.Observe(verificationDuration)
 
 	if calculatedChecksum != storedChecksum {
+		wn.setCorrupt(blockID, true)
 		metrics.ChecksumVerificationsTotal.WithLabelValues("corrupted").Inc()
 		metrics.BlockCorruptionTotal.WithLabelValues(wn.ID).Inc()
-		return fmt.Errorf("checksum mismatch: calculated=%d, stored=%d (CORRUPTION DETECTED)",
-			calculatedChecksum, storedChecksum)
+		return fmt.Errorf("%w: calculated=%d, stored=%d (CORRUPTION DETECTED)",
+			ErrChecksumMismatch, calculatedChecksum, storedChecksum)
 	}
 
+	wn.setCorrupt(blockID, false)
+	wn.setVerified(blockID)
 	metrics.ChecksumVerificationsTotal.WithLabelValues("valid").Inc()
 	log.Printf("✓ Block %s integrity verified (checksum: %d)", blockID, calculatedChecksum)
 	return nil
@@ -202,10 +490,39 @@ func (wn *WorkerNode) FetchBlock(req *datanodev1.FetchBlockRequest, stream datan
 	filePath := filepath.Join(wn.StorageDir, fmt.Sprintf("%s.bin", blockID))
 	var totalBytes int64
 
-	if err := wn.verifyBlockIntegrity(blockID); err != nil {
-		log.Printf("⚠️ Block integrity check failed for %s: %v", blockID, err)
+	if _, err := wn.checkBlockToken(req.BlockToken, blockID, blocktoken.OpRead); err != nil {
+		metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	if req.Offset < 0 || req.Length < 0 {
 		metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
-		return fmt.Errorf("block integrity check failed: %w", err)
+		return status.Errorf(codes.InvalidArgument, "invalid range %d+%d of block %s", req.Offset, req.Length, blockID)
+	}
+
+	class := requestIOClass(stream.Context(), IOClassForegroundRead)
+	release, err := wn.QoS.Admit(stream.Context(), class)
+	if err != nil {
+		metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	defer release()
+	ctx := contextWithIOClass(stream.Context(), class)
+
+	// A ranged read is an extent of a container block. Verifying the whole
+	// container on every extent read would read far more than the extents, so
+	// it is verified at most once per rangedReadVerifyInterval; the client
+	// checks the extent's own checksum as well.
+	ranged := req.Offset > 0 || req.Length > 0
+	if !ranged || !wn.recentlyVerified(blockID) {
+		if err := wn.verifyBlockIntegrityThrottled(ctx, blockID, nil); err != nil {
+			metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
+			if errors.Is(err, os.ErrNotExist) {
+				log.Printf("Block not found: %s", blockID)
+				return status.Errorf(codes.NotFound, "block %s not found", blockID)
+			}
+			log.Printf("⚠️ Block integrity check failed for %s: %v", blockID, err)
+			return status.Errorf(codes.DataLoss, "block integrity check failed: %v", err)
+		}
 	}
 
 	file, err := os.Open(filePath)
@@ -213,17 +530,37 @@ func (wn *WorkerNode) FetchBlock(req *datanodev1.FetchBlockRequest, stream datan
 		if os.IsNotExist(err) {
 			log.Printf("Block not found: %s", blockID)
 			metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
-			return fmt.Errorf("block not found")
+			return status.Errorf(codes.NotFound, "block %s not found", blockID)
 		}
 		metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
 		return err
 	}
 	defer file.Close()
 
-	log.Printf("📤 Streaming Block %s to client...", blockID)
+	var src io.Reader = file
+	if ranged {
+		info, err := file.Stat()
+		if err != nil {
+			metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
+			return err
+		}
+		length := req.Length
+		if length == 0 {
+			length = info.Size() - req.Offset
+		}
+		if length < 0 || req.Offset+length > info.Size() {
+			metrics.BlockReadsTotal.WithLabelValues("failure").Inc()
+			return status.Errorf(codes.OutOfRange, "range %d+%d is past the end of block %s (%d bytes)",
+				req.Offset, req.Length, blockID, info.Size())
+		}
+		src = io.NewSectionReader(file, req.Offset, length)
+		log.Printf("📤 Streaming %d bytes at %d of block %s to client...", length, req.Offset, blockID)
+	} else {
+		log.Printf("📤 Streaming Block %s to client...", blockID)
+	}
 
 	buffer := make([]byte, 64*1024)
-	reader := bufio.NewReader(file)
+	reader := bufio.NewReader(wn.QoS.Reader(ctx, class, src))
 
 	for {
 		n, err := reader.Read(buffer)
@@ -253,6 +590,9 @@ func (wn *WorkerNode) FetchBlock(req *datanodev1.FetchBlockRequest, stream datan
 
 func (wn *WorkerNode) GetBlockChecksum(ctx context.Context, req *datanodev1.GetBlockChecksumRequest) (*datanodev1.GetBlockChecksumResponse, error) {
 	blockID := req.BlockId
+	if _, err := wn.checkBlockToken(req.BlockToken, blockID, blocktoken.OpRead); err != nil {
+		return nil, err
+	}
 
 	checksum, err := wn.getStoredChecksum(blockID)
 	if err != nil {
@@ -266,8 +606,9 @@ func (wn *WorkerNode) GetBlockChecksum(ctx context.Context, req *datanodev1.GetB
 	}
 
 	return &datanodev1.GetBlockChecksumResponse{
-		Checksum: checksum,
-		Exists:   true,
+		Checksum:    checksum,
+		Exists:      true,
+		ContentHash: wn.getStoredContentHash(blockID),
 	}, nil
 }
 
@@ -276,12 +617,19 @@ func (wn *WorkerNode) DeleteBlock(ctx context.Context, req *datanodev1.DeleteBlo
 	filePath := filepath.Join(wn.StorageDir, fmt.Sprintf("%s.bin", blockID))
 	checksumFilePath := filepath.Join(wn.StorageDir, fmt.Sprintf("%s.checksum", blockID))
 
+	if _, err := wn.checkBlockToken(req.BlockToken, blockID, blocktoken.OpDelete); err != nil {
+		return &datanodev1.DeleteBlockResponse{Success: false, Message: err.Error()}, err
+	}
+
 	log.Printf("🗑️ Deleting Block %s", blockID)
+	wn.setCorrupt(blockID, false)
 
 	if err := os.Remove(filePath); err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("Block %s not found during deletion (already deleted?)", blockID)
 			os.Remove(checksumFilePath)
+			os.Remove(wn.contentHashPath(blockID))
+			os.Remove(blockProjectPath(wn.StorageDir, blockID))
 			return &datanodev1.DeleteBlockResponse{Success: true, Message: "Block not found, assumed deleted"}, nil
 		}
 		log.Printf("Failed to delete block %s: %v", blockID, err)
@@ -291,6 +639,113 @@ func (wn *WorkerNode) DeleteBlock(ctx context.Context, req *datanodev1.DeleteBlo
 	if err := os.Remove(checksumFilePath); err != nil && !os.IsNotExist(err) {
 		log.Printf("Warning: Failed to delete checksum file for block %s: %v", blockID, err)
 	}
+	if err := os.Remove(wn.contentHashPath(blockID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to delete content hash file for block %s: %v", blockID, err)
+	}
+	if err := os.Remove(blockProjectPath(wn.StorageDir, blockID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to delete project file for block %s: %v", blockID, err)
+	}
 
 	return &datanodev1.DeleteBlockResponse{Success: true, Message: "Block deleted successfully"}, nil
 }
+
+// TransferBlock copies a locally stored block to another worker. The master uses
+// it to drain decommissioning workers and to restore lost replicas.
+func (wn *WorkerNode) TransferBlock(ctx context.Context, req *datanodev1.TransferBlockRequest) (*datanodev1.TransferBlockResponse, error) {
+	blockID := req.BlockId
+	filePath := filepath.Join(wn.StorageDir, fmt.Sprintf("%s.bin", blockID))
+
+	if _, err := wn.checkBlockToken(req.BlockToken, blockID, blocktoken.OpRead); err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, err
+	}
+
+	// Transfers re-replicate, drain and rebalance: background work on both
+	// ends.
+	release, err := wn.QoS.Admit(ctx, IOClassBackground)
+	if err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, err
+	}
+	defer release()
+	ctx = contextWithIOClass(ctx, IOClassBackground)
+
+	if err := wn.verifyBlockIntegrityThrottled(ctx, blockID, nil); err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, fmt.Errorf("refusing to transfer block %s: %w", blockID, err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, err
+	}
+
+	dialOpts := wn.DialOptions
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(req.TargetAddress, dialOpts...)
+	if err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, fmt.Errorf("failed to connect to %s: %w", req.TargetAddress, err)
+	}
+	defer conn.Close()
+
+	stream, err := datanodev1.NewDataNodeServiceClient(conn).PushBlock(outgoingIOClass(ctx, IOClassBackground))
+	if err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, fmt.Errorf("failed to open push stream: %w", err)
+	}
+
+	if err := stream.Send(&datanodev1.PushBlockRequest{
+		Data: &datanodev1.PushBlockRequest_Metadata{
+			Metadata: &datanodev1.BlockMetadata{
+				BlockId:    blockID,
+				TotalSize:  info.Size(),
+				BlockToken: req.BlockToken,
+				ProjectId:  StoredBlockProject(wn.StorageDir, blockID),
+			},
+		},
+	}); err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, err
+	}
+
+	log.Printf("🚚 Transferring Block %s to %s", blockID, req.TargetAddress)
+
+	var totalBytes int64
+	buffer := make([]byte, 64*1024)
+	src := wn.QoS.Reader(ctx, IOClassBackground, file)
+	for {
+		n, err := src.Read(buffer)
+		if n > 0 {
+			if sendErr := stream.Send(&datanodev1.PushBlockRequest{
+				Data: &datanodev1.PushBlockRequest_Chunk{Chunk: buffer[:n]},
+			}); sendErr != nil {
+				return &datanodev1.TransferBlockResponse{Success: false, Message: sendErr.Error()}, sendErr
+			}
+			totalBytes += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: err.Error()}, fmt.Errorf("target rejected block %s: %w", blockID, err)
+	}
+	if !resp.Success {
+		return &datanodev1.TransferBlockResponse{Success: false, Message: resp.Message}, fmt.Errorf("target rejected block %s: %s", blockID, resp.Message)
+	}
+
+	log.Printf("✅ Transferred Block %s to %s (%d bytes)", blockID, req.TargetAddress, totalBytes)
+	return &datanodev1.TransferBlockResponse{
+		Success:          true,
+		Message:          resp.Message,
+		BytesTransferred: totalBytes,
+	}, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,18 +9,27 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
+	"github.com/razvanmarinn/dfs/internal/nodes"
 )
 
 type HTTPServer struct {
 	storageDir string
 	port       int
 	server     *http.Server
+	// tokens verifies block tokens on downloads; nil when they are off.
+	tokens *blocktoken.KeyRing
+	// qos budgets downloads with the worker's gRPC reads; nil is unlimited.
+	qos *nodes.IOQoS
 }
 
-func NewHTTPServer(storageDir string, port int) *HTTPServer {
+func NewHTTPServer(storageDir string, port int, tokens *blocktoken.KeyRing, qos *nodes.IOQoS) *HTTPServer {
 	return &HTTPServer{
 		storageDir: storageDir,
 		port:       port,
+		tokens:     tokens,
+		qos:        qos,
 	}
 }
 
@@ -68,6 +78,24 @@ func (s *HTTPServer) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The token is only taken from the X-Block-Token header; in the URL it
+	// would end up in access logs and proxy caches.
+	claims, err := s.tokens.Verify(r.Header.Get("X-Block-Token"), cleanPath, blocktoken.OpRead)
+	if err != nil {
+		log.Printf("Rejected HTTP download of block %s: %v", cleanPath, err)
+		code := http.StatusForbidden
+		if errors.Is(err, blocktoken.ErrExpired) {
+			code = http.StatusUnauthorized
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if project := nodes.StoredBlockProject(s.storageDir, cleanPath); !claims.AllowsProject(project) {
+		log.Printf("Rejected HTTP download of block %s of project %s with a token of project %s", cleanPath, project, claims.ProjectID)
+		http.Error(w, "block token does not cover this block", http.StatusForbidden)
+		return
+	}
+
 	fullPath := filepath.Join(s.storageDir, cleanPath+".bin")
 
 	absStorageDir, _ := filepath.Abs(s.storageDir)
@@ -78,11 +106,34 @@ func (s *HTTPServer) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	file, err := os.Open(fullPath)
+	if os.IsNotExist(err) {
 		http.Error(w, "Block not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	class := nodes.IOClassForegroundRead
+	if c, ok := nodes.ParseIOClass(r.Header.Get(nodes.IOClassHeader)); ok {
+		class = c
+	}
+	release, err := s.qos.Admit(r.Context(), class)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
 
 	log.Printf("Serving block via HTTP: %s.bin", cleanPath)
-	http.ServeFile(w, r, fullPath)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), s.qos.ReadSeeker(r.Context(), class, file))
 }
@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
 )
 
 func TestHTTPServer_SecurityAndAccess(t *testing.T) {
@@ -22,7 +25,7 @@ func TestHTTPServer_SecurityAndAccess(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	server := NewHTTPServer(tmpDir, 8080)
+	server := NewHTTPServer(tmpDir, 8080, nil, nil)
 
 	tests := []struct {
 		name           string
@@ -78,3 +81,51 @@ func TestHTTPServer_SecurityAndAccess(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPServer_BlockTokens(t *testing.T) {
+	tmpDir := t.TempDir()
+	for name, content := range map[string]string{"blk-1.bin": "data", "blk-2.bin": "data", "blk-2.project": "other"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyFile := filepath.Join(tmpDir, "keys")
+	if err := os.WriteFile(keyFile, []byte("k1 MDEyMzQ1Njc4OWFiY2RlZg==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := blocktoken.Load(keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readToken, _ := keys.Issue("blk-1", "proj", blocktoken.OpRead)
+	writeToken, _ := keys.Issue("blk-1", "proj", blocktoken.OpWrite)
+	otherProjectToken, _ := keys.Issue("blk-2", "proj", blocktoken.OpRead)
+
+	server := NewHTTPServer(tmpDir, 8080, keys, nil)
+	tests := []struct {
+		name           string
+		requestPath    string
+		header         string
+		expectedStatus int
+	}{
+		{name: "No Token", requestPath: "/blocks/blk-1", expectedStatus: http.StatusForbidden},
+		{name: "Write Token", requestPath: "/blocks/blk-1", header: writeToken, expectedStatus: http.StatusForbidden},
+		{name: "Query Token", requestPath: "/blocks/blk-1?token=" + readToken, expectedStatus: http.StatusForbidden},
+		{name: "Header Token", requestPath: "/blocks/blk-1", header: readToken, expectedStatus: http.StatusOK},
+		{name: "Other Project", requestPath: "/blocks/blk-2", header: otherProjectToken, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.requestPath, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Block-Token", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			server.handleDownload(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("got status %v, want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
@@ -12,12 +12,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/blocktoken"
 	"github.com/razvanmarinn/dfs/internal/nodes"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -30,6 +31,15 @@ const (
 	storageDir      = "/data"
 )
 
+// callerPolicy keeps the RPCs that delete, copy or report on stored blocks to
+// the master. Blocks are written and read by clients and other workers alike.
+var callerPolicy = tlsconfig.Policy{
+	datanodev1.DataNodeService_DeleteBlock_FullMethodName:      {"master"},
+	datanodev1.DataNodeService_TransferBlock_FullMethodName:    {"master"},
+	datanodev1.DataNodeService_GetBlockReport_FullMethodName:   {"master"},
+	datanodev1.DataNodeService_GetBlockChecksum_FullMethodName: {"master"},
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.Println("Starting worker node...")
@@ -61,11 +71,21 @@ func main() {
 
 	state := nodes.NewWorkerNodeState()
 
-	if err := state.LoadStateFromFile(); err != nil {
+	if err := state.LoadStateFromFile(nodes.WorkerStateFile); err != nil {
 		log.Printf("No existing state found or failed to load, starting fresh: %v", err)
 	}
 
+	tlsSource := tlsconfig.MustLoad(tlsconfig.FromEnv("worker"))
+
 	worker := nodes.NewWorkerNode(storageDir, port)
+	worker.DialOptions = []grpc.DialOption{tlsSource.DialOption("worker")}
+
+	blockTokens, err := blocktoken.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load block token keys: %v", err)
+	}
+	worker.BlockTokens = blockTokens
+	worker.QoS = nodes.NewIOQoS(loadIOQoSConfig())
 
 	if state.ID != "" {
 		log.Printf("Restoring previous Worker ID: %s", state.ID)
@@ -76,10 +96,10 @@ func main() {
 
 	worker.Start()
 
-	integrityChecker := nodes.NewIntegrityChecker(worker, 1*time.Hour)
-	integrityChecker.Start()
+	blockScanner := nodes.NewBlockScanner(worker, loadBlockScannerConfig())
+	blockScanner.Start()
 
-	httpServer := NewHTTPServer(storageDir, httpPort)
+	httpServer := NewHTTPServer(storageDir, httpPort, blockTokens, worker.QoS)
 	httpServer.Start()
 
 	go func() {
@@ -105,8 +125,8 @@ func main() {
 			Time:    10 * time.Second,
 			Timeout: 20 * time.Second,
 		}),
-		grpc.Creds(insecure.NewCredentials()),
 	}
+	opts = append(opts, tlsSource.ServerOptions(callerPolicy)...)
 
 	grpcServer := grpc.NewServer(opts...)
 
@@ -145,12 +165,12 @@ func main() {
 			log.Printf("failed to update state: %v", err)
 		}
 
-		if err := state.SaveState(); err != nil {
+		if err := state.SaveState(nodes.WorkerStateFile); err != nil {
 			log.Printf("failed to save state: %v", err)
 		}
 
 		grpcServer.GracefulStop()
-		integrityChecker.Stop()
+		blockScanner.Stop()
 		worker.Stop()
 		httpServer.Stop()
 		log.Println("Worker node stopped")
@@ -160,3 +180,54 @@ func main() {
 	wg.Wait()
 	log.Println("Main function exiting !")
 }
+
+func loadBlockScannerConfig() nodes.BlockScannerConfig {
+	cfg := nodes.DefaultBlockScannerConfig()
+
+	if v := os.Getenv("BLOCK_SCANNER_BYTES_PER_SEC"); v != "" {
+		rate, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid BLOCK_SCANNER_BYTES_PER_SEC: %v", err)
+		}
+		cfg.BytesPerSecond = rate
+	}
+
+	if v := os.Getenv("BLOCK_SCANNER_PERIOD"); v != "" {
+		period, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid BLOCK_SCANNER_PERIOD: %v", err)
+		}
+		cfg.ScanPeriod = period
+	}
+
+	return cfg
+}
+
+// loadIOQoSConfig reads the I/O budget of each request class from
+// IO_QOS_<CLASS>_BYTES_PER_SEC and IO_QOS_<CLASS>_MAX_CONCURRENT, where CLASS
+// is FOREGROUND_READ, FOREGROUND_WRITE or BACKGROUND. 0 lifts a limit.
+func loadIOQoSConfig() nodes.IOQoSConfig {
+	cfg := nodes.DefaultIOQoSConfig()
+
+	for prefix, budget := range map[string]*nodes.IOBudget{
+		"IO_QOS_FOREGROUND_READ":  &cfg.ForegroundRead,
+		"IO_QOS_FOREGROUND_WRITE": &cfg.ForegroundWrite,
+		"IO_QOS_BACKGROUND":       &cfg.Background,
+	} {
+		if v := os.Getenv(prefix + "_BYTES_PER_SEC"); v != "" {
+			rate, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid %s_BYTES_PER_SEC: %v", prefix, err)
+			}
+			budget.BytesPerSecond = rate
+		}
+		if v := os.Getenv(prefix + "_MAX_CONCURRENT"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatalf("Invalid %s_MAX_CONCURRENT: %v", prefix, err)
+			}
+			budget.MaxConcurrent = n
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
+	datanodev1 "github.com/razvanmarinn/datalake/protobuf/gen/go/datanode/v1"
+	"github.com/razvanmarinn/dfs/internal/nodes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallerPolicy(t *testing.T) {
+	caDir := t.TempDir()
+	source := func(service string) *tlsconfig.Source {
+		src, err := tlsconfig.Load(tlsconfig.Config{Service: service, DevMode: true, DevDir: caDir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(source("worker").ServerOptions(callerPolicy)...)
+	datanodev1.RegisterDataNodeServiceServer(server, nodes.NewWorkerNode(t.TempDir(), 0))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	dial := func(service string, peers ...string) datanodev1.DataNodeServiceClient {
+		conn, err := grpc.NewClient(lis.Addr().String(), source(service).DialOption(peers...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return datanodev1.NewDataNodeServiceClient(conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	master := dial("master", "worker")
+	if _, err := master.DeleteBlock(ctx, &datanodev1.DeleteBlockRequest{BlockId: "b1"}); err != nil {
+		t.Errorf("master DeleteBlock: %v", err)
+	}
+
+	client := dial("dfscli", "worker")
+	if _, err := client.GetWorkerInfo(ctx, &datanodev1.GetWorkerInfoRequest{}); err != nil {
+		t.Errorf("client GetWorkerInfo: %v", err)
+	}
+	if _, err := client.DeleteBlock(ctx, &datanodev1.DeleteBlockRequest{BlockId: "b1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("client DeleteBlock: got %v, want PermissionDenied", err)
+	}
+
+	wrongPeer := dial("dfscli", "master")
+	if _, err := wrongPeer.GetWorkerInfo(ctx, &datanodev1.GetWorkerInfoRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("dialing a worker as the master: got %v, want Unavailable", err)
+	}
+
+	untrusted, err := tlsconfig.Load(tlsconfig.Config{Service: "master", DevMode: true, DevDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(lis.Addr().String(), untrusted.DialOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = datanodev1.NewDataNodeServiceClient(conn).DeleteBlock(ctx, &datanodev1.DeleteBlockRequest{BlockId: "b1"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("master certificate from another CA: got %v, want Unavailable", err)
+	}
+}
+
+func TestLoadIOQoSConfig(t *testing.T) {
+	t.Setenv("IO_QOS_FOREGROUND_READ_MAX_CONCURRENT", "32")
+	t.Setenv("IO_QOS_BACKGROUND_BYTES_PER_SEC", "1048576")
+	t.Setenv("IO_QOS_BACKGROUND_MAX_CONCURRENT", "0")
+
+	cfg := loadIOQoSConfig()
+	want := nodes.IOQoSConfig{
+		ForegroundRead: nodes.IOBudget{MaxConcurrent: 32},
+		Background:     nodes.IOBudget{BytesPerSecond: 1 << 20},
+	}
+	if cfg != want {
+		t.Errorf("loadIOQoSConfig() = %+v, want %+v", cfg, want)
+	}
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
 	"fmt"
 	"os"
 
@@ -157,3 +160,51 @@ func GetUserByID(db *sql.DB, id uuid.UUID) (*models.Client, error) {
 	}
 	return &user, nil
 }
+
+// CreateAccessKey issues a new S3-style access key for the user.
+func CreateAccessKey(db *sql.DB, userID uuid.UUID) (*models.AccessKey, error) {
+	id, err := randomKey(10, base32.StdEncoding.WithPadding(base32.NoPadding))
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomKey(30, base64.RawURLEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.AccessKey{
+		AccessKeyID:     "DL" + id,
+		SecretAccessKey: secret,
+		UserID:          userID,
+	}
+
+	query := `INSERT INTO access_keys (access_key_id, secret_access_key, user_id) VALUES ($1, $2, $3)`
+	if _, err := db.Exec(query, key.AccessKeyID, key.SecretAccessKey, key.UserID); err != nil {
+		return nil, fmt.Errorf("error inserting access key: %v", err)
+	}
+	return key, nil
+}
+
+func GetAccessKey(db *sql.DB, accessKeyID string) (*models.AccessKey, error) {
+	query := `SELECT k.access_key_id, k.secret_access_key, u.id, u.username
+		FROM access_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.access_key_id = $1`
+
+	var key models.AccessKey
+	err := db.QueryRow(query, accessKeyID).Scan(&key.AccessKeyID, &key.SecretAccessKey, &key.UserID, &key.Username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error scanning access key: %v", err)
+	}
+	return &key, nil
+}
+
+func randomKey(n int, enc interface{ EncodeToString([]byte) string }) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return enc.EncodeToString(buf), nil
+}
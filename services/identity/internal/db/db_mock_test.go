@@ -250,3 +250,58 @@ func TestGetDBConfig_EmptyEnv(t *testing.T) {
 	host, _, _, _, _ := GetDBConfig()
 	assert.Equal(t, "identity-postgres", host) // Default value
 }
+
+func TestCreateAccessKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	userID := uuid.New()
+	mock.ExpectExec("INSERT INTO access_keys").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	key, err := CreateAccessKey(db, userID)
+	require.NoError(t, err)
+	assert.Equal(t, userID, key.UserID)
+	assert.Len(t, key.AccessKeyID, 18)
+	assert.Len(t, key.SecretAccessKey, 40)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAccessKey_Found(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	userID := uuid.New()
+	rows := sqlmock.NewRows([]string{"access_key_id", "secret_access_key", "id", "username"}).
+		AddRow("DLKEY", "secret", userID, "testuser")
+
+	mock.ExpectQuery("SELECT k.access_key_id, k.secret_access_key, u.id, u.username").
+		WithArgs("DLKEY").
+		WillReturnRows(rows)
+
+	key, err := GetAccessKey(db, "DLKEY")
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	assert.Equal(t, "secret", key.SecretAccessKey)
+	assert.Equal(t, userID, key.UserID)
+	assert.Equal(t, "testuser", key.Username)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAccessKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT k.access_key_id").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"access_key_id", "secret_access_key", "id", "username"}))
+
+	key, err := GetAccessKey(db, "missing")
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,12 @@
+package models
+
+import "github.com/google/uuid"
+
+// AccessKey is an S3-style credential pair owned by a user. The secret is
+// stored as issued because gateways need it to verify request signatures.
+type AccessKey struct {
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	UserID          uuid.UUID `json:"user_id"`
+	Username        string    `json:"username"`
+}
@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"time"
 
 	"github.com/razvanmarinn/datalake/pkg/logging"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	identityv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
 	pb "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
 	"github.com/razvanmarinn/identity-service/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type GRPCServer struct {
@@ -20,3 +26,59 @@ func (s *GRPCServer) GetUserInfo(ctx context.Context, in *pb.GetUserInfoRequest)
 	user, _ := db.GetUser(s.DB, in.GetUsername())
 	return &pb.GetUserInfoResponse{UserId: user.ID.String(), Email: user.Email}, nil
 }
+
+// GetAccessKey serves the SigV4 signing key of an access key for one
+// credential scope, and only to TLS callers: the caller policy can only hold
+// the keys to the S3 gateway for callers that present a certificate.
+func (s *GRPCServer) GetAccessKey(ctx context.Context, in *pb.GetAccessKeyRequest) (*pb.GetAccessKeyResponse, error) {
+	if _, ok := tlsconfig.PeerIdentity(ctx); !ok {
+		return nil, status.Error(codes.PermissionDenied, "access keys are only served over TLS")
+	}
+	if err := checkScope(in, time.Now()); err != nil {
+		return nil, err
+	}
+
+	key, err := db.GetAccessKey(s.DB, in.GetAccessKeyId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to look up access key")
+	}
+	if key == nil {
+		return nil, status.Error(codes.NotFound, "access key not found")
+	}
+	return &pb.GetAccessKeyResponse{
+		UserId:     key.UserID.String(),
+		Username:   key.Username,
+		SigningKey: signingKey(key.SecretAccessKey, in.Date, in.Region, in.Service),
+	}, nil
+}
+
+// checkScope accepts credential scopes of S3 requests signed around now. A
+// day either side leaves room for clock skew across midnight.
+func checkScope(in *pb.GetAccessKeyRequest, now time.Time) error {
+	if in.Service != "s3" || in.Region == "" {
+		return status.Error(codes.InvalidArgument, "credential scope must be an S3 region")
+	}
+	date, err := time.Parse("20060102", in.Date)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "malformed credential date %q", in.Date)
+	}
+	if d := now.UTC().Sub(date); d < -24*time.Hour || d > 48*time.Hour {
+		return status.Errorf(codes.InvalidArgument, "credential date %s is not current", in.Date)
+	}
+	return nil
+}
+
+// signingKey derives the SigV4 signing key of a secret for a credential
+// scope.
+func signingKey(secret, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
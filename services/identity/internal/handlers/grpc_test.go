@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"encoding/hex"
 	"testing"
+	"time"
 
 	identityv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestGRPCServer_Structure(t *testing.T) {
@@ -25,3 +30,39 @@ func TestGRPCServer_GetUserInfo(t *testing.T) {
 		assert.Equal(t, "testuser", req.GetUsername())
 	})
 }
+
+func TestGRPCServer_GetAccessKey(t *testing.T) {
+	t.Run("refuses callers without TLS", func(t *testing.T) {
+		server := &GRPCServer{}
+		_, err := server.GetAccessKey(context.Background(), &identityv1.GetAccessKeyRequest{
+			AccessKeyId: "DLKEY", Date: time.Now().UTC().Format("20060102"), Region: "us-east-1", Service: "s3",
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("checks the credential scope", func(t *testing.T) {
+		now := time.Date(2024, 3, 10, 0, 5, 0, 0, time.UTC)
+		for _, tc := range []struct {
+			date, region, service string
+			ok                    bool
+		}{
+			{"20240310", "us-east-1", "s3", true},
+			{"20240309", "us-east-1", "s3", true},
+			{"20240311", "us-east-1", "s3", true},
+			{"20240301", "us-east-1", "s3", false},
+			{"20240312", "us-east-1", "s3", false},
+			{"2024-03-10", "us-east-1", "s3", false},
+			{"20240310", "", "s3", false},
+			{"20240310", "us-east-1", "iam", false},
+		} {
+			err := checkScope(&identityv1.GetAccessKeyRequest{Date: tc.date, Region: tc.region, Service: tc.service}, now)
+			assert.Equal(t, tc.ok, err == nil, "%+v: %v", tc, err)
+		}
+	})
+
+	t.Run("derives the signing key", func(t *testing.T) {
+		// The example from the AWS "Signature Version 4" documentation.
+		key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+		assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+	})
+}
@@ -88,5 +88,36 @@ func SetupRouter(database *sql.DB, logger *logging.Logger) *gin.Engine {
 		c.JSON(http.StatusOK, gin.H{"token": tkn, "user-id": user.ID})
 	})
 
+	// Issues S3 access keys for the S3 gateway. Callers authenticate with
+	// their username and password, like /login/.
+	r.POST("/access-keys/", func(c *gin.Context) {
+		var loginBody LoginBody
+		if err := c.ShouldBindJSON(&loginBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, err := db.GetUser(database, loginBody.Username)
+		if err != nil {
+			logger.WithRequest(c).Error("Failed to get user", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if user == nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginBody.Password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			return
+		}
+
+		key, err := db.CreateAccessKey(database, user.ID)
+		if err != nil {
+			logger.WithRequest(c).Error("Failed to create access key", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access key"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"access_key_id":     key.AccessKeyID,
+			"secret_access_key": key.SecretAccessKey,
+		})
+	})
+
 	return r
 }
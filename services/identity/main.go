@@ -10,12 +10,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/razvanmarinn/datalake/pkg/logging"
 	"github.com/razvanmarinn/datalake/pkg/metrics"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	indentityv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
 	"github.com/razvanmarinn/identity-service/internal/db"
 	"github.com/razvanmarinn/identity-service/internal/handlers"
 	"google.golang.org/grpc"
 )
 
+// callerPolicy keeps the S3 signing keys to the S3 gateway, which verifies
+// request signatures with them.
+var callerPolicy = tlsconfig.Policy{
+	indentityv1.IdentityService_GetAccessKey_FullMethodName: {"s3-gateway"},
+}
+
 func main() {
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = io.Discard
@@ -31,7 +38,13 @@ func main() {
 	}
 	defer database.Close()
 
-	s := grpc.NewServer()
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv("identity-service"))
+	if err != nil {
+		logger.LogStartupError("tls", err)
+		os.Exit(1)
+	}
+
+	s := grpc.NewServer(tlsSource.ServerOptions(callerPolicy)...)
 	indentityv1.RegisterIdentityServiceServer(s, &handlers.GRPCServer{
 		DB:     database,
 		Logger: logger,
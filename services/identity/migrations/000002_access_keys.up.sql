@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS access_keys (
+    access_key_id VARCHAR(32) PRIMARY KEY,
+    secret_access_key TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_keys_user_id ON access_keys(user_id);
@@ -33,12 +33,18 @@ func (sc *SchemaCache) Set(key string, schema *batcher.Schema) {
 }
 
 type GRPCConnCache struct {
-	mu    sync.RWMutex
-	conns map[string]*grpc.ClientConn
+	mu       sync.RWMutex
+	conns    map[string]*grpc.ClientConn
+	dialOpts []grpc.DialOption
 }
 
-func NewGRPCConnCache() *GRPCConnCache {
-	return &GRPCConnCache{conns: make(map[string]*grpc.ClientConn)}
+// NewGRPCConnCache dials new connections with dialOpts, plaintext when none
+// are given.
+func NewGRPCConnCache(dialOpts ...grpc.DialOption) *GRPCConnCache {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return &GRPCConnCache{conns: make(map[string]*grpc.ClientConn), dialOpts: dialOpts}
 }
 
 func (cc *GRPCConnCache) Get(addr string) (*grpc.ClientConn, error) {
@@ -66,10 +72,7 @@ func (cc *GRPCConnCache) Get(addr string) (*grpc.ClientConn, error) {
 		grpc.MaxCallSendMsgSize(64 * 1024 * 1024),
 	}
 
-	newConn, err := grpc.Dial(addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithDefaultCallOptions(opts...),
-	)
+	newConn, err := grpc.Dial(addr, append(cc.dialOpts, grpc.WithDefaultCallOptions(opts...))...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	catalogv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/catalog/v1"
 	"github.com/razvanmarinn/ingestion_consumer/internal/batcher"
 	"github.com/razvanmarinn/ingestion_consumer/internal/config"
@@ -54,7 +55,12 @@ func NewApp(cfg config.Config, logger *slog.Logger) (*App, error) {
 		return nil, err
 	}
 
-	dfsClient, err := dfs.NewClient(cfg.MasterAddress)
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv(ServiceName))
+	if err != nil {
+		return nil, err
+	}
+
+	dfsClient, err := dfs.NewClient(cfg.MasterAddress, dfs.WithTransportCredentials(tlsSource.ClientCredentials("master", "worker")))
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +71,7 @@ func NewApp(cfg config.Config, logger *slog.Logger) (*App, error) {
 		TracerProvider: tp,
 		Tracer:         otel.Tracer(ServiceName),
 		HttpClient:     &http.Client{Timeout: 5 * time.Second},
-		GrpcConnCache:  infra.NewGRPCConnCache(),
+		GrpcConnCache:  infra.NewGRPCConnCache(tlsSource.DialOption("metadata-service")),
 		DFSClient:      dfsClient,
 		DLTProducer:    dltProducer,
 		Batcher:        batcher.NewBatcher("mixed-topics-batch", MaxBatchSize),
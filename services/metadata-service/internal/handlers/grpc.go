@@ -11,6 +11,8 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/razvanmarinn/metadata-service/internal/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -30,7 +32,7 @@ func (s *GRPCServer) GetProject(ctx context.Context, req *catalogv1.GetProjectRe
 	project, err := db.GetProjectByID(s.DB, projectId)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("project not found")
+			return nil, status.Error(codes.NotFound, "project not found")
 		}
 		s.Logger.Error("Failed to fetch project", zap.Error(err))
 		return nil, fmt.Errorf("internal database error")
@@ -5,6 +5,7 @@ import (
 
 	"github.com/razvanmarinn/datalake/pkg/logging"
 	"github.com/razvanmarinn/datalake/pkg/metrics"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	catalogv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/catalog/v1"
 	identityv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
 	"github.com/razvanmarinn/metadata-service/internal/db"
@@ -13,9 +14,16 @@ import (
 	"github.com/razvanmarinn/metadata-service/internal/scheduler"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
+// callerPolicy keeps the compaction job queue to the compactor and data file
+// registration to the services that write them.
+var callerPolicy = tlsconfig.Policy{
+	catalogv1.CatalogService_PollCompactionJobs_FullMethodName: {"compactor"},
+	catalogv1.CatalogService_UpdateJobStatus_FullMethodName:    {"compactor"},
+	catalogv1.CatalogService_RegisterDataFile_FullMethodName:   {"compactor", "ingestion-consumer"},
+}
+
 func main() {
 	logger := logging.NewDefaultLogger("metadata-service")
 	serviceMetrics := metrics.NewServiceMetrics("metadata-service")
@@ -31,7 +39,12 @@ func main() {
 		logger.Error("K8s Provisioner disabled (check if running inside K8s)", zap.Error(err))
 	}
 
-	conn, err := grpc.Dial("identity-service:50056", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv("metadata-service"))
+	if err != nil {
+		logger.Fatal("Failed to load TLS certificates", zap.Error(err))
+	}
+
+	conn, err := grpc.Dial("identity-service:50056", tlsSource.DialOption("identity-service"))
 	if err != nil {
 		logger.Fatal("Failed to connect to Identity gRPC service", zap.Error(err))
 	}
@@ -44,7 +57,7 @@ func main() {
 	metrics.SetupMetricsEndpoint(r)
 	r.Use(serviceMetrics.PrometheusMiddleware())
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(tlsSource.ServerOptions(callerPolicy)...)
 	metadataGRPCServer := &handlers.GRPCServer{
 		DB:     database,
 		Logger: logger,
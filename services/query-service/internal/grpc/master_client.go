@@ -4,37 +4,37 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
+	"os"
 
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
 	coordinatorv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/coordinator/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type MasterClient struct {
-	conn    *grpc.ClientConn
+	conn    *dfs.MountConn
 	service coordinatorv1.CoordinatorServiceClient
 }
 
-func NewMasterClient(address string) (*MasterClient, error) {
-	log.Printf("Attempting to dial Coordinator at address: %s", address)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	conn, err := grpc.DialContext(
-		ctx,
-		address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
-
+// NewMasterClient connects to the masters in addresses, a comma separated
+// list, and follows the leader across failovers. Paths mounted on other master
+// groups by $DFS_MOUNT_TABLE are routed there. Without dial options the
+// connections are plaintext.
+func NewMasterClient(addresses string, dialOpts ...grpc.DialOption) (*MasterClient, error) {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	mounts, err := dfs.ParseMountTable(os.Getenv("DFS_MOUNT_TABLE"))
 	if err != nil {
-		log.Printf("FATAL ERROR: Failed to connect to Coordinator at %s: %v", address, err)
-		return nil, fmt.Errorf("could not connect to coordinator at %s: %w", address, err)
+		return nil, fmt.Errorf("DFS_MOUNT_TABLE: %w", err)
+	}
+	conn, err := dfs.DialMounts(dfs.ParseMasterAddrs(addresses), mounts, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to coordinator at %s: %w", addresses, err)
 	}
 
-	log.Printf("Successfully connected to Coordinator at: %s", address)
+	log.Printf("Coordinator client ready for masters: %s", addresses)
 
 	return &MasterClient{
 		conn:    conn,
@@ -42,6 +42,12 @@ func NewMasterClient(address string) (*MasterClient, error) {
 	}, nil
 }
 
+// SetStaleReads lets standby masters answer metadata lookups and listings,
+// taking scan load off the leader.
+func (c *MasterClient) SetStaleReads(enabled bool) {
+	c.conn.SetStaleReads(enabled)
+}
+
 func (c *MasterClient) Close() {
 	c.conn.Close()
 }
@@ -16,11 +16,13 @@ type DataNodeClient struct {
 	service datanodev1.DataNodeServiceClient
 }
 
-func NewDataNodeClient(address string) (*DataNodeClient, error) {
-	conn, err := grpc.Dial(
-		address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+// NewDataNodeClient connects to a worker. Without dial options the connection
+// is plaintext.
+func NewDataNodeClient(address string, dialOpts ...grpc.DialOption) (*DataNodeClient, error) {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.Dial(address, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -34,9 +36,12 @@ func (c *DataNodeClient) Close() {
 	c.conn.Close()
 }
 
-func (c *DataNodeClient) FetchBlock(ctx context.Context, blockID string) ([]byte, error) {
+// FetchBlock reads a whole block. token is the block's read token from the
+// file metadata, empty when the cluster does not use block tokens.
+func (c *DataNodeClient) FetchBlock(ctx context.Context, blockID, token string) ([]byte, error) {
 	req := &datanodev1.FetchBlockRequest{
-		BlockId: blockID,
+		BlockId:    blockID,
+		BlockToken: token,
 	}
 
 	stream, err := c.service.FetchBlock(ctx, req)
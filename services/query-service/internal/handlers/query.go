@@ -16,6 +16,7 @@ import (
 	"github.com/xitongsys/parquet-go/source"
 	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
+	googlegrpc "google.golang.org/grpc"
 )
 
 type MemoryFile struct {
@@ -37,15 +38,18 @@ type QueryHandler struct {
 
 	dataNodeClientsMu sync.Mutex
 	dataNodeClients   map[string]*grpc.DataNodeClient
+	// dialOpts are used for the DataNode connections opened on demand.
+	dialOpts []googlegrpc.DialOption
 
 	QueryCounter metric.Int64Counter
 }
 
-func NewQueryHandler(logger *zap.Logger, masterClient *grpc.MasterClient, queryCounter metric.Int64Counter) *QueryHandler {
+func NewQueryHandler(logger *zap.Logger, masterClient *grpc.MasterClient, queryCounter metric.Int64Counter, dialOpts ...googlegrpc.DialOption) *QueryHandler {
 	return &QueryHandler{
 		logger:          logger,
 		MasterClient:    masterClient,
 		dataNodeClients: make(map[string]*grpc.DataNodeClient),
+		dialOpts:        dialOpts,
 		QueryCounter:    queryCounter,
 	}
 }
@@ -59,7 +63,7 @@ func (h *QueryHandler) getDataNodeClient(address string) (*grpc.DataNodeClient,
 	}
 
 	h.logger.Info("Creating new connection to DataNode", zap.String("address", address))
-	client, err := grpc.NewDataNodeClient(address)
+	client, err := grpc.NewDataNodeClient(address, h.dialOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +134,7 @@ func (h *QueryHandler) GetData(c *gin.Context) {
 		}
 
 		h.logger.Debug("Fetching block", zap.String("block_id", blockID), zap.String("worker", workerAddr))
-		blockData, err := client.FetchBlock(c.Request.Context(), blockID)
+		blockData, err := client.FetchBlock(c.Request.Context(), blockID, metadata.BlockTokens[blockID])
 		if err != nil {
 			h.logger.Error("Failed to fetch block", zap.String("block_id", blockID), zap.Error(err))
 			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to retrieve data block"})
@@ -199,7 +203,7 @@ func (h *QueryHandler) GetSchemaData(c *gin.Context) {
 				continue
 			}
 
-			data, err := client.FetchBlock(c.Request.Context(), blockInfo.BlockId)
+			data, err := client.FetchBlock(c.Request.Context(), blockInfo.BlockId, metadata.BlockTokens[blockInfo.BlockId])
 			if err != nil {
 				h.logger.Error("Failed to retrieve block", zap.String("block", blockInfo.BlockId), zap.Error(err))
 				continue
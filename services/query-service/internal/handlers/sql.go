@@ -239,7 +239,7 @@ func (h *QueryHandler) VirtualFileHandler(c *gin.Context) {
 			return
 		}
 
-		data, err := client.FetchBlock(c.Request.Context(), block.BlockId)
+		data, err := client.FetchBlock(c.Request.Context(), block.BlockId, metadata.BlockTokens[block.BlockId])
 		if err != nil {
 			c.AbortWithStatus(http.StatusBadGateway)
 			return
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	middleware "github.com/razvanmarinn/datalake/pkg/jwt/middleware"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
 	i_grpc "github.com/razvanmarinn/query_service/internal/grpc"
 	"github.com/razvanmarinn/query_service/internal/handlers"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
@@ -53,15 +54,24 @@ func main() {
 		logger.Fatal("WORKER_SERVICE_ADDRESSES environment variable not set")
 	}
 
-	masterClient, err := i_grpc.NewMasterClient(masterAddress)
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv("query-service"))
+	if err != nil {
+		logger.Fatal("Failed to load TLS certificates", zap.Error(err))
+	}
+	workerDialOpt := tlsSource.DialOption("worker")
+
+	masterClient, err := i_grpc.NewMasterClient(masterAddress, tlsSource.DialOption("master"))
 	if err != nil {
 		logger.Fatal("Failed to create master client", zap.Error(err))
 	}
 	defer masterClient.Close()
+	if os.Getenv("MASTER_STALE_READS") == "true" {
+		masterClient.SetStaleReads(true)
+	}
 
 	workerClients := make(map[string]*i_grpc.DataNodeClient)
 	for _, addr := range strings.Split(workerAddresses, ",") {
-		client, err := i_grpc.NewDataNodeClient(addr)
+		client, err := i_grpc.NewDataNodeClient(addr, workerDialOpt)
 		if err != nil {
 			logger.Fatal("Failed to create worker client", zap.String("address", addr), zap.Error(err))
 		}
@@ -69,7 +79,7 @@ func main() {
 		defer client.Close()
 	}
 
-	queryHandler := handlers.NewQueryHandler(logger, masterClient, queryCounter)
+	queryHandler := handlers.NewQueryHandler(logger, masterClient, queryCounter, workerDialOpt)
 
 	r := gin.New()
 	r.Use(gin.Recovery())
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize bounds a single aws-chunked chunk, which is buffered whole so
+// its signature can be checked before any of it is released.
+const maxChunkSize = 16 * 1024 * 1024
+
+// chunkSigner computes the rolling signatures of STREAMING-AWS4-HMAC-SHA256-PAYLOAD
+// chunks, each chained to the previous one starting from the seed signature.
+type chunkSigner struct {
+	key     []byte
+	amzDate string
+	scope   string
+	prevSig string
+}
+
+func (s *chunkSigner) next(data []byte) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		s.amzDate,
+		s.scope,
+		s.prevSig,
+		emptySHA256,
+		hexSHA256(data),
+	}, "\n")
+	s.prevSig = hex.EncodeToString(hmacSHA256(s.key, []byte(stringToSign)))
+	return s.prevSig
+}
+
+// chunkedReader decodes an aws-chunked body. With a signer every chunk must
+// carry a valid chunk-signature; without one the chunks are unsigned and the
+// body may end with trailing headers, which are discarded.
+type chunkedReader struct {
+	body   io.ReadCloser
+	br     *bufio.Reader
+	signer *chunkSigner
+	buf    []byte
+	done   bool
+	err    error
+}
+
+func newChunkedReader(body io.ReadCloser, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{body: body, br: bufio.NewReader(body), signer: signer}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.nextChunk()
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) Close() error { return c.body.Close() }
+
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err == io.EOF {
+		return errorf("IncompleteBody", "missing final chunk")
+	}
+	if err != nil {
+		return err
+	}
+
+	sizeField, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errorf("IncompleteBody", "malformed chunk header")
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		return errorf("IncompleteBody", "truncated chunk")
+	}
+
+	if c.signer != nil {
+		sig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok || !hmac.Equal([]byte(sig), []byte(c.signer.next(data))) {
+			return errorf("SignatureDoesNotMatch", "chunk signature does not match")
+		}
+	}
+
+	if size == 0 {
+		c.done = true
+		return c.readTrailer()
+	}
+	if line, err := c.readLine(); err != nil || line != "" {
+		return errorf("IncompleteBody", "missing chunk terminator")
+	}
+	c.buf = data
+	return nil
+}
+
+// readTrailer consumes the optional trailing headers after the final chunk.
+func (c *chunkedReader) readTrailer() error {
+	for {
+		line, err := c.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+	}
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		}
+		return "", errorf("IncompleteBody", "truncated chunked body")
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	identityv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IdentityStore looks access keys up in the identity service and caches
+// their signing keys for ttl, so a busy client does not cost a lookup per
+// request.
+type IdentityStore struct {
+	client identityv1.IdentityServiceClient
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[cacheKey]cachedCredentials
+}
+
+type cacheKey struct {
+	accessKeyID string
+	scope       Scope
+}
+
+type cachedCredentials struct {
+	creds   *Credentials
+	expires time.Time
+}
+
+func NewIdentityStore(client identityv1.IdentityServiceClient, ttl time.Duration) *IdentityStore {
+	return &IdentityStore{
+		client: client,
+		ttl:    ttl,
+		cache:  make(map[cacheKey]cachedCredentials),
+	}
+}
+
+func (s *IdentityStore) Lookup(ctx context.Context, accessKeyID string, scope Scope) (*Credentials, error) {
+	key := cacheKey{accessKeyID, scope}
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.creds, nil
+	}
+
+	resp, err := s.client.GetAccessKey(ctx, &identityv1.GetAccessKeyRequest{
+		AccessKeyId: accessKeyID,
+		Date:        scope.Date,
+		Region:      scope.Region,
+		Service:     scope.Service,
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &Credentials{
+		AccessKeyID: accessKeyID,
+		SigningKey:  resp.SigningKey,
+		UserID:      resp.UserId,
+		Username:    resp.Username,
+	}
+
+	s.mu.Lock()
+	s.cache[key] = cachedCredentials{creds: creds, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return creds, nil
+}
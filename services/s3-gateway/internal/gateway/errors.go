@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/razvanmarinn/s3-gateway/internal/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// s3Error is an S3 error response.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string { return e.Code + ": " + e.Message }
+
+var (
+	errNoSuchBucket       = &s3Error{"NoSuchBucket", "The specified bucket does not exist."}
+	errNoSuchKey          = &s3Error{"NoSuchKey", "The specified key does not exist."}
+	errNoSuchUpload       = &s3Error{"NoSuchUpload", "The specified multipart upload does not exist."}
+	errAccessDenied       = &s3Error{"AccessDenied", "Access Denied"}
+	errInvalidKey         = &s3Error{"InvalidArgument", "Object keys must be normalized slash-separated paths."}
+	errInvalidPart        = &s3Error{"InvalidPart", "One or more of the specified parts could not be found or its ETag did not match."}
+	errInvalidPartOrder   = &s3Error{"InvalidPartOrder", "The list of parts was not in ascending order."}
+	errMalformedXML       = &s3Error{"MalformedXML", "The XML you provided was not well-formed."}
+	errNotImplemented     = &s3Error{"NotImplemented", "A header or query you provided implies functionality that is not implemented."}
+	errServiceUnavailable = &s3Error{"ServiceUnavailable", "Please reduce your request rate."}
+	errInternal           = &s3Error{"InternalError", "We encountered an internal error. Please try again."}
+)
+
+var statusByCode = map[string]int{
+	"AccessDenied":                 http.StatusForbidden,
+	"AuthorizationHeaderMalformed": http.StatusBadRequest,
+	"IncompleteBody":               http.StatusBadRequest,
+	"InvalidAccessKeyId":           http.StatusForbidden,
+	"InvalidArgument":              http.StatusBadRequest,
+	"InvalidPart":                  http.StatusBadRequest,
+	"InvalidPartOrder":             http.StatusBadRequest,
+	"InvalidRequest":               http.StatusBadRequest,
+	"MalformedXML":                 http.StatusBadRequest,
+	"NoSuchBucket":                 http.StatusNotFound,
+	"NoSuchKey":                    http.StatusNotFound,
+	"NoSuchUpload":                 http.StatusNotFound,
+	"NotImplemented":               http.StatusNotImplemented,
+	"RequestTimeTooSkewed":         http.StatusForbidden,
+	"ServiceUnavailable":           http.StatusServiceUnavailable,
+	"SignatureDoesNotMatch":        http.StatusForbidden,
+	"XAmzContentSHA256Mismatch":    http.StatusBadRequest,
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// toS3Error maps gateway, signature and DFS errors onto S3 errors.
+func toS3Error(err error) *s3Error {
+	var se *s3Error
+	if errors.As(err, &se) {
+		return se
+	}
+	var ae *auth.Error
+	if errors.As(err, &ae) {
+		return &s3Error{ae.Code, ae.Message}
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return errNoSuchKey
+	case codes.PermissionDenied:
+		return errAccessDenied
+	case codes.Unavailable:
+		return errServiceUnavailable
+	}
+	return errInternal
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	se := toS3Error(err)
+	code, ok := statusByCode[se.Code]
+	if !ok {
+		code = http.StatusInternalServerError
+		s.logger.Error("S3 request failed",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Error(err))
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	_ = xml.NewEncoder(w).Encode(errorResponse{
+		Code:     se.Code,
+		Message:  se.Message,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,498 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"github.com/razvanmarinn/datalake/pkg/logging"
+	"github.com/razvanmarinn/s3-gateway/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memStore is an in-memory Store. Concat keeps the data of its sources, like
+// the DFS keeps their blocks.
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	mtime map[string]int64
+	clock int64
+	// listed counts the files listings returned.
+	listed int
+	// writeErr fails writes to created files.
+	writeErr error
+	aborted  []string
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string][]byte), mtime: make(map[string]int64)}
+}
+
+func (m *memStore) put(p string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock++
+	m.files[p] = data
+	m.mtime[p] = time.Unix(1700000000, 0).UnixNano() + m.clock
+}
+
+func (m *memStore) Stat(ctx context.Context, p string) (dfs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[p]
+	if !ok {
+		for name := range m.files {
+			if strings.HasPrefix(name, p+"/") {
+				return dfs.FileInfo{Name: p, IsDir: true}, nil
+			}
+		}
+		return dfs.FileInfo{}, status.Error(codes.NotFound, "not found")
+	}
+	return dfs.FileInfo{Name: p, Size: int64(len(data)), ModTime: m.mtime[p]}, nil
+}
+
+func (m *memStore) Open(ctx context.Context, p string) (dfs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[p]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return &memFile{Reader: bytes.NewReader(data)}, nil
+}
+
+func (m *memStore) Create(ctx context.Context, p string, opts ...dfs.CreateOption) (dfs.File, error) {
+	return &memFile{store: m, path: p}, nil
+}
+
+func (m *memStore) Delete(ctx context.Context, p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[p]; !ok {
+		return status.Error(codes.NotFound, "not found")
+	}
+	delete(m.files, p)
+	return nil
+}
+
+func (m *memStore) ListFiles(ctx context.Context, projectID, prefix string) ([]dfs.FileInfo, error) {
+	infos, _, err := m.ListFilesAfter(ctx, projectID, prefix, "", 0)
+	return infos, err
+}
+
+func (m *memStore) ListFilesAfter(ctx context.Context, projectID, prefix, startAfter string, limit int) ([]dfs.FileInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var infos []dfs.FileInfo
+	for name, data := range m.files {
+		rel, ok := strings.CutPrefix(name, projectID+"/")
+		if ok && strings.HasPrefix(rel, prefix) && rel > startAfter {
+			infos = append(infos, dfs.FileInfo{Name: name, Size: int64(len(data)), ModTime: m.mtime[name]})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	truncated := limit > 0 && len(infos) > limit
+	if truncated {
+		infos = infos[:limit]
+	}
+	m.listed += len(infos)
+	return infos, truncated, nil
+}
+
+func (m *memStore) Concat(ctx context.Context, target string, sources []string, opts ...dfs.CreateOption) error {
+	m.mu.Lock()
+	var data []byte
+	for _, src := range sources {
+		d, ok := m.files[src]
+		if !ok {
+			m.mu.Unlock()
+			return status.Error(codes.NotFound, "not found")
+		}
+		data = append(data, d...)
+		delete(m.files, src)
+	}
+	m.mu.Unlock()
+	m.put(target, data)
+	return nil
+}
+
+// memFile is a reader for Open and a writer that commits on Close for Create.
+type memFile struct {
+	*bytes.Reader
+	store *memStore
+	path  string
+	buf   bytes.Buffer
+}
+
+func (f *memFile) Read(p []byte) (int, error) { return f.Reader.Read(p) }
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.store != nil && f.store.writeErr != nil {
+		return 0, f.store.writeErr
+	}
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	if f.store != nil {
+		f.store.put(f.path, f.buf.Bytes())
+	}
+	return nil
+}
+
+// Abort drops the written data, as an aborted dfs writer commits nothing.
+func (f *memFile) Abort() error {
+	if f.store != nil {
+		f.store.mu.Lock()
+		f.store.aborted = append(f.store.aborted, f.path)
+		f.store.mu.Unlock()
+	}
+	f.store = nil
+	return nil
+}
+
+func (f *memFile) Sync() error                 { return nil }
+func (f *memFile) Stat() (dfs.FileInfo, error) { return dfs.FileInfo{Name: f.path}, nil }
+
+type staticProjects map[string]*Project
+
+func (p staticProjects) Lookup(ctx context.Context, bucket string) (*Project, error) {
+	return p[bucket], nil
+}
+
+// headerAuth trusts the user named in the X-Test-User header.
+type headerAuth struct{}
+
+func (headerAuth) Verify(r *http.Request) (*auth.Credentials, error) {
+	user := r.Header.Get("X-Test-User")
+	if user == "" {
+		return nil, &auth.Error{Code: "AccessDenied", Message: "no credentials"}
+	}
+	return &auth.Credentials{UserID: user}, nil
+}
+
+func setupTestGateway(t *testing.T) (*httptest.Server, *memStore) {
+	t.Helper()
+	store := newMemStore()
+	projects := staticProjects{
+		"sales":   {ID: "p-sales", OwnerID: "alice"},
+		"finance": {ID: "p-finance", OwnerID: "bob"},
+	}
+	srv := httptest.NewServer(NewServer(store, projects, headerAuth{}, logging.NewDefaultLogger("s3-gateway-test")).Handler())
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func do(t *testing.T, srv *httptest.Server, method, target string, body io.Reader, headers ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+target, body)
+	require.NoError(t, err)
+	req.Header.Set("X-Test-User", "alice")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func errorCode(t *testing.T, body string) string {
+	t.Helper()
+	var e errorResponse
+	require.NoError(t, xml.Unmarshal([]byte(body), &e))
+	return e.Code
+}
+
+func TestGateway_PutGetHeadDelete(t *testing.T) {
+	srv, store := setupTestGateway(t)
+
+	resp, _ := do(t, srv, http.MethodPut, "/sales/2024/orders.csv", strings.NewReader("id,total\n1,10\n"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Contains(t, store.files, "sales/2024/orders.csv")
+
+	resp, body := do(t, srv, http.MethodGet, "/sales/2024/orders.csv", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "id,total\n1,10\n", body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	resp, body = do(t, srv, http.MethodGet, "/sales/2024/orders.csv", nil, "Range", "bytes=3-7")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "total", body)
+	assert.Equal(t, "bytes 3-7/14", resp.Header.Get("Content-Range"))
+
+	resp, _ = do(t, srv, http.MethodGet, "/sales/2024/orders.csv", nil, "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, body = do(t, srv, http.MethodHead, "/sales/2024/orders.csv", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "14", resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	resp, _ = do(t, srv, http.MethodHead, "/sales/2024", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "directories are not objects")
+
+	resp, _ = do(t, srv, http.MethodDelete, "/sales/2024/orders.csv", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NotContains(t, store.files, "sales/2024/orders.csv")
+
+	resp, body = do(t, srv, http.MethodGet, "/sales/2024/orders.csv", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "NoSuchKey", errorCode(t, body))
+
+	resp, _ = do(t, srv, http.MethodDelete, "/sales/2024/orders.csv", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestGateway_Authorization(t *testing.T) {
+	srv, _ := setupTestGateway(t)
+
+	resp, body := do(t, srv, http.MethodGet, "/finance/report.csv", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "AccessDenied", errorCode(t, body))
+
+	resp, body = do(t, srv, http.MethodGet, "/missing/report.csv", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "NoSuchBucket", errorCode(t, body))
+
+	resp, body = do(t, srv, http.MethodPut, "/sales/folder/", strings.NewReader("x"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "InvalidArgument", errorCode(t, body))
+
+	resp, _ = do(t, srv, http.MethodGet, "/sales/.s3-multipart/x/upload", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/sales/report.csv", nil)
+	require.NoError(t, err)
+	anon, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	anon.Body.Close()
+	assert.Equal(t, http.StatusForbidden, anon.StatusCode)
+}
+
+func listObjects(t *testing.T, srv *httptest.Server, query string) listBucketResult {
+	t.Helper()
+	resp, body := do(t, srv, http.MethodGet, "/sales?list-type=2&"+query, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var result listBucketResult
+	require.NoError(t, xml.Unmarshal([]byte(body), &result))
+	return result
+}
+
+func keys(result listBucketResult) []string {
+	var out []string
+	for _, c := range result.Contents {
+		out = append(out, c.Key)
+	}
+	for _, p := range result.CommonPrefixes {
+		out = append(out, p.Prefix+"*")
+	}
+	return out
+}
+
+func TestGateway_ListObjectsV2(t *testing.T) {
+	srv, store := setupTestGateway(t)
+	for _, p := range []string{"a.txt", "logs/2024/01.log", "logs/2024/02.log", "logs/2025/01.log", "logs/index", "z.txt"} {
+		store.put("sales/"+p, []byte(p))
+	}
+	store.put("sales/.s3-multipart/abc/upload", []byte("hidden"))
+	store.put("finance/secret.txt", []byte("x"))
+
+	result := listObjects(t, srv, "")
+	assert.Equal(t, []string{"a.txt", "logs/2024/01.log", "logs/2024/02.log", "logs/2025/01.log", "logs/index", "z.txt"}, keys(result))
+	assert.Equal(t, 6, result.KeyCount)
+	assert.False(t, result.IsTruncated)
+	assert.Equal(t, int64(len("a.txt")), result.Contents[0].Size)
+
+	result = listObjects(t, srv, "delimiter=/")
+	assert.Equal(t, []string{"a.txt", "z.txt", "logs/*"}, keys(result))
+
+	result = listObjects(t, srv, "prefix=logs/&delimiter=/")
+	assert.Equal(t, []string{"logs/index", "logs/2024/*", "logs/2025/*"}, keys(result))
+
+	result = listObjects(t, srv, "prefix=logs/2024/")
+	assert.Equal(t, []string{"logs/2024/01.log", "logs/2024/02.log"}, keys(result))
+
+	// Paging with a delimiter must not repeat a common prefix.
+	var pages [][]string
+	token := ""
+	for {
+		q := "delimiter=/&max-keys=1&prefix=logs/"
+		if token != "" {
+			q += "&continuation-token=" + token
+		}
+		result = listObjects(t, srv, q)
+		pages = append(pages, keys(result))
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	assert.Equal(t, [][]string{{"logs/2024/*"}, {"logs/2025/*"}, {"logs/index"}}, pages)
+
+	result = listObjects(t, srv, "start-after=logs/2025/01.log")
+	assert.Equal(t, []string{"logs/index", "z.txt"}, keys(result))
+
+	result = listObjects(t, srv, "max-keys=0")
+	assert.Empty(t, keys(result))
+	assert.False(t, result.IsTruncated, "nothing to continue from")
+	assert.Empty(t, result.NextContinuationToken)
+}
+
+func TestGateway_ListObjectsV2Pages(t *testing.T) {
+	srv, store := setupTestGateway(t)
+	for i := 0; i < 1000; i++ {
+		store.put(fmt.Sprintf("sales/big/%03d", i), []byte("x"))
+		store.put(fmt.Sprintf("sales/.s3-multipart/u/%03d", i), []byte("x"))
+	}
+	for i := 0; i < 10; i++ {
+		store.put(fmt.Sprintf("sales/k%d", i), []byte("x"))
+	}
+
+	var pages [][]string
+	token := ""
+	for {
+		q := "max-keys=4&start-after=big/995"
+		if token != "" {
+			q += "&continuation-token=" + token
+		}
+		result := listObjects(t, srv, q)
+		pages = append(pages, keys(result))
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	assert.Equal(t, [][]string{
+		{"big/996", "big/997", "big/998", "big/999"},
+		{"k0", "k1", "k2", "k3"},
+		{"k4", "k5", "k6", "k7"},
+		{"k8", "k9"},
+	}, pages)
+	assert.LessOrEqual(t, store.listed, 4*5, "pages list about as many files as they return")
+
+	store.listed = 0
+	result := listObjects(t, srv, "delimiter=/")
+	assert.Equal(t, []string{"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9", "big/*"}, keys(result))
+	assert.LessOrEqual(t, store.listed, 2*listPageSize+10, "the files below a common prefix are skipped")
+}
+
+func TestGateway_PutObjectAbortsOnReadError(t *testing.T) {
+	srv, store := setupTestGateway(t)
+	store.writeErr = errors.New("connection reset")
+
+	resp, _ := do(t, srv, http.MethodPut, "/sales/a.txt", strings.NewReader("data"))
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, []string{"sales/a.txt"}, store.aborted)
+	assert.NotContains(t, store.files, "sales/a.txt")
+}
+
+func TestGateway_MultipartUpload(t *testing.T) {
+	srv, store := setupTestGateway(t)
+
+	resp, body := do(t, srv, http.MethodPost, "/sales/big.bin?uploads", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var initiated initiateMultipartUploadResult
+	require.NoError(t, xml.Unmarshal([]byte(body), &initiated))
+	uploadID := initiated.UploadID
+	require.NotEmpty(t, uploadID)
+
+	etags := map[int]string{}
+	for n, data := range map[int]string{1: "hello ", 2: "unused", 3: "world"} {
+		resp, body = do(t, srv, http.MethodPut, fmt.Sprintf("/sales/big.bin?partNumber=%d&uploadId=%s", n, uploadID), strings.NewReader(data))
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		etags[n] = resp.Header.Get("ETag")
+	}
+
+	// Parts are hidden from listings.
+	assert.Empty(t, listObjects(t, srv, "").Contents)
+
+	resp, body = do(t, srv, http.MethodPut, "/sales/other.bin?partNumber=1&uploadId="+uploadID, strings.NewReader("x"))
+	assert.Equal(t, "NoSuchUpload", errorCode(t, body))
+
+	complete := func(parts ...completedPart) (*http.Response, string) {
+		data, err := xml.Marshal(struct {
+			XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+			Parts   []completedPart `xml:"Part"`
+		}{Parts: parts})
+		require.NoError(t, err)
+		return do(t, srv, http.MethodPost, "/sales/big.bin?uploadId="+uploadID, bytes.NewReader(data))
+	}
+
+	_, body = complete(completedPart{3, etags[3]}, completedPart{1, etags[1]})
+	assert.Equal(t, "InvalidPartOrder", errorCode(t, body))
+	_, body = complete(completedPart{1, etags[1]}, completedPart{3, etags[1]})
+	assert.Equal(t, "InvalidPart", errorCode(t, body))
+
+	resp, body = complete(completedPart{1, etags[1]}, completedPart{3, etags[3]})
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var completed completeMultipartUploadResult
+	require.NoError(t, xml.Unmarshal([]byte(body), &completed))
+
+	resp, body = do(t, srv, http.MethodGet, "/sales/big.bin", nil)
+	assert.Equal(t, "hello world", body)
+	assert.Equal(t, completed.ETag, resp.Header.Get("ETag"))
+
+	for name := range store.files {
+		assert.False(t, strings.Contains(name, multipartDir), "staged file %s left behind", name)
+	}
+
+	_, body = complete(completedPart{1, etags[1]})
+	assert.Equal(t, "NoSuchUpload", errorCode(t, body))
+}
+
+func TestGateway_AbortMultipartUpload(t *testing.T) {
+	srv, store := setupTestGateway(t)
+
+	_, body := do(t, srv, http.MethodPost, "/sales/big.bin?uploads", nil)
+	var initiated initiateMultipartUploadResult
+	require.NoError(t, xml.Unmarshal([]byte(body), &initiated))
+
+	resp, _ := do(t, srv, http.MethodPut, "/sales/big.bin?partNumber=1&uploadId="+initiated.UploadID, strings.NewReader("data"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = do(t, srv, http.MethodDelete, "/sales/big.bin?uploadId="+initiated.UploadID, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, store.files)
+
+	resp, body = do(t, srv, http.MethodDelete, "/sales/big.bin?uploadId="+initiated.UploadID, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "NoSuchUpload", errorCode(t, body))
+}
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"a.txt":                true,
+		"logs/2024/01.log":     true,
+		"folder/":              false,
+		"a//b":                 false,
+		"a/./b":                false,
+		"../a":                 false,
+		"/a":                   false,
+		".s3-multipart":        false,
+		".s3-multipart/x/part": false,
+		".s3-multipartx":       true,
+		"bad\xffkey":           false,
+	} {
+		assert.Equal(t, want, validKey(key), key)
+	}
+}
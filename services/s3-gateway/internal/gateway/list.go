@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxListKeys = 1000
+
+// listPageSize bounds the files listed from the DFS at once, and so how many
+// are listed in vain below a common prefix before skipping past it.
+const listPageSize = 100
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, r *request) error {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+
+	maxKeys := maxListKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &s3Error{"InvalidArgument", "max-keys must be a non-negative integer."}
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+
+	// The continuation token is the last key or common prefix returned.
+	marker := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return &s3Error{"InvalidArgument", "The continuation token provided is incorrect."}
+		}
+		marker = string(decoded)
+	}
+
+	result := listBucketResult{
+		Name:              r.bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+		StartAfter:        q.Get("start-after"),
+	}
+
+	// Listing pages of the files after the marker, and skipping past every
+	// common prefix once it is listed, keeps each request to about as many
+	// files as it returns. With max-keys=0 nothing is listed, so there is
+	// nothing to continue from either.
+	after := marker
+	if delimiter != "" && strings.HasSuffix(marker, delimiter) {
+		after = pastPrefix(marker)
+	}
+	last := ""
+pages:
+	for maxKeys > 0 {
+		files, more, err := s.store.ListFilesAfter(r.Context(), r.bucket, prefix, after, min(maxKeys-result.KeyCount+1, listPageSize))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			key := strings.TrimPrefix(f.Name, r.bucket+"/")
+			after = key
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if strings.HasPrefix(key, multipartDir+"/") {
+				after = pastPrefix(multipartDir + "/")
+				continue pages
+			}
+
+			entry := key
+			isPrefix := false
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					entry = key[:len(prefix)+i+len(delimiter)]
+					isPrefix = true
+				}
+			}
+
+			if result.KeyCount == maxKeys {
+				result.IsTruncated = true
+				result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+				break pages
+			}
+			result.KeyCount++
+			last = entry
+
+			if isPrefix {
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+				after = pastPrefix(entry)
+				continue pages
+			}
+			info := fileInfo{f}
+			result.Contents = append(result.Contents, object{
+				Key:          key,
+				LastModified: info.lastModified().Format(time.RFC3339Nano),
+				ETag:         info.etag(),
+				Size:         f.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		if !more {
+			break
+		}
+	}
+
+	if q.Get("encoding-type") == "url" {
+		encodeListing(&result)
+	}
+
+	writeXML(w, result)
+	return nil
+}
+
+// pastPrefix returns a key that sorts after every key starting with p and
+// before every other key after p: keys are UTF-8, which has no 0xff byte.
+func pastPrefix(p string) string {
+	return p + "\xff"
+}
+
+func encodeListing(result *listBucketResult) {
+	enc := func(s string) string { return strings.ReplaceAll(url.QueryEscape(s), "+", "%20") }
+
+	result.EncodingType = "url"
+	result.Prefix = enc(result.Prefix)
+	result.Delimiter = enc(result.Delimiter)
+	result.StartAfter = enc(result.StartAfter)
+	for i := range result.Contents {
+		result.Contents[i].Key = enc(result.Contents[i].Key)
+	}
+	for i := range result.CommonPrefixes {
+		result.CommonPrefixes[i].Prefix = enc(result.CommonPrefixes[i].Prefix)
+	}
+}
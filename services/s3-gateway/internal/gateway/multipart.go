@@ -0,0 +1,204 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"go.uber.org/zap"
+)
+
+// Multipart uploads are staged in the bucket itself, so the gateway keeps no
+// state of its own: an upload is the directory multipartDir/<uploadID> holding
+// a marker file with the object key and one file per part. Completing the
+// upload concatenates the parts' blocks into the object without copying data.
+const (
+	multipartDir = ".s3-multipart"
+	uploadMarker = "upload"
+	maxPartNum   = 10000
+)
+
+func uploadDir(bucket, uploadID string) string {
+	return bucket + "/" + multipartDir + "/" + uploadID
+}
+
+func partPath(bucket, uploadID string, partNum int) string {
+	return fmt.Sprintf("%s/part-%05d", uploadDir(bucket, uploadID), partNum)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *request) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	f, err := s.store.Create(r.Context(), uploadDir(r.bucket, uploadID)+"/"+uploadMarker,
+		dfs.WithProjectID(r.project.ID), dfs.WithOwnerID(r.project.OwnerID))
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, r.key); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	writeXML(w, initiateMultipartUploadResult{Bucket: r.bucket, Key: r.key, UploadID: uploadID})
+	return nil
+}
+
+// checkUpload verifies that uploadID names an upload started for r.key.
+func (s *Server) checkUpload(r *request, uploadID string) error {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return errNoSuchUpload
+	}
+
+	f, err := s.store.Open(r.Context(), uploadDir(r.bucket, uploadID)+"/"+uploadMarker)
+	if err != nil {
+		if toS3Error(err) == errNoSuchKey {
+			return errNoSuchUpload
+		}
+		return err
+	}
+	defer f.Close()
+
+	key, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if string(key) != r.key {
+		return errNoSuchUpload
+	}
+	return nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *request, uploadID, partNumber string) error {
+	partNum, err := strconv.Atoi(partNumber)
+	if err != nil || partNum < 1 || partNum > maxPartNum {
+		return &s3Error{"InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive."}
+	}
+	if err := s.checkUpload(r, uploadID); err != nil {
+		return err
+	}
+
+	info, err := s.writeFile(r, partPath(r.bucket, uploadID, partNum), s.createOptions(r))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", info.etag())
+	return nil
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *request, uploadID string) error {
+	if err := s.checkUpload(r, uploadID); err != nil {
+		return err
+	}
+
+	var body completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	sources := make([]string, 0, len(body.Parts))
+	for i, part := range body.Parts {
+		if i > 0 && part.PartNumber <= body.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+
+		p := partPath(r.bucket, uploadID, part.PartNumber)
+		info, ok, err := s.stat(r.Context(), p)
+		if err != nil {
+			return err
+		}
+		if !ok || strings.Trim(info.etag(), `"`) != strings.Trim(part.ETag, `"`) {
+			return errInvalidPart
+		}
+		sources = append(sources, p)
+	}
+
+	if err := s.store.Concat(r.Context(), r.objectPath(), sources, s.createOptions(r)...); err != nil {
+		return err
+	}
+	// Parts left out of the completed object and the marker go with the
+	// upload. The object is already committed, so a failure here only leaves
+	// staged files behind.
+	if err := s.removeUpload(r, uploadID); err != nil {
+		s.logger.Warn("Failed to clean up multipart upload", zap.String("upload_id", uploadID), zap.Error(err))
+	}
+
+	info, ok, err := s.stat(r.Context(), r.objectPath())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoSuchKey
+	}
+	writeXML(w, completeMultipartUploadResult{Bucket: r.bucket, Key: r.key, ETag: info.etag()})
+	return nil
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *request, uploadID string) error {
+	if err := s.checkUpload(r, uploadID); err != nil {
+		return err
+	}
+	if err := s.removeUpload(r, uploadID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeUpload deletes every file staged for an upload, the marker last so
+// that a failed cleanup can be retried with another abort.
+func (s *Server) removeUpload(r *request, uploadID string) error {
+	prefix := strings.TrimPrefix(uploadDir(r.bucket, uploadID), r.bucket+"/") + "/"
+	files, err := s.store.ListFiles(r.Context(), r.bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	marker := uploadDir(r.bucket, uploadID) + "/" + uploadMarker
+	for _, f := range files {
+		if f.Name == marker {
+			continue
+		}
+		if err := s.store.Delete(r.Context(), f.Name); err != nil && toS3Error(err) != errNoSuchKey {
+			return err
+		}
+	}
+	if err := s.store.Delete(r.Context(), marker); err != nil && toS3Error(err) != errNoSuchKey {
+		return err
+	}
+	return nil
+}
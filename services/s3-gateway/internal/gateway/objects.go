@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"go.uber.org/zap"
+)
+
+type fileInfo struct {
+	dfs.FileInfo
+}
+
+// etag is an opaque entity tag derived from the file's path, size and
+// modification time. It carries a multipart-style suffix so that clients do
+// not mistake it for the MD5 of the content.
+func (fi fileInfo) etag() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", fi.Name, fi.Size, fi.ModTime)))
+	return `"` + hex.EncodeToString(sum[:16]) + `-1"`
+}
+
+func (fi fileInfo) lastModified() time.Time {
+	if fi.ModTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, fi.ModTime).UTC()
+}
+
+func contentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// fileFormat is the DFS file format recorded for an object: its extension, as
+// the ingestion and compaction paths do for avro and parquet.
+func fileFormat(key string) string {
+	if ext := strings.TrimPrefix(path.Ext(key), "."); ext != "" {
+		return ext
+	}
+	return "bin"
+}
+
+// createOptions are the options for writing objects and parts, which like in
+// S3 replace whatever is at their key.
+func (s *Server) createOptions(r *request) []dfs.CreateOption {
+	return []dfs.CreateOption{
+		dfs.WithProjectID(r.project.ID),
+		dfs.WithOwnerID(r.project.OwnerID),
+		dfs.WithFormat(fileFormat(r.key)),
+		dfs.Overwrite(),
+	}
+}
+
+// getObject serves GET and HEAD. http.ServeContent handles Range and the
+// conditional headers against the ETag and modification time.
+func (s *Server) getObject(w http.ResponseWriter, r *request) error {
+	info, ok, err := s.stat(r.Context(), r.objectPath())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoSuchKey
+	}
+
+	f, err := s.store.Open(r.Context(), r.objectPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w.Header().Set("ETag", info.etag())
+	w.Header().Set("Content-Type", contentType(r.key))
+	http.ServeContent(w, r.Request, "", info.lastModified(), f)
+	return nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *request) error {
+	info, err := s.writeFile(r, r.objectPath(), s.createOptions(r))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", info.etag())
+	return nil
+}
+
+// writeFile stores the request body at p. The file is only committed once the
+// whole body has been read and verified.
+func (s *Server) writeFile(r *request, p string, opts []dfs.CreateOption) (fileInfo, error) {
+	f, err := s.store.Create(r.Context(), p, opts...)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	if _, err := io.Copy(f, r.Body); err != nil {
+		// Nothing is committed: the writer's uploads stop and the blocks
+		// stored so far are abandoned.
+		if abortErr := f.Abort(); abortErr != nil {
+			s.logger.Warn("Failed to abort write", zap.String("path", p), zap.Error(abortErr))
+		}
+		return fileInfo{}, err
+	}
+	if err := f.Close(); err != nil {
+		return fileInfo{}, err
+	}
+
+	info, ok, err := s.stat(r.Context(), p)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	if !ok {
+		return fileInfo{}, fmt.Errorf("%s missing after commit", p)
+	}
+	return info, nil
+}
+
+// deleteObject succeeds whether or not the object exists, as in S3.
+func (s *Server) deleteObject(w http.ResponseWriter, r *request) error {
+	if err := s.store.Delete(r.Context(), r.objectPath()); err != nil && toS3Error(err) != errNoSuchKey {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,161 @@
+// Package gateway serves a subset of the S3 REST API on top of the DFS.
+// Buckets are projects: bucket "sales" is the namespace subtree "sales/" and
+// may only be used by the project's owner. Requests must be path-style.
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/razvanmarinn/datalake/pkg/logging"
+	"github.com/razvanmarinn/s3-gateway/internal/auth"
+)
+
+// Authenticator verifies a request and returns the caller's credentials.
+type Authenticator interface {
+	Verify(r *http.Request) (*auth.Credentials, error)
+}
+
+type Server struct {
+	store    Store
+	projects Projects
+	auth     Authenticator
+	logger   *logging.Logger
+}
+
+func NewServer(store Store, projects Projects, authn Authenticator, logger *logging.Logger) *Server {
+	return &Server{store: store, projects: projects, auth: authn, logger: logger}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", s.withBucket(nil))
+	mux.HandleFunc("/{bucket}", s.withBucket(s.serveBucket))
+	mux.HandleFunc("/{bucket}/{key...}", s.withBucket(s.serveObject))
+	return mux
+}
+
+// request is an authenticated request against a bucket the caller owns.
+type request struct {
+	*http.Request
+	bucket  string
+	key     string
+	project *Project
+}
+
+func (r *request) objectPath() string { return r.bucket + "/" + r.key }
+
+// withBucket authenticates the request and checks that the caller owns the
+// bucket before calling next.
+func (s *Server) withBucket(next func(http.ResponseWriter, *request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		creds, err := s.auth.Verify(r)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if next == nil {
+			// ListBuckets would need a project-by-owner lookup in the catalog.
+			s.writeError(w, r, errNotImplemented)
+			return
+		}
+
+		req := &request{Request: r, bucket: r.PathValue("bucket"), key: r.PathValue("key")}
+		if req.key != "" && !validKey(req.key) {
+			s.writeError(w, r, errInvalidKey)
+			return
+		}
+
+		req.project, err = s.projects.Lookup(r.Context(), req.bucket)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if req.project == nil {
+			s.writeError(w, r, errNoSuchBucket)
+			return
+		}
+		if req.project.OwnerID != creds.UserID {
+			s.writeError(w, r, errAccessDenied)
+			return
+		}
+
+		if err := next(w, req); err != nil {
+			s.writeError(w, r, err)
+		}
+	}
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *request) error {
+	switch r.Method {
+	case http.MethodHead:
+		return nil
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") != "2" {
+			return errNotImplemented
+		}
+		return s.listObjectsV2(w, r)
+	}
+	return errNotImplemented
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *request) error {
+	if r.key == "" {
+		return s.serveBucket(w, r)
+	}
+
+	q := r.URL.Query()
+	uploadID := q.Get("uploadId")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return s.getObject(w, r)
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			return errNotImplemented
+		}
+		if uploadID != "" {
+			return s.uploadPart(w, r, uploadID, q.Get("partNumber"))
+		}
+		return s.putObject(w, r)
+	case http.MethodDelete:
+		if uploadID != "" {
+			return s.abortMultipartUpload(w, r, uploadID)
+		}
+		return s.deleteObject(w, r)
+	case http.MethodPost:
+		if q.Has("uploads") {
+			return s.createMultipartUpload(w, r)
+		}
+		if uploadID != "" {
+			return s.completeMultipartUpload(w, r, uploadID)
+		}
+	}
+	return errNotImplemented
+}
+
+// validKey accepts UTF-8 keys that map one to one onto DFS paths: no empty,
+// "." or ".." segments and nothing inside the multipart staging area.
+func validKey(key string) bool {
+	if !utf8.ValidString(key) || path.Clean(key) != key || strings.HasPrefix(key, "/") || strings.HasPrefix(key, "../") || key == ".." {
+		return false
+	}
+	return key != multipartDir && !strings.HasPrefix(key, multipartDir+"/")
+}
+
+func (s *Server) stat(ctx context.Context, p string) (fileInfo, bool, error) {
+	info, err := s.store.Stat(ctx, p)
+	if err != nil {
+		if toS3Error(err) == errNoSuchKey {
+			return fileInfo{}, false, nil
+		}
+		return fileInfo{}, false, err
+	}
+	if info.IsDir {
+		return fileInfo{}, false, nil
+	}
+	return fileInfo{info}, true, nil
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"context"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	catalogv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/catalog/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store is the part of the DFS client the gateway uses. dfs.Client satisfies
+// it.
+type Store interface {
+	Stat(ctx context.Context, path string) (dfs.FileInfo, error)
+	Open(ctx context.Context, path string) (dfs.File, error)
+	Create(ctx context.Context, path string, opts ...dfs.CreateOption) (dfs.File, error)
+	Delete(ctx context.Context, path string) error
+	ListFiles(ctx context.Context, projectID, prefix string) ([]dfs.FileInfo, error)
+	ListFilesAfter(ctx context.Context, projectID, prefix, startAfter string, limit int) ([]dfs.FileInfo, bool, error)
+	Concat(ctx context.Context, target string, sources []string, opts ...dfs.CreateOption) error
+}
+
+// Project is the catalog project behind a bucket.
+type Project struct {
+	ID      string
+	OwnerID string
+}
+
+// Projects resolves bucket names to projects. It returns nil for unknown
+// buckets.
+type Projects interface {
+	Lookup(ctx context.Context, bucket string) (*Project, error)
+}
+
+// CatalogProjects resolves buckets through the metadata service's catalog.
+type CatalogProjects struct {
+	client catalogv1.CatalogServiceClient
+}
+
+func NewCatalogProjects(client catalogv1.CatalogServiceClient) *CatalogProjects {
+	return &CatalogProjects{client: client}
+}
+
+func (p *CatalogProjects) Lookup(ctx context.Context, bucket string) (*Project, error) {
+	resp, err := p.client.GetProject(ctx, &catalogv1.GetProjectRequest{ProjectName: bucket})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProjectId == "" {
+		return nil, nil
+	}
+	return &Project{ID: resp.ProjectId, OwnerID: resp.OwnerId}, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	dfs "github.com/razvanmarinn/datalake/pkg/dfs-client"
+	"github.com/razvanmarinn/datalake/pkg/logging"
+	"github.com/razvanmarinn/datalake/pkg/tlsconfig"
+	catalogv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/catalog/v1"
+	identityv1 "github.com/razvanmarinn/datalake/protobuf/gen/go/identity/v1"
+	"github.com/razvanmarinn/s3-gateway/internal/auth"
+	"github.com/razvanmarinn/s3-gateway/internal/gateway"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+const credentialCacheTTL = 5 * time.Minute
+
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+func main() {
+	logger := logging.NewDefaultLogger("s3-gateway")
+	defer logger.Sync()
+
+	masterAddr := getEnv("MASTER_SERVICE_ADDR", "master.datalake.svc.cluster.local:50055")
+	identityAddr := getEnv("IDENTITY_SERVICE_ADDR", "identity-service:50056")
+	metadataAddr := getEnv("METADATA_SERVICE_ADDR", "metadata-service:50056")
+	listenAddr := getEnv("LISTEN_ADDR", ":9000")
+
+	tlsSource, err := tlsconfig.Load(tlsconfig.FromEnv("s3-gateway"))
+	if err != nil {
+		logger.Fatal("Failed to load TLS certificates", zap.Error(err))
+	}
+
+	dfsClient, err := dfs.NewClient(masterAddr, dfs.WithTransportCredentials(tlsSource.ClientCredentials("master", "worker")))
+	if err != nil {
+		logger.Fatal("Failed to create DFS client", zap.Error(err))
+	}
+	defer dfsClient.Close()
+
+	identityConn, err := grpc.NewClient(identityAddr, tlsSource.DialOption("identity-service"))
+	if err != nil {
+		logger.Fatal("Failed to connect to identity service", zap.Error(err))
+	}
+	defer identityConn.Close()
+
+	metaConn, err := grpc.NewClient(metadataAddr, tlsSource.DialOption("metadata-service"))
+	if err != nil {
+		logger.Fatal("Failed to connect to metadata service", zap.Error(err))
+	}
+	defer metaConn.Close()
+
+	verifier := auth.NewVerifier(auth.NewIdentityStore(identityv1.NewIdentityServiceClient(identityConn), credentialCacheTTL))
+	projects := gateway.NewCatalogProjects(catalogv1.NewCatalogServiceClient(metaConn))
+	srv := &http.Server{
+		Addr:    listenAddr,
+		Handler: gateway.NewServer(dfsClient, projects, verifier, logger).Handler(),
+	}
+
+	go func() {
+		logger.Info("S3 gateway listening", zap.String("addr", listenAddr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("S3 gateway failed", zap.Error(err))
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("Graceful shutdown failed", zap.Error(err))
+	}
+}